@@ -0,0 +1,231 @@
+// 2025 TabDock: darui3018823 All rights reserved.
+// All works created by darui3018823 associated with this repository are the intellectual property of darui3018823.
+// Packages and other third-party materials used in this repository are subject to their respective licenses and copyrights.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+var (
+	// errReauthRequired is returned when a destructive action needs a recent sign-in.
+	errReauthRequired = errors.New("reauthentication required")
+	// errLoginRejected means the login guard has already written the response.
+	errLoginRejected = errors.New("login rejected")
+)
+
+func getUserIDByUsername(username string) (string, error) {
+	if db == nil {
+		return "", fmt.Errorf("データベース接続がありません")
+	}
+	var userID string
+	err := db.QueryRow(`SELECT id FROM users WHERE username = ?`, username).Scan(&userID)
+	return userID, err
+}
+
+// getReauthMaxAge is how recent a sign-in must be to delete a passwordless account.
+func getReauthMaxAge() time.Duration {
+	return time.Duration(envPositiveInt("ACCOUNT_REAUTH_MAX_AGE_MIN", 10)) * time.Minute
+}
+
+// Sessions started by a sign-in within getReauthMaxAge, keyed by fingerprint.
+// Sessions restored from a stored restore token are never added, so only a real
+// password, passkey or SSO sign-in counts as re-authentication.
+var (
+	recentSignInsMu sync.Mutex
+	recentSignIns   = map[string]time.Time{}
+)
+
+// noteSignIn records that the session cookie value was issued by a sign-in.
+func noteSignIn(sessionValue string) {
+	now := time.Now()
+	recentSignInsMu.Lock()
+	defer recentSignInsMu.Unlock()
+	for key, at := range recentSignIns {
+		if now.Sub(at) > getReauthMaxAge() {
+			delete(recentSignIns, key)
+		}
+	}
+	recentSignIns[sessionFingerprint(sessionValue)] = now
+}
+
+// hasRecentSignIn reports whether the request's session was started by a sign-in
+// within getReauthMaxAge.
+func hasRecentSignIn(r *http.Request) bool {
+	cookie, err := r.Cookie(sessionCookieName)
+	if err != nil {
+		return false
+	}
+	recentSignInsMu.Lock()
+	defer recentSignInsMu.Unlock()
+	at, ok := recentSignIns[sessionFingerprint(cookie.Value)]
+	return ok && time.Since(at) <= getReauthMaxAge()
+}
+
+func clearSessionCookie(w http.ResponseWriter) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookieName,
+		Value:    "",
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteLaxMode,
+	})
+	clearCSRFCookie(w)
+}
+
+// handleAccountExport streams a zip archive of everything stored for the caller.
+func handleAccountExport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Like deletion, an export needs a browser session: never a token or the header fallback.
+	username, err := getUsernameFromSession(r)
+	if err != nil {
+		http.Error(w, "認証が必要です", http.StatusUnauthorized)
+		return
+	}
+	userID, err := getUserIDByUsername(username)
+	if err != nil {
+		http.Error(w, "ユーザーが見つかりません", http.StatusNotFound)
+		return
+	}
+
+	data, err := loadUserExport(userID)
+	if err != nil {
+		log.Printf("[ERROR] データエクスポート失敗 (%s): %v", username, err)
+		http.Error(w, "データのエクスポートに失敗しました", http.StatusInternalServerError)
+		return
+	}
+
+	// Build the archive in memory first so a failure can still be reported as an error status.
+	var buf bytes.Buffer
+	if err := writeUserExport(&buf, data); err != nil {
+		log.Printf("[ERROR] エクスポートアーカイブ作成失敗 (%s): %v", username, err)
+		http.Error(w, "データのエクスポートに失敗しました", http.StatusInternalServerError)
+		return
+	}
+
+	filename := fmt.Sprintf("tabdock-export-%s.zip", time.Now().Format("20060102-150405"))
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+filename+`"`)
+	w.Header().Set("Content-Length", strconv.Itoa(buf.Len()))
+	w.Header().Set("Cache-Control", "no-store")
+	if _, err := buf.WriteTo(w); err != nil {
+		log.Printf("エクスポート送信エラー: %v", err)
+		return
+	}
+
+	log.Printf("[INFO] データエクスポート: ユーザー=%s ファイル数=%d IP=%s", username, len(data.files), getIPAddress(r))
+}
+
+// verifyAccountDeletion re-authenticates the caller. Accounts with a password must
+// supply it; passkey and SSO accounts must have signed in with them within
+// getReauthMaxAge, which a restored session does not count as.
+func verifyAccountDeletion(w http.ResponseWriter, r *http.Request, username, password string) error {
+	var hasPassword bool
+	if err := db.QueryRow(`SELECT COALESCE(password, '') != '' FROM users WHERE username = ?`, username).Scan(&hasPassword); err != nil {
+		return err
+	}
+
+	if hasPassword {
+		ip := getIPAddress(r)
+		if rejectLockedLogin(w, r, username, ip, loginMethodPassword) {
+			return errLoginRejected
+		}
+		if _, err := authenticateAuthUser(username, password); err != nil {
+			recordLoginFailure(r, username, ip, loginMethodPassword)
+			return errReauthRequired
+		}
+		recordLoginSuccess(username, ip)
+		return nil
+	}
+
+	if !hasRecentSignIn(r) {
+		return errReauthRequired
+	}
+	return nil
+}
+
+// handleAccountDelete lets a user delete their own account and all of its data.
+func handleAccountDelete(w http.ResponseWriter, r *http.Request) {
+	// Only a browser session may delete an account: never a token or the header fallback.
+	username, err := getUsernameFromSession(r)
+	if err != nil {
+		http.Error(w, "認証が必要です", http.StatusUnauthorized)
+		return
+	}
+
+	var req struct {
+		Password string `json:"password"`
+	}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid JSON", http.StatusBadRequest)
+			return
+		}
+	}
+
+	if err := verifyAccountDeletion(w, r, username, req.Password); err != nil {
+		switch {
+		case errors.Is(err, errLoginRejected):
+		case errors.Is(err, errReauthRequired):
+			writeJSON(w, http.StatusUnauthorized, map[string]interface{}{
+				keySuccess:       false,
+				keyMessage:       "本人確認に失敗しました。パスワードを入力するか、再ログインしてからお試しください",
+				"reauthRequired": true,
+			})
+		default:
+			log.Printf("[ERROR] 本人確認エラー (%s): %v", username, err)
+			http.Error(w, "アカウントの削除に失敗しました", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	if role, err := getUserRole(username); err == nil && role == roleAdmin {
+		if others, err := countOtherAdmins(username); err != nil || others == 0 {
+			http.Error(w, "最後の管理者アカウントは削除できません", http.StatusBadRequest)
+			return
+		}
+	}
+
+	userID, err := getUserIDByUsername(username)
+	if err != nil {
+		http.Error(w, "ユーザーが見つかりません", http.StatusNotFound)
+		return
+	}
+	if err := deleteUserAccount(userID); err != nil {
+		log.Printf("[ERROR] アカウント削除失敗 (%s): %v", username, err)
+		http.Error(w, "アカウントの削除に失敗しました", http.StatusInternalServerError)
+		return
+	}
+
+	clearSessionCookie(w)
+	log.Printf("[INFO] ユーザー自身によるアカウント削除: ユーザー=%s IP=%s", username, getIPAddress(r))
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		keySuccess: true,
+		keyMessage: "アカウントを削除しました",
+	})
+}
+
+// handleAccount routes /api/account by method.
+func handleAccount(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodDelete:
+		handleAccountDelete(w, r)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
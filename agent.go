@@ -0,0 +1,176 @@
+// 2025 TabDock: darui3018823 All rights reserved.
+// All works created by darui3018823 associated with this repository are the intellectual property of darui3018823.
+// Packages and other third-party materials used in this repository are subject to their respective licenses and copyrights.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"tabdock/getstatus"
+)
+
+const (
+	agentPushPath       = "/api/agent/push"
+	agentRequestTimeout = 15 * time.Second
+	agentMaxBackoff     = 5 * time.Minute
+)
+
+// agentPush is the body an agent sends to the central server.
+type agentPush struct {
+	Host        string              `json:"host"`
+	IntervalSec int                 `json:"intervalSec"`
+	Version     string              `json:"version"`
+	Status      *getstatus.PCStatus `json:"status"`
+	Details     *getstatus.StatusV2 `json:"details"`
+}
+
+// runAgent implements "tabdock agent": it collects the local status and pushes it to
+// a central Tabdock instead of serving the dashboard itself.
+func runAgent(args []string) int {
+	hostname, _ := os.Hostname()
+	fs := flag.NewFlagSet("agent", flag.ContinueOnError)
+	server := fs.String("server", getEnv("AGENT_SERVER", ""), "URL of the central Tabdock, such as https://tabdock.example.com")
+	host := fs.String("host", getEnv("AGENT_HOST", hostname), "name this machine is shown under")
+	interval := fs.Duration("interval", time.Duration(envPositiveInt("AGENT_INTERVAL_SEC", 10))*time.Second, "time between pushes")
+	once := fs.Bool("once", false, "push a single sample and exit")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: tabdock agent [flags]")
+		fmt.Fprintln(fs.Output(), "Pushes this machine's status to a central Tabdock. The API token, with the")
+		fmt.Fprintln(fs.Output(), "status:push scope and owned by an admin, is read from AGENT_TOKEN.")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return 0
+		}
+		return 2
+	}
+
+	token := strings.TrimSpace(os.Getenv("AGENT_TOKEN"))
+	endpoint, err := agentEndpoint(*server)
+	switch {
+	case err != nil:
+		fmt.Fprintf(os.Stderr, "agent: %v\n", err)
+		return 2
+	case token == "":
+		fmt.Fprintln(os.Stderr, "agent: AGENT_TOKEN is not set")
+		return 2
+	case !validHostName(*host):
+		fmt.Fprintf(os.Stderr, "agent: invalid host name %q (letters, digits, '.', '_' and '-', up to 64)\n", *host)
+		return 2
+	case *interval < time.Second:
+		fmt.Fprintln(os.Stderr, "agent: -interval must be at least 1s")
+		return 2
+	}
+
+	configureStatusProbes()
+	configureStatusCollectors()
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	client := &http.Client{Timeout: agentRequestTimeout}
+	push := func() error {
+		status, details, err := getstatus.Collect(defaultStatusTopProcesses)
+		if err != nil {
+			return fmt.Errorf("status collection failed: %w", err)
+		}
+		return sendAgentPush(ctx, client, endpoint, token, agentPush{
+			Host:        *host,
+			IntervalSec: int(*interval / time.Second),
+			Version:     version,
+			Status:      status,
+			Details:     details,
+		})
+	}
+
+	if *once {
+		if err := push(); err != nil {
+			fmt.Fprintf(os.Stderr, "agent: %v\n", err)
+			return 1
+		}
+		return 0
+	}
+
+	log.Printf("[INFO] エージェントを開始します: ホスト=%s 送信先=%s 間隔=%s", *host, endpoint, *interval)
+	wait := *interval
+	failing := false
+	for {
+		if err := push(); err != nil {
+			// Back off while the server is unreachable so a dead server is not hammered.
+			if !failing {
+				wait = *interval
+			}
+			wait = min(wait*2, agentMaxBackoff)
+			failing = true
+			log.Printf("[WARN] ステータスの送信に失敗しました (%s後に再試行): %v", wait, err)
+		} else {
+			if failing {
+				log.Printf("[INFO] ステータスの送信が復旧しました")
+			}
+			failing = false
+			wait = *interval
+		}
+
+		select {
+		case <-ctx.Done():
+			log.Printf("[INFO] エージェントを停止します")
+			return 0
+		case <-time.After(wait):
+		}
+	}
+}
+
+// agentEndpoint builds the push URL from the server's base URL.
+func agentEndpoint(server string) (string, error) {
+	server = strings.TrimSpace(server)
+	if server == "" {
+		return "", errors.New("-server or AGENT_SERVER is required")
+	}
+	u, err := url.Parse(server)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return "", fmt.Errorf("invalid server URL %q", server)
+	}
+	u.Path = strings.TrimRight(u.Path, "/") + agentPushPath
+	return u.String(), nil
+}
+
+func sendAgentPush(ctx context.Context, client *http.Client, endpoint, token string, body agentPush) error {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("User-Agent", "tabdock-agent/"+version)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("server answered %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	_, _ = io.Copy(io.Discard, resp.Body)
+	return nil
+}
@@ -0,0 +1,469 @@
+// 2025 TabDock: darui3018823 All rights reserved.
+// All works created by darui3018823 associated with this repository are the intellectual property of darui3018823.
+// Packages and other third-party materials used in this repository are subject to their respective licenses and copyrights.
+
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	apiTokenPrefix       = "tdk_"
+	apiTokenDisplayChars = 8
+	maxAPITokensPerUser  = 20
+
+	scopeSchedulesRead      = "schedules:read"
+	scopeSchedulesWrite     = "schedules:write"
+	scopeShiftsRead         = "shifts:read"
+	scopeShiftsWrite        = "shifts:write"
+	scopeSubscriptionsRead  = "subscriptions:read"
+	scopeSubscriptionsWrite = "subscriptions:write"
+	scopeWallpapersRead     = "wallpapers:read"
+	scopeWallpapersWrite    = "wallpapers:write"
+	scopeStatusPush         = "status:push"
+	scopeMetricsRead        = "metrics:read"
+)
+
+var apiTokenScopes = map[string]bool{
+	scopeSchedulesRead:      true,
+	scopeSchedulesWrite:     true,
+	scopeShiftsRead:         true,
+	scopeShiftsWrite:        true,
+	scopeSubscriptionsRead:  true,
+	scopeSubscriptionsWrite: true,
+	scopeWallpapersRead:     true,
+	scopeWallpapersWrite:    true,
+	scopeStatusPush:         true,
+	scopeMetricsRead:        true,
+}
+
+var (
+	errAPITokenInvalid = errors.New("invalid api token")
+	errAPITokenScope   = errors.New("api token scope not granted")
+)
+
+// APIToken is a personal access token as shown to its owner. The secret itself
+// is never stored; only its SHA-256 hash and a short prefix for identification.
+type APIToken struct {
+	ID         string   `json:"id"`
+	Name       string   `json:"name"`
+	Prefix     string   `json:"prefix"`
+	Scopes     []string `json:"scopes"`
+	CreatedAt  string   `json:"createdAt"`
+	ExpiresAt  string   `json:"expiresAt,omitempty"`
+	LastUsedAt string   `json:"lastUsedAt,omitempty"`
+	LastUsedIP string   `json:"lastUsedIp,omitempty"`
+	UseCount   int64    `json:"useCount"`
+}
+
+func initAPITokens() error {
+	_, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS api_tokens (
+			id TEXT PRIMARY KEY,
+			user_id TEXT NOT NULL,
+			name TEXT NOT NULL,
+			token_hash TEXT NOT NULL UNIQUE,
+			prefix TEXT NOT NULL,
+			scopes TEXT NOT NULL,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			expires_at DATETIME,
+			last_used_at DATETIME,
+			last_used_ip TEXT,
+			use_count INTEGER DEFAULT 0
+		)
+	`)
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec(`CREATE INDEX IF NOT EXISTS idx_api_tokens_user_id ON api_tokens(user_id)`)
+	return err
+}
+
+func hashAPIToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func generateAPIToken() (string, error) {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	return apiTokenPrefix + base64.RawURLEncoding.EncodeToString(raw), nil
+}
+
+// normalizeAPITokenScopes validates, de-duplicates and sorts the requested scopes.
+// A write scope implies read access for the same resource.
+func normalizeAPITokenScopes(requested []string) ([]string, error) {
+	seen := map[string]bool{}
+	for _, scope := range requested {
+		scope = strings.ToLower(strings.TrimSpace(scope))
+		if scope == "" {
+			continue
+		}
+		if !apiTokenScopes[scope] {
+			return nil, fmt.Errorf("不明なスコープです: %s", scope)
+		}
+		seen[scope] = true
+		if resource, ok := strings.CutSuffix(scope, ":write"); ok {
+			seen[resource+":read"] = true
+		}
+	}
+	if len(seen) == 0 {
+		return nil, fmt.Errorf("スコープを1つ以上指定してください")
+	}
+
+	scopes := make([]string, 0, len(seen))
+	for scope := range seen {
+		scopes = append(scopes, scope)
+	}
+	sort.Strings(scopes)
+	return scopes, nil
+}
+
+// requiredTokenScope maps a request to the scope an API token needs to perform it.
+// An empty result means the endpoint cannot be used with a token at all.
+func requiredTokenScope(r *http.Request) string {
+	read := r.Method == http.MethodGet || r.Method == http.MethodHead
+
+	switch r.URL.Path {
+	case "/api/schedule":
+		if read {
+			return scopeSchedulesRead
+		}
+		return scopeSchedulesWrite
+	case "/api/shift":
+		if read {
+			return scopeShiftsRead
+		}
+		return scopeShiftsWrite
+	case "/api/list-wallpapers":
+		return scopeWallpapersRead
+	case "/api/upload-wallpaper", "/api/delete-wallpaper":
+		return scopeWallpapersWrite
+	case "/api/subscriptions/list", "/api/subscriptions/upcoming":
+		return scopeSubscriptionsRead
+	case "/api/subscriptions", "/api/subscriptions/update", "/api/subscriptions/status",
+		"/api/subscriptions/renew", "/api/subscriptions/delete":
+		return scopeSubscriptionsWrite
+	case agentPushPath:
+		return scopeStatusPush
+	case "/metrics":
+		return scopeMetricsRead
+	}
+	return ""
+}
+
+func getBearerToken(r *http.Request) string {
+	header := strings.TrimSpace(r.Header.Get("Authorization"))
+	if len(header) < 7 || !strings.EqualFold(header[:7], "Bearer ") {
+		return ""
+	}
+	return strings.TrimSpace(header[7:])
+}
+
+// getUsernameFromAPIToken authenticates a bearer token and checks that it grants
+// the scope required by the request. Successful use is recorded on the token.
+func getUsernameFromAPIToken(r *http.Request, token string) (string, error) {
+	if db == nil {
+		return "", fmt.Errorf("データベース接続がありません")
+	}
+	if !strings.HasPrefix(token, apiTokenPrefix) {
+		return "", errAPITokenInvalid
+	}
+
+	var (
+		id        string
+		username  string
+		scopesRaw string
+		disabled  bool
+	)
+	err := db.QueryRow(`
+		SELECT t.id, u.username, t.scopes, COALESCE(u.disabled, 0)
+		FROM api_tokens t
+		JOIN users u ON u.id = t.user_id
+		WHERE t.token_hash = ? AND (t.expires_at IS NULL OR t.expires_at > ?)
+	`, hashAPIToken(token), sqliteTimestamp(time.Now())).Scan(&id, &username, &scopesRaw, &disabled)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", errAPITokenInvalid
+		}
+		return "", err
+	}
+	if disabled {
+		return "", errAccountDisabled
+	}
+
+	required := requiredTokenScope(r)
+	if required == "" || !containsScope(strings.Split(scopesRaw, ","), required) {
+		log.Printf("[WARN] APIトークンのスコープ不足: ユーザー=%s トークン=%s 必要=%q パス=%s", username, id, required, r.URL.Path)
+		return "", errAPITokenScope
+	}
+
+	if _, err := db.Exec(`UPDATE api_tokens SET last_used_at = CURRENT_TIMESTAMP, last_used_ip = ?, use_count = use_count + 1 WHERE id = ?`,
+		getIPAddress(r), id); err != nil {
+		log.Printf("[WARN] APIトークン使用記録の更新に失敗しました (%s): %v", id, err)
+	}
+	return username, nil
+}
+
+// lookupAPITokenOwner resolves an unexpired token to its id and owner without
+// checking scopes or recording use.
+func lookupAPITokenOwner(token string) (string, string, bool) {
+	if db == nil || !strings.HasPrefix(token, apiTokenPrefix) {
+		return "", "", false
+	}
+	var id, username string
+	err := db.QueryRow(`
+		SELECT t.id, u.username
+		FROM api_tokens t
+		JOIN users u ON u.id = t.user_id
+		WHERE t.token_hash = ? AND (t.expires_at IS NULL OR t.expires_at > ?)
+	`, hashAPIToken(token), sqliteTimestamp(time.Now())).Scan(&id, &username)
+	if err != nil {
+		return "", "", false
+	}
+	return id, username, true
+}
+
+// sqliteTimestamp formats t like SQLite's CURRENT_TIMESTAMP so the two compare as text.
+func sqliteTimestamp(t time.Time) string {
+	return t.UTC().Format("2006-01-02 15:04:05")
+}
+
+func containsScope(scopes []string, want string) bool {
+	for _, scope := range scopes {
+		if scope == want {
+			return true
+		}
+	}
+	return false
+}
+
+func listAPITokens(userID string) ([]APIToken, error) {
+	rows, err := db.Query(`
+		SELECT id, name, prefix, scopes, COALESCE(created_at, ''), COALESCE(expires_at, ''),
+		       COALESCE(last_used_at, ''), COALESCE(last_used_ip, ''), COALESCE(use_count, 0)
+		FROM api_tokens
+		WHERE user_id = ?
+		ORDER BY created_at DESC
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if closeErr := rows.Close(); closeErr != nil {
+			log.Printf("Failed to close rows: %v", closeErr)
+		}
+	}()
+
+	tokens := []APIToken{}
+	for rows.Next() {
+		var t APIToken
+		var scopes string
+		if err := rows.Scan(&t.ID, &t.Name, &t.Prefix, &scopes, &t.CreatedAt, &t.ExpiresAt,
+			&t.LastUsedAt, &t.LastUsedIP, &t.UseCount); err != nil {
+			return nil, err
+		}
+		t.Scopes = strings.Split(scopes, ",")
+		tokens = append(tokens, t)
+	}
+	return tokens, rows.Err()
+}
+
+// deleteAPITokensForUser removes every token owned by the user.
+func deleteAPITokensForUser(userID string) error {
+	_, err := db.Exec(`DELETE FROM api_tokens WHERE user_id = ?`, userID)
+	return err
+}
+
+// resolveTokenOwner returns the user id of the caller. Only the session cookie is
+// accepted: a leaked token can never mint or revoke other tokens, and the
+// X-Username fallback cannot turn LAN access into a credential usable anywhere.
+func resolveTokenOwner(w http.ResponseWriter, r *http.Request) (string, string, bool) {
+	if getBearerToken(r) != "" {
+		http.Error(w, "APIトークンではこの操作を実行できません", http.StatusForbidden)
+		return "", "", false
+	}
+	username, err := getUsernameFromSession(r)
+	if err != nil {
+		http.Error(w, "認証が必要です", http.StatusUnauthorized)
+		return "", "", false
+	}
+	userID, err := getUserIDByUsername(username)
+	if err != nil {
+		http.Error(w, "ユーザーが見つかりません", http.StatusNotFound)
+		return "", "", false
+	}
+	return username, userID, true
+}
+
+// handleAPITokens lists (GET) or creates (POST) personal API tokens for the caller.
+func handleAPITokens(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		_, userID, ok := resolveTokenOwner(w, r)
+		if !ok {
+			return
+		}
+		tokens, err := listAPITokens(userID)
+		if err != nil {
+			log.Printf("[ERROR] APIトークン一覧取得エラー: %v", err)
+			http.Error(w, "APIトークンの取得に失敗しました", http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			keySuccess: true,
+			"tokens":   tokens,
+			"scopes":   sortedAPITokenScopes(),
+		})
+	case http.MethodPost:
+		handleCreateAPIToken(w, r)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func sortedAPITokenScopes() []string {
+	scopes := make([]string, 0, len(apiTokenScopes))
+	for scope := range apiTokenScopes {
+		scopes = append(scopes, scope)
+	}
+	sort.Strings(scopes)
+	return scopes
+}
+
+func handleCreateAPIToken(w http.ResponseWriter, r *http.Request) {
+	username, userID, ok := resolveTokenOwner(w, r)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		Name          string   `json:"name"`
+		Scopes        []string `json:"scopes"`
+		ExpiresInDays int      `json:"expiresInDays"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" || len(req.Name) > 64 {
+		http.Error(w, "name は1〜64文字で指定してください", http.StatusBadRequest)
+		return
+	}
+	scopes, err := normalizeAPITokenScopes(req.Scopes)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.ExpiresInDays < 0 {
+		http.Error(w, "expiresInDays が不正です", http.StatusBadRequest)
+		return
+	}
+
+	var count int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM api_tokens WHERE user_id = ?`, userID).Scan(&count); err != nil {
+		log.Printf("[ERROR] APIトークン数取得エラー: %v", err)
+		http.Error(w, "APIトークンの作成に失敗しました", http.StatusInternalServerError)
+		return
+	}
+	if count >= maxAPITokensPerUser {
+		http.Error(w, fmt.Sprintf("APIトークンは最大%d個までです", maxAPITokensPerUser), http.StatusBadRequest)
+		return
+	}
+
+	token, err := generateAPIToken()
+	if err != nil {
+		log.Printf("[ERROR] APIトークン生成エラー: %v", err)
+		http.Error(w, "APIトークンの作成に失敗しました", http.StatusInternalServerError)
+		return
+	}
+
+	created := APIToken{
+		ID:        uuid.New().String(),
+		Name:      req.Name,
+		Prefix:    token[:len(apiTokenPrefix)+apiTokenDisplayChars],
+		Scopes:    scopes,
+		CreatedAt: sqliteTimestamp(time.Now()),
+	}
+	var expiresAt interface{}
+	if req.ExpiresInDays > 0 {
+		created.ExpiresAt = sqliteTimestamp(time.Now().AddDate(0, 0, req.ExpiresInDays))
+		expiresAt = created.ExpiresAt
+	}
+
+	_, err = db.Exec(`
+		INSERT INTO api_tokens (id, user_id, name, token_hash, prefix, scopes, expires_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+	`, created.ID, userID, created.Name, hashAPIToken(token), created.Prefix, strings.Join(scopes, ","), expiresAt)
+	if err != nil {
+		log.Printf("[ERROR] APIトークン保存エラー: %v", err)
+		http.Error(w, "APIトークンの作成に失敗しました", http.StatusInternalServerError)
+		return
+	}
+
+	log.Printf("[INFO] APIトークンを作成しました: ユーザー=%s 名前=%q スコープ=%s IP=%s",
+		username, created.Name, strings.Join(scopes, ","), getIPAddress(r))
+
+	writeJSON(w, http.StatusCreated, map[string]interface{}{
+		keySuccess: true,
+		"token":    token,
+		"info":     created,
+		keyMessage: "このトークンは再表示できません。安全な場所に保存してください",
+	})
+}
+
+// handleRevokeAPIToken deletes one of the caller's tokens by id.
+func handleRevokeAPIToken(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost && r.Method != http.MethodDelete {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	username, userID, ok := resolveTokenOwner(w, r)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		ID string `json:"id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || strings.TrimSpace(req.ID) == "" {
+		http.Error(w, "id が必要です", http.StatusBadRequest)
+		return
+	}
+
+	res, err := db.Exec(`DELETE FROM api_tokens WHERE id = ? AND user_id = ?`, strings.TrimSpace(req.ID), userID)
+	if err != nil {
+		log.Printf("[ERROR] APIトークン削除エラー: %v", err)
+		http.Error(w, "APIトークンの削除に失敗しました", http.StatusInternalServerError)
+		return
+	}
+	if affected, _ := res.RowsAffected(); affected == 0 {
+		http.Error(w, "APIトークンが見つかりません", http.StatusNotFound)
+		return
+	}
+
+	log.Printf("[INFO] APIトークンを失効させました: ユーザー=%s ID=%s IP=%s", username, req.ID, getIPAddress(r))
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		keySuccess: true,
+		keyMessage: "APIトークンを失効させました",
+	})
+}
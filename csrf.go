@@ -0,0 +1,138 @@
+// 2025 TabDock: darui3018823 All rights reserved.
+// All works created by darui3018823 associated with this repository are the intellectual property of darui3018823.
+// Packages and other third-party materials used in this repository are subject to their respective licenses and copyrights.
+
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+const (
+	// csrfCookieName holds the token for scripts to copy into csrfHeaderName.
+	// It is readable from JavaScript on purpose; the session cookie stays HttpOnly.
+	csrfCookieName = "tabdock_csrf"
+	csrfHeaderName = "X-CSRF-Token"
+)
+
+// csrfTokenForSession derives the CSRF token from the session cookie value. It changes
+// with every login and needs no server-side state.
+func csrfTokenForSession(sessionValue string) string {
+	mac := hmac.New(sha256.New, getSessionSecret())
+	mac.Write([]byte("csrf\n" + sessionValue))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func setCSRFCookie(w http.ResponseWriter, sessionValue string, expiresAt time.Time) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     csrfCookieName,
+		Value:    csrfTokenForSession(sessionValue),
+		Path:     "/",
+		Expires:  expiresAt,
+		Secure:   true,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+func clearCSRFCookie(w http.ResponseWriter) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     csrfCookieName,
+		Value:    "",
+		Path:     "/",
+		MaxAge:   -1,
+		Secure:   true,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+func isUnsafeMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+		return false
+	default:
+		return true
+	}
+}
+
+// requestOrigin returns the Origin header, or the origin of the Referer when it is missing.
+func requestOrigin(r *http.Request) string {
+	if origin := r.Header.Get("Origin"); origin != "" && origin != "null" {
+		return origin
+	}
+	referer, err := url.Parse(r.Header.Get("Referer"))
+	if err != nil || referer.Scheme == "" || referer.Host == "" {
+		return ""
+	}
+	return referer.Scheme + "://" + referer.Host
+}
+
+// verifyCSRF checks unsafe requests authenticated by the session cookie. The
+// X-CSRF-Token header must match the session; without it, the Origin (or Referer)
+// must be this site or an allowed CORS origin. Bearer-token and cookieless requests
+// carry no ambient credentials and pass. It answers the request and returns false
+// when the check fails.
+func verifyCSRF(w http.ResponseWriter, r *http.Request) bool {
+	if !isUnsafeMethod(r.Method) || getBearerToken(r) != "" {
+		return true
+	}
+	cookie, err := r.Cookie(sessionCookieName)
+	if err != nil || cookie.Value == "" {
+		return true
+	}
+
+	reason := ""
+	if token := r.Header.Get(csrfHeaderName); token != "" {
+		if hmac.Equal([]byte(token), []byte(csrfTokenForSession(cookie.Value))) {
+			return true
+		}
+		reason = "トークン不一致"
+	} else if origin := requestOrigin(r); origin == "" {
+		reason = "トークンとOriginがありません"
+	} else if isAllowedCORSOrigin(r, origin) {
+		return true
+	} else {
+		reason = "許可されていないOrigin " + origin
+	}
+
+	ip := getIPAddress(r)
+	log.Printf("[SECURITY] CSRF検証に失敗しました: %s %s IP=%s (%s)", r.Method, r.URL.Path, ip, reason)
+	recordSecurityDecision("csrf", ActionWarn, false)
+	logRequest(r, ip, ActionWarn)
+	http.Error(w, "CSRFトークンが無効です", http.StatusForbidden)
+	return false
+}
+
+// handleCSRFToken returns the caller's CSRF token and refreshes its cookie, for
+// sessions issued before the token existed or clients that cannot read cookies.
+func handleCSRFToken(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if _, err := getUsernameFromSession(r); err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	cookie, err := r.Cookie(sessionCookieName)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	_, expiresAt, err := parseAndVerifySessionCookieValue(cookie.Value)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	setCSRFCookie(w, cookie.Value, expiresAt)
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		keySuccess: true,
+		"token":    csrfTokenForSession(cookie.Value),
+	})
+}
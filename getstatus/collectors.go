@@ -0,0 +1,372 @@
+// 2025 TabDock: darui3018823 All rights reserved.
+// All works created by darui3018823 associated with this repository are the intellectual property of darui3018823.
+// Packages and other third-party materials used in this repository are subject to their respective licenses and copyrights.
+
+package getstatus
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultCollectorTTL     = time.Minute
+	minCollectorTTL         = 5 * time.Second
+	defaultCollectorTimeout = 10 * time.Second
+	maxCollectorTimeout     = time.Minute
+	maxCollectors           = 32
+
+	// maxCustomValueBytes caps the JSON size of one custom field, which is sent with
+	// every status response; a larger result needs a narrower path.
+	maxCustomValueBytes = 4096
+)
+
+// CollectorConfig declares one custom status field. Which of the type-specific fields
+// are used depends on Type; see the built-in collectors. Path selects part of a JSON
+// result with a JSONPath expression.
+type CollectorConfig struct {
+	Name      string `json:"name"`
+	Type      string `json:"type"`
+	Label     string `json:"label,omitempty"`
+	Unit      string `json:"unit,omitempty"`
+	TTLSec    int    `json:"ttl_sec,omitempty"`
+	TimeoutMs int    `json:"timeout_ms,omitempty"`
+	Path      string `json:"path,omitempty"`
+	// Public shows the field to clients that are neither signed in nor local.
+	Public bool `json:"public,omitempty"`
+
+	Command []string          `json:"command,omitempty"`
+	Format  string            `json:"format,omitempty"`
+	Pattern string            `json:"pattern,omitempty"`
+	URL     string            `json:"url,omitempty"`
+	Headers map[string]string `json:"headers,omitempty"`
+	Target  string            `json:"target,omitempty"`
+	UPS     string            `json:"ups,omitempty"`
+}
+
+func (c CollectorConfig) ttl() time.Duration { return time.Duration(c.TTLSec) * time.Second }
+func (c CollectorConfig) timeout() time.Duration {
+	return time.Duration(c.TimeoutMs) * time.Millisecond
+}
+
+// CollectFunc reads the current value of a custom field. The value must encode as
+// JSON; numbers, strings, booleans and decoded JSON documents all do.
+type CollectFunc func(ctx context.Context) (interface{}, error)
+
+// CollectorBuilder validates a config of its type, filling in defaults, and returns
+// the function that collects it.
+type CollectorBuilder func(cfg *CollectorConfig) (CollectFunc, error)
+
+var (
+	collectorKindsMu sync.RWMutex
+	collectorKinds   = map[string]CollectorBuilder{}
+)
+
+// RegisterCollector makes a collector type available to ConfigureCollectors. It panics
+// when the type is already registered, like http.Handle does for a pattern.
+func RegisterCollector(kind string, build CollectorBuilder) {
+	collectorKindsMu.Lock()
+	defer collectorKindsMu.Unlock()
+	if _, exists := collectorKinds[kind]; exists {
+		panic("getstatus: collector type registered twice: " + kind)
+	}
+	collectorKinds[kind] = build
+}
+
+// CollectorTypes returns the registered collector types in order.
+func CollectorTypes() []string {
+	collectorKindsMu.RLock()
+	defer collectorKindsMu.RUnlock()
+	kinds := make([]string, 0, len(collectorKinds))
+	for kind := range collectorKinds {
+		kinds = append(kinds, kind)
+	}
+	sort.Strings(kinds)
+	return kinds
+}
+
+// CustomStatus is the latest value of a custom field. Value and UpdatedAt come from
+// the last successful collection and are kept while later ones fail with Error; both
+// are nil before the first one has finished.
+type CustomStatus struct {
+	Label     string
+	Unit      string
+	Value     interface{}
+	Error     string
+	UpdatedAt *time.Time
+	Public    bool `json:",omitempty"`
+}
+
+// collector caches one custom field and refreshes it in the background once its TTL
+// has passed, so status requests never wait for a command or an HTTP call.
+type collector struct {
+	cfg     CollectorConfig
+	collect CollectFunc
+	path    jsonPath
+
+	mu        sync.Mutex
+	value     interface{}
+	err       error
+	updatedAt time.Time
+	checkedAt time.Time
+	running   bool
+}
+
+var (
+	activeCollectorsMu sync.Mutex
+	activeCollectors   []*collector
+)
+
+// ConfigureCollectors validates configs and replaces the custom fields with them. On
+// an error the current fields are kept.
+func ConfigureCollectors(configs []CollectorConfig) error {
+	if len(configs) > maxCollectors {
+		return fmt.Errorf("too many collectors (%d, max %d)", len(configs), maxCollectors)
+	}
+	collectors := make([]*collector, 0, len(configs))
+	seen := map[string]bool{}
+	for _, cfg := range configs {
+		c, err := newCollector(cfg)
+		if err != nil {
+			return err
+		}
+		if seen[c.cfg.Name] {
+			return fmt.Errorf("duplicate collector name %q", c.cfg.Name)
+		}
+		seen[c.cfg.Name] = true
+		collectors = append(collectors, c)
+	}
+
+	activeCollectorsMu.Lock()
+	activeCollectors = collectors
+	activeCollectorsMu.Unlock()
+	return nil
+}
+
+func newCollector(cfg CollectorConfig) (*collector, error) {
+	cfg.Name = strings.TrimSpace(cfg.Name)
+	cfg.Type = strings.ToLower(strings.TrimSpace(cfg.Type))
+	if !probeNamePattern.MatchString(cfg.Name) {
+		return nil, fmt.Errorf("invalid collector name %q (letters, digits, '.', '_' and '-', up to 64)", cfg.Name)
+	}
+	collectorKindsMu.RLock()
+	build, ok := collectorKinds[cfg.Type]
+	collectorKindsMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("collector %s: unknown type %q (%s)", cfg.Name, cfg.Type, strings.Join(CollectorTypes(), ", "))
+	}
+
+	if cfg.TTLSec == 0 {
+		cfg.TTLSec = int(defaultCollectorTTL / time.Second)
+	}
+	if cfg.ttl() < minCollectorTTL {
+		return nil, fmt.Errorf("collector %s: ttl_sec must be at least %d", cfg.Name, minCollectorTTL/time.Second)
+	}
+	if cfg.TimeoutMs == 0 {
+		cfg.TimeoutMs = int(defaultCollectorTimeout / time.Millisecond)
+	}
+	if cfg.TimeoutMs < 1 || cfg.timeout() > maxCollectorTimeout {
+		return nil, fmt.Errorf("collector %s: timeout_ms must be between 1 and %d", cfg.Name, maxCollectorTimeout/time.Millisecond)
+	}
+	if cfg.Label == "" {
+		cfg.Label = cfg.Name
+	}
+
+	// The builder may set a default path, so it is compiled afterwards.
+	collect, err := build(&cfg)
+	if err != nil {
+		return nil, fmt.Errorf("collector %s: %w", cfg.Name, err)
+	}
+	c := &collector{cfg: cfg, collect: collect}
+	if cfg.Path != "" {
+		if c.path, err = compileJSONPath(cfg.Path); err != nil {
+			return nil, fmt.Errorf("collector %s: invalid path: %w", cfg.Name, err)
+		}
+	}
+	return c, nil
+}
+
+// customStatus returns every custom field by name, starting refreshes of the stale ones.
+func customStatus() map[string]CustomStatus {
+	activeCollectorsMu.Lock()
+	collectors := activeCollectors
+	activeCollectorsMu.Unlock()
+
+	result := make(map[string]CustomStatus, len(collectors))
+	for _, c := range collectors {
+		result[c.cfg.Name] = c.get()
+	}
+	return result
+}
+
+func (c *collector) get() CustomStatus {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.running && time.Since(c.checkedAt) >= c.cfg.ttl() {
+		c.running = true
+		go c.refresh()
+	}
+
+	status := CustomStatus{Label: c.cfg.Label, Unit: c.cfg.Unit, Value: c.value, Public: c.cfg.Public}
+	if c.err != nil {
+		status.Error = c.err.Error()
+	}
+	if !c.updatedAt.IsZero() {
+		at := c.updatedAt
+		status.UpdatedAt = &at
+	}
+	return status
+}
+
+func (c *collector) refresh() {
+	ctx, cancel := context.WithTimeout(context.Background(), c.cfg.timeout())
+	defer cancel()
+	value, err := c.collect(ctx)
+	if err == nil && c.path != nil {
+		value, err = c.path.selectFrom(value)
+	}
+	if err == nil {
+		err = checkCustomValue(value)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	c.checkedAt, c.running, c.err = now, false, err
+	if err == nil {
+		c.value, c.updatedAt = value, now.UTC()
+	}
+}
+
+func checkCustomValue(value interface{}) error {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Errorf("value cannot be encoded as JSON: %w", err)
+	}
+	if len(data) > maxCustomValueBytes {
+		return fmt.Errorf("value is %d bytes (max %d); select a smaller part with path", len(data), maxCustomValueBytes)
+	}
+	return nil
+}
+
+// jsonPath is a compiled selector of the JSONPath subset collectors accept: "$"
+// followed by .key, ['key'], [index] (negative from the end) and [*] or .*, which
+// select every element and return a list.
+type jsonPath []jsonPathStep
+
+type jsonPathStep struct {
+	key      string
+	index    int
+	isIndex  bool
+	wildcard bool
+}
+
+func compileJSONPath(path string) (jsonPath, error) {
+	if !strings.HasPrefix(path, "$") {
+		return nil, fmt.Errorf("%q must start with $", path)
+	}
+	var steps jsonPath
+	rest := path[1:]
+	for rest != "" {
+		switch rest[0] {
+		case '.':
+			rest = rest[1:]
+			end := strings.IndexAny(rest, ".[")
+			if end < 0 {
+				end = len(rest)
+			}
+			key := rest[:end]
+			switch key {
+			case "":
+				return nil, fmt.Errorf("%q has an empty key", path)
+			case "*":
+				steps = append(steps, jsonPathStep{wildcard: true})
+			default:
+				steps = append(steps, jsonPathStep{key: key})
+			}
+			rest = rest[end:]
+		case '[':
+			end := strings.IndexByte(rest, ']')
+			if end < 0 {
+				return nil, fmt.Errorf("%q has an unclosed [", path)
+			}
+			inner := rest[1:end]
+			switch {
+			case inner == "*":
+				steps = append(steps, jsonPathStep{wildcard: true})
+			case len(inner) >= 2 && (inner[0] == '\'' || inner[0] == '"') && inner[len(inner)-1] == inner[0]:
+				steps = append(steps, jsonPathStep{key: inner[1 : len(inner)-1]})
+			default:
+				index, err := strconv.Atoi(inner)
+				if err != nil {
+					return nil, fmt.Errorf("%q has an invalid index [%s]", path, inner)
+				}
+				steps = append(steps, jsonPathStep{index: index, isIndex: true})
+			}
+			rest = rest[end+1:]
+		default:
+			return nil, fmt.Errorf("%q: unexpected %q", path, rest[0])
+		}
+	}
+	return steps, nil
+}
+
+func (p jsonPath) selectFrom(value interface{}) (interface{}, error) {
+	for i, step := range p {
+		switch {
+		case step.wildcard:
+			var items []interface{}
+			switch v := value.(type) {
+			case []interface{}:
+				items = v
+			case map[string]interface{}:
+				keys := make([]string, 0, len(v))
+				for key := range v {
+					keys = append(keys, key)
+				}
+				sort.Strings(keys)
+				for _, key := range keys {
+					items = append(items, v[key])
+				}
+			default:
+				return nil, fmt.Errorf("cannot select * from %T", value)
+			}
+			result := make([]interface{}, 0, len(items))
+			for _, item := range items {
+				// Elements without the rest of the path are skipped, as in JSONPath.
+				if selected, err := p[i+1:].selectFrom(item); err == nil {
+					result = append(result, selected)
+				}
+			}
+			return result, nil
+		case step.isIndex:
+			list, ok := value.([]interface{})
+			if !ok {
+				return nil, fmt.Errorf("cannot index %T", value)
+			}
+			index := step.index
+			if index < 0 {
+				index += len(list)
+			}
+			if index < 0 || index >= len(list) {
+				return nil, fmt.Errorf("index %d out of range (%d elements)", step.index, len(list))
+			}
+			value = list[index]
+		default:
+			object, ok := value.(map[string]interface{})
+			if !ok {
+				return nil, fmt.Errorf("cannot select %q from %T", step.key, value)
+			}
+			if value, ok = object[step.key]; !ok {
+				return nil, fmt.Errorf("key %q not found", step.key)
+			}
+		}
+	}
+	return value, nil
+}
@@ -0,0 +1,377 @@
+// 2025 TabDock: darui3018823 All rights reserved.
+// All works created by darui3018823 associated with this repository are the intellectual property of darui3018823.
+// Packages and other third-party materials used in this repository are subject to their respective licenses and copyrights.
+
+package getstatus
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"net/url"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Built-in collector types.
+const (
+	CollectorCommand   = "command"
+	CollectorHTTP      = "http"
+	CollectorNUT       = "nut"
+	CollectorMinecraft = "minecraft"
+
+	// maxCollectorOutput caps what is read from a command or a response.
+	maxCollectorOutput = 1 << 20
+
+	defaultNUTPort       = "3493"
+	defaultMinecraftPort = "25565"
+)
+
+func init() {
+	RegisterCollector(CollectorCommand, buildCommandCollector)
+	RegisterCollector(CollectorHTTP, buildHTTPCollector)
+	RegisterCollector(CollectorNUT, buildNUTCollector)
+	RegisterCollector(CollectorMinecraft, buildMinecraftCollector)
+}
+
+// buildCommandCollector runs Command without a shell. Its output is parsed as JSON, or
+// with the text format taken as a number when it is one and as a string otherwise.
+// Pattern picks the first capture group, or the whole match, out of the text.
+func buildCommandCollector(cfg *CollectorConfig) (CollectFunc, error) {
+	if len(cfg.Command) == 0 || cfg.Command[0] == "" {
+		return nil, errors.New("command is required")
+	}
+	if cfg.Format == "" {
+		cfg.Format = "text"
+	}
+	parse, err := outputParser(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.URL != "" || len(cfg.Headers) > 0 || cfg.Target != "" || cfg.UPS != "" {
+		return nil, errors.New("url, headers, target and ups are not used by command collectors")
+	}
+	command := append([]string(nil), cfg.Command...)
+	return func(ctx context.Context) (interface{}, error) {
+		var stdout, stderr cappedBuffer
+		cmd := commandContext(ctx, command[0], command[1:]...)
+		cmd.Stdout, cmd.Stderr = &stdout, &stderr
+		// ErrWaitDelay means the command succeeded but left a child holding its output.
+		if err := cmd.Run(); err != nil && !errors.Is(err, exec.ErrWaitDelay) {
+			if msg, _, _ := strings.Cut(strings.TrimSpace(stderr.String()), "\n"); msg != "" {
+				return nil, fmt.Errorf("%w: %s", err, trimString(msg, 200))
+			}
+			return nil, err
+		}
+		if stdout.truncated {
+			return nil, fmt.Errorf("output exceeds %d bytes", maxCollectorOutput)
+		}
+		return parse(stdout.Bytes())
+	}, nil
+}
+
+// outputParser returns the parser for the text or json format of a collector.
+func outputParser(cfg *CollectorConfig) (func([]byte) (interface{}, error), error) {
+	switch cfg.Format {
+	case "json":
+		if cfg.Pattern != "" {
+			return nil, errors.New("pattern is only used with the text format")
+		}
+		return parseJSONValue, nil
+	case "text":
+		if cfg.Path != "" {
+			return nil, errors.New("path needs the json format")
+		}
+		var pattern *regexp.Regexp
+		if cfg.Pattern != "" {
+			var err error
+			if pattern, err = regexp.Compile(cfg.Pattern); err != nil {
+				return nil, fmt.Errorf("invalid pattern: %w", err)
+			}
+		}
+		return func(out []byte) (interface{}, error) {
+			text := string(out)
+			if pattern != nil {
+				m := pattern.FindStringSubmatch(text)
+				if m == nil {
+					return nil, errors.New("pattern did not match the output")
+				}
+				text = m[0]
+				if len(m) > 1 {
+					text = m[1]
+				}
+			}
+			return textValue(text), nil
+		}, nil
+	}
+	return nil, fmt.Errorf("unknown format %q (text or json)", cfg.Format)
+}
+
+func parseJSONValue(data []byte) (interface{}, error) {
+	var value interface{}
+	if err := json.Unmarshal(data, &value); err != nil {
+		return nil, fmt.Errorf("invalid JSON: %w", err)
+	}
+	return value, nil
+}
+
+// textValue is a number when s is a finite one, and the trimmed text otherwise.
+func textValue(s string) interface{} {
+	s = strings.TrimSpace(s)
+	if n, err := strconv.ParseFloat(s, 64); err == nil && !math.IsInf(n, 0) && !math.IsNaN(n) {
+		return n
+	}
+	return s
+}
+
+// cappedBuffer keeps the first maxCollectorOutput bytes written to it and drops the
+// rest, so a runaway command cannot exhaust memory.
+type cappedBuffer struct {
+	bytes.Buffer
+	truncated bool
+}
+
+func (b *cappedBuffer) Write(p []byte) (int, error) {
+	if room := maxCollectorOutput - b.Len(); len(p) > room {
+		b.truncated = true
+		b.Buffer.Write(p[:max(room, 0)])
+		return len(p), nil
+	}
+	return b.Buffer.Write(p)
+}
+
+// buildHTTPCollector fetches URL with GET and decodes the JSON body. Any status other
+// than 2xx is an error.
+func buildHTTPCollector(cfg *CollectorConfig) (CollectFunc, error) {
+	u, err := url.Parse(cfg.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, errors.New("url must be an http or https URL")
+	}
+	if len(cfg.Command) > 0 || cfg.Format != "" || cfg.Pattern != "" || cfg.Target != "" || cfg.UPS != "" {
+		return nil, errors.New("command, format, pattern, target and ups are not used by http collectors")
+	}
+	target := cfg.URL
+	headers := make(http.Header, len(cfg.Headers))
+	for key, value := range cfg.Headers {
+		headers.Set(key, value)
+	}
+	if headers.Get("Accept") == "" {
+		headers.Set("Accept", "application/json")
+	}
+	if headers.Get("User-Agent") == "" {
+		headers.Set("User-Agent", "Tabdock-Collector")
+	}
+	client := &http.Client{}
+	return func(ctx context.Context) (interface{}, error) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header = headers.Clone()
+		resp, err := client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode < 200 || resp.StatusCode > 299 {
+			return nil, fmt.Errorf("unexpected status %d", resp.StatusCode)
+		}
+		body, err := io.ReadAll(io.LimitReader(resp.Body, maxCollectorOutput+1))
+		if err != nil {
+			return nil, err
+		}
+		if len(body) > maxCollectorOutput {
+			return nil, fmt.Errorf("response exceeds %d bytes", maxCollectorOutput)
+		}
+		return parseJSONValue(body)
+	}, nil
+}
+
+// buildNUTCollector reads every variable of a UPS from a Network UPS Tools server
+// (upsd) at Target, by default 127.0.0.1:3493. The result is an object such as
+// {"battery.charge": 100, "ups.status": "OL"}; pick one with a path like
+// $['battery.charge'].
+func buildNUTCollector(cfg *CollectorConfig) (CollectFunc, error) {
+	if cfg.UPS == "" || strings.ContainsAny(cfg.UPS, " \r\n\"") {
+		return nil, errors.New("ups must name the UPS as configured in upsd")
+	}
+	if len(cfg.Command) > 0 || cfg.Format != "" || cfg.Pattern != "" || cfg.URL != "" || len(cfg.Headers) > 0 {
+		return nil, errors.New("command, format, pattern, url and headers are not used by nut collectors")
+	}
+	address, err := hostPortWithDefault(cfg.Target, "127.0.0.1", defaultNUTPort)
+	if err != nil {
+		return nil, err
+	}
+	cfg.Target = address
+	ups := cfg.UPS
+	return func(ctx context.Context) (interface{}, error) {
+		var d net.Dialer
+		conn, err := d.DialContext(ctx, "tcp", address)
+		if err != nil {
+			return nil, err
+		}
+		defer conn.Close()
+		if deadline, ok := ctx.Deadline(); ok {
+			if err := conn.SetDeadline(deadline); err != nil {
+				return nil, err
+			}
+		}
+		if _, err := fmt.Fprintf(conn, "LIST VAR %s\n", ups); err != nil {
+			return nil, err
+		}
+
+		vars := map[string]interface{}{}
+		prefix := "VAR " + ups + " "
+		scanner := bufio.NewScanner(io.LimitReader(conn, maxCollectorOutput))
+		for scanner.Scan() {
+			line := scanner.Text()
+			switch {
+			case strings.HasPrefix(line, "ERR "):
+				return nil, fmt.Errorf("upsd: %s", strings.TrimPrefix(line, "ERR "))
+			case strings.HasPrefix(line, "END LIST VAR"):
+				_, _ = io.WriteString(conn, "LOGOUT\n")
+				return vars, nil
+			case strings.HasPrefix(line, prefix):
+				name, quoted, ok := strings.Cut(strings.TrimPrefix(line, prefix), " ")
+				if !ok {
+					continue
+				}
+				value, err := strconv.Unquote(quoted)
+				if err != nil {
+					value = strings.Trim(quoted, `"`)
+				}
+				vars[name] = textValue(value)
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+		return nil, errors.New("upsd closed the connection before the end of the list")
+	}, nil
+}
+
+// buildMinecraftCollector asks a Minecraft Java server at Target (port 25565 by
+// default) for its status with the server list ping. Without a path the number of
+// online players is reported; the whole status, minus the favicon, is available to it.
+func buildMinecraftCollector(cfg *CollectorConfig) (CollectFunc, error) {
+	if cfg.Target == "" {
+		return nil, errors.New("target is required")
+	}
+	if len(cfg.Command) > 0 || cfg.Format != "" || cfg.Pattern != "" || cfg.URL != "" || len(cfg.Headers) > 0 || cfg.UPS != "" {
+		return nil, errors.New("command, format, pattern, url, headers and ups are not used by minecraft collectors")
+	}
+	address, err := hostPortWithDefault(cfg.Target, "", defaultMinecraftPort)
+	if err != nil {
+		return nil, err
+	}
+	cfg.Target = address
+	if cfg.Path == "" {
+		cfg.Path = "$.players.online"
+	}
+	return func(ctx context.Context) (interface{}, error) {
+		return minecraftStatus(ctx, address)
+	}, nil
+}
+
+func hostPortWithDefault(target, defaultHost, defaultPort string) (string, error) {
+	if target == "" {
+		target = defaultHost
+	}
+	if target == "" {
+		return "", errors.New("target is required")
+	}
+	if _, _, err := net.SplitHostPort(target); err != nil {
+		target = net.JoinHostPort(strings.Trim(target, "[]"), defaultPort)
+	}
+	host, port, err := net.SplitHostPort(target)
+	if err != nil || host == "" {
+		return "", fmt.Errorf("invalid target %q", target)
+	}
+	if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+		return "", fmt.Errorf("invalid port in target %q", target)
+	}
+	return target, nil
+}
+
+// minecraftStatus performs the handshake and status request of the server list ping
+// and returns the decoded status document.
+func minecraftStatus(ctx context.Context, address string) (interface{}, error) {
+	host, portText, _ := net.SplitHostPort(address)
+	port, _ := strconv.Atoi(portText)
+
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", address)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		if err := conn.SetDeadline(deadline); err != nil {
+			return nil, err
+		}
+	}
+
+	// Handshake: protocol version -1 (any), server address, port, next state 1 (status).
+	var handshake bytes.Buffer
+	handshake.Write(binary.AppendUvarint(nil, uint64(uint32(0xffffffff))))
+	handshake.Write(binary.AppendUvarint(nil, uint64(len(host))))
+	handshake.WriteString(host)
+	handshake.Write(binary.BigEndian.AppendUint16(nil, uint16(port)))
+	handshake.Write(binary.AppendUvarint(nil, 1))
+	if err := writeMinecraftPacket(conn, 0x00, handshake.Bytes()); err != nil {
+		return nil, err
+	}
+	if err := writeMinecraftPacket(conn, 0x00, nil); err != nil {
+		return nil, err
+	}
+
+	r := bufio.NewReader(conn)
+	length, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, err
+	}
+	if length == 0 || length > maxCollectorOutput {
+		return nil, fmt.Errorf("invalid status packet length %d", length)
+	}
+	packet := make([]byte, length)
+	if _, err := io.ReadFull(r, packet); err != nil {
+		return nil, err
+	}
+	body := bytes.NewReader(packet)
+	if id, err := binary.ReadUvarint(body); err != nil || id != 0x00 {
+		return nil, errors.New("unexpected status packet")
+	}
+	size, err := binary.ReadUvarint(body)
+	if err != nil || size > uint64(body.Len()) {
+		return nil, errors.New("invalid status string")
+	}
+	data := make([]byte, size)
+	if _, err := io.ReadFull(body, data); err != nil {
+		return nil, err
+	}
+
+	status, err := parseJSONValue(data)
+	if err != nil {
+		return nil, err
+	}
+	if object, ok := status.(map[string]interface{}); ok {
+		delete(object, "favicon")
+	}
+	return status, nil
+}
+
+func writeMinecraftPacket(w io.Writer, id byte, payload []byte) error {
+	packet := append([]byte{id}, payload...)
+	_, err := w.Write(append(binary.AppendUvarint(nil, uint64(len(packet))), packet...))
+	return err
+}
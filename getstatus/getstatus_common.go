@@ -6,14 +6,76 @@
 package getstatus
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
 	"os/exec"
 	"runtime"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
+
+	"github.com/shirou/gopsutil/v3/cpu"
+	"github.com/shirou/gopsutil/v3/disk"
+	"github.com/shirou/gopsutil/v3/host"
+	"github.com/shirou/gopsutil/v3/mem"
 )
 
-// PCStatus is the aggregated device status snapshot.
+// PCStatus is the aggregated device status snapshot. The string fields are
+// preformatted for display; the typed fields carry the same readings as numbers.
 type PCStatus struct {
 	PC, Battery, WAN, Uptime, CPU, Mem, GPU0, GPU1, VRAM, DriveC, MainWindow string
+
+	CPUPercent    float64
+	MemUsedBytes  uint64
+	MemTotalBytes uint64
+	MemPercent    float64
+	UptimeSeconds uint64
+	WANOnline     bool
+	// Disks lists mounted filesystems, with the one shown as DriveC first.
+	Disks []DiskStatus
+	// BatteryPercent and BatteryCharging are nil when the host has no battery.
+	BatteryPercent  *float64
+	BatteryCharging *bool
+	// GPUPercent and the VRAM sizes are nil when no NVIDIA GPU was found.
+	GPUPercent     *float64
+	VRAMUsedBytes  *uint64
+	VRAMTotalBytes *uint64
+	// Sessions lists the logged-in users. IdleSeconds is the time since the last
+	// keyboard or mouse input, nil when the platform cannot tell; UserActive is whether
+	// someone used the PC within activeIdleLimit, nil when neither is known.
+	Sessions    []SessionStatus
+	IdleSeconds *uint64
+	UserActive  *bool
+	// Custom holds the fields of the configured collectors by name.
+	Custom map[string]CustomStatus
+
+	// probes are the connectivity probe results behind WAN, reported by StatusV2.
+	probes []ProbeInfo
+}
+
+// DiskStatus is the usage of one mounted filesystem.
+type DiskStatus struct {
+	Mount      string
+	Device     string
+	FSType     string
+	UsedBytes  uint64
+	TotalBytes uint64
+	Percent    float64
+}
+
+// SessionStatus is one logged-in user session. IdleSeconds is the time since input on
+// its terminal, nil for graphical and remote desktop sessions without one.
+type SessionStatus struct {
+	User        string `json:",omitempty"`
+	Terminal    string
+	Host        string `json:",omitempty"`
+	StartedAt   time.Time
+	IdleSeconds *uint64
 }
 
 // Supported OS identifiers and standard status labels.
@@ -38,21 +100,365 @@ const (
 	FieldMainWindow = "MainWindow"
 )
 
-func runPS(cmd string) string {
-	switch runtime.GOOS {
-	case OSWindows:
-		out, err := exec.Command("powershell", "-Command", cmd).Output()
-		if err != nil {
-			return StatusError
+const (
+	// slowProbeTTL is how long results of external tools such as nvidia-smi are reused.
+	slowProbeTTL     = 30 * time.Second
+	slowProbeTimeout = 5 * time.Second
+	// desktopProbeTTL is the shorter reuse period of the front window and idle time,
+	// which change while someone works.
+	desktopProbeTTL = 5 * time.Second
+
+	// activeIdleLimit is how long after the last input the PC still counts as in use.
+	activeIdleLimit = 5 * time.Minute
+
+	gib = 1024 * 1024 * 1024
+	mib = 1024 * 1024
+)
+
+// batteryReading is a battery charge as reported by the platform.
+type batteryReading struct {
+	percent  float64
+	charging bool
+}
+
+// platformReaders supply the platform-specific parts of a snapshot. idle reports the
+// time since the last input of the desktop, and false when it is unknown.
+type platformReaders struct {
+	battery    func() (*batteryReading, error)
+	mainWindow func() string
+	idle       func() (time.Duration, bool)
+}
+
+// collectStatus fills every platform-neutral field of a snapshot. rootPath is the
+// filesystem shown as DriveC.
+func collectStatus(rootPath string, platform platformReaders) *PCStatus {
+	status := &PCStatus{}
+	var wg sync.WaitGroup
+	run := func(fn func()) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			fn()
+		}()
+	}
+
+	run(func() { status.PC = hostName() })
+	run(func() { fillCPU(status) })
+	run(func() { fillMem(status) })
+	run(func() { fillUptime(status) })
+	run(func() { fillDisks(status, rootPath) })
+	run(func() { fillWAN(status) })
+	run(func() { fillBattery(status, platform.battery) })
+	run(func() { status.MainWindow = platform.mainWindow() })
+	run(func() { fillGPU(status) })
+	run(func() { fillActivity(status, platform.idle) })
+	run(func() { status.Custom = customStatus() })
+
+	wg.Wait()
+	status.GPU1 = StatusNA
+	return status
+}
+
+func hostName() string {
+	if info, err := host.Info(); err == nil && info.Hostname != "" {
+		return info.Hostname
+	}
+	if name, err := os.Hostname(); err == nil {
+		return name
+	}
+	return StatusError
+}
+
+// firstCPUSample makes the first reading measure a short interval; gopsutil would
+// otherwise compare it with a baseline taken milliseconds earlier at start-up.
+var firstCPUSample sync.Once
+
+// fillCPU reports usage since the previous call.
+func fillCPU(status *PCStatus) {
+	interval := time.Duration(0)
+	firstCPUSample.Do(func() { interval = 250 * time.Millisecond })
+	p, err := cpu.Percent(interval, false)
+	if err != nil || len(p) == 0 {
+		log.Printf("failed to query CPU usage: %v", err)
+		status.CPU = StatusError
+		return
+	}
+	status.CPUPercent = p[0]
+	status.CPU = fmt.Sprintf("%.0f%%", p[0])
+}
+
+func fillMem(status *PCStatus) {
+	v, err := mem.VirtualMemory()
+	if err != nil {
+		log.Printf("failed to query memory usage: %v", err)
+		status.Mem = StatusError
+		return
+	}
+	status.MemUsedBytes = v.Used
+	status.MemTotalBytes = v.Total
+	status.MemPercent = v.UsedPercent
+	status.Mem = fmt.Sprintf("%.0f%% (%.1fGB/%.1fGB)", v.UsedPercent,
+		float64(v.Used)/1e9, float64(v.Total)/1e9)
+}
+
+func fillUptime(status *PCStatus) {
+	up, err := host.Uptime()
+	if err != nil {
+		log.Printf("failed to query uptime: %v", err)
+		status.Uptime = StatusError
+		return
+	}
+	status.UptimeSeconds = up
+	status.Uptime = fmt.Sprintf("%dd %dh %dm", up/86400, (up%86400)/3600, (up%3600)/60)
+}
+
+func fillDisks(status *PCStatus, rootPath string) {
+	status.DriveC = StatusNA
+	root, err := diskStatus(rootPath, "", "")
+	if err != nil {
+		log.Printf("failed to query usage of %s: %v", rootPath, err)
+	} else {
+		status.DriveC = fmt.Sprintf("%.0f%% (%.1fGiB/%.1fGiB)",
+			root.Percent, float64(root.UsedBytes)/gib, float64(root.TotalBytes)/gib)
+	}
+
+	partitions, err := disk.Partitions(false)
+	if err != nil {
+		log.Printf("failed to list disk partitions: %v", err)
+	}
+	var others []DiskStatus
+	seen := map[string]bool{}
+	for _, p := range partitions {
+		if samePath(p.Mountpoint, rootPath) {
+			root.Device, root.FSType = p.Device, p.Fstype
+			continue
+		}
+		// Bind mounts and snapshots repeat a device under several mount points.
+		if seen[p.Mountpoint] || (p.Device != "" && seen[p.Device]) {
+			continue
+		}
+		seen[p.Mountpoint], seen[p.Device] = true, true
+		if d, err := diskStatus(p.Mountpoint, p.Device, p.Fstype); err == nil && d.TotalBytes > 0 {
+			others = append(others, d)
+		}
+	}
+	if root.TotalBytes > 0 {
+		status.Disks = append(status.Disks, root)
+	}
+	status.Disks = append(status.Disks, others...)
+}
+
+// samePath compares mount points, treating "C:" and `C:\` as the same drive.
+func samePath(a, b string) bool {
+	trim := func(p string) string {
+		if len(p) > 1 {
+			return strings.TrimRight(p, `\/`)
+		}
+		return p
+	}
+	return strings.EqualFold(trim(a), trim(b))
+}
+
+func diskStatus(path, device, fsType string) (DiskStatus, error) {
+	usage, err := disk.Usage(path)
+	if err != nil {
+		return DiskStatus{}, err
+	}
+	if usage == nil {
+		return DiskStatus{}, fmt.Errorf("no usage reported for %s", path)
+	}
+	if fsType == "" {
+		fsType = usage.Fstype
+	}
+	return DiskStatus{
+		Mount:      path,
+		Device:     device,
+		FSType:     fsType,
+		UsedBytes:  usage.Used,
+		TotalBytes: usage.Total,
+		Percent:    usage.UsedPercent,
+	}, nil
+}
+
+func fillBattery(status *PCStatus, battery func() (*batteryReading, error)) {
+	reading, err := battery()
+	if err != nil {
+		log.Printf("failed to query battery: %v", err)
+		status.Battery = StatusError
+		return
+	}
+	if reading == nil {
+		status.Battery = StatusNA
+		return
+	}
+	percent, charging := reading.percent, reading.charging
+	status.BatteryPercent = &percent
+	status.BatteryCharging = &charging
+	status.Battery = fmt.Sprintf("%.0f", percent)
+}
+
+// fillActivity lists the sessions and decides whether someone is using the PC, from the
+// desktop's idle time or else from the most recently used terminal.
+func fillActivity(status *PCStatus, idle func() (time.Duration, bool)) {
+	now := time.Now()
+	status.Sessions = []SessionStatus{}
+	// gopsutil reads the session list from utmp, which Windows and some minimal
+	// systems do not have. Without it the sessions are unknown rather than none.
+	var users []host.UserStat
+	sessionsKnown := false
+	if runtime.GOOS != OSWindows {
+		var err error
+		users, err = host.Users()
+		switch {
+		case err == nil:
+			sessionsKnown = true
+		case !errors.Is(err, fs.ErrNotExist):
+			log.Printf("failed to list user sessions: %v", err)
 		}
-		return strings.TrimSpace(string(out))
-	case OSLinux, OSDarwin:
-		out, err := exec.Command("sh", "-c", cmd).Output()
-		if err != nil {
-			return StatusError
+	}
+	var terminalIdle *uint64
+	for _, u := range users {
+		session := SessionStatus{
+			User:      u.User,
+			Terminal:  u.Terminal,
+			Host:      u.Host,
+			StartedAt: time.Unix(int64(u.Started), 0).UTC(),
+		}
+		if d, ok := ttyIdle(u.Terminal, now); ok {
+			seconds := uint64(d / time.Second)
+			session.IdleSeconds = &seconds
+			if terminalIdle == nil || seconds < *terminalIdle {
+				terminalIdle = &seconds
+			}
 		}
-		return strings.TrimSpace(string(out))
-	default:
-		return StatusNA
+		status.Sessions = append(status.Sessions, session)
+	}
+
+	if d, ok := idle(); ok {
+		seconds := uint64(d / time.Second)
+		status.IdleSeconds = &seconds
+	} else {
+		status.IdleSeconds = terminalIdle
+	}
+	// Nobody is using the machine without a login session, whatever the desktop reports.
+	if sessionsKnown && len(status.Sessions) == 0 {
+		active := false
+		status.IdleSeconds, status.UserActive = nil, &active
+		return
+	}
+	if status.IdleSeconds != nil {
+		active := time.Duration(*status.IdleSeconds)*time.Second < activeIdleLimit
+		status.UserActive = &active
+	}
+}
+
+var nvidiaProbe = &slowProbe{run: func(ctx context.Context) (string, error) {
+	return runCommand(ctx, "nvidia-smi", "--query-gpu=utilization.gpu,memory.used,memory.total",
+		"--format=csv,noheader,nounits")
+}}
+
+// fillGPU reads the first NVIDIA GPU from the cached nvidia-smi output.
+func fillGPU(status *PCStatus) {
+	status.GPU0, status.VRAM = StatusNA, StatusNA
+	out, ok := nvidiaProbe.get()
+	if !ok {
+		return
+	}
+	line, _, _ := strings.Cut(out, "\n")
+	fields := strings.Split(line, ",")
+	if len(fields) != 3 {
+		return
+	}
+	util, err1 := strconv.ParseFloat(strings.TrimSpace(fields[0]), 64)
+	used, err2 := strconv.ParseFloat(strings.TrimSpace(fields[1]), 64)
+	total, err3 := strconv.ParseFloat(strings.TrimSpace(fields[2]), 64)
+	if err1 != nil || err2 != nil || err3 != nil || total <= 0 {
+		return
+	}
+	usedBytes, totalBytes := uint64(used*mib), uint64(total*mib)
+	status.GPUPercent = &util
+	status.VRAMUsedBytes = &usedBytes
+	status.VRAMTotalBytes = &totalBytes
+	status.GPU0 = fmt.Sprintf("%.0f", util)
+	status.VRAM = fmt.Sprintf("%.0f%% (%.1fGB/%.1fGB)", used/total*100, used/1024, total/1024)
+}
+
+// slowProbe caches the output of an external tool and refreshes it in the background,
+// so status requests never wait for a subprocess. Until the first run finishes, and
+// while the tool keeps failing, get reports no value. A zero ttl means slowProbeTTL.
+type slowProbe struct {
+	run func(ctx context.Context) (string, error)
+	ttl time.Duration
+
+	mu        sync.Mutex
+	value     string
+	ok        bool
+	checkedAt time.Time
+	running   bool
+}
+
+func (p *slowProbe) get() (string, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	ttl := p.ttl
+	if ttl == 0 {
+		ttl = slowProbeTTL
+	}
+	if !p.running && time.Since(p.checkedAt) >= ttl {
+		p.running = true
+		go p.refresh()
+	}
+	return p.value, p.ok
+}
+
+func (p *slowProbe) refresh() {
+	ctx, cancel := context.WithTimeout(context.Background(), slowProbeTimeout)
+	defer cancel()
+	value, err := p.run(ctx)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.value, p.ok = value, err == nil
+	p.checkedAt = time.Now()
+	p.running = false
+}
+
+func runCommand(ctx context.Context, name string, args ...string) (string, error) {
+	return runCommandEnv(ctx, nil, name, args...)
+}
+
+// commandWaitDelay is how long a cancelled command may keep its output pipes open,
+// for example through a child it started, before they are closed.
+const commandWaitDelay = 2 * time.Second
+
+// commandContext is exec.CommandContext for status readers. When ctx ends, the
+// command's process group is killed and its pipes are closed after commandWaitDelay,
+// so a command that forks cannot keep a reader waiting past its deadline.
+func commandContext(ctx context.Context, name string, args ...string) *exec.Cmd {
+	cmd := exec.CommandContext(ctx, name, args...)
+	killProcessGroup(cmd)
+	cmd.WaitDelay = commandWaitDelay
+	return cmd
+}
+
+// runCommandEnv runs a command with env added to Tabdock's own environment.
+func runCommandEnv(ctx context.Context, env []string, name string, args ...string) (string, error) {
+	cmd := commandContext(ctx, name, args...)
+	if len(env) > 0 {
+		cmd.Env = append(os.Environ(), env...)
+	}
+	out, err := cmd.Output()
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// trimString shortens s to limit characters, marking the cut with "...".
+func trimString(s string, limit int) string {
+	runes := []rune(s)
+	if len(runes) > limit {
+		return string(runes[:limit]) + "..."
 	}
+	return s
 }
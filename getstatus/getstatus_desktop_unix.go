@@ -0,0 +1,345 @@
+//go:build !windows
+
+// 2025 TabDock: darui3018823 All rights reserved.
+// All works created by darui3018823 associated with this repository are the intellectual property of darui3018823.
+// Packages and other third-party materials used in this repository are subject to their respective licenses and copyrights.
+
+package getstatus
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// desktopEnvKeys locate a user's graphical session and its compositor.
+var desktopEnvKeys = []string{
+	"DISPLAY", "XAUTHORITY", "WAYLAND_DISPLAY", "XDG_RUNTIME_DIR", "XDG_CURRENT_DESKTOP",
+	"DBUS_SESSION_BUS_ADDRESS", "SWAYSOCK", "HYPRLAND_INSTANCE_SIGNATURE",
+}
+
+// desktopEnvTTL is how long a session found in another process is reused.
+const desktopEnvTTL = time.Minute
+
+var (
+	desktopEnvMu    sync.Mutex
+	desktopEnvCache map[string]string
+	desktopEnvAt    time.Time
+
+	errNoDesktop      = errors.New("no graphical session found")
+	errNoActiveWindow = errors.New("compositor does not report the active window")
+
+	xpropWindowID   = regexp.MustCompile(`# (0x[0-9a-fA-F]+)`)
+	xpropString     = regexp.MustCompile(`^\w+ = (".*")$`)
+	firstNumber     = regexp.MustCompile(`\d+`)
+	ioregIdleTimeNs = regexp.MustCompile(`"HIDIdleTime" = (\d+)`)
+)
+
+// desktopEnv returns the variables of the graphical session: Tabdock's own when it runs
+// inside one, otherwise those of a readable process that does, such as the desktop of
+// the logged-in user while Tabdock runs as a service. It is nil without a session.
+func desktopEnv() map[string]string {
+	own := readDesktopEnv(os.Environ())
+	if own["DISPLAY"] != "" || own["WAYLAND_DISPLAY"] != "" {
+		return own
+	}
+
+	desktopEnvMu.Lock()
+	defer desktopEnvMu.Unlock()
+	if time.Since(desktopEnvAt) < desktopEnvTTL {
+		return desktopEnvCache
+	}
+	desktopEnvCache, desktopEnvAt = findDesktopEnv(), time.Now()
+	return desktopEnvCache
+}
+
+// findDesktopEnv scans the environments of running processes, preferring a Wayland
+// session. Processes of other users are only readable by root.
+func findDesktopEnv() map[string]string {
+	entries, err := os.ReadDir("/proc")
+	if err != nil {
+		return nil
+	}
+	var x11 map[string]string
+	for _, entry := range entries {
+		if _, err := strconv.Atoi(entry.Name()); err != nil {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join("/proc", entry.Name(), "environ"))
+		if err != nil {
+			continue
+		}
+		env := readDesktopEnv(strings.Split(string(bytes.TrimRight(data, "\x00")), "\x00"))
+		switch {
+		case env["WAYLAND_DISPLAY"] != "":
+			return env
+		case env["DISPLAY"] != "" && x11 == nil:
+			x11 = env
+		}
+	}
+	return x11
+}
+
+func readDesktopEnv(environ []string) map[string]string {
+	env := map[string]string{}
+	for _, kv := range environ {
+		key, value, ok := strings.Cut(kv, "=")
+		if !ok {
+			continue
+		}
+		for _, k := range desktopEnvKeys {
+			if key == k && value != "" {
+				env[key] = value
+			}
+		}
+	}
+	return env
+}
+
+// runDesktopCommand runs a command inside the graphical session env.
+func runDesktopCommand(ctx context.Context, env map[string]string, name string, args ...string) (string, error) {
+	vars := make([]string, 0, len(env))
+	for key, value := range env {
+		vars = append(vars, key+"="+value)
+	}
+	return runCommandEnv(ctx, vars, name, args...)
+}
+
+// linuxWindowProbe reads the focused window from Hyprland or Sway, or from
+// _NET_ACTIVE_WINDOW on X11. Other Wayland compositors do not expose it to clients.
+var linuxWindowProbe = &slowProbe{ttl: desktopProbeTTL, run: func(ctx context.Context) (string, error) {
+	env := desktopEnv()
+	switch {
+	case env["HYPRLAND_INSTANCE_SIGNATURE"] != "":
+		return hyprlandActiveWindow(ctx, env)
+	case env["SWAYSOCK"] != "":
+		return swayActiveWindow(ctx, env)
+	case env["WAYLAND_DISPLAY"] != "":
+		// XWayland's _NET_ACTIVE_WINDOW only covers X clients, so it would mislead.
+		return "", errNoActiveWindow
+	case env["DISPLAY"] != "":
+		return x11ActiveWindow(ctx, env)
+	}
+	return "", errNoDesktop
+}}
+
+func hyprlandActiveWindow(ctx context.Context, env map[string]string) (string, error) {
+	out, err := runDesktopCommand(ctx, env, "hyprctl", "activewindow", "-j")
+	if err != nil {
+		return "", err
+	}
+	var window struct {
+		Class string `json:"class"`
+		Title string `json:"title"`
+	}
+	if err := json.Unmarshal([]byte(out), &window); err != nil {
+		return "", err
+	}
+	return windowLabel(window.Title, window.Class), nil
+}
+
+// swayNode is the part of a node of "swaymsg -t get_tree" needed to find the focus.
+type swayNode struct {
+	Name          string     `json:"name"`
+	Type          string     `json:"type"`
+	Focused       bool       `json:"focused"`
+	AppID         string     `json:"app_id"`
+	Nodes         []swayNode `json:"nodes"`
+	FloatingNodes []swayNode `json:"floating_nodes"`
+}
+
+func (n *swayNode) focused() *swayNode {
+	if n.Focused {
+		return n
+	}
+	for _, children := range [][]swayNode{n.Nodes, n.FloatingNodes} {
+		for i := range children {
+			if found := children[i].focused(); found != nil {
+				return found
+			}
+		}
+	}
+	return nil
+}
+
+func swayActiveWindow(ctx context.Context, env map[string]string) (string, error) {
+	out, err := runDesktopCommand(ctx, env, "swaymsg", "-t", "get_tree", "-r")
+	if err != nil {
+		return "", err
+	}
+	var tree swayNode
+	if err := json.Unmarshal([]byte(out), &tree); err != nil {
+		return "", err
+	}
+	// An empty workspace holds the focus when no window has it.
+	node := tree.focused()
+	if node == nil || (node.Type != "con" && node.Type != "floating_con") {
+		return windowLabel("", ""), nil
+	}
+	return windowLabel(node.Name, node.AppID), nil
+}
+
+func x11ActiveWindow(ctx context.Context, env map[string]string) (string, error) {
+	out, err := runDesktopCommand(ctx, env, "xprop", "-root", "-notype", "_NET_ACTIVE_WINDOW")
+	if err != nil {
+		return "", err
+	}
+	m := xpropWindowID.FindStringSubmatch(out)
+	if m == nil {
+		return "", errNoActiveWindow // the window manager does not set it
+	}
+	if id, _ := strconv.ParseUint(m[1], 0, 64); id == 0 {
+		return windowLabel("", ""), nil
+	}
+
+	out, err = runDesktopCommand(ctx, env, "xprop", "-id", m[1], "-notype", "_NET_WM_NAME", "WM_NAME", "WM_CLASS")
+	if err != nil {
+		return "", err
+	}
+	var title, class string
+	for _, line := range strings.Split(out, "\n") {
+		value := xpropString.FindStringSubmatch(strings.TrimSpace(line))
+		if value == nil {
+			continue
+		}
+		quoted := value[1]
+		if strings.HasPrefix(line, "WM_CLASS") {
+			quoted, _, _ = strings.Cut(quoted, ", ") // "instance", "Class"
+		}
+		text, err := strconv.Unquote(quoted)
+		if err != nil {
+			text = strings.Trim(quoted, `"`)
+		}
+		switch {
+		case strings.HasPrefix(line, "_NET_WM_NAME") || (strings.HasPrefix(line, "WM_NAME") && title == ""):
+			title = text
+		case strings.HasPrefix(line, "WM_CLASS"):
+			class = text
+		}
+	}
+	return windowLabel(title, class), nil
+}
+
+// windowLabel shows a window by its title, or by its application when it has none.
+func windowLabel(title, app string) string {
+	title = strings.TrimSpace(title)
+	if title == "" {
+		title = strings.TrimSpace(app)
+	}
+	if title == "" {
+		return "None"
+	}
+	return trimString(title, 50)
+}
+
+// linuxIdleProbe reports the milliseconds since the last input: from xprintidle on
+// X11 or Mutter's idle monitor on GNOME, and otherwise from logind, which knows it
+// for terminals and for desktops that report their idle hint.
+var linuxIdleProbe = &slowProbe{ttl: desktopProbeTTL, run: func(ctx context.Context) (string, error) {
+	env := desktopEnv()
+	if env["DISPLAY"] != "" && env["WAYLAND_DISPLAY"] == "" {
+		if out, err := runDesktopCommand(ctx, env, "xprintidle"); err == nil {
+			return out, nil
+		}
+	}
+	if strings.Contains(env["XDG_CURRENT_DESKTOP"], "GNOME") {
+		out, err := runDesktopCommand(ctx, env, "gdbus", "call", "--session",
+			"--dest", "org.gnome.Mutter.IdleMonitor",
+			"--object-path", "/org/gnome/Mutter/IdleMonitor/Core",
+			"--method", "org.gnome.Mutter.IdleMonitor.GetIdletime")
+		if ms := firstNumber.FindString(out); err == nil && ms != "" {
+			return ms, nil
+		}
+	}
+	return logindIdle(ctx)
+}}
+
+// logindIdle reads the idle hint logind keeps over all sessions. IdleSinceHint is in
+// microseconds since the epoch and marks when the hint last changed.
+func logindIdle(ctx context.Context) (string, error) {
+	out, err := runCommand(ctx, "loginctl", "show-session", "-p", "IdleHint", "-p", "IdleSinceHint")
+	if err != nil {
+		return "", err
+	}
+	props := map[string]string{}
+	for _, line := range strings.Split(out, "\n") {
+		if key, value, ok := strings.Cut(line, "="); ok {
+			props[key] = value
+		}
+	}
+	switch props["IdleHint"] {
+	case "no":
+		return "0", nil
+	case "yes":
+		since, err := strconv.ParseInt(props["IdleSinceHint"], 10, 64)
+		if err != nil || since <= 0 {
+			return "", errors.New("logind did not report when the session became idle")
+		}
+		return strconv.FormatInt(time.Since(time.UnixMicro(since)).Milliseconds(), 10), nil
+	}
+	return "", errors.New("logind did not report an idle hint")
+}
+
+func getLinuxIdle() (time.Duration, bool) {
+	return millisecondsProbe(linuxIdleProbe)
+}
+
+// Darwin
+var darwinIdleProbe = &slowProbe{ttl: desktopProbeTTL, run: func(ctx context.Context) (string, error) {
+	out, err := runCommand(ctx, "ioreg", "-c", "IOHIDSystem", "-d", "4")
+	if err != nil {
+		return "", err
+	}
+	m := ioregIdleTimeNs.FindStringSubmatch(out)
+	if m == nil {
+		return "", errors.New("HIDIdleTime not found")
+	}
+	ns, err := strconv.ParseInt(m[1], 10, 64)
+	if err != nil {
+		return "", err
+	}
+	return strconv.FormatInt(ns/int64(time.Millisecond), 10), nil
+}}
+
+func getDarwinIdle() (time.Duration, bool) {
+	return millisecondsProbe(darwinIdleProbe)
+}
+
+func millisecondsProbe(p *slowProbe) (time.Duration, bool) {
+	out, ok := p.get()
+	if !ok {
+		return 0, false
+	}
+	ms, err := strconv.ParseInt(strings.TrimSpace(out), 10, 64)
+	if err != nil || ms < 0 {
+		return 0, false
+	}
+	return time.Duration(ms) * time.Millisecond, true
+}
+
+// ttyIdle is the time since input on a terminal, from the access time of its device as
+// w(1) reports it. Graphical sessions such as ":0" have no device.
+func ttyIdle(terminal string, now time.Time) (time.Duration, bool) {
+	if terminal == "" || strings.HasPrefix(terminal, ":") || strings.Contains(terminal, "..") {
+		return 0, false
+	}
+	var st unix.Stat_t
+	if err := unix.Stat(filepath.Join("/dev", terminal), &st); err != nil {
+		return 0, false
+	}
+	idle := now.Sub(time.Unix(st.Atim.Unix()))
+	if idle < 0 {
+		idle = 0
+	}
+	return idle, true
+}
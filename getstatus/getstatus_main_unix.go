@@ -7,154 +7,124 @@
 package getstatus
 
 import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
 	"runtime"
-	"sync"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
 )
 
-// Darwin
-func getDarwinStatus() (*PCStatus, error) {
-	status := &PCStatus{}
-	type fieldResult struct {
-		field string
-		value string
-	}
+const powerSupplyDir = "/sys/class/power_supply"
 
-	commands := map[string]string{
-		FieldPC:         `scutil --get ComputerName`,
-		FieldBattery:    `pmset -g batt | grep -Eo "\d+%" | head -1`,
-		FieldWAN:        `ping -c 1 -W 1 1.1.1.1 >/dev/null; if ($?) { "Active" } else { "Offline" }`,
-		FieldUptime:     `uptime | awk '{print $3 " " $4 " " $5}'`,
-		FieldCPU:        `ps -A -o %cpu | awk '{s+=$1} END {print s "%"}'`,
-		FieldMem:        `vm_stat | grep "Pages active" | awk '{print $3}'`,
-		FieldDriveC:     `df -h / | awk 'NR==2{print $5 " (" $3 "/" $2 ")"}'`,
-		FieldMainWindow: `osascript -e 'tell application "System Events" to get name of (processes where frontmost is true)'`,
+// killProcessGroup starts cmd in a process group of its own and makes cancellation
+// kill the whole group, including children the command started.
+func killProcessGroup(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error {
+		return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
 	}
+}
 
-	results := make(chan fieldResult, len(commands))
-	var wg sync.WaitGroup
+// Darwin
+var (
+	pmsetProbe = &slowProbe{run: func(ctx context.Context) (string, error) {
+		return runCommand(ctx, "pmset", "-g", "batt")
+	}}
+	frontmostProbe = &slowProbe{ttl: desktopProbeTTL, run: func(ctx context.Context) (string, error) {
+		return runCommand(ctx, "osascript", "-e",
+			`tell application "System Events" to get name of (processes where frontmost is true)`)
+	}}
+	pmsetPercent = regexp.MustCompile(`(\d+)%;\s*([a-zA-Z ]+)`)
+)
 
-	for field, cmd := range commands {
-		wg.Add(1)
-		go func(field, cmd string) {
-			defer wg.Done()
-			results <- fieldResult{field: field, value: runPS(cmd)}
-		}(field, cmd)
+// getDarwinBattery parses the cached pmset output, such as "85%; discharging".
+func getDarwinBattery() (*batteryReading, error) {
+	out, ok := pmsetProbe.get()
+	if !ok {
+		return nil, nil
 	}
-
-	wg.Wait()
-	close(results)
-
-	for res := range results {
-		switch res.field {
-		case FieldPC:
-			status.PC = res.value
-		case FieldBattery:
-			status.Battery = res.value
-		case FieldWAN:
-			status.WAN = res.value
-		case FieldUptime:
-			status.Uptime = res.value
-		case FieldCPU:
-			status.CPU = res.value
-		case FieldMem:
-			status.Mem = res.value
-		case FieldDriveC:
-			status.DriveC = res.value
-		case FieldMainWindow:
-			status.MainWindow = res.value
-		}
+	m := pmsetPercent.FindStringSubmatch(out)
+	if m == nil {
+		return nil, nil
 	}
-
-	status.GPU0 = StatusNA // macOSでは実装予定なし
-	status.VRAM = StatusNA // macOSでは実装予定なし
-
-	return status, nil
+	percent, err := strconv.ParseFloat(m[1], 64)
+	if err != nil {
+		return nil, err
+	}
+	state := strings.TrimSpace(m[2])
+	return &batteryReading{percent: percent, charging: state == "charging" || state == "charged"}, nil
 }
 
-// Linux
-func getLinuxStatus() (*PCStatus, error) {
-	status := &PCStatus{}
-	type fieldResult struct {
-		field string
-		value string
+func getDarwinMainWindow() string {
+	if name, ok := frontmostProbe.get(); ok && name != "" {
+		return name
 	}
+	return StatusNA
+}
 
-	commands := map[string]string{
-		FieldPC:      `hostname`,
-		FieldBattery: `cat /sys/class/power_supply/BAT0/capacity`,
-		FieldWAN:     `ping -c 1 -W 1 1.1.1.1 >/dev/null; if ($?) { "Active" } else { "Offline" }`,
-		FieldUptime:  `uptime -p`,
-		FieldCPU:     `top -bn1 | grep "Cpu(s)" | awk '{print $2 + $4}' | ForEach-Object { "$_%" }`,
-		FieldMem:     `free -m | awk 'NR==2{printf "%.0f%% (%dMB/%dMB)", $3*100/$2, $3, $2 }'`,
-		FieldGPU0:    `nvidia-smi --query-gpu=utilization.gpu --format=csv,noheader,nounits | Select-Object -First 1`,
-		FieldVRAM: `nvidia-smi --query-gpu=memory.used,memory.total --format=csv,noheader,nounits | ForEach-Object {
-        $p=$_.Split(",");$u=[double]$p[0];$t=[double]$p[1];$pr=[math]::Round(($u/$t)*100,0);
-        "$pr% ($u" + "MiB/$t" + "MiB)"
-    } | Select-Object -First 1`,
-		FieldDriveC: `df -h / | awk 'NR==2{print $5 " (" $3 "/" $2 ")"}'`,
+// Linux
+// getLinuxBattery reads the first battery under /sys/class/power_supply.
+func getLinuxBattery() (*batteryReading, error) {
+	entries, err := os.ReadDir(powerSupplyDir)
+	if os.IsNotExist(err) {
+		return nil, nil
 	}
-
-	results := make(chan fieldResult, len(commands))
-	var wg sync.WaitGroup
-
-	for field, cmd := range commands {
-		wg.Add(1)
-		go func(field, cmd string) {
-			defer wg.Done()
-			results <- fieldResult{field: field, value: runPS(cmd)}
-		}(field, cmd)
+	if err != nil {
+		return nil, err
 	}
-
-	wg.Wait()
-	close(results)
-
-	for res := range results {
-		switch res.field {
-		case FieldPC:
-			status.PC = res.value
-		case FieldBattery:
-			status.Battery = res.value
-		case FieldWAN:
-			status.WAN = res.value
-		case FieldUptime:
-			status.Uptime = res.value
-		case FieldCPU:
-			status.CPU = res.value
-		case FieldMem:
-			status.Mem = res.value
-		case FieldGPU0:
-			status.GPU0 = res.value
-		case FieldVRAM:
-			status.VRAM = res.value
-		case FieldDriveC:
-			status.DriveC = res.value
+	for _, entry := range entries {
+		dir := filepath.Join(powerSupplyDir, entry.Name())
+		if readSysfs(dir, "type") != "Battery" {
+			continue
 		}
+		capacity := readSysfs(dir, "capacity")
+		if capacity == "" {
+			continue
+		}
+		percent, err := strconv.ParseFloat(capacity, 64)
+		if err != nil {
+			return nil, err
+		}
+		state := readSysfs(dir, "status")
+		return &batteryReading{percent: percent, charging: state == "Charging" || state == "Full"}, nil
 	}
+	return nil, nil
+}
 
-	status.MainWindow = StatusNA // Linuxでは未実装
+func readSysfs(dir, name string) string {
+	data, err := os.ReadFile(filepath.Join(dir, name))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(data))
+}
 
-	return status, nil
+// getLinuxMainWindow reports the focused window, or N/A without a graphical session
+// or on a compositor that does not expose it.
+func getLinuxMainWindow() string {
+	if title, ok := linuxWindowProbe.get(); ok && title != "" {
+		return title
+	}
+	return StatusNA
 }
 
 // GetStatus returns the current PC status for Unix systems (Linux/macOS)
 func GetStatus() (*PCStatus, error) {
 	switch runtime.GOOS {
-	case "linux":
-		return getLinuxStatus()
-	case "darwin":
-		return getDarwinStatus()
+	case OSLinux:
+		return collectStatus("/", platformReaders{getLinuxBattery, getLinuxMainWindow, getLinuxIdle}), nil
+	case OSDarwin:
+		return collectStatus("/", platformReaders{getDarwinBattery, getDarwinMainWindow, getDarwinIdle}), nil
 	default:
-		return &PCStatus{
-			PC:         "Unknown",
-			Battery:    StatusNA,
-			WAN:        StatusNA,
-			Uptime:     StatusNA,
-			CPU:        StatusNA,
-			Mem:        StatusNA,
-			GPU0:       StatusNA,
-			VRAM:       StatusNA,
-			DriveC:     StatusNA,
-			MainWindow: StatusNA,
-		}, nil
+		return collectStatus("/", platformReaders{
+			battery:    func() (*batteryReading, error) { return nil, nil },
+			mainWindow: func() string { return StatusNA },
+			idle:       func() (time.Duration, bool) { return 0, false },
+		}), nil
 	}
 }
@@ -7,172 +7,66 @@
 package getstatus
 
 import (
-	"fmt"
 	"log"
-	"net"
-	"os"
-	"strings"
-	"sync"
+	"os/exec"
 	"syscall"
 	"time"
 	"unsafe"
 
-	"github.com/shirou/gopsutil/v3/cpu"
-	"github.com/shirou/gopsutil/v3/disk"
-	"github.com/shirou/gopsutil/v3/host"
-	"github.com/shirou/gopsutil/v3/mem"
 	"golang.org/x/sys/windows"
 )
 
+// killProcessGroup keeps the default cancellation, which kills the command itself.
+// Children it started are left running, but commandWaitDelay still closes the pipes.
+func killProcessGroup(cmd *exec.Cmd) {}
+
 // Windows
 var (
 	user32             = windows.NewLazySystemDLL("user32.dll")
 	procGetForeground  = user32.NewProc("GetForegroundWindow")
 	procGetWindowTextW = user32.NewProc("GetWindowTextW")
-)
-
-func getWindowsStatus() (*PCStatus, error) {
-	status := &PCStatus{}
-	type fieldResult struct {
-		field string
-		value string
-	}
-
-	tasks := map[string]func() string{
-		"PC":         getPCName,
-		"Battery":    getBattery,
-		"WAN":        getWAN,
-		"Uptime":     getUptime,
-		"CPU":        getCPU,
-		"Mem":        getMem,
-		"GPU0":       getGPU,
-		"VRAM":       getVRAM,
-		"DriveC":     getDriveC,
-		"MainWindow": getMainWindow,
-	}
-
-	results := make(chan fieldResult, len(tasks))
-	var wg sync.WaitGroup
+	procGetLastInput   = user32.NewProc("GetLastInputInfo")
 
-	for field, fn := range tasks {
-		wg.Add(1)
-		go func(field string, fn func() string) {
-			defer wg.Done()
-			results <- fieldResult{field: field, value: fn()}
-		}(field, fn)
-	}
-
-	wg.Wait()
-	close(results)
-
-	for res := range results {
-		switch res.field {
-		case "PC":
-			status.PC = res.value
-		case "Battery":
-			status.Battery = res.value
-		case "WAN":
-			status.WAN = res.value
-		case "Uptime":
-			status.Uptime = res.value
-		case "CPU":
-			status.CPU = res.value
-		case "Mem":
-			status.Mem = res.value
-		case "GPU0":
-			status.GPU0 = res.value
-		case "VRAM":
-			status.VRAM = res.value
-		case "DriveC":
-			status.DriveC = res.value
-		case "MainWindow":
-			status.MainWindow = res.value
-		}
-	}
-
-	return status, nil
-}
+	kernel32                 = windows.NewLazySystemDLL("kernel32.dll")
+	procGetSystemPowerStatus = kernel32.NewProc("GetSystemPowerStatus")
+	procGetTickCount         = kernel32.NewProc("GetTickCount")
+)
 
-func getPCName() string {
-	name, _ := os.Hostname()
-	return name
+// lastInputInfo mirrors LASTINPUTINFO.
+type lastInputInfo struct {
+	cbSize uint32
+	dwTime uint32
 }
 
-func getBattery() string {
-	return runPS(`(Get-CimInstance Win32_Battery).EstimatedChargeRemaining`)
+// systemPowerStatus mirrors SYSTEM_POWER_STATUS.
+type systemPowerStatus struct {
+	ACLineStatus        byte
+	BatteryFlag         byte
+	BatteryLifePercent  byte
+	SystemStatusFlag    byte
+	BatteryLifeTime     uint32
+	BatteryFullLifeTime uint32
 }
 
-func getWAN() string {
-	conn, err := net.DialTimeout("tcp", "1.1.1.1:53", 200*time.Millisecond)
-	if err != nil {
-		return "Offline"
-	}
-	if closeErr := conn.Close(); closeErr != nil {
-		log.Printf("failed to close WAN probe connection: %v", closeErr)
-	}
-	return "Active"
-}
-
-func getCPU() string {
-	p, _ := cpu.Percent(0, false)
-	if len(p) > 0 {
-		return strings.TrimSpace(fmt.Sprintf("%.0f%%", p[0]))
-	}
-	return "Unavailable"
-}
-
-func getMem() string {
-	v, _ := mem.VirtualMemory()
-	return fmt.Sprintf("%.0f%% (%.1fGB/%.1fGB)", v.UsedPercent,
-		float64(v.Used)/1e9, float64(v.Total)/1e9)
-}
+const (
+	batteryFlagCharging  = 8
+	batteryFlagNoBattery = 128
+	batteryUnknown       = 255
+)
 
-func getDriveC() string {
-	usage, err := disk.Usage("C:\\")
-	if err != nil {
-		log.Printf("failed to query drive C usage: %v", err)
-		return StatusNA
+func getBattery() (*batteryReading, error) {
+	var sps systemPowerStatus
+	if ok, _, callErr := procGetSystemPowerStatus.Call(uintptr(unsafe.Pointer(&sps))); ok == 0 {
+		return nil, callErr
 	}
-	if usage == nil {
-		log.Printf("drive C usage result is nil")
-		return StatusNA
-	}
-
-	const gib = 1024 * 1024 * 1024
-	return fmt.Sprintf("%.0f%% (%.1fGiB/%.1fGiB)",
-		usage.UsedPercent, float64(usage.Used)/float64(gib), float64(usage.Total)/float64(gib))
-}
-
-func getUptime() string {
-	up, _ := host.Uptime()
-	d := up / 86400
-	h := (up % 86400) / 3600
-	m := (up % 3600) / 60
-	return fmt.Sprintf("%dd %dh %dm", d, h, m)
-}
-
-func getGPU() string {
-	return runPS(`nvidia-smi --query-gpu=utilization.gpu --format=csv,noheader,nounits | Select-Object -First 1`)
-}
-
-func getVRAM() string {
-	return runPS(`nvidia-smi --query-gpu=memory.used,memory.total --format=csv,noheader,nounits | ForEach-Object {
-        $p=$_.Split(",")
-        $u=[double]$p[0]
-        $t=[double]$p[1]
-        $pr=[math]::Round(($u/$t)*100,0)
-        $uGB = [math]::Round($u/1024,1)
-        $tGB = [math]::Round($t/1024,1)
-        "$pr% ($uGB" + "GB/$tGB" + "GB)"
-    } | Select-Object -First 1`)
-}
-
-func trimString(s string, limit int) string {
-	runes := []rune(s)
-	if len(runes) > limit {
-		return string(runes[:limit]) + "..."
+	if sps.BatteryFlag == batteryUnknown || sps.BatteryFlag&batteryFlagNoBattery != 0 ||
+		sps.BatteryLifePercent == batteryUnknown {
+		return nil, nil
 	}
-	return s
+	return &batteryReading{
+		percent:  float64(sps.BatteryLifePercent),
+		charging: sps.BatteryFlag&batteryFlagCharging != 0,
+	}, nil
 }
 
 func getMainWindow() string {
@@ -189,7 +83,25 @@ func getMainWindow() string {
 	return trimString(title, 50)
 }
 
+// getIdle reports the time since the last input of the session Tabdock runs in, which
+// for a service is not the interactive desktop.
+func getIdle() (time.Duration, bool) {
+	info := lastInputInfo{cbSize: uint32(unsafe.Sizeof(lastInputInfo{}))}
+	if ok, _, _ := procGetLastInput.Call(uintptr(unsafe.Pointer(&info))); ok == 0 {
+		return 0, false
+	}
+	// Both are milliseconds since boot that wrap every 49.7 days; the unsigned
+	// difference stays correct across the wrap.
+	now, _, _ := procGetTickCount.Call()
+	return time.Duration(uint32(now)-info.dwTime) * time.Millisecond, true
+}
+
+// ttyIdle is not available on Windows, which has no terminal devices.
+func ttyIdle(string, time.Time) (time.Duration, bool) {
+	return 0, false
+}
+
 // GetStatus returns the current PC status for Windows
 func GetStatus() (*PCStatus, error) {
-	return getWindowsStatus()
+	return collectStatus(`C:\`, platformReaders{getBattery, getMainWindow, getIdle}), nil
 }
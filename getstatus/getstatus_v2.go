@@ -0,0 +1,473 @@
+// 2025 TabDock: darui3018823 All rights reserved.
+// All works created by darui3018823 associated with this repository are the intellectual property of darui3018823.
+// Packages and other third-party materials used in this repository are subject to their respective licenses and copyrights.
+
+package getstatus
+
+import (
+	"log"
+	"net"
+	"runtime"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/shirou/gopsutil/v3/host"
+	"github.com/shirou/gopsutil/v3/load"
+	"github.com/shirou/gopsutil/v3/mem"
+	psnet "github.com/shirou/gopsutil/v3/net"
+	"github.com/shirou/gopsutil/v3/process"
+)
+
+// StatusV2Version is the schema version reported by StatusV2.
+const StatusV2Version = 2
+
+// minRateInterval is the shortest gap between two samples used to compute a rate.
+// Closer requests reuse the previous rates instead of measuring a few milliseconds.
+const minRateInterval = time.Second
+
+// StatusV2 is the structured status snapshot served by /api/v2/status.
+type StatusV2 struct {
+	Version      int                   `json:"version"`
+	CollectedAt  time.Time             `json:"collectedAt"`
+	Host         HostInfo              `json:"host"`
+	CPU          CPUInfo               `json:"cpu"`
+	Memory       UsageInfo             `json:"memory"`
+	Swap         *UsageInfo            `json:"swap"`
+	Mounts       []MountInfo           `json:"mounts"`
+	Network      []InterfaceInfo       `json:"network"`
+	Temperatures []TemperatureInfo     `json:"temperatures"`
+	Battery      *BatteryInfo          `json:"battery"`
+	GPUs         []GPUInfo             `json:"gpus"`
+	WAN          WANInfo               `json:"wan"`
+	MainWindow   string                `json:"mainWindow"`
+	Activity     ActivityInfo          `json:"activity"`
+	Custom       map[string]CustomInfo `json:"custom"`
+	Processes    ProcessRankingInfo    `json:"processes"`
+}
+
+// HostInfo identifies the machine.
+type HostInfo struct {
+	Name          string `json:"name"`
+	OS            string `json:"os"`
+	Platform      string `json:"platform,omitempty"`
+	UptimeSeconds uint64 `json:"uptimeSeconds"`
+}
+
+// CPUInfo is the overall CPU usage. Load is nil where the OS has no load average.
+type CPUInfo struct {
+	Percent float64      `json:"percent"`
+	Cores   int          `json:"cores"`
+	Load    *LoadAverage `json:"load"`
+}
+
+// LoadAverage is the 1, 5 and 15 minute load average.
+type LoadAverage struct {
+	Load1  float64 `json:"load1"`
+	Load5  float64 `json:"load5"`
+	Load15 float64 `json:"load15"`
+}
+
+// UsageInfo is the usage of a memory pool.
+type UsageInfo struct {
+	UsedBytes  uint64  `json:"usedBytes"`
+	TotalBytes uint64  `json:"totalBytes"`
+	Percent    float64 `json:"percent"`
+}
+
+// MountInfo is the usage of one mounted filesystem.
+type MountInfo struct {
+	Mount      string  `json:"mount"`
+	Device     string  `json:"device,omitempty"`
+	FSType     string  `json:"fsType,omitempty"`
+	UsedBytes  uint64  `json:"usedBytes"`
+	TotalBytes uint64  `json:"totalBytes"`
+	Percent    float64 `json:"percent"`
+}
+
+// InterfaceInfo is the traffic of one network interface. The per-second rates are
+// nil until two samples at least minRateInterval apart have been taken.
+type InterfaceInfo struct {
+	Name          string   `json:"name"`
+	Up            bool     `json:"up"`
+	Loopback      bool     `json:"loopback"`
+	RxBytes       uint64   `json:"rxBytes"`
+	TxBytes       uint64   `json:"txBytes"`
+	RxBytesPerSec *float64 `json:"rxBytesPerSec"`
+	TxBytesPerSec *float64 `json:"txBytesPerSec"`
+	RxErrors      uint64   `json:"rxErrors"`
+	TxErrors      uint64   `json:"txErrors"`
+	RxDropped     uint64   `json:"rxDropped"`
+	TxDropped     uint64   `json:"txDropped"`
+}
+
+// TemperatureInfo is one sensor reading in degrees Celsius. High and Critical are 0
+// when the sensor does not report them.
+type TemperatureInfo struct {
+	Sensor   string  `json:"sensor"`
+	Celsius  float64 `json:"celsius"`
+	High     float64 `json:"high,omitempty"`
+	Critical float64 `json:"critical,omitempty"`
+}
+
+// BatteryInfo is the battery charge.
+type BatteryInfo struct {
+	Percent  float64 `json:"percent"`
+	Charging bool    `json:"charging"`
+}
+
+// GPUInfo is the load of one GPU.
+type GPUInfo struct {
+	Index          int     `json:"index"`
+	Percent        float64 `json:"percent"`
+	VRAMUsedBytes  uint64  `json:"vramUsedBytes"`
+	VRAMTotalBytes uint64  `json:"vramTotalBytes"`
+}
+
+// WANInfo is the result of the connectivity probes. Online is true when any probe
+// marked as a WAN probe is up.
+type WANInfo struct {
+	Online bool        `json:"online"`
+	Probes []ProbeInfo `json:"probes"`
+}
+
+// ActivityInfo tells whether someone is using the machine. IdleSeconds is the time
+// since the last keyboard or mouse input and Active whether that is under five minutes;
+// both are nil when the platform cannot tell.
+type ActivityInfo struct {
+	Active      *bool         `json:"active"`
+	IdleSeconds *uint64       `json:"idleSeconds"`
+	Sessions    []SessionInfo `json:"sessions"`
+}
+
+// SessionInfo is one logged-in user session. IdleSeconds is nil for sessions without
+// a terminal device, such as graphical ones.
+type SessionInfo struct {
+	User        string    `json:"user,omitempty"`
+	Terminal    string    `json:"terminal"`
+	Host        string    `json:"host,omitempty"`
+	StartedAt   time.Time `json:"startedAt"`
+	IdleSeconds *uint64   `json:"idleSeconds"`
+}
+
+// CustomInfo is the value of a configured collector. Value and UpdatedAt are from the
+// last successful collection and stay while Error reports a failing one.
+type CustomInfo struct {
+	Label     string      `json:"label"`
+	Unit      string      `json:"unit,omitempty"`
+	Value     interface{} `json:"value"`
+	Error     string      `json:"error,omitempty"`
+	UpdatedAt *time.Time  `json:"updatedAt"`
+	Public    bool        `json:"public,omitempty"`
+}
+
+// ProcessRankingInfo lists the busiest processes.
+type ProcessRankingInfo struct {
+	Total    int           `json:"total"`
+	ByCPU    []ProcessInfo `json:"byCpu"`
+	ByMemory []ProcessInfo `json:"byMemory"`
+}
+
+// ProcessInfo is the resource usage of one process. CPUPercent is relative to one
+// core, so a busy multi-threaded process can exceed 100.
+type ProcessInfo struct {
+	PID        int32   `json:"pid"`
+	Name       string  `json:"name"`
+	CPUPercent float64 `json:"cpuPercent"`
+	RSSBytes   uint64  `json:"rssBytes"`
+	MemPercent float64 `json:"memPercent"`
+}
+
+// GetStatusV2 returns a structured snapshot with the top processes limited to topN.
+func GetStatusV2(topN int) (*StatusV2, error) {
+	_, status, err := Collect(topN)
+	return status, err
+}
+
+// Collect returns both snapshot formats from a single collection.
+func Collect(topN int) (*PCStatus, *StatusV2, error) {
+	base, err := GetStatus()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	status := &StatusV2{
+		Version:     StatusV2Version,
+		CollectedAt: time.Now().UTC(),
+		Host: HostInfo{
+			Name:          base.PC,
+			OS:            runtime.GOOS,
+			UptimeSeconds: base.UptimeSeconds,
+		},
+		CPU:    CPUInfo{Percent: base.CPUPercent, Cores: runtime.NumCPU()},
+		Memory: UsageInfo{UsedBytes: base.MemUsedBytes, TotalBytes: base.MemTotalBytes, Percent: base.MemPercent},
+		Mounts: make([]MountInfo, 0, len(base.Disks)),
+		GPUs:   []GPUInfo{},
+		WAN:    WANInfo{Online: base.WANOnline, Probes: base.probes},
+
+		MainWindow: base.MainWindow,
+		Activity: ActivityInfo{
+			Active:      base.UserActive,
+			IdleSeconds: base.IdleSeconds,
+			Sessions:    make([]SessionInfo, 0, len(base.Sessions)),
+		},
+		Custom: make(map[string]CustomInfo, len(base.Custom)),
+	}
+	for _, d := range base.Disks {
+		status.Mounts = append(status.Mounts, MountInfo(d))
+	}
+	for _, s := range base.Sessions {
+		status.Activity.Sessions = append(status.Activity.Sessions, SessionInfo(s))
+	}
+	for name, c := range base.Custom {
+		status.Custom[name] = CustomInfo(c)
+	}
+	if base.BatteryPercent != nil {
+		status.Battery = &BatteryInfo{Percent: *base.BatteryPercent, Charging: *base.BatteryCharging}
+	}
+	if base.GPUPercent != nil {
+		status.GPUs = append(status.GPUs, GPUInfo{
+			Percent:        *base.GPUPercent,
+			VRAMUsedBytes:  *base.VRAMUsedBytes,
+			VRAMTotalBytes: *base.VRAMTotalBytes,
+		})
+	}
+
+	var wg sync.WaitGroup
+	run := func(fn func()) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			fn()
+		}()
+	}
+	run(func() {
+		if info, err := host.Info(); err == nil {
+			status.Host.Platform = info.Platform
+		}
+	})
+	run(func() { status.CPU.Load = loadAverage() })
+	run(func() { status.Swap = swapUsage() })
+	run(func() { status.Network = networkInterfaces() })
+	run(func() { status.Temperatures = temperatures() })
+	run(func() { status.Processes = topProcesses(topN, base.MemTotalBytes) })
+	wg.Wait()
+
+	return base, status, nil
+}
+
+func loadAverage() *LoadAverage {
+	if runtime.GOOS == OSWindows {
+		return nil
+	}
+	avg, err := load.Avg()
+	if err != nil {
+		log.Printf("failed to query load average: %v", err)
+		return nil
+	}
+	return &LoadAverage{Load1: avg.Load1, Load5: avg.Load5, Load15: avg.Load15}
+}
+
+func swapUsage() *UsageInfo {
+	swap, err := mem.SwapMemory()
+	if err != nil {
+		log.Printf("failed to query swap usage: %v", err)
+		return nil
+	}
+	return &UsageInfo{UsedBytes: swap.Used, TotalBytes: swap.Total, Percent: swap.UsedPercent}
+}
+
+// temperatures returns the readable sensors. Some platforms report partial results
+// together with warnings, which are kept.
+func temperatures() []TemperatureInfo {
+	sensors, err := host.SensorsTemperatures()
+	if err != nil && len(sensors) == 0 {
+		return []TemperatureInfo{}
+	}
+	result := make([]TemperatureInfo, 0, len(sensors))
+	for _, s := range sensors {
+		if s.Temperature <= 0 {
+			continue
+		}
+		result = append(result, TemperatureInfo{
+			Sensor:   s.SensorKey,
+			Celsius:  s.Temperature,
+			High:     s.High,
+			Critical: s.Critical,
+		})
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Sensor < result[j].Sensor })
+	return result
+}
+
+// rateSampler turns cumulative counters into per-second rates between calls.
+type rateSampler struct {
+	mu       sync.Mutex
+	at       time.Time
+	counters map[string][2]uint64
+	rates    map[string][2]float64
+}
+
+var interfaceRates = &rateSampler{}
+
+// sample records counters taken at now and returns the rates per key. Samples less
+// than minRateInterval after the previous one return the previous rates.
+func (s *rateSampler) sample(now time.Time, counters map[string][2]uint64) map[string][2]float64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	elapsed := now.Sub(s.at)
+	if s.counters != nil && elapsed < minRateInterval {
+		return s.rates
+	}
+	rates := make(map[string][2]float64, len(counters))
+	if s.counters != nil {
+		for key, cur := range counters {
+			prev, ok := s.counters[key]
+			// Counters that went backwards were reset, for example by a driver reload.
+			if !ok || cur[0] < prev[0] || cur[1] < prev[1] {
+				continue
+			}
+			rates[key] = [2]float64{
+				float64(cur[0]-prev[0]) / elapsed.Seconds(),
+				float64(cur[1]-prev[1]) / elapsed.Seconds(),
+			}
+		}
+	}
+	s.at, s.counters, s.rates = now, counters, rates
+	return rates
+}
+
+func networkInterfaces() []InterfaceInfo {
+	counters, err := psnet.IOCounters(true)
+	if err != nil {
+		log.Printf("failed to query network counters: %v", err)
+		return []InterfaceInfo{}
+	}
+
+	flags := map[string]net.Flags{}
+	if ifaces, err := net.Interfaces(); err == nil {
+		for _, iface := range ifaces {
+			flags[iface.Name] = iface.Flags
+		}
+	}
+
+	totals := make(map[string][2]uint64, len(counters))
+	for _, c := range counters {
+		totals[c.Name] = [2]uint64{c.BytesRecv, c.BytesSent}
+	}
+	rates := interfaceRates.sample(time.Now(), totals)
+
+	result := make([]InterfaceInfo, 0, len(counters))
+	for _, c := range counters {
+		info := InterfaceInfo{
+			Name:      c.Name,
+			Up:        flags[c.Name]&net.FlagUp != 0,
+			Loopback:  flags[c.Name]&net.FlagLoopback != 0,
+			RxBytes:   c.BytesRecv,
+			TxBytes:   c.BytesSent,
+			RxErrors:  c.Errin,
+			TxErrors:  c.Errout,
+			RxDropped: c.Dropin,
+			TxDropped: c.Dropout,
+		}
+		if rate, ok := rates[c.Name]; ok {
+			rx, tx := rate[0], rate[1]
+			info.RxBytesPerSec, info.TxBytesPerSec = &rx, &tx
+		}
+		result = append(result, info)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Name < result[j].Name })
+	return result
+}
+
+// processCPUSample is the CPU time a process had used when it was last seen.
+type processCPUSample struct {
+	seconds float64
+	at      time.Time
+	percent float64
+}
+
+var (
+	processCPUMu      sync.Mutex
+	processCPUSamples = map[int32]processCPUSample{}
+)
+
+// processCPUPercent returns the CPU usage of a process since the previous call. A
+// process seen for the first time reports its average over its lifetime.
+func processCPUPercent(p *process.Process, seconds float64, now time.Time, seen map[int32]processCPUSample) float64 {
+	prev, ok := processCPUSamples[p.Pid]
+	switch {
+	case ok && now.Sub(prev.at) < minRateInterval:
+		seen[p.Pid] = prev
+		return prev.percent
+	case ok && seconds >= prev.seconds:
+		percent := (seconds - prev.seconds) / now.Sub(prev.at).Seconds() * 100
+		seen[p.Pid] = processCPUSample{seconds: seconds, at: now, percent: percent}
+		return percent
+	}
+
+	percent := 0.0
+	if created, err := p.CreateTime(); err == nil {
+		if lifetime := now.Sub(time.UnixMilli(created)).Seconds(); lifetime > 0 {
+			percent = seconds / lifetime * 100
+		}
+	}
+	seen[p.Pid] = processCPUSample{seconds: seconds, at: now, percent: percent}
+	return percent
+}
+
+// topProcesses ranks running processes by CPU and by resident memory.
+func topProcesses(topN int, memTotal uint64) ProcessRankingInfo {
+	ranking := ProcessRankingInfo{ByCPU: []ProcessInfo{}, ByMemory: []ProcessInfo{}}
+	procs, err := process.Processes()
+	if err != nil {
+		log.Printf("failed to list processes: %v", err)
+		return ranking
+	}
+
+	type entry struct {
+		proc *process.Process
+		info ProcessInfo
+	}
+	entries := make([]entry, 0, len(procs))
+	now := time.Now()
+	seen := make(map[int32]processCPUSample, len(procs))
+
+	processCPUMu.Lock()
+	for _, p := range procs {
+		times, err := p.Times()
+		if err != nil {
+			continue // exited, or owned by another user on some platforms
+		}
+		info := ProcessInfo{PID: p.Pid}
+		info.CPUPercent = processCPUPercent(p, times.User+times.System, now, seen)
+		if m, err := p.MemoryInfo(); err == nil {
+			info.RSSBytes = m.RSS
+			if memTotal > 0 {
+				info.MemPercent = float64(m.RSS) / float64(memTotal) * 100
+			}
+		}
+		entries = append(entries, entry{proc: p, info: info})
+	}
+	processCPUSamples = seen
+	processCPUMu.Unlock()
+
+	ranking.Total = len(entries)
+	pick := func(less func(a, b ProcessInfo) bool) []ProcessInfo {
+		sort.Slice(entries, func(i, j int) bool { return less(entries[i].info, entries[j].info) })
+		n := min(topN, len(entries))
+		top := make([]ProcessInfo, 0, n)
+		for _, e := range entries[:n] {
+			info := e.info
+			if name, err := e.proc.Name(); err == nil {
+				info.Name = name
+			}
+			top = append(top, info)
+		}
+		return top
+	}
+	ranking.ByCPU = pick(func(a, b ProcessInfo) bool { return a.CPUPercent > b.CPUPercent })
+	ranking.ByMemory = pick(func(a, b ProcessInfo) bool { return a.RSSBytes > b.RSSBytes })
+	return ranking
+}
@@ -0,0 +1,481 @@
+// 2025 TabDock: darui3018823 All rights reserved.
+// All works created by darui3018823 associated with this repository are the intellectual property of darui3018823.
+// Packages and other third-party materials used in this repository are subject to their respective licenses and copyrights.
+
+package getstatus
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Probe types.
+const (
+	ProbeICMP = "icmp"
+	ProbeTCP  = "tcp"
+	ProbeHTTP = "http"
+	ProbeDNS  = "dns"
+)
+
+const (
+	defaultProbeInterval = 30 * time.Second
+	minProbeInterval     = 5 * time.Second
+	defaultProbeTimeout  = 2 * time.Second
+	maxProbeTimeout      = 30 * time.Second
+	maxProbeCount        = 10
+	maxProbes            = 32
+
+	// probeAttemptGap separates the attempts of one check, like ping's interval.
+	probeAttemptGap = 200 * time.Millisecond
+	// probeHistoryLen is how many past checks each probe keeps.
+	probeHistoryLen = 30
+	// firstProbeWait bounds how long the first status collection waits for the
+	// probes to report, so WAN is not shown as offline right after start-up.
+	firstProbeWait = 5 * time.Second
+)
+
+var probeNamePattern = regexp.MustCompile(`^[A-Za-z0-9_.-]{1,64}$`)
+
+// ProbeConfig describes one connectivity probe. Target is a host name or IP for icmp,
+// host:port for tcp, a URL for http and the name to resolve for dns. Server is the
+// resolver (host:port) of a dns probe; the system resolver is used without it.
+// Probes marked WAN decide whether the WAN is online; when none is marked, all do.
+type ProbeConfig struct {
+	Name         string `json:"name"`
+	Type         string `json:"type"`
+	Target       string `json:"target"`
+	Server       string `json:"server,omitempty"`
+	ExpectStatus int    `json:"expect_status,omitempty"`
+	Count        int    `json:"count,omitempty"`
+	TimeoutMs    int    `json:"timeout_ms,omitempty"`
+	IntervalSec  int    `json:"interval_sec,omitempty"`
+	WAN          bool   `json:"wan,omitempty"`
+}
+
+// DefaultProbes is the probe set used until ConfigureProbes is called: a TCP
+// connection to a public DNS resolver, which needs no privileges unlike ICMP.
+func DefaultProbes() []ProbeConfig {
+	return []ProbeConfig{{Name: "wan", Type: ProbeTCP, Target: "1.1.1.1:53", WAN: true}}
+}
+
+// normalize validates a probe and fills in its defaults.
+func (c *ProbeConfig) normalize() error {
+	c.Name = strings.TrimSpace(c.Name)
+	c.Type = strings.ToLower(strings.TrimSpace(c.Type))
+	c.Target = strings.TrimSpace(c.Target)
+	if !probeNamePattern.MatchString(c.Name) {
+		return fmt.Errorf("invalid probe name %q (letters, digits, '.', '_' and '-', up to 64)", c.Name)
+	}
+	if c.Target == "" {
+		return fmt.Errorf("probe %s: target is required", c.Name)
+	}
+
+	switch c.Type {
+	case ProbeICMP:
+		if c.Count == 0 {
+			c.Count = 3
+		}
+	case ProbeTCP:
+		if _, _, err := net.SplitHostPort(c.Target); err != nil {
+			return fmt.Errorf("probe %s: target must be host:port: %w", c.Name, err)
+		}
+	case ProbeHTTP:
+		u, err := url.Parse(c.Target)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("probe %s: target must be an http or https URL", c.Name)
+		}
+		if c.ExpectStatus == 0 {
+			c.ExpectStatus = http.StatusOK
+		}
+		if c.ExpectStatus < 100 || c.ExpectStatus > 599 {
+			return fmt.Errorf("probe %s: expect_status must be between 100 and 599", c.Name)
+		}
+	case ProbeDNS:
+		if c.Server != "" {
+			if _, _, err := net.SplitHostPort(c.Server); err != nil {
+				return fmt.Errorf("probe %s: server must be host:port: %w", c.Name, err)
+			}
+		}
+	default:
+		return fmt.Errorf("probe %s: unknown type %q (icmp, tcp, http or dns)", c.Name, c.Type)
+	}
+	if c.Server != "" && c.Type != ProbeDNS {
+		return fmt.Errorf("probe %s: server is only used by dns probes", c.Name)
+	}
+	if c.ExpectStatus != 0 && c.Type != ProbeHTTP {
+		return fmt.Errorf("probe %s: expect_status is only used by http probes", c.Name)
+	}
+
+	if c.Count == 0 {
+		c.Count = 1
+	}
+	if c.Count < 1 || c.Count > maxProbeCount {
+		return fmt.Errorf("probe %s: count must be between 1 and %d", c.Name, maxProbeCount)
+	}
+	if c.TimeoutMs == 0 {
+		c.TimeoutMs = int(defaultProbeTimeout / time.Millisecond)
+	}
+	if c.TimeoutMs < 1 || c.timeout() > maxProbeTimeout {
+		return fmt.Errorf("probe %s: timeout_ms must be between 1 and %d", c.Name, maxProbeTimeout/time.Millisecond)
+	}
+	if c.IntervalSec == 0 {
+		c.IntervalSec = int(defaultProbeInterval / time.Second)
+	}
+	if c.interval() < minProbeInterval {
+		return fmt.Errorf("probe %s: interval_sec must be at least %d", c.Name, minProbeInterval/time.Second)
+	}
+	return nil
+}
+
+func (c ProbeConfig) timeout() time.Duration  { return time.Duration(c.TimeoutMs) * time.Millisecond }
+func (c ProbeConfig) interval() time.Duration { return time.Duration(c.IntervalSec) * time.Second }
+
+// ProbeSample is the result of one check. LatencyMs is the average round trip of the
+// attempts that succeeded and is nil when none did.
+type ProbeSample struct {
+	At          time.Time `json:"at"`
+	Up          bool      `json:"up"`
+	LatencyMs   *float64  `json:"latencyMs"`
+	LossPercent float64   `json:"lossPercent"`
+	Error       string    `json:"error,omitempty"`
+}
+
+// ProbeInfo is the latest result of a probe with its recent checks, oldest first.
+// CheckedAt is nil until the first check has finished.
+type ProbeInfo struct {
+	Name        string        `json:"name"`
+	Type        string        `json:"type"`
+	Target      string        `json:"target,omitempty"`
+	WAN         bool          `json:"wan"`
+	Up          bool          `json:"up"`
+	LatencyMs   *float64      `json:"latencyMs"`
+	LossPercent float64       `json:"lossPercent"`
+	Error       string        `json:"error,omitempty"`
+	CheckedAt   *time.Time    `json:"checkedAt"`
+	History     []ProbeSample `json:"history"`
+}
+
+// probeMeasurement is what one check observed: how many attempts were made, how many
+// got an answer and their summed round trip.
+type probeMeasurement struct {
+	sent     int
+	received int
+	total    time.Duration
+	err      error
+}
+
+type probe struct {
+	cfg ProbeConfig
+
+	mu      sync.Mutex
+	history []ProbeSample
+}
+
+// probeSet runs the configured probes in the background, so status collection only
+// reads their latest results.
+type probeSet struct {
+	mu     sync.Mutex
+	probes []*probe
+	cancel context.CancelFunc
+	ready  chan struct{}
+	waited bool
+}
+
+var activeProbes = &probeSet{}
+
+// ConfigureProbes validates configs and replaces the running probes with them. On an
+// error the running probes are kept.
+func ConfigureProbes(configs []ProbeConfig) error {
+	if len(configs) > maxProbes {
+		return fmt.Errorf("too many probes (%d, max %d)", len(configs), maxProbes)
+	}
+	normalized := make([]ProbeConfig, len(configs))
+	seen := map[string]bool{}
+	for i, cfg := range configs {
+		if err := cfg.normalize(); err != nil {
+			return err
+		}
+		key := strings.ToLower(cfg.Name)
+		if seen[key] {
+			return fmt.Errorf("duplicate probe name %q", cfg.Name)
+		}
+		seen[key] = true
+		normalized[i] = cfg
+	}
+
+	activeProbes.mu.Lock()
+	defer activeProbes.mu.Unlock()
+	activeProbes.startLocked(normalized)
+	return nil
+}
+
+// startLocked stops the running probes and starts configs, which must be normalized.
+func (s *probeSet) startLocked(configs []ProbeConfig) {
+	if s.cancel != nil {
+		s.cancel()
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	s.cancel = cancel
+	s.ready = make(chan struct{})
+	s.waited = false
+	s.probes = make([]*probe, 0, len(configs))
+
+	var first sync.WaitGroup
+	for _, cfg := range configs {
+		p := &probe{cfg: cfg}
+		s.probes = append(s.probes, p)
+		first.Add(1)
+		go p.loop(ctx, first.Done)
+	}
+	ready := s.ready
+	go func() {
+		first.Wait()
+		close(ready)
+	}()
+}
+
+// snapshot returns the results of every probe. The first call starts the default
+// probes if none were configured, and it alone waits up to firstProbeWait for the
+// first round; later calls return whatever has been checked so far.
+func (s *probeSet) snapshot() []ProbeInfo {
+	s.mu.Lock()
+	if s.cancel == nil {
+		defaults := DefaultProbes()
+		for i := range defaults {
+			_ = defaults[i].normalize()
+		}
+		s.startLocked(defaults)
+	}
+	probes, ready, wait := s.probes, s.ready, !s.waited
+	s.waited = true
+	s.mu.Unlock()
+
+	if wait {
+		select {
+		case <-ready:
+		case <-time.After(firstProbeWait):
+		}
+	}
+
+	result := make([]ProbeInfo, 0, len(probes))
+	for _, p := range probes {
+		result = append(result, p.info())
+	}
+	return result
+}
+
+func (p *probe) info() ProbeInfo {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	info := ProbeInfo{
+		Name:    p.cfg.Name,
+		Type:    p.cfg.Type,
+		Target:  p.cfg.Target,
+		WAN:     p.cfg.WAN,
+		History: append([]ProbeSample(nil), p.history...),
+	}
+	if n := len(p.history); n > 0 {
+		last := p.history[n-1]
+		at := last.At
+		info.Up, info.LatencyMs, info.LossPercent, info.Error = last.Up, last.LatencyMs, last.LossPercent, last.Error
+		info.CheckedAt = &at
+	}
+	if info.History == nil {
+		info.History = []ProbeSample{}
+	}
+	return info
+}
+
+// loop checks the probe right away and then every interval until ctx is cancelled.
+// firstDone is called once the first check has finished.
+func (p *probe) loop(ctx context.Context, firstDone func()) {
+	p.check(ctx)
+	firstDone()
+
+	ticker := time.NewTicker(p.cfg.interval())
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			p.check(ctx)
+		}
+	}
+}
+
+func (p *probe) check(ctx context.Context) {
+	var m probeMeasurement
+	switch p.cfg.Type {
+	case ProbeICMP:
+		m = measureICMP(ctx, p.cfg)
+	case ProbeTCP:
+		m = measureAttempts(ctx, p.cfg, dialTCP)
+	case ProbeHTTP:
+		client := probeHTTPClient(p.cfg)
+		m = measureAttempts(ctx, p.cfg, func(ctx context.Context, cfg ProbeConfig) error {
+			return getHTTP(ctx, client, cfg)
+		})
+	case ProbeDNS:
+		m = measureAttempts(ctx, p.cfg, resolveDNS)
+	}
+	if ctx.Err() != nil {
+		return // replaced by a new configuration
+	}
+
+	sample := ProbeSample{At: time.Now().UTC(), Up: m.received > 0}
+	if m.sent > 0 {
+		sample.LossPercent = float64(m.sent-m.received) / float64(m.sent) * 100
+	}
+	if m.received > 0 {
+		latency := float64(m.total) / float64(m.received) / float64(time.Millisecond)
+		sample.LatencyMs = &latency
+	}
+	if m.err != nil && !sample.Up {
+		sample.Error = m.err.Error()
+	}
+
+	p.mu.Lock()
+	p.history = append(p.history, sample)
+	if len(p.history) > probeHistoryLen {
+		p.history = p.history[len(p.history)-probeHistoryLen:]
+	}
+	p.mu.Unlock()
+}
+
+// measureAttempts runs attempt cfg.Count times and times the ones that succeed. The
+// last error is kept for reporting.
+func measureAttempts(ctx context.Context, cfg ProbeConfig, attempt func(ctx context.Context, cfg ProbeConfig) error) probeMeasurement {
+	var m probeMeasurement
+	for i := 0; i < cfg.Count; i++ {
+		if i > 0 {
+			select {
+			case <-ctx.Done():
+				return m
+			case <-time.After(probeAttemptGap):
+			}
+		}
+		attemptCtx, cancel := context.WithTimeout(ctx, cfg.timeout())
+		start := time.Now()
+		err := attempt(attemptCtx, cfg)
+		elapsed := time.Since(start)
+		cancel()
+
+		m.sent++
+		if err != nil {
+			m.err = err
+			continue
+		}
+		m.received++
+		m.total += elapsed
+	}
+	return m
+}
+
+func dialTCP(ctx context.Context, cfg ProbeConfig) error {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", cfg.Target)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+// probeHTTPClient returns a client that opens a new connection for every attempt and
+// does not follow redirects, so expect_status can match a redirect itself.
+func probeHTTPClient(cfg ProbeConfig) *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DisableKeepAlives = true
+	return &http.Client{
+		Transport: transport,
+		Timeout:   cfg.timeout(),
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+func getHTTP(ctx context.Context, client *http.Client, cfg ProbeConfig) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, cfg.Target, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("User-Agent", "Tabdock-Probe")
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	if closeErr := resp.Body.Close(); closeErr != nil {
+		return closeErr
+	}
+	if resp.StatusCode != cfg.ExpectStatus {
+		return fmt.Errorf("unexpected status %d (want %d)", resp.StatusCode, cfg.ExpectStatus)
+	}
+	return nil
+}
+
+func resolveDNS(ctx context.Context, cfg ProbeConfig) error {
+	resolver := net.DefaultResolver
+	if cfg.Server != "" {
+		resolver = &net.Resolver{
+			PreferGo: true,
+			Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, network, cfg.Server)
+			},
+		}
+	}
+	addrs, err := resolver.LookupHost(ctx, cfg.Target)
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) && cfg.Server != "" {
+		// The custom dialer ignores the address the resolver picked from resolv.conf.
+		dnsErr.Server = cfg.Server
+	}
+	if err != nil {
+		return err
+	}
+	if len(addrs) == 0 {
+		return errors.New("no addresses returned")
+	}
+	return nil
+}
+
+// fillWAN reports the WAN as online when any WAN probe is up. Until a WAN probe has
+// been checked the state is unknown.
+func fillWAN(status *PCStatus) {
+	probes := activeProbes.snapshot()
+	status.probes = probes
+
+	marked := false
+	for _, p := range probes {
+		marked = marked || p.WAN
+	}
+	checked := false
+	for _, p := range probes {
+		if marked && !p.WAN {
+			continue
+		}
+		if p.CheckedAt != nil {
+			checked = true
+		}
+		if p.Up {
+			status.WANOnline = true
+		}
+	}
+	switch {
+	case status.WANOnline:
+		status.WAN = "Active"
+	case checked:
+		status.WAN = "Offline"
+	default:
+		status.WAN = StatusNA
+	}
+}
@@ -0,0 +1,175 @@
+// 2025 TabDock: darui3018823 All rights reserved.
+// All works created by darui3018823 associated with this repository are the intellectual property of darui3018823.
+// Packages and other third-party materials used in this repository are subject to their respective licenses and copyrights.
+
+package getstatus
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"os"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	icmpEchoRequest = 8
+	icmpEchoReply   = 0
+)
+
+var (
+	// icmpID tells the replies of concurrent probes apart on the shared raw socket.
+	icmpID atomic.Uint32
+
+	// Loss and average round trip in the summaries of iputils, BSD, busybox and
+	// Windows ping (English and Japanese).
+	pingLossPattern = regexp.MustCompile(`(\d+(?:\.\d+)?)% (?:packet loss|loss|の損失)`)
+	pingUnixAvg     = regexp.MustCompile(`= [\d.]+/([\d.]+)/`)
+	pingWindowsAvg  = regexp.MustCompile(`(?:Average|平均) = (\d+)ms`)
+)
+
+func init() {
+	icmpID.Store(uint32(os.Getpid()))
+}
+
+// measureICMP sends echo requests from a raw socket, which needs root or
+// CAP_NET_RAW, and falls back to the system ping command when it cannot open one or
+// the target has no IPv4 address.
+func measureICMP(ctx context.Context, cfg ProbeConfig) probeMeasurement {
+	conn, err := net.ListenPacket("ip4:icmp", "0.0.0.0")
+	if err != nil {
+		return measurePingCommand(ctx, cfg)
+	}
+	defer conn.Close()
+
+	dst, err := resolveIPv4(ctx, cfg.Target)
+	if err != nil {
+		return measurePingCommand(ctx, cfg)
+	}
+	id := uint16(icmpID.Add(1))
+	return measureAttempts(ctx, cfg, func(ctx context.Context, cfg ProbeConfig) error {
+		return echoICMP(ctx, conn, dst, id)
+	})
+}
+
+func resolveIPv4(ctx context.Context, host string) (*net.IPAddr, error) {
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	for _, addr := range addrs {
+		if addr.IP.To4() != nil {
+			return &addr, nil
+		}
+	}
+	return nil, fmt.Errorf("no IPv4 address for %s", host)
+}
+
+var icmpSeq atomic.Uint32
+
+// echoICMP sends one echo request and waits for the matching reply.
+func echoICMP(ctx context.Context, conn net.PacketConn, dst *net.IPAddr, id uint16) error {
+	seq := uint16(icmpSeq.Add(1))
+	packet := make([]byte, 16)
+	packet[0] = icmpEchoRequest
+	binary.BigEndian.PutUint16(packet[4:], id)
+	binary.BigEndian.PutUint16(packet[6:], seq)
+	binary.BigEndian.PutUint64(packet[8:], uint64(time.Now().UnixNano()))
+	binary.BigEndian.PutUint16(packet[2:], icmpChecksum(packet))
+
+	deadline, _ := ctx.Deadline()
+	if err := conn.SetDeadline(deadline); err != nil {
+		return err
+	}
+	if _, err := conn.WriteTo(packet, dst); err != nil {
+		return err
+	}
+
+	buf := make([]byte, 1500)
+	for {
+		n, from, err := conn.ReadFrom(buf)
+		if err != nil {
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				return errors.New("request timed out")
+			}
+			return err
+		}
+		// The raw socket sees every ICMP message of the host, including our own
+		// requests on loopback and the replies to other probes.
+		reply := buf[:n]
+		if n < 8 || reply[0] != icmpEchoReply || !from.(*net.IPAddr).IP.Equal(dst.IP) {
+			continue
+		}
+		if binary.BigEndian.Uint16(reply[4:]) == id && binary.BigEndian.Uint16(reply[6:]) == seq {
+			return nil
+		}
+	}
+}
+
+func icmpChecksum(b []byte) uint16 {
+	var sum uint32
+	for i := 0; i+1 < len(b); i += 2 {
+		sum += uint32(b[i])<<8 | uint32(b[i+1])
+	}
+	if len(b)%2 == 1 {
+		sum += uint32(b[len(b)-1]) << 8
+	}
+	for sum>>16 != 0 {
+		sum = sum&0xffff + sum>>16
+	}
+	return ^uint16(sum)
+}
+
+// measurePingCommand runs the system ping and reads its summary. ping exits non-zero
+// when no reply arrives, so the output is parsed regardless of the exit status.
+func measurePingCommand(ctx context.Context, cfg ProbeConfig) probeMeasurement {
+	count := strconv.Itoa(cfg.Count)
+	var args []string
+	switch runtime.GOOS {
+	case OSWindows:
+		args = []string{"-n", count, "-w", strconv.Itoa(cfg.TimeoutMs), cfg.Target}
+	case OSDarwin:
+		args = []string{"-c", count, "-W", strconv.Itoa(cfg.TimeoutMs), cfg.Target}
+	default:
+		args = []string{"-c", count, "-W", strconv.Itoa(int(math.Ceil(cfg.timeout().Seconds()))), cfg.Target}
+	}
+	// ping waits a second between requests.
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(cfg.Count)*(cfg.timeout()+time.Second))
+	defer cancel()
+	out, runErr := commandContext(ctx, "ping", args...).Output()
+	m := probeMeasurement{sent: cfg.Count}
+
+	loss := pingLossPattern.FindStringSubmatch(string(out))
+	if loss == nil {
+		if runErr == nil {
+			runErr = errors.New("unexpected ping output")
+		}
+		m.err = fmt.Errorf("ping failed: %w", runErr)
+		return m
+	}
+	lossPercent, _ := strconv.ParseFloat(loss[1], 64)
+	m.received = int(math.Round(float64(cfg.Count) * (100 - lossPercent) / 100))
+	if m.received == 0 {
+		m.err = errors.New("no reply")
+		return m
+	}
+
+	avg := pingUnixAvg.FindStringSubmatch(string(out))
+	if avg == nil {
+		avg = pingWindowsAvg.FindStringSubmatch(string(out))
+	}
+	if avg != nil {
+		ms, _ := strconv.ParseFloat(strings.TrimSpace(avg[1]), 64)
+		m.total = time.Duration(ms * float64(time.Millisecond) * float64(m.received))
+	}
+	return m
+}
@@ -0,0 +1,241 @@
+// 2025 TabDock: darui3018823 All rights reserved.
+// All works created by darui3018823 associated with this repository are the intellectual property of darui3018823.
+// Packages and other third-party materials used in this repository are subject to their respective licenses and copyrights.
+
+package getstatus
+
+import (
+	"context"
+	"encoding/binary"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// checkProbe normalizes cfg, runs one check and returns the probe's result.
+func checkProbe(t *testing.T, cfg ProbeConfig) ProbeInfo {
+	t.Helper()
+	if cfg.TimeoutMs == 0 {
+		cfg.TimeoutMs = 1000
+	}
+	if err := cfg.normalize(); err != nil {
+		t.Fatalf("normalize: %v", err)
+	}
+	p := &probe{cfg: cfg}
+	p.check(context.Background())
+	info := p.info()
+	if info.CheckedAt == nil || len(info.History) != 1 {
+		t.Fatalf("probe was not checked: %+v", info)
+	}
+	return info
+}
+
+func assertUp(t *testing.T, info ProbeInfo) {
+	t.Helper()
+	if !info.Up || info.Error != "" || info.LatencyMs == nil || info.LossPercent != 0 {
+		t.Fatalf("want up without loss, got up=%v error=%q latency=%v loss=%v", info.Up, info.Error, info.LatencyMs, info.LossPercent)
+	}
+}
+
+func assertDown(t *testing.T, info ProbeInfo, errPart string) {
+	t.Helper()
+	if info.Up || info.LatencyMs != nil || info.LossPercent != 100 {
+		t.Fatalf("want down with full loss, got up=%v latency=%v loss=%v", info.Up, info.LatencyMs, info.LossPercent)
+	}
+	if !strings.Contains(info.Error, errPart) {
+		t.Fatalf("error %q does not mention %q", info.Error, errPart)
+	}
+}
+
+// closedAddr returns a loopback address nothing listens on.
+func closedAddr(t *testing.T) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+	return addr
+}
+
+func TestTCPProbe(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+
+	assertUp(t, checkProbe(t, ProbeConfig{Name: "open", Type: ProbeTCP, Target: ln.Addr().String(), Count: 2}))
+	assertDown(t, checkProbe(t, ProbeConfig{Name: "closed", Type: ProbeTCP, Target: closedAddr(t)}), "refused")
+}
+
+func TestHTTPProbe(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("User-Agent") != "Tabdock-Probe" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		switch r.URL.Path {
+		case "/ok":
+			w.WriteHeader(http.StatusOK)
+		case "/moved":
+			http.Redirect(w, r, "/ok", http.StatusFound)
+		default:
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer srv.Close()
+
+	tests := []struct {
+		name   string
+		path   string
+		expect int
+		up     bool
+	}{
+		{"ok", "/ok", 0, true},
+		{"redirect is not followed", "/moved", http.StatusFound, true},
+		{"redirect does not reach 200", "/moved", 0, false},
+		{"server error", "/fail", 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			info := checkProbe(t, ProbeConfig{Name: "web", Type: ProbeHTTP, Target: srv.URL + tt.path, ExpectStatus: tt.expect})
+			if tt.up {
+				assertUp(t, info)
+			} else {
+				assertDown(t, info, "unexpected status")
+			}
+		})
+	}
+
+	assertDown(t, checkProbe(t, ProbeConfig{Name: "web", Type: ProbeHTTP, Target: "http://" + closedAddr(t) + "/"}), "refused")
+}
+
+// serveDNS answers A queries for known names with 127.0.0.1 and every other query with
+// NXDOMAIN, until the returned function is called.
+func serveDNS(t *testing.T, known string) (string, func()) {
+	t.Helper()
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			if resp := dnsResponse(buf[:n], known); resp != nil {
+				conn.WriteTo(resp, addr)
+			}
+		}
+	}()
+	return conn.LocalAddr().String(), func() { conn.Close() }
+}
+
+func dnsResponse(query []byte, known string) []byte {
+	if len(query) < 12 {
+		return nil
+	}
+	// Walk the question name to find where the question ends.
+	var labels []string
+	i := 12
+	for i < len(query) && query[i] != 0 {
+		l := int(query[i])
+		if i+1+l > len(query) {
+			return nil
+		}
+		labels = append(labels, string(query[i+1:i+1+l]))
+		i += 1 + l
+	}
+	end := i + 5 // zero label, type, class
+	if end > len(query) {
+		return nil
+	}
+	name := strings.ToLower(strings.Join(labels, "."))
+	qtype := binary.BigEndian.Uint16(query[i+1:])
+
+	resp := make([]byte, 12, 64)
+	copy(resp, query[:2])
+	flags := uint16(0x8180) // response, recursion desired and available
+	answers := 0
+	switch {
+	case name != known:
+		flags |= 3 // NXDOMAIN
+	case qtype == 1:
+		answers = 1
+	}
+	binary.BigEndian.PutUint16(resp[2:], flags)
+	binary.BigEndian.PutUint16(resp[4:], 1)
+	binary.BigEndian.PutUint16(resp[6:], uint16(answers))
+	resp = append(resp, query[12:end]...)
+	if answers == 1 {
+		// Name pointer to the question, type A, class IN, TTL 60, 127.0.0.1.
+		resp = append(resp, 0xc0, 0x0c, 0, 1, 0, 1, 0, 0, 0, 60, 0, 4, 127, 0, 0, 1)
+	}
+	return resp
+}
+
+func TestDNSProbe(t *testing.T) {
+	server, stop := serveDNS(t, "probe.example")
+	defer stop()
+
+	assertUp(t, checkProbe(t, ProbeConfig{Name: "dns", Type: ProbeDNS, Target: "probe.example", Server: server}))
+
+	info := checkProbe(t, ProbeConfig{Name: "dns", Type: ProbeDNS, Target: "missing.example", Server: server})
+	assertDown(t, info, "no such host")
+	if !strings.Contains(info.Error, server) {
+		t.Fatalf("error %q does not name the configured server %s", info.Error, server)
+	}
+}
+
+func TestProbeHistoryIsBounded(t *testing.T) {
+	cfg := ProbeConfig{Name: "closed", Type: ProbeTCP, Target: closedAddr(t), TimeoutMs: 200}
+	if err := cfg.normalize(); err != nil {
+		t.Fatal(err)
+	}
+	p := &probe{cfg: cfg}
+	for i := 0; i < probeHistoryLen+5; i++ {
+		p.check(context.Background())
+	}
+	if got := len(p.info().History); got != probeHistoryLen {
+		t.Fatalf("history has %d entries, want %d", got, probeHistoryLen)
+	}
+}
+
+func TestProbeConfigValidation(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  ProbeConfig
+		err  string
+	}{
+		{"tcp without port", ProbeConfig{Name: "a", Type: ProbeTCP, Target: "127.0.0.1"}, "host:port"},
+		{"http without scheme", ProbeConfig{Name: "a", Type: ProbeHTTP, Target: "example.com"}, "http or https"},
+		{"server on tcp", ProbeConfig{Name: "a", Type: ProbeTCP, Target: "127.0.0.1:1", Server: "127.0.0.1:53"}, "only used by dns"},
+		{"expect_status on dns", ProbeConfig{Name: "a", Type: ProbeDNS, Target: "example.com", ExpectStatus: 200}, "only used by http"},
+		{"short interval", ProbeConfig{Name: "a", Type: ProbeTCP, Target: "127.0.0.1:1", IntervalSec: 1}, "interval_sec"},
+		{"bad name", ProbeConfig{Name: "a b", Type: ProbeTCP, Target: "127.0.0.1:1"}, "invalid probe name"},
+		{"unknown type", ProbeConfig{Name: "a", Type: "udp", Target: "127.0.0.1:1"}, "unknown type"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.cfg.normalize()
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Fatalf("normalize() = %v, want an error mentioning %q", err, tt.err)
+			}
+		})
+	}
+}
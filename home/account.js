@@ -109,7 +109,38 @@ function initLegacyAuthFetchInterceptor() {
     window.__tabdockLegacyAuthFetchPatched = true;
 }
 
+function getCSRFToken() {
+    const match = document.cookie.match(/(?:^|;\s*)tabdock_csrf=([^;]*)/);
+    return match ? decodeURIComponent(match[1]) : "";
+}
+
+function initCSRFFetchInterceptor() {
+    if (window.__tabdockCSRFFetchPatched) return;
+    if (typeof window.fetch !== "function") return;
+
+    const originalFetch = window.fetch.bind(window);
+    const safeMethods = ["GET", "HEAD", "OPTIONS", "TRACE"];
+
+    window.fetch = (input, init = {}) => {
+        const method = String(init.method || input?.method || "GET").toUpperCase();
+        const token = getCSRFToken();
+        if (safeMethods.includes(method) || !token || !isSameOriginAPIRequest(input)) {
+            return originalFetch(input, init);
+        }
+
+        const headers = new Headers(init.headers || (input instanceof Request ? input.headers : {}));
+        if (!headers.has("X-CSRF-Token")) {
+            headers.set("X-CSRF-Token", token);
+        }
+
+        return originalFetch(input, { ...init, headers });
+    };
+
+    window.__tabdockCSRFFetchPatched = true;
+}
+
 initLegacyAuthFetchInterceptor();
+initCSRFFetchInterceptor();
 
 document.addEventListener("DOMContentLoaded", () => {
     window.onPasskeyLoginSuccess = function (user) {
@@ -165,7 +196,9 @@ document.addEventListener("DOMContentLoaded", () => {
             });
     };
 
-    if (isLoggedIn()) {
+    if (new URLSearchParams(window.location.search).has("auth")) {
+        completeOIDCLogin();
+    } else if (isLoggedIn()) {
         notifyAuthState(getLoggedInUser());
     } else {
         attemptSessionRestore();
@@ -356,7 +389,7 @@ function setupLoggedInModal(modal) {
                             <button id="dataExportBtn" type="button" class="w-full text-left text-xs text-white/70 hover:text-white/90 py-2 px-3 rounded hover:bg-white/10 transition-colors">
                                 データエクスポート
                             </button>
-                            <button class="w-full text-left text-xs text-red-400 hover:text-red-300 py-2 px-3 rounded hover:bg-red-600/10 transition-colors">
+                            <button id="deleteAccountBtn" type="button" class="w-full text-left text-xs text-red-400 hover:text-red-300 py-2 px-3 rounded hover:bg-red-600/10 transition-colors">
                                 アカウント削除
                             </button>
                         </div>
@@ -403,6 +436,9 @@ function setupLoginModal(modal) {
                             <button id="passkeyLoginBtn" class="w-full bg-green-600 hover:bg-green-500 text-white py-3 rounded-lg transition-colors font-medium">
                                 パスキーでログイン
                             </button>
+                            <button id="oidcLoginBtn" class="hidden w-full bg-gray-700 hover:bg-gray-600 text-white py-3 rounded-lg transition-colors font-medium">
+                                SSOでログイン
+                            </button>
                         </div>
                     </div>
                     
@@ -717,6 +753,11 @@ function setupLoggedInEventListeners() {
         dataExportBtn.addEventListener("click", showDataExportDialog);
     }
 
+    const deleteAccountBtn = document.getElementById("deleteAccountBtn");
+    if (deleteAccountBtn) {
+        deleteAccountBtn.addEventListener("click", showAccountDeleteDialog);
+    }
+
     document.getElementById("logoutBtn").addEventListener("click", () => {
         Swal.fire({
             title: "ログアウトしますか？",
@@ -974,7 +1015,7 @@ function showDataExportDialog() {
         title: "データエクスポート",
         html: `
             <div class="text-sm text-left leading-relaxed space-y-2">
-                <p>サブスクリプションやアカウントに関するデータをエクスポートできます。</p>
+                <p>アカウント情報・予定・シフト・サブスクリプション・壁紙・プロフィール画像をZIPファイルとしてダウンロードします。</p>
                 <p class="text-xs text-white/70">エクスポートされたファイルは、ダッシュボードのバックアップや別環境への移行時に利用できます。</p>
             </div>
         `,
@@ -986,6 +1027,53 @@ function showDataExportDialog() {
     }).then((result) => {
         if (result.isConfirmed) {
             window.dispatchEvent(new CustomEvent("account:data-export-requested"));
+            window.location.href = "/api/account/export";
+        }
+    });
+}
+
+function showAccountDeleteDialog() {
+    Swal.fire({
+        title: "アカウント削除",
+        html: `
+            <div class="text-sm text-left leading-relaxed space-y-2">
+                <p>アカウントと、予定・シフト・サブスクリプション・壁紙・プロフィール画像などすべてのデータを完全に削除します。この操作は取り消せません。</p>
+                <p class="text-xs">必要な場合は先にデータエクスポートを行ってください。パスワードを設定していない場合は空欄のまま続行できます（直近にログインしている必要があります）。</p>
+            </div>
+        `,
+        icon: "warning",
+        input: "password",
+        inputPlaceholder: "現在のパスワード",
+        showCancelButton: true,
+        confirmButtonText: "削除する",
+        confirmButtonColor: "#dc2626",
+        cancelButtonText: "キャンセル",
+        focusConfirm: false
+    }).then(async (result) => {
+        if (!result.isConfirmed) return;
+
+        try {
+            const resp = await fetch("/api/account", {
+                method: "DELETE",
+                headers: { "Content-Type": "application/json" },
+                body: JSON.stringify({ password: result.value || "" })
+            });
+            const contentType = resp.headers.get("content-type") || "";
+            const data = contentType.includes("application/json") ? await resp.json() : { message: await resp.text() };
+
+            if (!resp.ok || !data.success) {
+                Swal.fire("削除できませんでした", data.message || "アカウントの削除に失敗しました。", "error");
+                return;
+            }
+
+            localStorage.removeItem("tabdock_user");
+            clearRestoreToken();
+            notifyAuthState(null);
+            setupAccountModal();
+            Swal.fire("削除完了", "アカウントを削除しました。", "success");
+        } catch (err) {
+            console.error("アカウント削除エラー:", err);
+            Swal.fire("エラー", "通信中にエラーが発生しました。", "error");
         }
     });
 }
@@ -996,6 +1084,7 @@ function setupAccountEventListeners() {
 
     document.getElementById("normalLoginBtn").addEventListener("click", handleNormalLogin);
     document.getElementById("passkeyLoginBtn").addEventListener("click", handlePasskeyLogin);
+    setupOIDCLoginButton();
 
     document.getElementById("registerBtn").addEventListener("click", handleRegister);
 
@@ -1076,6 +1165,72 @@ function handleNormalLogin() {
         });
 }
 
+async function setupOIDCLoginButton() {
+    const button = document.getElementById("oidcLoginBtn");
+    if (!button) return;
+
+    try {
+        const resp = await fetch("/api/auth/oidc/config");
+        const config = await resp.json();
+        if (!resp.ok || !config.enabled) return;
+
+        button.textContent = `${config.providerName || "SSO"}でログイン`;
+        button.classList.remove("hidden");
+        button.addEventListener("click", () => {
+            window.location.href = config.loginUrl;
+        });
+    } catch (err) {
+        console.warn("OIDC設定の取得に失敗しました", err);
+    }
+}
+
+const OIDC_ERROR_MESSAGES = {
+    denied: "認証がキャンセルされました。",
+    state: "ログイン要求の有効期限が切れました。もう一度お試しください。",
+    no_account: "このアカウントに対応するユーザーが登録されていません。",
+    disabled: "このアカウントは無効化されています。"
+};
+
+async function completeOIDCLogin() {
+    const params = new URLSearchParams(window.location.search);
+    const result = params.get("auth");
+    if (result !== "oidc" && result !== "oidc_error") return;
+
+    const reason = params.get("reason");
+    params.delete("auth");
+    params.delete("reason");
+    const query = params.toString();
+    window.history.replaceState(null, "", window.location.pathname + (query ? `?${query}` : "") + window.location.hash);
+
+    if (result === "oidc_error") {
+        Swal.fire("ログイン失敗", OIDC_ERROR_MESSAGES[reason] || "SSOログインに失敗しました。", "error");
+        return;
+    }
+
+    try {
+        const resp = await fetch("/api/auth/oidc/session", { method: "POST" });
+        const data = await resp.json();
+        if (!resp.ok || !data.success) {
+            Swal.fire("ログイン失敗", data.message || "SSOログインに失敗しました。", "error");
+            return;
+        }
+
+        saveRestoreToken(data.restoreToken);
+        const userInfo = {
+            username: data.user.username,
+            email: data.user.email || "",
+            loginAt: data.user.loginAt,
+            loginMethod: "SSO",
+            profileImage: data.user.profileImage || null
+        };
+        saveLoginState(userInfo);
+        updateUIForLoggedInUser(userInfo);
+        Swal.fire("ログイン成功", "SSOでログインしました。", "success");
+    } catch (err) {
+        console.error("SSOログインエラー:", err);
+        Swal.fire("エラー", "SSOログイン処理中にエラーが発生しました", "error");
+    }
+}
 
 function handlePasskeyLogin() {
     const username = document.getElementById("loginUsername").value.trim();
@@ -62,7 +62,7 @@ document.addEventListener("DOMContentLoaded", () => {
     setInterval(updateClock, 1000);
 
     updatePCStatus();
-    setInterval(updatePCStatus, 120000);
+    startStatusStream();
 
     fetch('/api/version')
         .then(res => res.json())
@@ -75,65 +75,208 @@ document.addEventListener("DOMContentLoaded", () => {
 
 });
 
+// ページURLの ?host= でエージェントのホストを表示する。省略時はこのサーバー
+const statusHost = new URLSearchParams(location.search).get("host") || "";
+
+function withStatusHost(url) {
+    if (!statusHost) return url;
+    return url + (url.includes("?") ? "&" : "?") + "host=" + encodeURIComponent(statusHost);
+}
+
 function updatePCStatus() {
-    fetch("/api/status")
+    fetch(withStatusHost("/api/status"))
         .then(res => res.json())
-        .then(data => {
-            // 左列
-            const pcElem = document.getElementById("PC");
-            if (pcElem) {
-                pcElem.textContent = " (" + data.PC + ") : Online";
-                pcElem.className = "text-green-400"; // Reset to default green when online
-            }
-            document.getElementById("Battery").textContent = data.Battery + "%";
-            document.getElementById("WAN").textContent = data.WAN;
-            document.getElementById("Uptime").textContent = data.Uptime;
+        .then(renderPCStatus)
+        .catch(err => {
+            console.error("Status fetch error:", err);
+            renderPCStatusOffline();
+        });
+}
 
-            // 中央列
-            document.getElementById("CPU").textContent = data.CPU;
-            document.getElementById("Mem").textContent = data.Mem;
-            document.getElementById("GPU0").textContent = data.GPU0 + "%";
-            document.getElementById("VRAM").textContent = data.VRAM;
+function renderPCStatus(data) {
+    // 左列
+    const pcElem = document.getElementById("PC");
+    if (pcElem) {
+        pcElem.textContent = " (" + data.PC + ") : Online";
+        pcElem.className = "text-green-400"; // Reset to default green when online
+    }
+    document.getElementById("Battery").textContent = withPercent(data.Battery);
+    document.getElementById("WAN").textContent = data.WAN;
+    document.getElementById("Uptime").textContent = data.Uptime;
 
-            // 右列
-            document.getElementById("DriveC").textContent = data.DriveC;
-            document.getElementById("MainWindow").textContent = data.MainWindow;
+    // 中央列
+    document.getElementById("CPU").textContent = data.CPU;
+    document.getElementById("Mem").textContent = data.Mem;
+    document.getElementById("GPU0").textContent = withPercent(data.GPU0);
+    document.getElementById("VRAM").textContent = data.VRAM;
 
-            updateLastUpdateTime();
-        })
-        .catch(err => {
-            console.error("Status fetch error:", err);
+    // 右列
+    document.getElementById("DriveC").textContent = data.DriveC;
+    document.getElementById("MainWindow").textContent = data.MainWindow + idleSuffix(data);
+
+    renderCustomStatus(data.Custom);
+    updateLastUpdateTime();
 
-            // 1. Update PC Status Text to Offline
-            const pcElem = document.getElementById("PC");
-            if (pcElem) {
-                let currentText = pcElem.textContent;
-                let name = "(Unknown)";
-
-                // Extract name if adhering to format " (Name) : Online"
-                const match = currentText.match(/\(([^)]+)\)/);
-                if (match && match[1]) {
-                    name = `(${match[1]})`;
-                } else if (currentText.includes("--")) {
-                    name = "(Offline)";
-                }
-
-                pcElem.textContent = ` ${name} : Offline`;
-                pcElem.className = "text-red-400";
+    // スパークラインはストリームの更新ごとではなく1分に1回だけ取り直す
+    // 履歴はこのサーバーの分だけ記録している
+    const now = Date.now();
+    if (!statusHost && now - lastSparklineUpdate >= 60000) {
+        lastSparklineUpdate = now;
+        updateSparkline("sparkCPU", "cpu");
+        updateSparkline("sparkMem", "mem");
+    }
+}
+
+function renderPCStatusOffline() {
+    // 1. Update PC Status Text to Offline
+    const pcElem = document.getElementById("PC");
+    if (pcElem) {
+        let currentText = pcElem.textContent;
+        let name = "(Unknown)";
+
+        // Extract name if adhering to format " (Name) : Online"
+        const match = currentText.match(/\(([^)]+)\)/);
+        if (match && match[1]) {
+            name = `(${match[1]})`;
+        } else if (currentText.includes("--")) {
+            name = "(Offline)";
+        }
+
+        pcElem.textContent = ` ${name} : Offline`;
+        pcElem.className = "text-red-400";
+    }
+
+    // 2. Reset other values to "--"
+    const idsToReset = [
+        "Battery", "WAN", "Uptime",
+        "CPU", "Mem", "GPU0", "VRAM",
+        "DriveC", "MainWindow"
+    ];
+
+    idsToReset.forEach(id => {
+        const el = document.getElementById(id);
+        if (el) el.textContent = "--";
+    });
+    renderCustomStatus(null);
+}
+
+// 設定されたカスタム項目を表示する。値は設定やコマンドの出力なのでtextContentで入れる
+function renderCustomStatus(custom) {
+    const list = document.getElementById("customStatusList");
+    if (!list) return;
+    const names = Object.keys(custom || {}).sort();
+    list.replaceChildren();
+    list.classList.toggle("hidden", names.length === 0);
+
+    names.forEach(name => {
+        const field = custom[name];
+        const item = document.createElement("li");
+        item.className = "truncate";
+        item.textContent = `${field.Label}: `;
+
+        const value = document.createElement("span");
+        if (field.Value == null) {
+            value.className = "text-red-400";
+            value.textContent = field.Error ? "Error" : "--";
+        } else {
+            value.className = field.Error ? "text-yellow-400" : "text-green-400";
+            value.textContent = formatCustomValue(field.Value) + (field.Unit ? ` ${field.Unit}` : "");
+        }
+        if (field.Error) value.title = field.Error;
+        item.appendChild(value);
+        list.appendChild(item);
+    });
+}
+
+function formatCustomValue(value) {
+    if (typeof value === "number") return String(Math.round(value * 100) / 100);
+    if (typeof value === "boolean") return value ? "Yes" : "No";
+    if (typeof value === "string") return value;
+    return JSON.stringify(value);
+}
+
+let lastSparklineUpdate = 0;
+let statusPollTimer = null;
+
+// /api/status/stream の差分をまとめて受け取る。使えない場合は従来のポーリングに戻す
+function startStatusStream() {
+    if (typeof EventSource !== "function") {
+        startStatusPolling();
+        return;
+    }
+
+    let current = null;
+    const source = new EventSource(withStatusHost("/api/status/stream"));
+
+    source.addEventListener("snapshot", e => {
+        current = JSON.parse(e.data).status;
+        stopStatusPolling();
+        renderPCStatus(current);
+    });
+    source.addEventListener("delta", e => {
+        if (!current) return;
+        const changed = JSON.parse(e.data).status || {};
+        if (Object.keys(changed).length === 0) return;
+        Object.assign(current, changed);
+        renderPCStatus(current);
+    });
+    source.onerror = () => {
+        // EventSource は Last-Event-ID 付きで自動再接続する。閉じられた場合だけポーリングへ
+        if (source.readyState === EventSource.CLOSED) {
+            renderPCStatusOffline();
+            startStatusPolling();
+        }
+    };
+}
+
+function startStatusPolling() {
+    if (statusPollTimer) return;
+    updatePCStatus();
+    statusPollTimer = setInterval(updatePCStatus, 120000);
+}
+
+function stopStatusPolling() {
+    if (!statusPollTimer) return;
+    clearInterval(statusPollTimer);
+    statusPollTimer = null;
+}
+
+// 直近1時間の推移を0〜100%のスパークラインとして描画
+function updateSparkline(svgId, metric) {
+    const svg = document.getElementById(svgId);
+    if (!svg) return;
+
+    fetch(`/api/status/history?metric=${metric}&range=1h`)
+        .then(res => res.json())
+        .then(data => {
+            const points = data.points || [];
+            if (points.length < 2) {
+                svg.innerHTML = "";
+                return;
             }
+            const first = points[0].ts;
+            const span = Math.max(points[points.length - 1].ts - first, 1);
+            const coords = points.map(p => {
+                const x = ((p.ts - first) / span) * 100;
+                const y = 20 - (Math.min(Math.max(p.avg, 0), 100) / 100) * 20;
+                return `${x.toFixed(1)},${y.toFixed(1)}`;
+            }).join(" ");
+            svg.innerHTML = `<polyline points="${coords}" fill="none" stroke="currentColor" stroke-width="1.5" vector-effect="non-scaling-stroke" />`;
+        })
+        .catch(err => console.error("Status history fetch error:", err));
+}
 
-            // 2. Reset other values to "--"
-            const idsToReset = [
-                "Battery", "WAN", "Uptime",
-                "CPU", "Mem", "GPU0", "VRAM",
-                "DriveC", "MainWindow"
-            ];
-
-            idsToReset.forEach(id => {
-                const el = document.getElementById(id);
-                if (el) el.textContent = "--";
-            });
-        });
+// 5分以上操作が無い、または誰もログインしていない場合に表示する
+function idleSuffix(data) {
+    if (data.UserActive !== false) return "";
+    if (data.IdleSeconds == null) return " (not in use)";
+    const minutes = Math.floor(data.IdleSeconds / 60);
+    const idle = minutes >= 60 ? `${Math.floor(minutes / 60)}h ${minutes % 60}m` : `${minutes}m`;
+    return ` (idle ${idle})`;
+}
+
+function withPercent(value) {
+    return /^\d+(\.\d+)?$/.test(String(value)) ? value + "%" : value;
 }
 
 function updateLastUpdateTime() {
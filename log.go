@@ -5,67 +5,45 @@
 package main
 
 import (
-	"bytes"
 	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
 	"fmt"
-	"io/fs"
 	"net"
 	"net/http"
 	"os"
-	"path/filepath"
-	"regexp"
 	"strconv"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/oschwald/geoip2-golang"
+
+	"tabdock/notify"
 )
 
 var trustedCIDRs []*net.IPNet
-var trustedProxyCIDRs = parseCIDRs([]string{
-	"173.245.48.0/20",
-	"103.21.244.0/22",
-	"103.22.200.0/22",
-	"103.31.4.0/22",
-	"141.101.64.0/18",
-	"108.162.192.0/18",
-	"190.93.240.0/20",
-	"188.114.96.0/20",
-	"197.234.240.0/22",
-	"198.41.128.0/17",
-	"162.158.0.0/15",
-	"104.16.0.0/13",
-	"104.24.0.0/14",
-	"172.64.0.0/13",
-	"131.0.72.0/22",
-	"2400:cb00::/32",
-	"2405:8100::/32",
-	"2405:b500::/32",
-	"2606:4700::/32",
-	"2803:f800::/32",
-	"2c0f:f248::/32",
-	"2a06:98c0::/29",
-})
-var scoreFile = "./json/ip_scores.json"
+var trustedCIDRsMutex sync.RWMutex
 var ipScores = map[string]int{}
 var ipScoresMutex sync.RWMutex
 var blockedDirectIPs = []string{""}
 
-var firstAccessIPsFile = "./json/first_access_ips.json"
 var firstAccessIPs = map[string]time.Time{}
 var firstAccessIPsMutex sync.RWMutex
 var ipScoreLastReset = map[string]time.Time{}
 var ipScoreResetMutex sync.RWMutex
 
-var rateLimitMap = map[string]*RateLimit{}
+// rateLimitMap holds token buckets keyed by "<policy>|<ip:|user:|token:><id>".
+var rateLimitMap = map[string]*tokenBucket{}
 var rateLimitMutex sync.RWMutex
 
-var dynamicBlockMap = map[string]time.Time{}
+// dynamicBlockMap holds each blocked IP's expiry and the reason it was blocked.
+var dynamicBlockMap = map[string]dynamicBlock{}
 var dynamicBlockMutex sync.RWMutex
 
+// securityNow is the clock of the request pipeline. Replay sets it to the recorded time.
+var securityNow = time.Now
+
 var rateLimitExemptExtensions = []string{
 	".css", ".js",
 	".png", ".jpg", ".jpeg", ".gif", ".svg", ".ico", ".webp",
@@ -74,15 +52,9 @@ var rateLimitExemptExtensions = []string{
 }
 
 var (
-	sqlInjectionPattern    *regexp.Regexp
-	xssPattern             *regexp.Regexp
-	pathTraversalPattern   *regexp.Regexp
-	maliciousUAPatterns    []string
-	suspiciousUAPatterns   []string
-	suspiciousPathPatterns []string
-	geoipDB                *geoip2.Reader
-	corsOriginsOnce        sync.Once
-	corsAllowedOrigins     map[string]struct{}
+	geoipDB            *geoip2.Reader
+	corsOriginsOnce    sync.Once
+	corsAllowedOrigins map[string]struct{}
 )
 
 // Default score thresholds for auto-reset mechanism
@@ -110,13 +82,24 @@ const (
 	ThreatHeaderManipulation = "header_manipulation"
 	ThreatLongHeader         = "long_header"
 	ThreatLongHeaderName     = "long_header_name"
+
+	// Score reasons
+	scoreReasonMethod         = "method_not_allowed"
+	scoreReasonDirectIP       = "direct_ip"
+	scoreReasonRateLimit      = "rate_limit"
+	scoreReasonSuspiciousPath = "suspicious_path"
+	scoreReasonMaliciousUA    = "malicious_ua"
+	scoreReasonSuspiciousUA   = "suspicious_ua"
+	scoreReasonHighScore      = "high_score"
+	scoreReasonLoginLockout   = "login_lockout"
+	scoreReasonExpired        = "expired"
 )
 
-// RateLimit tracks request counts for rate limiting.
-type RateLimit struct {
-	Count     int
-	LastReset time.Time
-	Mutex     sync.Mutex
+// dynamicBlock is a temporary block on an IP, set automatically or by an administrator.
+type dynamicBlock struct {
+	Until  time.Time
+	Reason string
+	Actor  string
 }
 
 // DetectionPatterns defines regex patterns and UA lists for security checks.
@@ -133,12 +116,19 @@ type DetectionPatterns struct {
 type SecurityConfig struct {
 	MaxRequestSizeMB    int64                 `json:"max_request_size_mb"`
 	RateLimitPerMin     int                   `json:"rate_limit_per_min"`
+	RateLimitPolicies   []RateLimitPolicy     `json:"rate_limit_policies,omitempty"`
 	DynamicBlockTimeMin int                   `json:"dynamic_block_time_min"`
 	AllowedMethods      []string              `json:"allowed_methods"`
 	BlockedCountries    []string              `json:"blocked_countries"`
 	Patterns            DetectionPatterns     `json:"detection_patterns"`
 	SecurityLevel       string                `json:"security_level"`
 	BalancedSecure      *BalancedSecureConfig `json:"balanced_secure,omitempty"`
+	Notifiers           []notify.Config       `json:"notifiers,omitempty"`
+
+	// MonitorMode runs every rule but only logs and reports what it would have done.
+	MonitorMode bool `json:"monitor_mode,omitempty"`
+	// MonitorRules puts only the listed rules in monitor mode.
+	MonitorRules []string `json:"monitor_rules,omitempty"`
 }
 
 // BalancedSecureConfig tweaks balanced-secure behavior.
@@ -158,105 +148,17 @@ type BalancedSecureConfig struct {
 	ResetThresholds map[string]interface{} `json:"reset_thresholds"`
 }
 
-var secConfig SecurityConfig
-
+// loadSecurityConfig reads, validates and activates the security configuration.
+// On error the previously active configuration (if any) is left in place.
 func loadSecurityConfig(filepath string) error {
-	file, err := os.Open(filepath)
-	if err != nil {
-		return fmt.Errorf("セキュリティ設定ファイルが開けません: %w", err)
-	}
-	defer func() {
-		if closeErr := file.Close(); closeErr != nil {
-			fmt.Println("セキュリティ設定ファイルのクローズ失敗:", closeErr)
-		}
-	}()
-
-	if decodeErr := json.NewDecoder(file).Decode(&secConfig); decodeErr != nil {
-		return fmt.Errorf("セキュリティ設定のJSONデコードに失敗: %w", decodeErr)
-	}
-
-	if secConfig.SecurityLevel == "" {
-		secConfig.SecurityLevel = SecurityLevelBalanced
-	}
-
-	// 正規表現をコンパイル
-	sqlInjectionPattern, err = regexp.Compile(secConfig.Patterns.SQLInjection)
-	if err != nil {
-		return fmt.Errorf("SQL Injection パターンのコンパイルに失敗: %w", err)
-	}
-	xssPattern, err = regexp.Compile(secConfig.Patterns.XSS)
+	state, err := readSecurityState(filepath)
 	if err != nil {
-		return fmt.Errorf("XSS パターンのコンパイルに失敗: %w", err)
-	}
-	pathTraversalPattern, err = regexp.Compile(secConfig.Patterns.PathTraversal)
-	if err != nil {
-		return fmt.Errorf("path traversal パターンのコンパイルに失敗: %w", err)
+		return err
 	}
-
-	maliciousUAPatterns = secConfig.Patterns.MaliciousUA
-	suspiciousUAPatterns = secConfig.Patterns.SuspiciousUA
-	suspiciousPathPatterns = secConfig.Patterns.SuspiciousPath
-
+	activeSecurity.Store(state)
 	return nil
 }
 
-// loadFirstAccessIPs loads the first access IP tracking data from disk.
-// It reads from firstAccessIPsFile and populates the firstAccessIPs map.
-// Errors during loading are silently ignored as the file may not exist on first run.
-func loadFirstAccessIPs() {
-	data, err := os.ReadFile(firstAccessIPsFile)
-	if err != nil {
-		return
-	}
-
-	var fileData struct {
-		IPs map[string]string `json:"ips"`
-	}
-
-	if err := json.Unmarshal(data, &fileData); err != nil {
-		return
-	}
-
-	firstAccessIPsMutex.Lock()
-	defer firstAccessIPsMutex.Unlock()
-
-	firstAccessIPs = make(map[string]time.Time)
-	for ip, timeStr := range fileData.IPs {
-		if t, err := time.Parse(time.RFC3339, timeStr); err == nil {
-			firstAccessIPs[ip] = t
-		}
-	}
-}
-
-// saveFirstAccessIPs persists the first access IP tracking data to disk.
-// It writes the current state of firstAccessIPs to firstAccessIPsFile.
-// This is called periodically (every 10 minutes) to batch writes and reduce disk I/O.
-// Errors during saving are logged to stderr for administrator visibility.
-func saveFirstAccessIPs() {
-	firstAccessIPsMutex.RLock()
-	defer firstAccessIPsMutex.RUnlock()
-
-	fileData := struct {
-		IPs map[string]string `json:"ips"`
-	}{
-		IPs: make(map[string]string),
-	}
-
-	for ip, t := range firstAccessIPs {
-		fileData.IPs[ip] = t.Format(time.RFC3339)
-	}
-
-	data, err := json.MarshalIndent(fileData, "", "  ")
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "failed to marshal first access IPs to JSON: %v\n", err)
-		return
-	}
-
-	if err := os.WriteFile(firstAccessIPsFile, data, 0644); err != nil {
-		fmt.Fprintf(os.Stderr, "failed to write first access IPs file %q: %v\n", firstAccessIPsFile, err)
-	}
-}
-
 // cleanupFirstAccessIPs removes expired first access IP entries.
 // It deletes any IPs whose grace period has expired (expiry time has passed).
 // This is called periodically by cleanupMaps() to prevent unbounded growth.
@@ -280,7 +182,7 @@ func isFirstAccessIP(ip string) (bool, time.Time) {
 	defer firstAccessIPsMutex.RUnlock()
 
 	if expiry, exists := firstAccessIPs[ip]; exists {
-		if time.Now().Before(expiry) {
+		if securityNow().Before(expiry) {
 			return true, expiry
 		}
 	}
@@ -290,6 +192,7 @@ func isFirstAccessIP(ip string) (bool, time.Time) {
 // getGracePeriodMinutes returns the configured grace period in minutes.
 // Returns 60 minutes as default if not configured.
 func getGracePeriodMinutes() int {
+	secConfig := currentSecurityConfig()
 	if secConfig.SecurityLevel == SecurityLevelBalanced && secConfig.BalancedSecure != nil {
 		if secConfig.BalancedSecure.FirstAccessGracePeriodMin > 0 {
 			return secConfig.BalancedSecure.FirstAccessGracePeriodMin
@@ -307,6 +210,7 @@ func getGracePeriodMinutes() int {
 //
 // In balanced-secure mode, values are read from configuration; other modes use defaults.
 func getResetDuration(score int) time.Duration {
+	secConfig := currentSecurityConfig()
 	if secConfig.SecurityLevel != SecurityLevelBalanced || secConfig.BalancedSecure == nil {
 		return getResetDurationDefault(score)
 	}
@@ -366,7 +270,7 @@ func shouldResetScore(ip string, currentScore int) bool {
 
 	if !exists {
 		// First time seeing this IP with a score, record reset time
-		ipScoreLastReset[ip] = time.Now()
+		ipScoreLastReset[ip] = securityNow()
 		return false
 	}
 
@@ -375,7 +279,7 @@ func shouldResetScore(ip string, currentScore int) bool {
 		return false // score is at block level
 	}
 
-	if time.Since(lastReset) >= resetDuration {
+	if securityNow().Sub(lastReset) >= resetDuration {
 		return true
 	}
 
@@ -394,27 +298,33 @@ func resetIPScore(ip string) {
 	delete(ipScoreLastReset, ip)
 	ipScoreResetMutex.Unlock()
 
-	saveScores()
+	markScoreDirty(ip)
 }
 
-func init() {
-	if err := loadTrustedIPs("./json/trusted_ips.json"); err != nil {
+// initSecurity loads the trusted list, security configuration and GeoIP database,
+// then starts the cleanup and configuration watchers.
+func initSecurity() {
+	if err := loadTrustedIPs(trustedIPsFile); err != nil {
 		fmt.Println("trusted_ips.json の読み込みに失敗しました:", err)
 		os.Exit(1)
 	}
 
-	if err := loadSecurityConfig("./json/security_config.json"); err != nil {
+	if err := loadSecurityConfig(securityConfigPath); err != nil {
 		fmt.Println("security_config.json の読み込みに失敗しました:", err)
 		os.Exit(1)
 	}
 
-	loadFirstAccessIPs()
+	if err := loadTrustedProxies(getTrustedProxiesPath()); err != nil {
+		fmt.Println("信頼プロキシ設定の読み込みに失敗しました:", err)
+		os.Exit(1)
+	}
 
 	if err := loadGeoIPDatabase("./geoip/GeoLite2-Country.mmdb"); err != nil {
 		fmt.Println("警告: GeoIPデータベースが読み込めませんでした。国別ブロック機能は無効になります。:", err)
 	}
 
 	go startCleanupRoutine()
+	go watchSecurityConfig(securityConfigPath)
 }
 
 func startCleanupRoutine() {
@@ -429,20 +339,12 @@ func startCleanupRoutine() {
 func cleanupMaps() {
 	now := time.Now()
 
-	rateLimitMutex.Lock()
-	for ip, rateLimit := range rateLimitMap {
-		rateLimit.Mutex.Lock()
-		if now.Sub(rateLimit.LastReset) > 5*time.Minute {
-			delete(rateLimitMap, ip)
-		}
-		rateLimit.Mutex.Unlock()
-	}
-	rateLimitMutex.Unlock()
+	cleanupRateLimitBuckets(now)
+	cleanupShadowState(now)
 
 	dynamicBlockMutex.Lock()
-	blockDuration := time.Duration(secConfig.DynamicBlockTimeMin) * time.Minute
-	for ip, blockTime := range dynamicBlockMap {
-		if now.Sub(blockTime) > blockDuration {
+	for ip, block := range dynamicBlockMap {
+		if now.After(block.Until) {
 			delete(dynamicBlockMap, ip)
 		}
 	}
@@ -462,7 +364,11 @@ func cleanupMaps() {
 	ipScoresMutex.RUnlock()
 
 	cleanupFirstAccessIPs()
-	saveFirstAccessIPs()
+	cleanupLoginAttempts()
+	cleanupOIDCPendingLogins()
+	cleanupIPEvents()
+	pruneSecurityStore()
+	maintainSecurityLogs()
 }
 
 func loadTrustedIPs(filepath string) error {
@@ -494,7 +400,9 @@ func loadTrustedIPs(filepath string) error {
 				continue
 			}
 		}
+		trustedCIDRsMutex.Lock()
 		trustedCIDRs = append(trustedCIDRs, ipnet)
+		trustedCIDRsMutex.Unlock()
 	}
 	return nil
 }
@@ -504,6 +412,8 @@ func isTrustedIP(ipStr string) bool {
 	if ip == nil {
 		return false
 	}
+	trustedCIDRsMutex.RLock()
+	defer trustedCIDRsMutex.RUnlock()
 	for _, cidr := range trustedCIDRs {
 		if cidr.Contains(ip) {
 			return true
@@ -522,6 +432,7 @@ func loadGeoIPDatabase(filepath string) error {
 }
 
 func isBlockedCountry(ipStr string) bool {
+	secConfig := currentSecurityConfig()
 	if geoipDB == nil || len(secConfig.BlockedCountries) == 0 {
 		return false
 	}
@@ -559,29 +470,12 @@ func isPrivateOrLoopback(ip string) bool {
 	return parsed.IsLoopback() || parsed.IsPrivate()
 }
 
-func loadScores() {
-	data, err := os.ReadFile(scoreFile)
-	if err == nil {
-		var tempScores map[string]int
-		if json.Unmarshal(data, &tempScores) == nil {
-			ipScoresMutex.Lock()
-			ipScores = tempScores
-			ipScoresMutex.Unlock()
-		}
-	}
-}
-
-func saveScores() {
-	ipScoresMutex.RLock()
-	data, _ := json.MarshalIndent(ipScores, "", "  ")
-	ipScoresMutex.RUnlock()
-	_ = os.WriteFile(scoreFile, data, 0644)
-}
-
-func incrementScore(ip string, amount int) {
+// incrementScore adds amount to an IP's security score and records why in its history.
+func incrementScore(ip string, amount int, reason, path string) {
 	if isTrustedIP(ip) || isPrivateOrLoopback(ip) {
 		return
 	}
+	recordIPEvent(ip, ipEvent{Kind: ipEventScore, Delta: amount, Reason: reason, Path: path})
 
 	ipScoresMutex.Lock()
 	ipScores[ip] += amount
@@ -591,30 +485,37 @@ func incrementScore(ip string, amount int) {
 	// This ensures that continued suspicious activity extends the reset period,
 	// even if the score stays within the same threshold bracket.
 	ipScoreResetMutex.Lock()
-	ipScoreLastReset[ip] = time.Now()
+	ipScoreLastReset[ip] = securityNow()
 	ipScoreResetMutex.Unlock()
 
-	saveScores()
+	markScoreDirty(ip)
 }
 
+// isFromCloudflare reports whether the request came straight from a Cloudflare edge.
+// Cloudflare's headers prove nothing on their own, since any client can send them.
 func isFromCloudflare(r *http.Request) bool {
-	return r.Header.Get("CF-Connecting-IP") != "" || r.Header.Get("cf-ray") != ""
+	remoteIP, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		remoteIP = r.RemoteAddr
+	}
+	return isCloudflareEdge(net.ParseIP(remoteIP))
 }
 
 func detectSuspiciousUA(ua string) string {
+	sec := currentSecurity()
 	lower := strings.ToLower(ua)
 
 	if ua == "" || len(ua) < 10 {
 		return ActionDeny
 	}
 
-	for _, pattern := range maliciousUAPatterns {
+	for _, pattern := range sec.config.Patterns.MaliciousUA {
 		if strings.Contains(lower, pattern) {
 			return ActionDeny
 		}
 	}
 
-	for _, pattern := range suspiciousUAPatterns {
+	for _, pattern := range sec.config.Patterns.SuspiciousUA {
 		if strings.Contains(lower, pattern) {
 			return ActionWarn
 		}
@@ -624,7 +525,7 @@ func detectSuspiciousUA(ua string) string {
 		return ActionWarn
 	}
 
-	if sqlInjectionPattern.MatchString(ua) || xssPattern.MatchString(ua) {
+	if sec.sqlInjection.MatchString(ua) || sec.xss.MatchString(ua) {
 		return ActionDeny
 	}
 
@@ -632,62 +533,29 @@ func detectSuspiciousUA(ua string) string {
 }
 
 func isSuspiciousPath(path string) bool {
+	sec := currentSecurity()
 	lower := strings.ToLower(path)
-	for _, pattern := range suspiciousPathPatterns {
+	for _, pattern := range sec.config.Patterns.SuspiciousPath {
 		if strings.Contains(lower, pattern) {
 			return true
 		}
 	}
 
-	if sqlInjectionPattern.MatchString(path) {
+	if sec.sqlInjection.MatchString(path) {
 		return true
 	}
 
-	if xssPattern.MatchString(path) {
+	if sec.xss.MatchString(path) {
 		return true
 	}
 
-	if pathTraversalPattern.MatchString(path) {
+	if sec.pathTraversal.MatchString(path) {
 		return true
 	}
 
 	return false
 }
 
-func checkRateLimit(ip string, path string) bool {
-	for _, ext := range rateLimitExemptExtensions {
-		if strings.HasSuffix(strings.ToLower(path), ext) {
-			return true
-		}
-	}
-
-	if isTrustedIP(ip) || isPrivateOrLoopback(ip) {
-		return true
-	}
-
-	rateLimitMutex.Lock()
-	defer rateLimitMutex.Unlock()
-
-	if rateLimit, exists := rateLimitMap[ip]; exists {
-		rateLimit.Mutex.Lock()
-		defer rateLimit.Mutex.Unlock()
-
-		if time.Since(rateLimit.LastReset) > time.Minute {
-			rateLimit.Count = 0
-			rateLimit.LastReset = time.Now()
-		}
-
-		rateLimit.Count++
-		return rateLimit.Count <= secConfig.RateLimitPerMin
-	}
-
-	rateLimitMap[ip] = &RateLimit{
-		Count:     1,
-		LastReset: time.Now(),
-	}
-	return true
-}
-
 func isDynamicallyBlocked(ip string) bool {
 	if isTrustedIP(ip) || isPrivateOrLoopback(ip) {
 		return false
@@ -696,9 +564,8 @@ func isDynamicallyBlocked(ip string) bool {
 	dynamicBlockMutex.Lock()
 	defer dynamicBlockMutex.Unlock()
 
-	blockDuration := time.Duration(secConfig.DynamicBlockTimeMin) * time.Minute
-	if blockTime, exists := dynamicBlockMap[ip]; exists {
-		if time.Since(blockTime) < blockDuration {
+	if block, exists := dynamicBlockMap[ip]; exists {
+		if securityNow().Before(block.Until) {
 			return true
 		}
 		delete(dynamicBlockMap, ip)
@@ -706,18 +573,30 @@ func isDynamicallyBlocked(ip string) bool {
 	return false
 }
 
-func addDynamicBlock(ip string) {
+// addDynamicBlock blocks an IP for the configured dynamic block time.
+func addDynamicBlock(ip, reason string) {
 	if isTrustedIP(ip) || isPrivateOrLoopback(ip) {
 		return
 	}
 
+	blockDuration := time.Duration(currentSecurityConfig().DynamicBlockTimeMin) * time.Minute
+	until := securityNow().Add(blockDuration)
+
 	dynamicBlockMutex.Lock()
-	defer dynamicBlockMutex.Unlock()
-	dynamicBlockMap[ip] = time.Now()
+	// Never shorten a longer block, such as one set manually by an administrator.
+	if existing, exists := dynamicBlockMap[ip]; exists && existing.Until.After(until) {
+		dynamicBlockMutex.Unlock()
+		return
+	}
+	dynamicBlockMap[ip] = dynamicBlock{Until: until, Reason: reason}
+	dynamicBlockMutex.Unlock()
+	markBlockDirty(ip)
+
+	recordIPEvent(ip, ipEvent{Kind: ipEventBlock, Reason: reason, Until: &until})
 }
 
 func isAllowedMethod(method string) bool {
-	for _, allowed := range secConfig.AllowedMethods {
+	for _, allowed := range currentSecurityConfig().AllowedMethods {
 		if method == allowed {
 			return true
 		}
@@ -785,7 +664,7 @@ func applyCORSHeaders(w http.ResponseWriter, r *http.Request) bool {
 }
 
 func addSecurityHeaders(w http.ResponseWriter, r *http.Request) {
-	if secConfig.SecurityLevel != SecurityLevelRelaxed {
+	if currentSecurityConfig().SecurityLevel != SecurityLevelRelaxed {
 		w.Header().Set("X-Content-Type-Options", "nosniff")
 	}
 	w.Header().Set("X-Frame-Options", "DENY")
@@ -798,7 +677,7 @@ func addSecurityHeaders(w http.ResponseWriter, r *http.Request) {
 }
 
 func detectAdvancedThreats(r *http.Request) string {
-	maxSize := secConfig.MaxRequestSizeMB * 1024 * 1024
+	maxSize := currentSecurityConfig().MaxRequestSizeMB * 1024 * 1024
 	if r.Method == http.MethodPost || r.Method == http.MethodPut {
 		if r.ContentLength > maxSize {
 			return ThreatOversized
@@ -851,8 +730,15 @@ func logRequest(r *http.Request, ip, level string) {
 	hasher := sha256.Sum256([]byte(reqData))
 	reqHash := hex.EncodeToString(hasher[:])[:16]
 
+	switch level {
+	case ActionInfo, ActionWarn, ActionAttack, "error", ActionBlock:
+	default:
+		level = ActionInfo
+	}
+
+	now := time.Now()
 	entry := LogEntry{
-		Timestamp:   time.Now().Format(time.RFC3339),
+		Timestamp:   now.Format(time.RFC3339),
 		Level:       strings.ToUpper(level),
 		Method:      r.Method,
 		Path:        r.URL.Path,
@@ -866,55 +752,16 @@ func logRequest(r *http.Request, ip, level string) {
 		fmt.Println("Failed to marshal log entry:", err)
 		return
 	}
+	writeSecurityLog(entry, logData, now)
 
-	switch level {
-	case ActionInfo, ActionWarn, ActionAttack, "error", ActionBlock:
-	default:
-		level = ActionInfo
-	}
-
-	safeIP := sanitizeIP(ip)
-	baseDir := "./log"
-	dir := filepath.Join(baseDir, level, safeIP)
-	dir = filepath.Clean(dir)
-
-	if !strings.HasPrefix(dir, filepath.Clean(baseDir)) {
-		return
-	}
-
-	if mkdirErr := os.MkdirAll(dir, fs.ModePerm); mkdirErr != nil {
-		return
-	}
-
-	logFile := filepath.Join(dir, time.Now().Format("2006-01-02")+".log")
-	f, err := os.OpenFile(logFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
-	if err == nil {
-		defer func() {
-			if err := f.Close(); err != nil {
-				fmt.Println("Failed to close log file:", err)
-			}
-		}()
-		if _, writeErr := f.WriteString(string(logData) + "\n"); writeErr != nil {
-			fmt.Println("Failed to write log file:", writeErr)
-		}
-	}
-
-	if level == ActionWarn || level == ActionAttack || level == ActionBlock {
-		if isTrustedIP(ip) || isPrivateOrLoopback(ip) {
-			return
-		}
-		go sendDiscordWebhook(ip, level, r.Method, r.URL.Path, r.UserAgent(), reqHash)
-	}
-}
-
-func sanitizeIP(ip string) string {
-	var sb strings.Builder
-	for _, r := range ip {
-		if (r >= '0' && r <= '9') || r == '.' || r == ':' || r == '-' || r == '_' {
-			sb.WriteRune(r)
-		}
-	}
-	return sb.String()
+	dispatchSecurityAlert(notify.Alert{
+		Level:       level,
+		IP:          ip,
+		Method:      r.Method,
+		Path:        r.URL.Path,
+		UserAgent:   r.UserAgent(),
+		RequestHash: reqHash,
+	})
 }
 
 type securityContext struct {
@@ -926,6 +773,8 @@ type securityContext struct {
 	isBalancedSecure   bool
 	isFirstAccess      bool
 	nonCloudflareBoost int
+	monitor            *monitorRequest
+	trace              *securityTrace
 }
 
 func handlePreflight(w http.ResponseWriter, r *http.Request) bool {
@@ -940,32 +789,23 @@ func handlePreflight(w http.ResponseWriter, r *http.Request) bool {
 	return true
 }
 
-func handleBypassRoutes(w http.ResponseWriter, r *http.Request, next http.HandlerFunc, ip string) bool {
+func isBypassRoute(r *http.Request, ip string) bool {
 	if ip == "127.0.0.1" || ip == "::1" || ip == "localhost" {
-		next(w, r)
 		return true
 	}
-
-	if strings.HasPrefix(r.URL.Path, "/api/webauthn/") {
-		next(w, r)
-		return true
-	}
-
-	return false
+	return strings.HasPrefix(r.URL.Path, "/api/webauthn/")
 }
 
-func handleTrustedRequest(w http.ResponseWriter, r *http.Request, next http.HandlerFunc, ctx securityContext) bool {
-	if !ctx.isPrivateIP && !ctx.isTrusted {
-		return false
-	}
-
+// handleTrustedRequest only logs requests from trusted and private addresses.
+// It stops them solely for a disallowed method.
+func handleTrustedRequest(w http.ResponseWriter, r *http.Request, ctx securityContext) bool {
 	if !isAllowedMethod(r.Method) {
 		logRequest(r, ctx.ip, ActionWarn)
 		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
 		return true
 	}
 
-	if !checkRateLimit(ctx.ip, r.URL.Path) {
+	if !checkRateLimit(r, ctx.ip).Allowed {
 		logRequest(r, ctx.ip, ActionWarn)
 	}
 
@@ -974,9 +814,7 @@ func handleTrustedRequest(w http.ResponseWriter, r *http.Request, next http.Hand
 	} else {
 		logRequest(r, ctx.ip, ActionInfo)
 	}
-
-	next(w, r)
-	return true
+	return false
 }
 
 func prepareScoreState(ip string) int {
@@ -986,6 +824,7 @@ func prepareScoreState(ip string) int {
 
 	if currentScore > 0 && shouldResetScore(ip, currentScore) {
 		resetIPScore(ip)
+		recordIPEvent(ip, ipEvent{Kind: ipEventReset, Delta: -currentScore, Reason: scoreReasonExpired})
 		currentScore = 0
 	}
 
@@ -1003,10 +842,13 @@ func resolveFirstAccess(ip string, currentScore int) bool {
 			firstAccessIPsMutex.Lock()
 			if _, alreadyRecorded := firstAccessIPs[ip]; !alreadyRecorded {
 				gracePeriod := getGracePeriodMinutes()
-				firstAccessIPs[ip] = time.Now().Add(time.Duration(gracePeriod) * time.Minute)
+				firstAccessIPs[ip] = securityNow().Add(time.Duration(gracePeriod) * time.Minute)
 				isFirstAccess = true
 			}
 			firstAccessIPsMutex.Unlock()
+			if isFirstAccess {
+				markFirstAccessDirty(ip)
+			}
 		}
 	}
 
@@ -1017,16 +859,16 @@ func handleBalancedCloudflareBlock(w http.ResponseWriter, r *http.Request, ctx s
 	if !ctx.isBalancedSecure || ctx.isFirstAccess {
 		return false
 	}
-	if secConfig.BalancedSecure == nil || !secConfig.BalancedSecure.RequireCloudflare {
+	if balanced := currentSecurityConfig().BalancedSecure; balanced == nil || !balanced.RequireCloudflare {
 		return false
 	}
 	if isFromCloudflare(r) {
 		return false
 	}
 
-	logRequest(r, ctx.ip, ActionBlock)
-	http.Error(w, "Access Denied", http.StatusForbidden)
-	return true
+	return ctx.stop(r, ruleRequireCloudflare, ActionBlock, "403", func() {
+		http.Error(w, "Access Denied", http.StatusForbidden)
+	})
 }
 
 func handleCountryBlock(w http.ResponseWriter, r *http.Request, ctx securityContext) bool {
@@ -1034,9 +876,9 @@ func handleCountryBlock(w http.ResponseWriter, r *http.Request, ctx securityCont
 		return false
 	}
 
-	logRequest(r, ctx.ip, ActionBlock)
-	http.Error(w, "Access Denied", http.StatusForbidden)
-	return true
+	return ctx.stop(r, ruleBlockedCountry, ActionBlock, "403", func() {
+		http.Error(w, "Access Denied", http.StatusForbidden)
+	})
 }
 
 func handleMethodValidation(w http.ResponseWriter, r *http.Request, ctx securityContext) bool {
@@ -1044,14 +886,14 @@ func handleMethodValidation(w http.ResponseWriter, r *http.Request, ctx security
 		return false
 	}
 
-	incrementScore(ctx.ip, 10)
+	ctx.addScore(r, ruleMethod, 10, scoreReasonMethod)
+	level := ActionAttack
 	if ctx.isBalancedSecure {
-		logRequest(r, ctx.ip, ActionBlock)
-	} else {
-		logRequest(r, ctx.ip, ActionAttack)
+		level = ActionBlock
 	}
-	http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
-	return true
+	return ctx.stop(r, ruleMethod, level, "405", func() {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+	})
 }
 
 func handleDirectIPBlock(w http.ResponseWriter, r *http.Request, ctx securityContext) bool {
@@ -1059,55 +901,58 @@ func handleDirectIPBlock(w http.ResponseWriter, r *http.Request, ctx securityCon
 		return false
 	}
 
-	if ctx.isBalancedSecure {
-		logRequest(r, ctx.ip, ActionBlock)
-		http.Redirect(w, r, "/error/403", http.StatusFound)
-		return true
+	level := ActionBlock
+	if !ctx.isBalancedSecure {
+		ctx.addScore(r, ruleDirectIP, 5, scoreReasonDirectIP)
+		level = ActionWarn
 	}
-
-	incrementScore(ctx.ip, 5)
-	logRequest(r, ctx.ip, ActionWarn)
-	http.Redirect(w, r, "/error/403", http.StatusFound)
-	return true
+	return ctx.stop(r, ruleDirectIP, level, "302 /error/403", func() {
+		http.Redirect(w, r, "/error/403", http.StatusFound)
+	})
 }
 
 func handleRateLimitCheck(w http.ResponseWriter, r *http.Request, ctx securityContext) bool {
-	if checkRateLimit(ctx.ip, r.URL.Path) {
+	decision := checkRateLimit(r, ctx.ip)
+	writeRateLimitHeaders(w, decision)
+	if decision.Allowed {
 		return false
 	}
 
+	tooMany := func() {
+		http.Error(w, "Rate Limit Exceeded", http.StatusTooManyRequests)
+	}
 	switch {
 	case ctx.isRelaxedMode:
 		logRequest(r, ctx.ip, ActionWarn)
 		return false
-	case ctx.isBalancedSecure:
-		if ctx.isFirstAccess {
-			incrementScore(ctx.ip, 5)
-			logRequest(r, ctx.ip, ActionWarn)
-			return false
-		}
-		incrementScore(ctx.ip, 5)
-		logRequest(r, ctx.ip, ActionBlock)
-		addDynamicBlock(ctx.ip)
-		http.Error(w, "Rate Limit Exceeded", http.StatusTooManyRequests)
-		return true
+	case !decision.Policy.Penalize:
+		// Route policies only throttle; the caller may retry after Retry-After.
+		return ctx.stop(r, ruleRateLimit, ActionWarn, "429", tooMany)
+	case ctx.isBalancedSecure && ctx.isFirstAccess:
+		ctx.addScore(r, ruleRateLimit, 5, scoreReasonRateLimit)
+		logRequest(r, ctx.ip, ActionWarn)
+		return false
 	default:
-		incrementScore(ctx.ip, 5)
-		logRequest(r, ctx.ip, ActionBlock)
-		addDynamicBlock(ctx.ip)
-		http.Error(w, "Rate Limit Exceeded", http.StatusTooManyRequests)
-		return true
+		ctx.addScore(r, ruleRateLimit, 5, scoreReasonRateLimit)
+		ctx.block(ruleRateLimit, scoreReasonRateLimit)
+		return ctx.stop(r, ruleRateLimit, ActionBlock, "429", tooMany)
 	}
 }
 
 func handleDynamicBlock(w http.ResponseWriter, r *http.Request, ctx securityContext) bool {
-	if ctx.isRelaxedMode || !isDynamicallyBlocked(ctx.ip) {
+	if ctx.isRelaxedMode {
 		return false
 	}
 
-	logRequest(r, ctx.ip, ActionBlock)
-	http.Error(w, "Access Denied", http.StatusForbidden)
-	return true
+	if isDynamicallyBlocked(ctx.ip) {
+		return ctx.stop(r, ruleDynamicBlock, ActionBlock, "403", func() {
+			http.Error(w, "Access Denied", http.StatusForbidden)
+		})
+	}
+	if ctx.monitor != nil && isShadowBlocked(ctx.ip) {
+		ctx.observe(r, ruleDynamicBlock, ActionBlock, "403")
+	}
+	return false
 }
 
 func handleAdvancedThreats(w http.ResponseWriter, r *http.Request, ctx securityContext) bool {
@@ -1122,15 +967,15 @@ func handleAdvancedThreats(w http.ResponseWriter, r *http.Request, ctx securityC
 
 	switch threatLevel {
 	case ThreatOversized:
-		incrementScore(ctx.ip, 8)
-		logRequest(r, ctx.ip, ActionAttack)
-		http.Error(w, "Request Too Large", http.StatusRequestEntityTooLarge)
-		return true
+		ctx.addScore(r, ruleAdvancedThreat, 8, threatLevel)
+		return ctx.stop(r, ruleAdvancedThreat, ActionAttack, "413", func() {
+			http.Error(w, "Request Too Large", http.StatusRequestEntityTooLarge)
+		})
 	case ThreatHeaderManipulation, ThreatLongHeader, ThreatLongHeaderName:
-		incrementScore(ctx.ip, 7)
-		logRequest(r, ctx.ip, ActionAttack)
-		http.Redirect(w, r, "/error/403", http.StatusFound)
-		return true
+		ctx.addScore(r, ruleAdvancedThreat, 7, threatLevel)
+		return ctx.stop(r, ruleAdvancedThreat, ActionAttack, "302 /error/403", func() {
+			http.Redirect(w, r, "/error/403", http.StatusFound)
+		})
 	default:
 		return false
 	}
@@ -1148,16 +993,16 @@ func handleSuspiciousPath(w http.ResponseWriter, r *http.Request, ctx securityCo
 		return false
 	}
 
-	incrementScore(ctx.ip, 8+ctx.nonCloudflareBoost)
-	logRequest(r, ctx.ip, ActionAttack)
+	ctx.addScore(r, ruleSuspiciousPath, 8+ctx.nonCloudflareBoost, scoreReasonSuspiciousPath)
 
 	if strings.Contains(strings.ToLower(r.URL.Path), "sql") ||
 		strings.Contains(strings.ToLower(r.URL.Path), "script") {
-		addDynamicBlock(ctx.ip)
+		ctx.block(ruleSuspiciousPath, scoreReasonSuspiciousPath)
 	}
 
-	http.Redirect(w, r, "/error/403", http.StatusFound)
-	return true
+	return ctx.stop(r, ruleSuspiciousPath, ActionAttack, "302 /error/403", func() {
+		http.Redirect(w, r, "/error/403", http.StatusFound)
+	})
 }
 
 func handleUserAgent(w http.ResponseWriter, r *http.Request, ctx securityContext) bool {
@@ -1165,31 +1010,35 @@ func handleUserAgent(w http.ResponseWriter, r *http.Request, ctx securityContext
 	switch uaStatus {
 	case ActionDeny:
 		if ctx.isRelaxedMode {
-			incrementScore(ctx.ip, 1+ctx.nonCloudflareBoost)
+			ctx.addScore(r, ruleUserAgent, 1+ctx.nonCloudflareBoost, scoreReasonMaliciousUA)
 			logRequest(r, ctx.ip, ActionWarn)
 			return false
 		}
 		if ctx.isBalancedSecure && ctx.isFirstAccess {
-			incrementScore(ctx.ip, 8+ctx.nonCloudflareBoost)
+			ctx.addScore(r, ruleUserAgent, 8+ctx.nonCloudflareBoost, scoreReasonMaliciousUA)
 			logRequest(r, ctx.ip, ActionWarn)
 			return false
 		}
-		incrementScore(ctx.ip, 8+ctx.nonCloudflareBoost)
-		logRequest(r, ctx.ip, ActionAttack)
-		addDynamicBlock(ctx.ip)
-		http.Redirect(w, r, "/error/403", http.StatusFound)
-		return true
+		ctx.addScore(r, ruleUserAgent, 8+ctx.nonCloudflareBoost, scoreReasonMaliciousUA)
+		ctx.block(ruleUserAgent, scoreReasonMaliciousUA)
+		if ctx.stop(r, ruleUserAgent, ActionAttack, "302 /error/403", func() {
+			http.Redirect(w, r, "/error/403", http.StatusFound)
+		}) {
+			return true
+		}
+		logRequest(r, ctx.ip, ActionInfo)
+		return false
 	case ActionWarn:
 		if ctx.isRelaxedMode {
 			logRequest(r, ctx.ip, ActionInfo)
 			return false
 		}
 		if ctx.isBalancedSecure && ctx.isFirstAccess {
-			incrementScore(ctx.ip, 4+ctx.nonCloudflareBoost)
+			ctx.addScore(r, ruleUserAgent, 4+ctx.nonCloudflareBoost, scoreReasonSuspiciousUA)
 			logRequest(r, ctx.ip, ActionInfo)
 			return false
 		}
-		incrementScore(ctx.ip, 4+ctx.nonCloudflareBoost)
+		ctx.addScore(r, ruleUserAgent, 4+ctx.nonCloudflareBoost, scoreReasonSuspiciousUA)
 		logRequest(r, ctx.ip, ActionWarn)
 		return false
 	default:
@@ -1210,25 +1059,28 @@ func handleFinalScore(w http.ResponseWriter, r *http.Request, ctx securityContex
 		return false
 	}
 
+	threshold := ScoreThresholdMedium
+	respond := func() {
+		http.Redirect(w, r, "/error/503", http.StatusFound)
+	}
 	if ctx.isBalancedSecure {
-		if finalScore >= ScoreThresholdBlock {
+		threshold = ScoreThresholdBlock
+		respond = func() {
 			w.Header().Set("X-Blocked-Reason", "High Security Score")
 			w.Header().Set("X-Contact-Support", "https://daruks.com/contact")
-			addDynamicBlock(ctx.ip)
-			logRequest(r, ctx.ip, ActionBlock)
 			http.Redirect(w, r, "/error/503", http.StatusFound)
-			return true
 		}
-		return false
 	}
 
-	if finalScore >= ScoreThresholdMedium {
-		addDynamicBlock(ctx.ip)
-		logRequest(r, ctx.ip, ActionBlock)
-		http.Redirect(w, r, "/error/503", http.StatusFound)
-		return true
+	if finalScore >= threshold {
+		ctx.block(ruleHighScore, scoreReasonHighScore)
+		return ctx.stop(r, ruleHighScore, ActionBlock, "302 /error/503", respond)
+	}
+	// Scores held back by monitored rules show what the block threshold would have caught.
+	if ctx.monitor != nil && finalScore+shadowScoreFor(ctx.ip) >= threshold {
+		addShadowBlock(ctx.ip)
+		ctx.observe(r, ruleHighScore, ActionBlock, "302 /error/503")
 	}
-
 	return false
 }
 
@@ -1240,115 +1092,82 @@ func secureHandler(next http.HandlerFunc) http.HandlerFunc {
 			return
 		}
 
-		ip := getIPAddress(r)
-		ua := r.UserAgent()
-		loadScores()
-
-		if handleBypassRoutes(w, r, next, ip) {
-			return
-		}
-
-		ctx := securityContext{
-			ip:               ip,
-			ua:               ua,
-			isPrivateIP:      isPrivateOrLoopback(ip),
-			isTrusted:        isTrustedIP(ip),
-			isRelaxedMode:    secConfig.SecurityLevel == SecurityLevelRelaxed,
-			isBalancedSecure: secConfig.SecurityLevel == SecurityLevelBalanced,
-		}
-
-		if handleTrustedRequest(w, r, next, ctx) {
-			return
-		}
-
-		currentScore := prepareScoreState(ip)
-		ctx.isFirstAccess = resolveFirstAccess(ip, currentScore)
-
-		if handleBalancedCloudflareBlock(w, r, ctx) {
-			return
-		}
-		if handleCountryBlock(w, r, ctx) {
-			return
-		}
-		if handleMethodValidation(w, r, ctx) {
-			return
-		}
-		if handleDirectIPBlock(w, r, ctx) {
-			return
-		}
-		if handleRateLimitCheck(w, r, ctx) {
-			return
-		}
-		if handleDynamicBlock(w, r, ctx) {
-			return
+		if evaluateSecurity(w, r, getIPAddress(r), nil) && verifyCSRF(w, r) {
+			next(w, r)
 		}
-		if handleAdvancedThreats(w, r, ctx) {
-			return
-		}
-
-		ctx.nonCloudflareBoost = calculateNonCloudflareBoost(r, ctx.isBalancedSecure)
-		if handleSuspiciousPath(w, r, ctx) {
-			return
-		}
-		if handleUserAgent(w, r, ctx) {
-			return
-		}
-		if handleFinalScore(w, r, ctx) {
-			return
-		}
-
-		next(w, r)
 	}
 }
 
-func getIPAddress(r *http.Request) string {
-	remoteIP, _, err := net.SplitHostPort(r.RemoteAddr)
-	if err != nil {
-		remoteIP = r.RemoteAddr
+// evaluateSecurity runs every rule for a request from ip and reports whether it may
+// proceed. When it returns false a rule has already answered the request.
+// trace, when set, records what the rules did.
+func evaluateSecurity(w http.ResponseWriter, r *http.Request, ip string, trace *securityTrace) bool {
+	if isBypassRoute(r, ip) {
+		return true
 	}
 
-	if shouldTrustForwardedIP(remoteIP) {
-		if cf := strings.TrimSpace(r.Header.Get("CF-Connecting-IP")); cf != "" {
-			if parsed := net.ParseIP(cf); parsed != nil {
-				return parsed.String()
-			}
-		}
+	secConfig := currentSecurityConfig()
+	ctx := securityContext{
+		ip:               ip,
+		ua:               r.UserAgent(),
+		isPrivateIP:      isPrivateOrLoopback(ip),
+		isTrusted:        isTrustedIP(ip),
+		isRelaxedMode:    secConfig.SecurityLevel == SecurityLevelRelaxed,
+		isBalancedSecure: secConfig.SecurityLevel == SecurityLevelBalanced,
+		monitor:          newMonitorRequest(secConfig),
+		trace:            trace,
+	}
 
-		if xff := r.Header.Get("X-Forwarded-For"); xff != "" {
-			parts := strings.Split(xff, ",")
-			for _, part := range parts {
-				candidate := strings.TrimSpace(part)
-				if parsed := net.ParseIP(candidate); parsed != nil {
-					return parsed.String()
-				}
-			}
-		}
+	if ctx.isPrivateIP || ctx.isTrusted {
+		return !handleTrustedRequest(w, r, ctx)
 	}
 
-	return remoteIP
-}
+	currentScore := prepareScoreState(ip)
+	ctx.isFirstAccess = resolveFirstAccess(ip, currentScore)
 
-func shouldTrustForwardedIP(remoteIP string) bool {
-	if remoteIP == "" {
+	if handleBalancedCloudflareBlock(w, r, ctx) {
 		return false
 	}
-
-	if isPrivateOrLoopback(remoteIP) {
-		return true
+	if handleCountryBlock(w, r, ctx) {
+		return false
 	}
-
-	ip := net.ParseIP(remoteIP)
-	if ip == nil {
+	if handleMethodValidation(w, r, ctx) {
+		return false
+	}
+	if handleDirectIPBlock(w, r, ctx) {
+		return false
+	}
+	if handleRateLimitCheck(w, r, ctx) {
+		return false
+	}
+	if handleDynamicBlock(w, r, ctx) {
+		return false
+	}
+	if handleAdvancedThreats(w, r, ctx) {
 		return false
 	}
 
-	for _, cidr := range trustedProxyCIDRs {
-		if cidr.Contains(ip) {
-			return true
-		}
+	ctx.nonCloudflareBoost = calculateNonCloudflareBoost(r, ctx.isBalancedSecure)
+	if handleSuspiciousPath(w, r, ctx) {
+		return false
+	}
+	if handleUserAgent(w, r, ctx) {
+		return false
 	}
+	if handleFinalScore(w, r, ctx) {
+		return false
+	}
+	return true
+}
 
-	return false
+// getIPAddress returns the client address, honouring forwarding headers only from
+// trusted proxies (see trusted_proxies.go).
+func getIPAddress(r *http.Request) string {
+	remoteIP, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		remoteIP = r.RemoteAddr
+	}
+	return clientIPFromRequest(r, remoteIP)
 }
 
 func parseCIDRs(cidrs []string) []*net.IPNet {
@@ -1374,68 +1193,3 @@ func parseCIDRs(cidrs []string) []*net.IPNet {
 	}
 	return networks
 }
-
-func sendDiscordWebhook(ip, level, method, path, ua, reqHash string) {
-	webhookURL := os.Getenv("DISCORD_WEBHOOK_URL") // 環境変数から取得
-	if webhookURL == "" {
-		fmt.Println("DISCORD_WEBHOOK_URL が設定されていません")
-		return
-	}
-
-	color := 0xFFCC00
-	switch level {
-	case ActionAttack:
-		color = 0xFF0000
-	case ActionBlock:
-		color = 0x800080
-	case ActionWarn:
-		color = 0xFFCC00
-	}
-
-	timestamp := time.Now().Format("2006/01/02 15:04:05")
-	content := fmt.Sprintf("```%s %s %s\nUA: %s\nIP: %s\nTime: %s\nHash: %s```",
-		strings.ToUpper(level), method, path, ua, ip, timestamp, reqHash)
-
-	ipScoresMutex.RLock()
-	currentScore := ipScores[ip]
-	ipScoresMutex.RUnlock()
-
-	fields := []map[string]interface{}{
-		{
-			"name":   "Current IP Score",
-			"value":  strconv.Itoa(currentScore),
-			"inline": true,
-		},
-		{
-			"name":   "Request Hash",
-			"value":  reqHash,
-			"inline": true,
-		},
-	}
-
-	payload := map[string]interface{}{
-		"embeds": []map[string]interface{}{
-			{
-				"title":       fmt.Sprintf("[ALERT] %s アクセス検出", strings.ToUpper(level)),
-				"description": content,
-				"color":       color,
-				"fields":      fields,
-				"timestamp":   time.Now().Format(time.RFC3339),
-			},
-		},
-	}
-
-	jsonPayload, _ := json.Marshal(payload)
-
-	client := &http.Client{Timeout: 10 * time.Second}
-	resp, err := client.Post(webhookURL, "application/json", bytes.NewBuffer(jsonPayload))
-	if err != nil {
-		fmt.Println("Failed to send Discord webhook:", err)
-		return
-	}
-	defer func() {
-		if err := resp.Body.Close(); err != nil {
-			fmt.Println("Failed to close Discord webhook response body:", err)
-		}
-	}()
-}
@@ -0,0 +1,337 @@
+// 2025 TabDock: darui3018823 All rights reserved.
+// All works created by darui3018823 associated with this repository are the intellectual property of darui3018823.
+// Packages and other third-party materials used in this repository are subject to their respective licenses and copyrights.
+
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// loginAttempt tracks consecutive authentication failures for one key
+// (an account name or a client IP). Attempts still being verified count as pending
+// failures, so parallel requests cannot all pass the check before one is recorded.
+type loginAttempt struct {
+	failures    int
+	lastFailure time.Time
+	lockedUntil time.Time
+	pending     int
+	lastAttempt time.Time
+}
+
+// loginReservationTTL bounds how long a pending attempt counts. Requests that end
+// without recording a result, such as for a disabled account, release it this way.
+const loginReservationTTL = time.Minute
+
+// loginGuardConfig holds brute-force protection thresholds.
+type loginGuardConfig struct {
+	// backoffAfter is the number of failures tolerated before delays start.
+	backoffAfter int
+	// lockAfter is the number of failures after which the key is locked.
+	lockAfter   int
+	baseDelay   time.Duration
+	maxDelay    time.Duration
+	lockout     time.Duration
+	forgetAfter time.Duration
+}
+
+var (
+	loginAccountAttempts = map[string]*loginAttempt{}
+	loginIPAttempts      = map[string]*loginAttempt{}
+	loginAttemptsMutex   sync.Mutex
+
+	loginGuardOnce sync.Once
+	loginGuardCfg  loginGuardConfig
+)
+
+const (
+	loginMethodPassword = "password"
+	loginMethodWebAuthn = "webauthn"
+)
+
+func getLoginGuardConfig() loginGuardConfig {
+	loginGuardOnce.Do(func() {
+		loginGuardCfg = loginGuardConfig{
+			backoffAfter: envPositiveInt("LOGIN_BACKOFF_AFTER", 3),
+			lockAfter:    envPositiveInt("LOGIN_LOCK_AFTER", 10),
+			baseDelay:    time.Duration(envPositiveInt("LOGIN_BACKOFF_BASE_SEC", 2)) * time.Second,
+			maxDelay:     time.Duration(envPositiveInt("LOGIN_BACKOFF_MAX_SEC", 300)) * time.Second,
+			lockout:      time.Duration(envPositiveInt("LOGIN_LOCKOUT_MIN", 15)) * time.Minute,
+			forgetAfter:  time.Duration(envPositiveInt("LOGIN_FAILURE_WINDOW_MIN", 60)) * time.Minute,
+		}
+		if loginGuardCfg.lockAfter <= loginGuardCfg.backoffAfter {
+			loginGuardCfg.lockAfter = loginGuardCfg.backoffAfter + 1
+		}
+	})
+	return loginGuardCfg
+}
+
+func envPositiveInt(key string, fallback int) int {
+	raw := strings.TrimSpace(getEnv(key, ""))
+	if raw == "" {
+		return fallback
+	}
+	value, err := strconv.Atoi(raw)
+	if err != nil || value <= 0 {
+		log.Printf("[WARN] %s の値が不正なため既定値 %d を使用します: %q", key, fallback, raw)
+		return fallback
+	}
+	return value
+}
+
+func normalizeLoginAccount(username string) string {
+	return strings.ToLower(strings.TrimSpace(username))
+}
+
+// backoffDelay returns the wait time imposed after the given number of failures.
+// The delay doubles with every failure past backoffAfter and is capped at maxDelay.
+func (c loginGuardConfig) backoffDelay(failures int) time.Duration {
+	if failures < c.backoffAfter {
+		return 0
+	}
+	exponent := failures - c.backoffAfter
+	if exponent > 30 {
+		return c.maxDelay
+	}
+	delay := time.Duration(float64(c.baseDelay) * math.Pow(2, float64(exponent)))
+	if delay > c.maxDelay {
+		return c.maxDelay
+	}
+	return delay
+}
+
+// pendingAt returns the attempts still being verified, dropping stale reservations.
+func (a *loginAttempt) pendingAt(now time.Time) int {
+	if now.Sub(a.lastAttempt) >= loginReservationTTL {
+		a.pending = 0
+	}
+	return a.pending
+}
+
+// retryAfter returns how long the caller must wait before another attempt is accepted.
+func (a *loginAttempt) retryAfter(cfg loginGuardConfig, now time.Time) time.Duration {
+	if a == nil {
+		return 0
+	}
+	if now.Before(a.lockedUntil) {
+		return a.lockedUntil.Sub(now)
+	}
+	last := a.lastFailure
+	if a.pendingAt(now) > 0 && a.lastAttempt.After(last) {
+		last = a.lastAttempt
+	}
+	if delay := cfg.backoffDelay(a.failures + a.pending); delay > 0 {
+		if readyAt := last.Add(delay); now.Before(readyAt) {
+			return readyAt.Sub(now)
+		}
+	}
+	return 0
+}
+
+// reserveLoginAttempt checks the account and IP and, when the attempt may proceed,
+// counts it as pending in the same critical section. The reservation ends with
+// recordLoginFailure or recordLoginSuccess. When it is refused, the returned duration
+// is the time until the next attempt is accepted.
+func reserveLoginAttempt(username, ip string) (bool, time.Duration) {
+	cfg := getLoginGuardConfig()
+	now := time.Now()
+	account := normalizeLoginAccount(username)
+
+	loginAttemptsMutex.Lock()
+	defer loginAttemptsMutex.Unlock()
+
+	wait := loginAccountAttempts[account].retryAfter(cfg, now)
+	if ipWait := loginIPAttempts[ip].retryAfter(cfg, now); ipWait > wait {
+		wait = ipWait
+	}
+	if wait > 0 {
+		return false, wait
+	}
+	for _, attempt := range []*loginAttempt{loginAttemptLocked(loginAccountAttempts, account, cfg, now), loginAttemptLocked(loginIPAttempts, ip, cfg, now)} {
+		attempt.pendingAt(now)
+		attempt.pending++
+		attempt.lastAttempt = now
+	}
+	return true, 0
+}
+
+// loginAttemptLocked returns the counter for key, starting over once earlier
+// failures have been forgotten.
+func loginAttemptLocked(store map[string]*loginAttempt, key string, cfg loginGuardConfig, now time.Time) *loginAttempt {
+	attempt, ok := store[key]
+	if !ok {
+		attempt = &loginAttempt{}
+		store[key] = attempt
+	} else if attempt.failures > 0 && now.Sub(attempt.lastFailure) > cfg.forgetAfter && now.After(attempt.lockedUntil) {
+		attempt.failures = 0
+	}
+	return attempt
+}
+
+// releaseLoginAttemptLocked ends one pending attempt of the counter, if any.
+func releaseLoginAttemptLocked(attempt *loginAttempt, now time.Time) {
+	if attempt != nil && attempt.pendingAt(now) > 0 {
+		attempt.pending--
+	}
+}
+
+func recordLoginFailureLocked(store map[string]*loginAttempt, key string, cfg loginGuardConfig, now time.Time) (*loginAttempt, bool) {
+	attempt := loginAttemptLocked(store, key, cfg, now)
+	releaseLoginAttemptLocked(attempt, now)
+
+	attempt.failures++
+	attempt.lastFailure = now
+
+	if attempt.failures >= cfg.lockAfter && now.After(attempt.lockedUntil) {
+		attempt.lockedUntil = now.Add(cfg.lockout)
+		return attempt, true
+	}
+	return attempt, false
+}
+
+// recordLoginFailure counts a failed attempt against both the account and the IP
+// and writes the event to the security log.
+func recordLoginFailure(r *http.Request, username, ip, method string) {
+	cfg := getLoginGuardConfig()
+	now := time.Now()
+	account := normalizeLoginAccount(username)
+
+	loginAttemptsMutex.Lock()
+	accountAttempt, accountLocked := recordLoginFailureLocked(loginAccountAttempts, account, cfg, now)
+	ipAttempt, ipLocked := recordLoginFailureLocked(loginIPAttempts, ip, cfg, now)
+	accountFailures := accountAttempt.failures
+	ipFailures := ipAttempt.failures
+	loginAttemptsMutex.Unlock()
+
+	switch {
+	case accountLocked || ipLocked:
+		log.Printf("[SECURITY] ログインロック (%s): ユーザー=%q IP=%s 失敗回数=%d/%d ロック時間=%s",
+			method, account, ip, accountFailures, ipFailures, cfg.lockout)
+		if ipLocked {
+			incrementScore(ip, 5, scoreReasonLoginLockout, r.URL.Path)
+		}
+		logRequest(r, ip, ActionBlock)
+	case accountFailures >= cfg.backoffAfter || ipFailures >= cfg.backoffAfter:
+		log.Printf("[SECURITY] ログイン失敗が続いています (%s): ユーザー=%q IP=%s 失敗回数=%d/%d",
+			method, account, ip, accountFailures, ipFailures)
+		logRequest(r, ip, ActionWarn)
+	default:
+		logRequest(r, ip, ActionInfo)
+	}
+}
+
+// recordLoginSuccess clears the failure counter of the account. The IP keeps its
+// failures until they are forgotten, so logging into another account between guesses
+// does not reset its backoff; only its pending attempt is released.
+func recordLoginSuccess(username, ip string) {
+	loginAttemptsMutex.Lock()
+	delete(loginAccountAttempts, normalizeLoginAccount(username))
+	releaseLoginAttemptLocked(loginIPAttempts[ip], time.Now())
+	loginAttemptsMutex.Unlock()
+}
+
+// unlockLogin removes any lockout for the given account and/or IP.
+// It returns true when at least one entry was cleared.
+func unlockLogin(username, ip string) bool {
+	loginAttemptsMutex.Lock()
+	defer loginAttemptsMutex.Unlock()
+
+	cleared := false
+	if account := normalizeLoginAccount(username); account != "" {
+		if _, ok := loginAccountAttempts[account]; ok {
+			delete(loginAccountAttempts, account)
+			cleared = true
+		}
+	}
+	if ip = strings.TrimSpace(ip); ip != "" {
+		if _, ok := loginIPAttempts[ip]; ok {
+			delete(loginIPAttempts, ip)
+			cleared = true
+		}
+	}
+	return cleared
+}
+
+// cleanupLoginAttempts drops counters whose lockout has ended and that have been idle
+// longer than the failure window. It is called from cleanupMaps.
+func cleanupLoginAttempts() {
+	cfg := getLoginGuardConfig()
+	now := time.Now()
+
+	loginAttemptsMutex.Lock()
+	defer loginAttemptsMutex.Unlock()
+
+	for _, store := range []map[string]*loginAttempt{loginAccountAttempts, loginIPAttempts} {
+		for key, attempt := range store {
+			if now.After(attempt.lockedUntil) && now.Sub(attempt.lastFailure) > cfg.forgetAfter && attempt.pendingAt(now) == 0 {
+				delete(store, key)
+			}
+		}
+	}
+}
+
+// rejectLockedLogin reserves the attempt with the guard, or writes a 429 response when
+// it is refused. It returns true when the request was rejected.
+func rejectLockedLogin(w http.ResponseWriter, r *http.Request, username, ip, method string) bool {
+	allowed, wait := reserveLoginAttempt(username, ip)
+	if allowed {
+		return false
+	}
+
+	seconds := int(math.Ceil(wait.Seconds()))
+	log.Printf("[SECURITY] ロック中のログイン試行を拒否しました (%s): ユーザー=%q IP=%s 残り=%ds",
+		method, normalizeLoginAccount(username), ip, seconds)
+	logRequest(r, ip, ActionWarn)
+
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+	writeAuthResponse(w, http.StatusTooManyRequests, AuthResponse{
+		Success: false,
+		Message: "ログイン試行回数が多すぎます。しばらくしてから再度お試しください",
+	})
+	return true
+}
+
+// handleAuthUnlock clears a login lockout. Only admins may call it.
+func handleAuthUnlock(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	admin, ok := requireAdmin(w, r)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		Username string `json:"username"`
+		IP       string `json:"ip"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	if strings.TrimSpace(req.Username) == "" && strings.TrimSpace(req.IP) == "" {
+		http.Error(w, "username または ip が必要です", http.StatusBadRequest)
+		return
+	}
+
+	cleared := unlockLogin(req.Username, req.IP)
+	auditAdminAction(r, admin, "unlock ip="+strings.TrimSpace(req.IP), normalizeLoginAccount(req.Username))
+	logRequest(r, getIPAddress(r), ActionInfo)
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(map[string]interface{}{
+		keySuccess: true,
+		"cleared":  cleared,
+	}); err != nil {
+		log.Printf("JSON encode error: %v", err)
+	}
+}
@@ -169,7 +169,7 @@ func initDB() error {
 	var err error
 
 	// データベース接続を開く
-	db, err = sql.Open("sqlite", dbPath)
+	db, err = sql.Open(sqliteDriverName, dbPath)
 	if err != nil {
 		log.Printf("データベース接続エラー: %v", err)
 		return err
@@ -193,6 +193,11 @@ func initDB() error {
 		credential_id TEXT,
 		credential_public_key TEXT,
 		sign_count INTEGER,
+		role TEXT DEFAULT 'user',
+		disabled INTEGER DEFAULT 0,
+		sessions_revoked_at INTEGER DEFAULT 0,
+		oidc_issuer TEXT,
+		oidc_subject TEXT,
 		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
 		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
 	);`
@@ -210,6 +215,18 @@ func initDB() error {
 		return err
 	}
 
+	if err = initAppSettings(); err != nil {
+		log.Printf("設定テーブル作成エラー: %v", err)
+		return err
+	}
+
+	if err = initAPITokens(); err != nil {
+		log.Printf("APIトークンテーブル作成エラー: %v", err)
+		return err
+	}
+
+	promoteConfiguredAdmins()
+
 	log.Println("Database initialized successfully.")
 	return nil
 }
@@ -221,6 +238,11 @@ func addMissingColumns() error {
 		"email TEXT",
 		"password TEXT",
 		"profile_image TEXT",
+		"role TEXT DEFAULT 'user'",
+		"disabled INTEGER DEFAULT 0",
+		"sessions_revoked_at INTEGER DEFAULT 0",
+		"oidc_issuer TEXT",
+		"oidc_subject TEXT",
 		"created_at DATETIME DEFAULT CURRENT_TIMESTAMP",
 		"updated_at DATETIME DEFAULT CURRENT_TIMESTAMP",
 	}
@@ -247,6 +269,10 @@ func addMissingColumns() error {
 		}
 	}
 
+	if _, err := db.Exec(`CREATE UNIQUE INDEX IF NOT EXISTS idx_users_oidc ON users(oidc_issuer, oidc_subject) WHERE oidc_subject IS NOT NULL`); err != nil {
+		log.Printf("OIDCインデックス作成エラー: %v", err)
+	}
+
 	// NULL値のタイムスタンプを更新
 	if _, err := db.Exec("UPDATE users SET created_at = CURRENT_TIMESTAMP WHERE created_at IS NULL"); err != nil {
 		log.Printf("ユーザー作成日時更新エラー: %v", err)
@@ -354,55 +380,56 @@ func createSessionCookieValue(username string, now time.Time) (string, time.Time
 	return value, expiresAt, nil
 }
 
-func parseAndVerifySessionCookieValue(value string) (string, error) {
+func parseAndVerifySessionCookieValue(value string) (string, time.Time, error) {
 	parts := strings.Split(value, ".")
 	if len(parts) != 2 {
-		return "", errors.New("invalid cookie format")
+		return "", time.Time{}, errors.New("invalid cookie format")
 	}
 
 	payloadBytes, err := base64.RawURLEncoding.DecodeString(parts[0])
 	if err != nil {
-		return "", err
+		return "", time.Time{}, err
 	}
 	sigBytes, err := base64.RawURLEncoding.DecodeString(parts[1])
 	if err != nil {
-		return "", err
+		return "", time.Time{}, err
 	}
 
 	mac := hmac.New(sha256.New, getSessionSecret())
 	if _, err := mac.Write(payloadBytes); err != nil {
-		return "", err
+		return "", time.Time{}, err
 	}
 	expectedSig := mac.Sum(nil)
 	if !hmac.Equal(sigBytes, expectedSig) {
-		return "", errors.New("invalid cookie signature")
+		return "", time.Time{}, errors.New("invalid cookie signature")
 	}
 
 	segments := strings.Split(string(payloadBytes), "\n")
 	if len(segments) != 3 {
-		return "", errors.New("invalid payload")
+		return "", time.Time{}, errors.New("invalid payload")
 	}
 
 	username := strings.TrimSpace(segments[0])
 	if username == "" {
-		return "", errors.New("invalid username")
+		return "", time.Time{}, errors.New("invalid username")
 	}
 
 	expiresUnix, err := strconv.ParseInt(segments[1], 10, 64)
 	if err != nil {
-		return "", err
+		return "", time.Time{}, err
 	}
-	if time.Now().After(time.Unix(expiresUnix, 0)) {
-		return "", errors.New("session expired")
+	expiresAt := time.Unix(expiresUnix, 0)
+	if time.Now().After(expiresAt) {
+		return "", time.Time{}, errors.New("session expired")
 	}
 
-	return username, nil
+	return username, expiresAt, nil
 }
 
-func setSessionCookie(w http.ResponseWriter, _ *http.Request, username string) error {
+func setSessionCookie(w http.ResponseWriter, _ *http.Request, username string) (string, error) {
 	value, expiresAt, err := createSessionCookieValue(username, time.Now())
 	if err != nil {
-		return err
+		return "", err
 	}
 
 	http.SetCookie(w, &http.Cookie{
@@ -414,8 +441,9 @@ func setSessionCookie(w http.ResponseWriter, _ *http.Request, username string) e
 		Secure:   true,
 		SameSite: http.SameSiteLaxMode,
 	})
+	setCSRFCookie(w, value, expiresAt)
 
-	return nil
+	return value, nil
 }
 
 func getDeviceIDFromCookie(r *http.Request) string {
@@ -482,58 +510,75 @@ func createRestoreToken(username, deviceID string, now time.Time) (string, time.
 	return value, expiresAt, nil
 }
 
-func parseAndVerifyRestoreToken(token string) (string, string, error) {
+func parseAndVerifyRestoreToken(token string) (string, string, time.Time, error) {
 	parts := strings.Split(strings.TrimSpace(token), ".")
 	if len(parts) != 2 {
-		return "", "", errors.New("invalid token format")
+		return "", "", time.Time{}, errors.New("invalid token format")
 	}
 
 	payloadBytes, err := base64.RawURLEncoding.DecodeString(parts[0])
 	if err != nil {
-		return "", "", err
+		return "", "", time.Time{}, err
 	}
 	sigBytes, err := base64.RawURLEncoding.DecodeString(parts[1])
 	if err != nil {
-		return "", "", err
+		return "", "", time.Time{}, err
 	}
 
 	mac := hmac.New(sha256.New, getSessionSecret())
 	if _, err := mac.Write(payloadBytes); err != nil {
-		return "", "", err
+		return "", "", time.Time{}, err
 	}
 	expectedSig := mac.Sum(nil)
 	if !hmac.Equal(sigBytes, expectedSig) {
-		return "", "", errors.New("invalid token signature")
+		return "", "", time.Time{}, errors.New("invalid token signature")
 	}
 
 	segments := strings.Split(string(payloadBytes), "\n")
 	if len(segments) != 4 {
-		return "", "", errors.New("invalid token payload")
+		return "", "", time.Time{}, errors.New("invalid token payload")
 	}
 
 	username := strings.TrimSpace(segments[0])
 	deviceID := strings.TrimSpace(segments[1])
 	expiresUnix, err := strconv.ParseInt(strings.TrimSpace(segments[2]), 10, 64)
 	if err != nil {
-		return "", "", err
+		return "", "", time.Time{}, err
 	}
 
 	if username == "" || deviceID == "" {
-		return "", "", errors.New("token missing identity")
+		return "", "", time.Time{}, errors.New("token missing identity")
 	}
 
-	if time.Now().After(time.Unix(expiresUnix, 0)) {
-		return "", "", errors.New("restore token expired")
+	expiresAt := time.Unix(expiresUnix, 0)
+	if time.Now().After(expiresAt) {
+		return "", "", time.Time{}, errors.New("restore token expired")
 	}
 
-	return username, deviceID, nil
+	return username, deviceID, expiresAt, nil
 }
 
+// issueSessionAndRestore starts a session restored from a restore token and returns
+// a new restore token.
 func issueSessionAndRestore(w http.ResponseWriter, r *http.Request, username string) (string, error) {
-	if err := setSessionCookie(w, r, username); err != nil {
+	if _, err := setSessionCookie(w, r, username); err != nil {
+		return "", err
+	}
+	return issueRestoreToken(w, r, username)
+}
+
+// issueSignInSession is issueSessionAndRestore for a completed password, passkey or
+// SSO sign-in. Unlike a restored session, it counts as re-authentication.
+func issueSignInSession(w http.ResponseWriter, r *http.Request, username string) (string, error) {
+	value, err := setSessionCookie(w, r, username)
+	if err != nil {
 		return "", err
 	}
+	noteSignIn(value)
+	return issueRestoreToken(w, r, username)
+}
 
+func issueRestoreToken(w http.ResponseWriter, r *http.Request, username string) (string, error) {
 	deviceID, err := ensureDeviceIDCookie(w, r)
 	if err != nil {
 		return "", err
@@ -553,11 +598,16 @@ func getUsernameFromSession(r *http.Request) (string, error) {
 		return "", err
 	}
 
-	username, err := parseAndVerifySessionCookieValue(cookie.Value)
+	username, expiresAt, err := parseAndVerifySessionCookieValue(cookie.Value)
 	if err != nil {
 		return "", err
 	}
 
+	if err := checkAccountSessionState(username, expiresAt.Add(-getSessionTTL())); err != nil {
+		return "", err
+	}
+
+	noteActiveSession(cookie.Value, username, expiresAt)
 	return username, nil
 }
 
@@ -582,6 +632,11 @@ func getHeaderUsername(r *http.Request) string {
 }
 
 func getUsernameFromRequest(r *http.Request) (string, error) {
+	// A bearer token is an explicit credential; never fall back to other methods when it fails.
+	if token := getBearerToken(r); token != "" {
+		return getUsernameFromAPIToken(r, token)
+	}
+
 	if username, err := getUsernameFromSession(r); err == nil {
 		return username, nil
 	}
@@ -693,15 +748,17 @@ func authenticateAuthUser(username, password string) (*AuthUser, error) {
 		return nil, fmt.Errorf("データベース接続がありません")
 	}
 
-	query := `SELECT id, password, username, COALESCE(email, ''), COALESCE(profile_image, ''),
+	query := `SELECT id, COALESCE(password, ''), username, COALESCE(email, ''), COALESCE(profile_image, ''),
                         COALESCE(created_at, CURRENT_TIMESTAMP),
-                        COALESCE(updated_at, CURRENT_TIMESTAMP)
+                        COALESCE(updated_at, CURRENT_TIMESTAMP),
+                        COALESCE(disabled, 0)
                         FROM users WHERE username = ?`
 	row := db.QueryRow(query, username)
 
 	var user AuthUser
 	var userID, storedPassword, createdAtStr, updatedAtStr string
-	err := row.Scan(&userID, &storedPassword, &user.Username, &user.Email, &user.ProfileImage, &createdAtStr, &updatedAtStr)
+	var disabled bool
+	err := row.Scan(&userID, &storedPassword, &user.Username, &user.Email, &user.ProfileImage, &createdAtStr, &updatedAtStr, &disabled)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			log.Printf("[INFO] ユーザーが見つかりません: %s", username)
@@ -711,6 +768,17 @@ func authenticateAuthUser(username, password string) (*AuthUser, error) {
 		return nil, err
 	}
 
+	if storedPassword == "" {
+		log.Printf("[INFO] ユーザー '%s' にはパスワードが設定されていません。", username)
+		return nil, sql.ErrNoRows
+	}
+	// Reject disabled accounts before the password is checked, so the answer never
+	// tells whether the password was right.
+	if disabled {
+		log.Printf("[INFO] 無効化されたユーザー '%s' のログインを拒否しました。", username)
+		return nil, errAccountDisabled
+	}
+
 	err = bcrypt.CompareHashAndPassword([]byte(storedPassword), []byte(password))
 	if err != nil {
 		log.Printf("[DEBUG] bcrypt比較失敗: %v. 平文比較を試みます。", err)
@@ -1078,9 +1146,15 @@ func handleAuthLogin(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// データベース認証
+	ip := getIPAddress(r)
+	if rejectLockedLogin(w, r, req.Username, ip, loginMethodPassword) {
+		return
+	}
+
+	// データベース認証 (無効化されたアカウントも通常の失敗として扱う)
 	user, err := authenticateAuthUser(req.Username, req.Password)
 	if err != nil {
+		recordLoginFailure(r, req.Username, ip, loginMethodPassword)
 		response := AuthResponse{
 			Success: false,
 			Message: "ユーザー名またはパスワードが間違っています",
@@ -1092,6 +1166,7 @@ func handleAuthLogin(w http.ResponseWriter, r *http.Request) {
 		}
 		return
 	}
+	recordLoginSuccess(req.Username, ip)
 
 	response := AuthResponse{
 		Success: true,
@@ -1103,7 +1178,7 @@ func handleAuthLogin(w http.ResponseWriter, r *http.Request) {
 			keyLoginAt:      time.Now().Unix(),
 		},
 	}
-	restoreToken, err := issueSessionAndRestore(w, r, user.Username)
+	restoreToken, err := issueSignInSession(w, r, user.Username)
 	if err != nil {
 		log.Printf("[ERROR] セッション発行失敗: %v", err)
 		http.Error(w, "認証セッションの作成に失敗しました", http.StatusInternalServerError)
@@ -1192,12 +1267,17 @@ func handleAuthRestore(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	username, tokenDeviceID, err := parseAndVerifyRestoreToken(req.RestoreToken)
+	username, tokenDeviceID, expiresAt, err := parseAndVerifyRestoreToken(req.RestoreToken)
 	if err != nil {
 		http.Error(w, "認証情報が無効です", http.StatusUnauthorized)
 		return
 	}
 
+	if stateErr := checkAccountSessionState(username, expiresAt.Add(-getRestoreTokenTTL())); stateErr != nil {
+		http.Error(w, "認証情報が無効です", http.StatusUnauthorized)
+		return
+	}
+
 	isLocal := isLocalRequest(r)
 	deviceID := getDeviceIDFromCookie(r)
 
@@ -1255,6 +1335,14 @@ func handleAuthRegister(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if !isRegistrationOpen() {
+		writeAuthResponse(w, http.StatusForbidden, AuthResponse{
+			Success: false,
+			Message: "現在新規登録は受け付けていません",
+		})
+		return
+	}
+
 	if response := validateAuthRegisterRequest(req); response != nil {
 		writeAuthResponse(w, http.StatusOK, *response)
 		return
@@ -1361,6 +1449,11 @@ func HandleWebAuthnRegisterStart(w http.ResponseWriter, r *http.Request) {
 		user = existingUser
 		fmt.Println("[DEBUG] 既存ユーザーに新しいパスキー追加:", req.Username)
 	} else {
+		if !isRegistrationOpen() {
+			http.Error(w, "現在新規登録は受け付けていません", http.StatusForbidden)
+			return
+		}
+
 		// 新規ユーザー作成
 		userID := uuid.New().String()
 		displayName := req.Username
@@ -1606,6 +1699,11 @@ func HandleWebAuthnLoginFinish(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	ip := getIPAddress(r)
+	if rejectLockedLogin(w, r, rawReq.Username, ip, loginMethodWebAuthn) {
+		return
+	}
+
 	fmt.Println("[DEBUG] login body:", string(bodyBytes))
 
 	standardReqBytes, err := buildStandardLoginRequest(rawReq)
@@ -1623,12 +1721,20 @@ func HandleWebAuthnLoginFinish(w http.ResponseWriter, r *http.Request) {
 
 	user, err := FindWebAuthnUserByUsername(rawReq.Username)
 	if err != nil {
+		recordLoginFailure(r, rawReq.Username, ip, loginMethodWebAuthn)
 		http.Error(w, "User not found", http.StatusNotFound)
 		return
 	}
 
+	if isUserDisabled(rawReq.Username) {
+		recordLoginFailure(r, rawReq.Username, ip, loginMethodWebAuthn)
+		http.Error(w, "このアカウントは無効化されています", http.StatusForbidden)
+		return
+	}
+
 	sessionData, ok := popLoginSession(rawReq.Username)
 	if !ok {
+		recordLoginFailure(r, rawReq.Username, ip, loginMethodWebAuthn)
 		http.Error(w, "Session data not found", http.StatusBadRequest)
 		return
 	}
@@ -1637,12 +1743,14 @@ func HandleWebAuthnLoginFinish(w http.ResponseWriter, r *http.Request) {
 	_, err = webAuthnInstance.FinishLogin(user, *sessionData, newRequest)
 
 	if err != nil {
+		recordLoginFailure(r, rawReq.Username, ip, loginMethodWebAuthn)
 		log.Println("Login failed:", err)
 		http.Error(w, fmt.Sprintf(`{"success":false,"error":"%s"}`, err.Error()), http.StatusUnauthorized)
 		return
 	}
+	recordLoginSuccess(user.Name, ip)
 
-	restoreToken, err := issueSessionAndRestore(w, r, user.Name)
+	restoreToken, err := issueSignInSession(w, r, user.Name)
 	if err != nil {
 		log.Printf("[ERROR] セッション発行失敗: %v", err)
 		http.Error(w, "認証セッションの作成に失敗しました", http.StatusInternalServerError)
@@ -6,6 +6,7 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"database/sql"
 	"encoding/json"
 	"errors"
@@ -13,13 +14,16 @@ import (
 	"fmt"
 	"io"
 	"log"
+	"net"
 	"net/http"
 	"os"
 	"os/exec"
+	"os/signal"
 	"path/filepath"
 	"strconv"
 	"strings"
 	"sync"
+	"syscall"
 	"time"
 
 	"github.com/google/uuid"
@@ -190,18 +194,46 @@ func serve(mux http.Handler) {
 	log.Println("=================")
 	go checkForUpdates()
 
-	if certAvailable {
-		log.Printf("HTTPS Mode: using certificate. Listening on https://127.0.0.1:%s ...", port)
-		if err := http.ListenAndServeTLS(":"+port, certPath, keyPath, mux); err != nil {
-			log.Fatal("HTTPS Server error:", err)
-		}
-		return
+	// Requests derive from ctx, so open status streams end when shutdown begins.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	srv := &http.Server{
+		Addr:        ":" + port,
+		Handler:     mux,
+		BaseContext: func(net.Listener) context.Context { return ctx },
 	}
+	serveErr := make(chan error, 1)
+	go func() {
+		if certAvailable {
+			log.Printf("HTTPS Mode: using certificate. Listening on https://127.0.0.1:%s ...", port)
+			serveErr <- srv.ListenAndServeTLS(certPath, keyPath)
+			return
+		}
+		log.Printf("HTTP Mode: certificate not found. Falling back to HTTP. Listening on http://127.0.0.1:%s ...", port)
+		serveErr <- srv.ListenAndServe()
+	}()
 
-	log.Printf("HTTP Mode: certificate not found. Falling back to HTTP. Listening on http://127.0.0.1:%s ...", port)
-	if err := http.ListenAndServe(":"+port, mux); err != nil {
-		log.Fatal("HTTP Server error:", err)
+	select {
+	case err := <-serveErr:
+		log.Fatal("Server error:", err)
+	case <-ctx.Done():
 	}
+	shutdown(srv)
+}
+
+// shutdown stops accepting requests, waits briefly for the ones in flight, and then
+// persists the security state and delivers the pending alerts.
+func shutdown(srv *http.Server) {
+	log.Println("[INFO] 終了シグナルを受信しました。サーバーを停止します")
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil {
+		log.Printf("[WARN] 処理中のリクエストを待たずに停止します: %v", err)
+		srv.Close()
+	}
+	flushSecurityStore()
+	stopSecurityAlerts(5 * time.Second)
+	log.Println("[INFO] セキュリティ状態を保存して終了します")
 }
 
 func fileExists(p string) bool {
@@ -218,6 +250,13 @@ func main() {
 		log.Println("No .env file found or error loading it, using default values")
 	}
 
+	if len(os.Args) > 1 && os.Args[1] == "replay" {
+		os.Exit(runReplay(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "agent" {
+		os.Exit(runAgent(os.Args[2:]))
+	}
+
 	migrateSchedule := flag.Bool("migrate-schedule", false, "Migrate legacy schedule.json to DB")
 	flag.Parse()
 
@@ -229,12 +268,18 @@ func main() {
 		return
 	}
 
+	initSecurity()
 	mux := http.NewServeMux()
 	fallbackHolidays = preloadHolidays()
 
 	if err := initDatastores(); err != nil {
 		log.Fatal(err)
 	}
+	startSecurityAlerts()
+	configureStatusProbes()
+	configureStatusCollectors()
+	startStatusSampler()
+	go watchRemoteHosts()
 
 	// バージョンアップフラグを設定
 	if checkGitUpdates() {
@@ -259,13 +304,24 @@ func main() {
 	// apis
 	mux.HandleFunc("/api/ping", secureHandler(handlePing))
 	mux.HandleFunc("/api/version", secureHandler(handleVersion))
+	mux.HandleFunc("/metrics", secureHandler(handleMetrics))
 	mux.HandleFunc("/api/status", secureHandler(handleStatusAPI))
+	mux.HandleFunc("/api/v2/status", secureHandler(handleStatusV2API))
+	mux.HandleFunc("/api/status/history", secureHandler(handleStatusHistory))
+	mux.HandleFunc("/api/status/stream", secureHandler(handleStatusStream))
+	mux.HandleFunc("/api/status/hosts", secureHandler(handleStatusHosts))
+	mux.HandleFunc("/api/status/alerts", secureHandler(handleStatusAlerts))
+	mux.HandleFunc("/api/status/alerts/update", secureHandler(handleStatusAlertUpdate))
+	mux.HandleFunc("/api/status/alerts/delete", secureHandler(handleStatusAlertDelete))
+	mux.HandleFunc("/api/status/alerts/silences", secureHandler(handleStatusAlertSilences))
+	mux.HandleFunc("/api/status/alerts/silences/delete", secureHandler(handleStatusAlertSilenceDelete))
+	mux.HandleFunc("/api/agent/push", secureHandler(handleAgentPush))
 	mux.HandleFunc("/api/weather", secureHandler(handleWeather))
 	mux.HandleFunc("/api/holidays", secureHandler(holidaysHandler))
 
 	// Schedule APIs
 	scheduleDBPath := getEnv("DB_SCHEDULE_PATH", "./database/schedule.db")
-	scheduleDB, err := sql.Open("sqlite", scheduleDBPath)
+	scheduleDB, err := sql.Open(sqliteDriverName, scheduleDBPath)
 	if err != nil {
 		log.Fatal("スケジュールDB接続失敗:", err)
 	}
@@ -292,7 +348,7 @@ func main() {
 	}))
 	// Wallpaper APIs
 	wallpaperDBPath := getEnv("DB_WALLPAPER_PATH", "./database/wallpaper.db")
-	wallpaperDB, err := sql.Open("sqlite", wallpaperDBPath)
+	wallpaperDB, err := sql.Open(sqliteDriverName, wallpaperDBPath)
 	if err != nil {
 		log.Fatal("壁紙DB接続失敗:", err)
 	}
@@ -309,10 +365,42 @@ func main() {
 	mux.HandleFunc("/api/auth/user-info", secureHandler(handleUserInfo))
 	mux.HandleFunc("/api/auth/restore", secureHandler(handleAuthRestore))
 	mux.HandleFunc("/api/auth/change-password", secureHandler(handleAuthChangePassword))
+	mux.HandleFunc("/api/auth/unlock", secureHandler(handleAuthUnlock))
+	mux.HandleFunc("/api/auth/csrf", secureHandler(handleCSRFToken))
+	mux.HandleFunc("/api/auth/oidc/config", secureHandler(handleOIDCConfig))
+	mux.HandleFunc("/api/auth/oidc/login", secureHandler(handleOIDCLogin))
+	mux.HandleFunc("/api/auth/oidc/callback", secureHandler(handleOIDCCallback))
+	mux.HandleFunc("/api/auth/oidc/session", secureHandler(handleOIDCSession))
+
+	// Account self-service
+	mux.HandleFunc("/api/account", secureHandler(handleAccount))
+	mux.HandleFunc("/api/account/export", secureHandler(handleAccountExport))
+
+	// Personal API tokens
+	mux.HandleFunc("/api/tokens", secureHandler(handleAPITokens))
+	mux.HandleFunc("/api/tokens/revoke", secureHandler(handleRevokeAPIToken))
+
+	// Admin APIs
+	mux.HandleFunc("/api/admin/users", secureHandler(handleAdminUsers))
+	mux.HandleFunc("/api/admin/users/disable", secureHandler(handleAdminDisableUser))
+	mux.HandleFunc("/api/admin/users/delete", secureHandler(handleAdminDeleteUser))
+	mux.HandleFunc("/api/admin/users/reset-password", secureHandler(handleAdminResetPassword))
+	mux.HandleFunc("/api/admin/users/logout", secureHandler(handleAdminLogoutUser))
+	mux.HandleFunc("/api/admin/users/role", secureHandler(handleAdminSetRole))
+	mux.HandleFunc("/api/admin/users/unlock", secureHandler(handleAdminUnlockUser))
+	mux.HandleFunc("/api/admin/registration", secureHandler(handleAdminRegistration))
+	mux.HandleFunc("/api/admin/security/ips", secureHandler(handleAdminSecurityIPs))
+	mux.HandleFunc("/api/admin/security/ip", secureHandler(handleAdminSecurityIP))
+	mux.HandleFunc("/api/admin/security/block", secureHandler(handleAdminSecurityBlock))
+	mux.HandleFunc("/api/admin/security/unblock", secureHandler(handleAdminSecurityUnblock))
+	mux.HandleFunc("/api/admin/security/reset", secureHandler(handleAdminSecurityReset))
+	mux.HandleFunc("/api/admin/security/trusted", secureHandler(handleAdminSecurityTrusted))
+	mux.HandleFunc("/api/admin/security/logs", secureHandler(handleAdminSecurityLogs))
+	mux.HandleFunc("/api/admin/security/monitor", secureHandler(handleAdminSecurityMonitor))
 
 	// Subscription APIs
 	subscriptionDBPath := getEnv("DB_SUBSCRIPTION_PATH", "./database/subscription.db")
-	subscriptionDB, err := sql.Open("sqlite", subscriptionDBPath)
+	subscriptionDB, err := sql.Open(sqliteDriverName, subscriptionDBPath)
 	if err != nil {
 		log.Fatal("サブスクリプションDB接続失敗:", err)
 	}
@@ -337,7 +425,7 @@ func main() {
 		http.Redirect(w, r, "/home/", http.StatusFound)
 	})
 
-	serve(mux)
+	serve(instrumentHTTP(mux))
 }
 
 func initDatastores() error {
@@ -356,6 +444,15 @@ func initDatastores() error {
 	if err := initSubscriptionDB(); err != nil {
 		return fmt.Errorf("サブスクリプションDB初期化失敗: %w", err)
 	}
+	if err := initSecurityStore(); err != nil {
+		return fmt.Errorf("セキュリティDB初期化失敗: %w", err)
+	}
+	if err := initStatusHistory(); err != nil {
+		return fmt.Errorf("ステータス履歴DB初期化失敗: %w", err)
+	}
+	if err := initStatusAlerts(); err != nil {
+		return fmt.Errorf("ステータスアラート初期化失敗: %w", err)
+	}
 	return nil
 }
 
@@ -437,7 +534,7 @@ func checkForUpdates() {
 func initSubscriptionDB() error {
 	// subscription.db
 	dbPath := getEnv("DB_SUBSCRIPTION_PATH", "./database/subscription.db")
-	subscriptionDB, err := sql.Open("sqlite", dbPath)
+	subscriptionDB, err := sql.Open(sqliteDriverName, dbPath)
 	if err != nil {
 		return fmt.Errorf("subscription.db接続エラー: %v", err)
 	}
@@ -475,7 +572,7 @@ func initSubscriptionDB() error {
 
 func initScheduleDB() error {
 	dbPath := getEnv("DB_SCHEDULE_PATH", "./database/schedule.db")
-	db, err := sql.Open("sqlite", dbPath)
+	db, err := sql.Open(sqliteDriverName, dbPath)
 	if err != nil {
 		return fmt.Errorf("スケジュールデータベース接続エラー: %v", err)
 	}
@@ -510,7 +607,7 @@ func initScheduleDB() error {
 
 func initWallpaperDB() error {
 	dbPath := getEnv("DB_WALLPAPER_PATH", "./database/wallpaper.db")
-	db, err := sql.Open("sqlite", dbPath)
+	db, err := sql.Open(sqliteDriverName, dbPath)
 	if err != nil {
 		return fmt.Errorf("壁紙データベース接続エラー: %v", err)
 	}
@@ -575,7 +672,7 @@ func fixUnlinkedWallpapers(wallpaperDB *sql.DB) error {
 
 func loadValidWallpaperUsers() (map[string]bool, error) {
 	accDBPath := getEnv("DB_ACC_PATH", "./database/acc.db")
-	accDB, err := sql.Open("sqlite", accDBPath)
+	accDB, err := sql.Open(sqliteDriverName, accDBPath)
 	if err != nil {
 		return nil, fmt.Errorf("acc.db open failed: %v", err)
 	}
@@ -678,7 +775,7 @@ func withSlashAndErrorHandler(next http.Handler) http.Handler {
 
 func initShiftDB() error {
 	dbPath := getEnv("DB_SHIFT_PATH", "./database/shift.db")
-	db, err := sql.Open("sqlite", dbPath)
+	db, err := sql.Open(sqliteDriverName, dbPath)
 	if err != nil {
 		return fmt.Errorf("シフトデータベース接続エラー: %v", err)
 	}
@@ -710,7 +807,7 @@ func initShiftDB() error {
 
 func registerShift(username string, shift ShiftEntry) error {
 	dbPath := getEnv("DB_SHIFT_PATH", "./database/shift.db")
-	db, err := sql.Open("sqlite", dbPath)
+	db, err := sql.Open(sqliteDriverName, dbPath)
 	if err != nil {
 		return fmt.Errorf("データベース接続エラー: %v", err)
 	}
@@ -740,7 +837,7 @@ func registerShift(username string, shift ShiftEntry) error {
 
 func getShifts(username string) ([]ShiftEntry, error) {
 	dbPath := getEnv("DB_SHIFT_PATH", "./database/shift.db")
-	db, err := sql.Open("sqlite", dbPath)
+	db, err := sql.Open(sqliteDriverName, dbPath)
 	if err != nil {
 		return nil, fmt.Errorf("データベース接続エラー: %v", err)
 	}
@@ -800,8 +897,26 @@ func handleStatusAPI(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if hostName := r.URL.Query().Get("host"); hostName != "" && !strings.EqualFold(hostName, localHostName) {
+		hub, online, lastSeen, err := statusHubForHost(hostName)
+		if writeHostUnavailable(w, hostName, online, lastSeen, err) {
+			return
+		}
+		status, _, _ := hub.latest()
+		writeJSON(w, http.StatusOK, viewStatus(r, status))
+		return
+	}
+
 	w.Header().Set("Content-Type", "application/json; charset=utf-8")
 
+	// Reuse the background sample while it is current instead of collecting again.
+	if status, _, at := statusStream.latest(); status != nil && time.Since(at) < 2*statusSampleInterval() {
+		if err := json.NewEncoder(w).Encode(viewStatus(r, status)); err != nil {
+			log.Println("encode error:", err)
+		}
+		return
+	}
+
 	statusCh := make(chan *getstatus.PCStatus)
 	errCh := make(chan error)
 
@@ -816,7 +931,7 @@ func handleStatusAPI(w http.ResponseWriter, r *http.Request) {
 
 	select {
 	case status := <-statusCh:
-		if err := json.NewEncoder(w).Encode(status); err != nil {
+		if err := json.NewEncoder(w).Encode(viewStatus(r, status)); err != nil {
 			log.Println("encode error:", err)
 		}
 	case err := <-errCh:
@@ -831,6 +946,78 @@ func getPCStatus() (*getstatus.PCStatus, error) {
 	return getstatus.GetStatus()
 }
 
+const (
+	defaultStatusTopProcesses = 5
+	maxStatusTopProcesses     = 50
+)
+
+// handleStatusV2API returns the structured status. "top" sets how many processes
+// are ranked by CPU and by memory.
+func handleStatusV2API(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodHead {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	top := defaultStatusTopProcesses
+	if raw := r.URL.Query().Get("top"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 0 || n > maxStatusTopProcesses {
+			http.Error(w, fmt.Sprintf("top は0から%dの整数で指定してください", maxStatusTopProcesses), http.StatusBadRequest)
+			return
+		}
+		top = n
+	}
+	// Process lists are hidden from public clients, so top does not change what they get.
+	if !canViewStatusDetails(r) {
+		top = defaultStatusTopProcesses
+	}
+
+	if hostName := r.URL.Query().Get("host"); hostName != "" && !strings.EqualFold(hostName, localHostName) {
+		hub, online, lastSeen, err := statusHubForHost(hostName)
+		if writeHostUnavailable(w, hostName, online, lastSeen, err) {
+			return
+		}
+		// Agents always send the default number of processes.
+		_, details, _ := hub.latest()
+		writeJSON(w, http.StatusOK, viewStatusV2(r, details))
+		return
+	}
+
+	// The sampler already lists the default number of processes; reuse its sample while
+	// it is current instead of walking the process table for every request.
+	if _, details, at := statusStream.latest(); top == defaultStatusTopProcesses && details != nil && time.Since(at) < 2*statusSampleInterval() {
+		writeJSON(w, http.StatusOK, viewStatusV2(r, details))
+		return
+	}
+
+	type result struct {
+		status *getstatus.StatusV2
+		err    error
+	}
+	done := make(chan result, 1)
+	go func() {
+		status, err := getstatus.GetStatusV2(top)
+		done <- result{status, err}
+	}()
+
+	select {
+	case res := <-done:
+		if res.err != nil {
+			log.Println("status error:", res.err)
+			http.Error(w, "Failed to get status", http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusOK, viewStatusV2(r, res.status))
+	case <-time.After(15 * time.Second):
+		http.Error(w, "Timeout getting status", http.StatusGatewayTimeout)
+	}
+}
+
 func handleWeather(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 
@@ -849,7 +1036,16 @@ func handleWeather(w http.ResponseWriter, r *http.Request) {
 
 	regionKey := parseWeatherRegionKey(reqBody)
 
+	start := time.Now()
 	resp, apiRespBody, err := fetchWeatherAPI(reqBody)
+	switch {
+	case err != nil:
+		weatherUpstreamDuration.observe(time.Since(start), "error")
+	case resp.StatusCode >= 400:
+		weatherUpstreamDuration.observe(time.Since(start), "http_error")
+	default:
+		weatherUpstreamDuration.observe(time.Since(start), "ok")
+	}
 	if err != nil {
 		if resp != nil {
 			if closeErr := resp.Body.Close(); closeErr != nil {
@@ -934,6 +1130,11 @@ func mergeWeatherResponse(regionKey string, upstream map[string]interface{}) (ma
 	weatherCacheMu.Lock()
 	defer weatherCacheMu.Unlock()
 
+	if weatherCache[regionKey] != nil {
+		weatherCacheTotal.inc("hit")
+	} else {
+		weatherCacheTotal.inc("miss")
+	}
 	mutable := deepCopyMap(upstream)
 
 	body, _ := mutable["body"].(map[string]interface{})
@@ -1291,8 +1492,8 @@ func handleProfileImageUpload(w http.ResponseWriter, r *http.Request) {
 
 	uniqueID := uuid.New().String()
 	filename := uniqueID + ext
-	filePath := "home/assets/acc_icon/" + filename
-	imagePath := "/home/assets/acc_icon/" + filename
+	filePath := profileImageDir + "/" + filename
+	imagePath := "/" + profileImageDir + "/" + filename
 
 	out, err := os.Create(filePath)
 	if err != nil {
@@ -1460,7 +1661,7 @@ func handleShiftPost(w http.ResponseWriter, r *http.Request, schedDB *schedule.S
 		return
 	}
 
-	db, err := sql.Open("sqlite", "./database/shift.db")
+	db, err := sql.Open(sqliteDriverName, "./database/shift.db")
 	if err != nil {
 		http.Error(w, "データベースエラー", http.StatusInternalServerError)
 		return
@@ -1550,7 +1751,7 @@ func handleShiftGet(w http.ResponseWriter, r *http.Request) {
 }
 
 func deleteAllShiftsForUser(username string) error {
-	db, err := sql.Open("sqlite", "./database/shift.db")
+	db, err := sql.Open(sqliteDriverName, "./database/shift.db")
 	if err != nil {
 		return fmt.Errorf("データベース接続エラー: %v", err)
 	}
@@ -1583,7 +1784,7 @@ func getUserIDFromSession(r *http.Request) (string, error) {
 		return "", fmt.Errorf("unauthorized: invalid session")
 	}
 
-	db, err := sql.Open("sqlite", "./database/acc.db")
+	db, err := sql.Open(sqliteDriverName, "./database/acc.db")
 	if err != nil {
 		return "", fmt.Errorf("database error: %v", err)
 	}
@@ -0,0 +1,634 @@
+// 2025 TabDock: darui3018823 All rights reserved.
+// All works created by darui3018823 associated with this repository are the intellectual property of darui3018823.
+// Packages and other third-party materials used in this repository are subject to their respective licenses and copyrights.
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"math"
+	"net/http"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"tabdock/getstatus"
+)
+
+// Tabdock writes the Prometheus text format itself; the handful of counters and
+// histograms below do not justify the client library and its dependencies.
+
+// defaultDurationBuckets are the latency buckets in seconds shared by every histogram.
+var defaultDurationBuckets = []float64{0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// counterVec is a counter with labels.
+type counterVec struct {
+	name, help string
+	labels     []string
+
+	mu     sync.Mutex
+	values map[string]float64
+}
+
+func newCounterVec(name, help string, labels ...string) *counterVec {
+	return &counterVec{name: name, help: help, labels: labels, values: map[string]float64{}}
+}
+
+func (c *counterVec) inc(values ...string) {
+	c.mu.Lock()
+	c.values[strings.Join(values, "\xff")]++
+	c.mu.Unlock()
+}
+
+func (c *counterVec) write(w *bufio.Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	writeMetricHeader(w, c.name, c.help, "counter")
+	for _, key := range sortedKeys(c.values) {
+		writeSample(w, c.name, c.labels, strings.Split(key, "\xff"), c.values[key])
+	}
+}
+
+// histogramVec is a histogram with labels.
+type histogramVec struct {
+	name, help string
+	labels     []string
+	buckets    []float64
+
+	mu     sync.Mutex
+	series map[string]*histogramSeries
+}
+
+type histogramSeries struct {
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+func newHistogramVec(name, help string, labels ...string) *histogramVec {
+	return &histogramVec{name: name, help: help, labels: labels, buckets: defaultDurationBuckets, series: map[string]*histogramSeries{}}
+}
+
+func (h *histogramVec) observe(d time.Duration, values ...string) {
+	seconds := d.Seconds()
+	key := strings.Join(values, "\xff")
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s := h.series[key]
+	if s == nil {
+		s = &histogramSeries{counts: make([]uint64, len(h.buckets))}
+		h.series[key] = s
+	}
+	for i, bound := range h.buckets {
+		if seconds <= bound {
+			s.counts[i]++
+		}
+	}
+	s.sum += seconds
+	s.count++
+}
+
+func (h *histogramVec) write(w *bufio.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	writeMetricHeader(w, h.name, h.help, "histogram")
+	keys := make([]string, 0, len(h.series))
+	for key := range h.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	labels := append(append([]string{}, h.labels...), "le")
+	for _, key := range keys {
+		s := h.series[key]
+		var values []string
+		if len(h.labels) > 0 {
+			values = strings.Split(key, "\xff")
+		}
+		for i, bound := range h.buckets {
+			writeSample(w, h.name+"_bucket", labels, append(values, formatFloat(bound)), float64(s.counts[i]))
+		}
+		writeSample(w, h.name+"_bucket", labels, append(values, "+Inf"), float64(s.count))
+		writeSample(w, h.name+"_sum", h.labels, values, s.sum)
+		writeSample(w, h.name+"_count", h.labels, values, float64(s.count))
+	}
+}
+
+func sortedKeys(m map[string]float64) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func writeMetricHeader(w *bufio.Writer, name, help, kind string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+var labelValueEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+
+func writeSample(w *bufio.Writer, name string, labels, values []string, value float64) {
+	w.WriteString(name)
+	if len(labels) > 0 {
+		w.WriteByte('{')
+		for i, label := range labels {
+			if i > 0 {
+				w.WriteByte(',')
+			}
+			fmt.Fprintf(w, `%s="%s"`, label, labelValueEscaper.Replace(values[i]))
+		}
+		w.WriteByte('}')
+	}
+	w.WriteByte(' ')
+	w.WriteString(formatFloat(value))
+	w.WriteByte('\n')
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// gauge writes a single unlabelled gauge.
+func gauge(w *bufio.Writer, name, help string, value float64) {
+	writeMetricHeader(w, name, help, "gauge")
+	writeSample(w, name, nil, nil, value)
+}
+
+// ===== Tabdock metrics =====
+
+var (
+	httpRequestsTotal = newCounterVec("tabdock_http_requests_total",
+		"HTTP requests by route, method and status code.", "route", "method", "code")
+	httpRequestDuration = newHistogramVec("tabdock_http_request_duration_seconds",
+		"HTTP request latency by route and method. Event streams are not observed.", "route", "method")
+	httpRequestsInFlight atomic.Int64
+
+	securityDecisionsTotal = newCounterVec("tabdock_security_decisions_total",
+		"Requests stopped by secureHandler by rule and level; mode=monitor counts what a monitored rule would have stopped.",
+		"rule", "level", "mode")
+
+	weatherUpstreamDuration = newHistogramVec("tabdock_weather_upstream_duration_seconds",
+		"Latency of the upstream weather API by result.", "result")
+	weatherCacheTotal = newCounterVec("tabdock_weather_cache_requests_total",
+		"Weather responses by whether a cached response for the region was merged in.", "result")
+
+	dbQueryDuration = newHistogramVec("tabdock_db_query_duration_seconds",
+		"SQLite statement latency by database and operation, up to the first row for queries.", "db", "op")
+)
+
+// recordSecurityDecision counts a request a rule stopped, or would have stopped when
+// the rule is monitored.
+func recordSecurityDecision(rule, level string, monitored bool) {
+	mode := "enforce"
+	if monitored {
+		mode = "monitor"
+	}
+	securityDecisionsTotal.inc(rule, level, mode)
+}
+
+// activeSessionWindow is how recently a session must have been used to count as active.
+const activeSessionWindow = 15 * time.Minute
+
+// Sessions are stateless signed cookies, so active sessions are counted from the
+// requests that present one.
+var (
+	activeSessionsMu sync.Mutex
+	activeSessions   = map[string]activeSession{}
+)
+
+type activeSession struct {
+	username  string
+	lastSeen  time.Time
+	expiresAt time.Time
+}
+
+// noteActiveSession records that a valid session cookie was used.
+func noteActiveSession(value, username string, expiresAt time.Time) {
+	now := time.Now()
+	activeSessionsMu.Lock()
+	defer activeSessionsMu.Unlock()
+	// Without scrapes nothing else prunes the map, so bound it here.
+	if len(activeSessions) >= maxTrackedSessions {
+		pruneActiveSessionsLocked(now)
+	}
+	activeSessions[sessionFingerprint(value)] = activeSession{username: username, lastSeen: now, expiresAt: expiresAt}
+}
+
+const maxTrackedSessions = 4096
+
+func pruneActiveSessionsLocked(now time.Time) {
+	for key, s := range activeSessions {
+		if now.Sub(s.lastSeen) > activeSessionWindow || now.After(s.expiresAt) {
+			delete(activeSessions, key)
+		}
+	}
+}
+
+func countActiveSessions(now time.Time) (sessions, users int) {
+	activeSessionsMu.Lock()
+	defer activeSessionsMu.Unlock()
+	pruneActiveSessionsLocked(now)
+	seen := map[string]bool{}
+	for _, s := range activeSessions {
+		seen[s.username] = true
+	}
+	return len(activeSessions), len(seen)
+}
+
+// sessionFingerprint keys a session without keeping the cookie itself in memory.
+func sessionFingerprint(value string) string {
+	return hashAPIToken(value)[:16]
+}
+
+func countDynamicBlocks(now time.Time) int {
+	dynamicBlockMutex.Lock()
+	defer dynamicBlockMutex.Unlock()
+	n := 0
+	for _, block := range dynamicBlockMap {
+		if now.Before(block.Until) {
+			n++
+		}
+	}
+	return n
+}
+
+// ===== HTTP =====
+
+// metricsResponseWriter remembers the status code. Unwrap keeps flushing and write
+// deadlines working through http.ResponseController.
+type metricsResponseWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *metricsResponseWriter) WriteHeader(code int) {
+	if w.status == 0 {
+		w.status = code
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *metricsResponseWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *metricsResponseWriter) Flush() {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *metricsResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+var metricMethods = map[string]bool{
+	http.MethodGet: true, http.MethodHead: true, http.MethodPost: true, http.MethodPut: true,
+	http.MethodPatch: true, http.MethodDelete: true, http.MethodOptions: true,
+}
+
+// instrumentHTTP counts every request under the ServeMux pattern that serves it, so
+// the route label stays bounded whatever paths clients send.
+func instrumentHTTP(mux *http.ServeMux) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, route := mux.Handler(r)
+		if route == "" {
+			route = "unmatched"
+		}
+		method := r.Method
+		if !metricMethods[method] {
+			method = "OTHER"
+		}
+
+		httpRequestsInFlight.Add(1)
+		defer httpRequestsInFlight.Add(-1)
+		start := time.Now()
+		mw := &metricsResponseWriter{ResponseWriter: w}
+		mux.ServeHTTP(mw, r)
+
+		status := mw.status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		httpRequestsTotal.inc(route, method, strconv.Itoa(status))
+		if !strings.HasPrefix(w.Header().Get("Content-Type"), "text/event-stream") {
+			httpRequestDuration.observe(time.Since(start), route, method)
+		}
+	})
+}
+
+// ===== /metrics =====
+
+// handleMetrics serves Prometheus metrics to trusted or private addresses, or to an API
+// token with the metrics:read scope.
+func handleMetrics(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if token := getBearerToken(r); token != "" {
+		if _, err := getUsernameFromAPIToken(r, token); err != nil {
+			http.Error(w, "認証情報が確認できません", http.StatusUnauthorized)
+			return
+		}
+	} else if !isLocalRequest(r) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	if r.Method == http.MethodHead {
+		return
+	}
+
+	out := bufio.NewWriter(w)
+	defer out.Flush()
+	now := time.Now()
+
+	writeMetricHeader(out, "tabdock_build_info", "Tabdock version.", "gauge")
+	writeSample(out, "tabdock_build_info", []string{"version"}, []string{version}, 1)
+
+	httpRequestsTotal.write(out)
+	httpRequestDuration.write(out)
+	gauge(out, "tabdock_http_requests_in_flight", "HTTP requests being served, including open event streams.",
+		float64(httpRequestsInFlight.Load()))
+
+	securityDecisionsTotal.write(out)
+	gauge(out, "tabdock_security_dynamic_blocks", "IP addresses currently under a dynamic block.",
+		float64(countDynamicBlocks(now)))
+
+	weatherUpstreamDuration.write(out)
+	weatherCacheTotal.write(out)
+	dbQueryDuration.write(out)
+
+	sessions, users := countActiveSessions(now)
+	gauge(out, "tabdock_active_sessions", "Session cookies used in the last 15 minutes.", float64(sessions))
+	gauge(out, "tabdock_active_users", "Users with a session used in the last 15 minutes.", float64(users))
+	gauge(out, "tabdock_status_stream_clients", "Open /api/status/stream connections for this server.",
+		float64(statusStream.connected.Load()))
+
+	gauge(out, "go_goroutines", "Number of goroutines that currently exist.", float64(runtime.NumGoroutine()))
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+	gauge(out, "go_memstats_alloc_bytes", "Number of bytes allocated and still in use.", float64(mem.Alloc))
+
+	writeHostMetrics(out, now)
+}
+
+// hostMetricSample is the latest sample of one host, from the local sampler or an agent.
+type hostMetricSample struct {
+	host    string
+	up      bool
+	at      time.Time
+	details *getstatus.StatusV2
+}
+
+func hostMetricSamples(now time.Time) []hostMetricSample {
+	var samples []hostMetricSample
+	if _, details, at := statusStream.latest(); details != nil {
+		samples = append(samples, hostMetricSample{host: localHostName, up: true, at: at, details: details})
+	}
+
+	type agent struct {
+		name string
+		hub  *statusHub
+		up   bool
+	}
+	remoteHostsMu.Lock()
+	agents := make([]agent, 0, len(remoteHosts))
+	for _, host := range remoteHosts {
+		agents = append(agents, agent{host.name, host.hub, host.isOnline(now)})
+	}
+	remoteHostsMu.Unlock()
+	sort.Slice(agents, func(i, j int) bool { return agents[i].name < agents[j].name })
+
+	for _, a := range agents {
+		if _, details, at := a.hub.latest(); details != nil {
+			samples = append(samples, hostMetricSample{host: a.name, up: a.up, at: at, details: details})
+		}
+	}
+	return samples
+}
+
+// writeHostMetrics exports the latest getstatus sample of this server and of every agent.
+// Nothing is collected at scrape time.
+func writeHostMetrics(w *bufio.Writer, now time.Time) {
+	samples := hostMetricSamples(now)
+
+	type series struct {
+		name, help, kind string
+		labels           []string
+		emit             func(s hostMetricSample, add func(value float64, labels ...string))
+	}
+	perHost := func(value func(d *getstatus.StatusV2) (float64, bool)) func(hostMetricSample, func(float64, ...string)) {
+		return func(s hostMetricSample, add func(float64, ...string)) {
+			if v, ok := value(s.details); ok {
+				add(v)
+			}
+		}
+	}
+
+	all := []series{
+		{"tabdock_host_up", "1 when the host reported within its offline timeout.", "gauge", nil,
+			func(s hostMetricSample, add func(float64, ...string)) { add(boolMetric(s.up)) }},
+		{"tabdock_host_sample_timestamp_seconds", "Time of the host's latest sample.", "gauge", nil,
+			func(s hostMetricSample, add func(float64, ...string)) { add(float64(s.at.Unix())) }},
+		{"tabdock_host_uptime_seconds", "Host uptime.", "gauge", nil,
+			perHost(func(d *getstatus.StatusV2) (float64, bool) { return float64(d.Host.UptimeSeconds), true })},
+		{"tabdock_host_cpu_percent", "CPU usage over all cores.", "gauge", nil,
+			perHost(func(d *getstatus.StatusV2) (float64, bool) { return d.CPU.Percent, true })},
+		{"tabdock_host_cpu_cores", "Logical CPU cores.", "gauge", nil,
+			perHost(func(d *getstatus.StatusV2) (float64, bool) { return float64(d.CPU.Cores), true })},
+		{"tabdock_host_load1", "1 minute load average.", "gauge", nil,
+			perHost(func(d *getstatus.StatusV2) (float64, bool) {
+				if d.CPU.Load == nil {
+					return 0, false
+				}
+				return d.CPU.Load.Load1, true
+			})},
+		{"tabdock_host_load5", "5 minute load average.", "gauge", nil,
+			perHost(func(d *getstatus.StatusV2) (float64, bool) {
+				if d.CPU.Load == nil {
+					return 0, false
+				}
+				return d.CPU.Load.Load5, true
+			})},
+		{"tabdock_host_load15", "15 minute load average.", "gauge", nil,
+			perHost(func(d *getstatus.StatusV2) (float64, bool) {
+				if d.CPU.Load == nil {
+					return 0, false
+				}
+				return d.CPU.Load.Load15, true
+			})},
+		{"tabdock_host_memory_used_bytes", "Used memory.", "gauge", nil,
+			perHost(func(d *getstatus.StatusV2) (float64, bool) { return float64(d.Memory.UsedBytes), true })},
+		{"tabdock_host_memory_total_bytes", "Total memory.", "gauge", nil,
+			perHost(func(d *getstatus.StatusV2) (float64, bool) { return float64(d.Memory.TotalBytes), true })},
+		{"tabdock_host_swap_used_bytes", "Used swap.", "gauge", nil,
+			perHost(func(d *getstatus.StatusV2) (float64, bool) {
+				if d.Swap == nil {
+					return 0, false
+				}
+				return float64(d.Swap.UsedBytes), true
+			})},
+		{"tabdock_host_swap_total_bytes", "Total swap.", "gauge", nil,
+			perHost(func(d *getstatus.StatusV2) (float64, bool) {
+				if d.Swap == nil {
+					return 0, false
+				}
+				return float64(d.Swap.TotalBytes), true
+			})},
+		{"tabdock_host_filesystem_used_bytes", "Used space per mount.", "gauge", []string{"mount", "fstype"},
+			func(s hostMetricSample, add func(float64, ...string)) {
+				for _, m := range s.details.Mounts {
+					add(float64(m.UsedBytes), m.Mount, m.FSType)
+				}
+			}},
+		{"tabdock_host_filesystem_size_bytes", "Size per mount.", "gauge", []string{"mount", "fstype"},
+			func(s hostMetricSample, add func(float64, ...string)) {
+				for _, m := range s.details.Mounts {
+					add(float64(m.TotalBytes), m.Mount, m.FSType)
+				}
+			}},
+		{"tabdock_host_network_receive_bytes_total", "Bytes received per interface.", "counter", []string{"interface"},
+			func(s hostMetricSample, add func(float64, ...string)) {
+				for _, iface := range s.details.Network {
+					add(float64(iface.RxBytes), iface.Name)
+				}
+			}},
+		{"tabdock_host_network_transmit_bytes_total", "Bytes sent per interface.", "counter", []string{"interface"},
+			func(s hostMetricSample, add func(float64, ...string)) {
+				for _, iface := range s.details.Network {
+					add(float64(iface.TxBytes), iface.Name)
+				}
+			}},
+		{"tabdock_host_temperature_celsius", "Temperature per sensor.", "gauge", []string{"sensor"},
+			func(s hostMetricSample, add func(float64, ...string)) {
+				for _, t := range s.details.Temperatures {
+					add(t.Celsius, t.Sensor)
+				}
+			}},
+		{"tabdock_host_battery_percent", "Battery charge.", "gauge", nil,
+			perHost(func(d *getstatus.StatusV2) (float64, bool) {
+				if d.Battery == nil {
+					return 0, false
+				}
+				return d.Battery.Percent, true
+			})},
+		{"tabdock_host_battery_charging", "1 while the battery is charging.", "gauge", nil,
+			perHost(func(d *getstatus.StatusV2) (float64, bool) {
+				if d.Battery == nil {
+					return 0, false
+				}
+				return boolMetric(d.Battery.Charging), true
+			})},
+		{"tabdock_host_gpu_percent", "GPU usage.", "gauge", []string{"gpu"},
+			func(s hostMetricSample, add func(float64, ...string)) {
+				for _, g := range s.details.GPUs {
+					add(g.Percent, strconv.Itoa(g.Index))
+				}
+			}},
+		{"tabdock_host_gpu_memory_used_bytes", "Used VRAM.", "gauge", []string{"gpu"},
+			func(s hostMetricSample, add func(float64, ...string)) {
+				for _, g := range s.details.GPUs {
+					add(float64(g.VRAMUsedBytes), strconv.Itoa(g.Index))
+				}
+			}},
+		{"tabdock_host_gpu_memory_total_bytes", "Total VRAM.", "gauge", []string{"gpu"},
+			func(s hostMetricSample, add func(float64, ...string)) {
+				for _, g := range s.details.GPUs {
+					add(float64(g.VRAMTotalBytes), strconv.Itoa(g.Index))
+				}
+			}},
+		{"tabdock_host_wan_up", "1 when a WAN probe succeeded.", "gauge", nil,
+			perHost(func(d *getstatus.StatusV2) (float64, bool) { return boolMetric(d.WAN.Online), true })},
+		{"tabdock_host_probe_up", "1 when the connectivity probe's latest check succeeded.", "gauge", []string{"probe", "type"},
+			func(s hostMetricSample, add func(float64, ...string)) {
+				for _, p := range s.details.WAN.Probes {
+					if p.CheckedAt != nil {
+						add(boolMetric(p.Up), p.Name, p.Type)
+					}
+				}
+			}},
+		{"tabdock_host_probe_latency_seconds", "Average round trip of the probe's latest check.", "gauge", []string{"probe", "type"},
+			func(s hostMetricSample, add func(float64, ...string)) {
+				for _, p := range s.details.WAN.Probes {
+					if p.LatencyMs != nil {
+						add(*p.LatencyMs/1000, p.Name, p.Type)
+					}
+				}
+			}},
+		{"tabdock_host_probe_loss_ratio", "Share of failed attempts in the probe's latest check.", "gauge", []string{"probe", "type"},
+			func(s hostMetricSample, add func(float64, ...string)) {
+				for _, p := range s.details.WAN.Probes {
+					if p.CheckedAt != nil {
+						add(p.LossPercent/100, p.Name, p.Type)
+					}
+				}
+			}},
+		{"tabdock_host_user_active", "1 when someone used the host within five minutes.", "gauge", nil,
+			perHost(func(d *getstatus.StatusV2) (float64, bool) {
+				if d.Activity.Active == nil {
+					return 0, false
+				}
+				return boolMetric(*d.Activity.Active), true
+			})},
+		{"tabdock_host_idle_seconds", "Time since the last keyboard or mouse input.", "gauge", nil,
+			perHost(func(d *getstatus.StatusV2) (float64, bool) {
+				if d.Activity.IdleSeconds == nil {
+					return 0, false
+				}
+				return float64(*d.Activity.IdleSeconds), true
+			})},
+		{"tabdock_host_sessions", "Logged-in user sessions.", "gauge", nil,
+			perHost(func(d *getstatus.StatusV2) (float64, bool) { return float64(len(d.Activity.Sessions)), true })},
+		{"tabdock_host_custom_value", "Custom status fields with a number or boolean value.", "gauge", []string{"name"},
+			func(s hostMetricSample, add func(float64, ...string)) {
+				names := make([]string, 0, len(s.details.Custom))
+				for name := range s.details.Custom {
+					names = append(names, name)
+				}
+				sort.Strings(names)
+				for _, name := range names {
+					switch v := s.details.Custom[name].Value.(type) {
+					case float64:
+						add(v, name)
+					case bool:
+						add(boolMetric(v), name)
+					}
+				}
+			}},
+		{"tabdock_host_processes", "Number of processes.", "gauge", nil,
+			perHost(func(d *getstatus.StatusV2) (float64, bool) { return float64(d.Processes.Total), true })},
+	}
+
+	for _, m := range all {
+		writeMetricHeader(w, m.name, m.help, m.kind)
+		labels := append([]string{"host"}, m.labels...)
+		for _, s := range samples {
+			m.emit(s, func(value float64, values ...string) {
+				writeSample(w, m.name, labels, append([]string{s.host}, values...), value)
+			})
+		}
+	}
+}
@@ -0,0 +1,192 @@
+// 2025 TabDock: darui3018823 All rights reserved.
+// All works created by darui3018823 associated with this repository are the intellectual property of darui3018823.
+// Packages and other third-party materials used in this repository are subject to their respective licenses and copyrights.
+
+package main
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"modernc.org/sqlite"
+)
+
+// sqliteDriverName is the SQLite driver every database is opened with. It wraps the
+// modernc driver to time each statement for /metrics.
+const sqliteDriverName = "tabdock-sqlite"
+
+func init() {
+	sql.Register(sqliteDriverName, &timedDriver{inner: &sqlite.Driver{}})
+}
+
+// dbLabel names a database by its file, such as "acc" for ./database/acc.db.
+func dbLabel(dsn string) string {
+	path, _, _ := strings.Cut(strings.TrimPrefix(dsn, "file:"), "?")
+	name := filepath.Base(path)
+	return strings.TrimSuffix(name, filepath.Ext(name))
+}
+
+type timedDriver struct {
+	inner driver.Driver
+}
+
+func (d *timedDriver) Open(dsn string) (driver.Conn, error) {
+	conn, err := d.inner.Open(dsn)
+	if err != nil {
+		return nil, err
+	}
+	return &timedConn{inner: conn, db: dbLabel(dsn)}, nil
+}
+
+// timedConn passes everything through to the driver's connection, timing statements.
+// Optional interfaces the driver lacks fall back the way database/sql expects.
+type timedConn struct {
+	inner driver.Conn
+	db    string
+}
+
+func observeDB(db, op string, start time.Time) {
+	dbQueryDuration.observe(time.Since(start), db, op)
+}
+
+func (c *timedConn) Prepare(query string) (driver.Stmt, error) {
+	stmt, err := c.inner.Prepare(query)
+	if err != nil {
+		return nil, err
+	}
+	return &timedStmt{inner: stmt, db: c.db}, nil
+}
+
+func (c *timedConn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	preparer, ok := c.inner.(driver.ConnPrepareContext)
+	if !ok {
+		return c.Prepare(query)
+	}
+	stmt, err := preparer.PrepareContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	return &timedStmt{inner: stmt, db: c.db}, nil
+}
+
+func (c *timedConn) Close() error {
+	return c.inner.Close()
+}
+
+func (c *timedConn) Begin() (driver.Tx, error) {
+	return c.inner.Begin()
+}
+
+func (c *timedConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	if beginner, ok := c.inner.(driver.ConnBeginTx); ok {
+		return beginner.BeginTx(ctx, opts)
+	}
+	if opts.ReadOnly || driver.IsolationLevel(opts.Isolation) != driver.IsolationLevel(sql.LevelDefault) {
+		return nil, errors.New("sqlite: transaction options are not supported")
+	}
+	return c.inner.Begin()
+}
+
+func (c *timedConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	execer, ok := c.inner.(driver.ExecerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+	defer observeDB(c.db, "exec", time.Now())
+	return execer.ExecContext(ctx, query, args)
+}
+
+func (c *timedConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	queryer, ok := c.inner.(driver.QueryerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+	defer observeDB(c.db, "query", time.Now())
+	return queryer.QueryContext(ctx, query, args)
+}
+
+func (c *timedConn) Ping(ctx context.Context) error {
+	if pinger, ok := c.inner.(driver.Pinger); ok {
+		return pinger.Ping(ctx)
+	}
+	return nil
+}
+
+func (c *timedConn) ResetSession(ctx context.Context) error {
+	if resetter, ok := c.inner.(driver.SessionResetter); ok {
+		return resetter.ResetSession(ctx)
+	}
+	return nil
+}
+
+func (c *timedConn) IsValid() bool {
+	if validator, ok := c.inner.(driver.Validator); ok {
+		return validator.IsValid()
+	}
+	return true
+}
+
+type timedStmt struct {
+	inner driver.Stmt
+	db    string
+}
+
+func (s *timedStmt) Close() error {
+	return s.inner.Close()
+}
+
+func (s *timedStmt) NumInput() int {
+	return s.inner.NumInput()
+}
+
+func (s *timedStmt) Exec(args []driver.Value) (driver.Result, error) {
+	defer observeDB(s.db, "exec", time.Now())
+	return s.inner.Exec(args)
+}
+
+func (s *timedStmt) Query(args []driver.Value) (driver.Rows, error) {
+	defer observeDB(s.db, "query", time.Now())
+	return s.inner.Query(args)
+}
+
+func (s *timedStmt) ExecContext(ctx context.Context, args []driver.NamedValue) (driver.Result, error) {
+	execer, ok := s.inner.(driver.StmtExecContext)
+	if !ok {
+		values, err := namedValuesToValues(args)
+		if err != nil {
+			return nil, err
+		}
+		return s.Exec(values)
+	}
+	defer observeDB(s.db, "exec", time.Now())
+	return execer.ExecContext(ctx, args)
+}
+
+func (s *timedStmt) QueryContext(ctx context.Context, args []driver.NamedValue) (driver.Rows, error) {
+	queryer, ok := s.inner.(driver.StmtQueryContext)
+	if !ok {
+		values, err := namedValuesToValues(args)
+		if err != nil {
+			return nil, err
+		}
+		return s.Query(values)
+	}
+	defer observeDB(s.db, "query", time.Now())
+	return queryer.QueryContext(ctx, args)
+}
+
+func namedValuesToValues(args []driver.NamedValue) ([]driver.Value, error) {
+	values := make([]driver.Value, len(args))
+	for i, arg := range args {
+		if arg.Name != "" {
+			return nil, errors.New("sqlite: named parameters are not supported by this statement")
+		}
+		values[i] = arg.Value
+	}
+	return values, nil
+}
@@ -57,7 +57,7 @@ type legacyUser struct {
 }
 
 func loadLegacyUsers() ([]legacyUser, error) {
-	accDB, err := sql.Open("sqlite", "./database/acc.db")
+	accDB, err := sql.Open(sqliteDriverName, "./database/acc.db")
 	if err != nil {
 		return nil, fmt.Errorf("failed to open acc.db: %v", err)
 	}
@@ -175,7 +175,7 @@ func performLegacyMigration(users []legacyUser, schedules []LegacySchedule) (int
 		return 0, initErr
 	}
 
-	schedDB, err := sql.Open("sqlite", "./database/schedule.db")
+	schedDB, err := sql.Open(sqliteDriverName, "./database/schedule.db")
 	if err != nil {
 		return 0, fmt.Errorf("failed to open schedule.db: %v", err)
 	}
@@ -0,0 +1,204 @@
+// 2025 TabDock: darui3018823 All rights reserved.
+// All works created by darui3018823 associated with this repository are the intellectual property of darui3018823.
+// Packages and other third-party materials used in this repository are subject to their respective licenses and copyrights.
+
+package notify
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// Notifier types accepted in Config.Type.
+const (
+	TypeDiscord = "discord"
+	TypeSlack   = "slack"
+	TypeWebhook = "webhook"
+	TypeNtfy    = "ntfy"
+	TypeGotify  = "gotify"
+	TypeSMTP    = "smtp"
+)
+
+// Defaults applied when a Config leaves the field at zero.
+const (
+	DefaultDedupWindow = 5 * time.Minute
+	DefaultMaxRetries  = 3
+	DefaultRetryDelay  = 2 * time.Second
+	DefaultSMTPPort    = 587
+	sendTimeout        = 10 * time.Second
+)
+
+// Config describes one notifier in the security configuration. Secrets can be given
+// directly or read from an environment variable named by the matching *_env field.
+type Config struct {
+	Name   string   `json:"name"`
+	Type   string   `json:"type"`
+	Levels []string `json:"levels,omitempty"`
+
+	// DedupWindowSec holds back repeats of the same IP and level for this long and
+	// reports them as one summary afterwards. 0 uses 300 seconds; -1 disables it.
+	DedupWindowSec int `json:"dedup_window_sec,omitempty"`
+	// AggregateWindowSec collects events for this long and sends them as one message.
+	AggregateWindowSec int `json:"aggregate_window_sec,omitempty"`
+	MaxRetries         int `json:"max_retries,omitempty"`
+	RetryDelaySec      int `json:"retry_delay_sec,omitempty"`
+
+	URL      string            `json:"url,omitempty"`
+	URLEnv   string            `json:"url_env,omitempty"`
+	Token    string            `json:"token,omitempty"`
+	TokenEnv string            `json:"token_env,omitempty"`
+	Headers  map[string]string `json:"headers,omitempty"`
+
+	SMTPHost    string   `json:"smtp_host,omitempty"`
+	SMTPPort    int      `json:"smtp_port,omitempty"`
+	Username    string   `json:"username,omitempty"`
+	Password    string   `json:"password,omitempty"`
+	PasswordEnv string   `json:"password_env,omitempty"`
+	From        string   `json:"from,omitempty"`
+	To          []string `json:"to,omitempty"`
+}
+
+func resolveSecret(value, envKey string) string {
+	if envKey != "" {
+		if fromEnv := os.Getenv(envKey); fromEnv != "" {
+			return fromEnv
+		}
+	}
+	return value
+}
+
+func (c *Config) dedupWindow() time.Duration {
+	switch {
+	case c.DedupWindowSec < 0:
+		return 0
+	case c.DedupWindowSec == 0:
+		return DefaultDedupWindow
+	default:
+		return time.Duration(c.DedupWindowSec) * time.Second
+	}
+}
+
+func (c *Config) maxRetries() int {
+	if c.MaxRetries > 0 {
+		return c.MaxRetries
+	}
+	return DefaultMaxRetries
+}
+
+func (c *Config) retryDelay() time.Duration {
+	if c.RetryDelaySec > 0 {
+		return time.Duration(c.RetryDelaySec) * time.Second
+	}
+	return DefaultRetryDelay
+}
+
+// levels returns the routed levels, defaulting to warn, attack and block.
+func (c *Config) levels() map[string]bool {
+	levels := map[string]bool{}
+	for _, level := range c.Levels {
+		levels[strings.ToLower(strings.TrimSpace(level))] = true
+	}
+	if len(levels) == 0 {
+		levels = map[string]bool{"warn": true, "attack": true, "block": true}
+	}
+	return levels
+}
+
+// Validate reports every problem with a list of notifier configs.
+// Secrets read from the environment are not checked, since they may be set later.
+func Validate(configs []Config) []string {
+	var problems []string
+	names := map[string]bool{}
+	for i, c := range configs {
+		label := fmt.Sprintf("notifiers[%d]", i)
+		if c.Name != "" {
+			label = fmt.Sprintf("notifiers[%q]", c.Name)
+			if names[c.Name] {
+				problems = append(problems, label+": name が重複しています")
+			}
+			names[c.Name] = true
+		}
+		if c.DedupWindowSec < -1 || c.AggregateWindowSec < 0 || c.MaxRetries < 0 || c.RetryDelaySec < 0 {
+			problems = append(problems, label+": 時間・回数に負の値は指定できません (dedup_window_sec の -1 を除く)")
+		}
+		for _, level := range c.Levels {
+			switch strings.ToLower(strings.TrimSpace(level)) {
+			case "info", "warn", "attack", "block", "error":
+			default:
+				problems = append(problems, fmt.Sprintf("%s: levels に不正な値があります: %q", label, level))
+			}
+		}
+
+		switch c.Type {
+		case TypeDiscord, TypeSlack, TypeWebhook, TypeNtfy, TypeGotify:
+			if c.URL == "" && c.URLEnv == "" {
+				problems = append(problems, label+": url または url_env が必要です")
+			} else if c.URL != "" {
+				if parsed, err := url.Parse(c.URL); err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+					problems = append(problems, fmt.Sprintf("%s: url が不正です: %q", label, c.URL))
+				}
+			}
+			if c.Type == TypeGotify && c.Token == "" && c.TokenEnv == "" {
+				problems = append(problems, label+": gotify には token または token_env が必要です")
+			}
+		case TypeSMTP:
+			if c.SMTPHost == "" {
+				problems = append(problems, label+": smtp_host が必要です")
+			}
+			if c.From == "" || len(c.To) == 0 {
+				problems = append(problems, label+": from と to が必要です")
+			}
+		default:
+			problems = append(problems, fmt.Sprintf("%s: type が不正です: %q", label, c.Type))
+		}
+	}
+	return problems
+}
+
+// New builds the notifier described by c.
+func New(c Config) (Notifier, error) {
+	name := c.Name
+	if name == "" {
+		name = c.Type
+	}
+	client := &http.Client{Timeout: sendTimeout}
+	endpoint := resolveSecret(c.URL, c.URLEnv)
+	token := resolveSecret(c.Token, c.TokenEnv)
+
+	if c.Type != TypeSMTP && endpoint == "" {
+		return nil, fmt.Errorf("%s: 送信先URLが設定されていません", name)
+	}
+
+	switch c.Type {
+	case TypeDiscord:
+		return &DiscordNotifier{name: name, url: endpoint, client: client}, nil
+	case TypeSlack:
+		return &SlackNotifier{name: name, url: endpoint, client: client}, nil
+	case TypeWebhook:
+		return &WebhookNotifier{name: name, url: endpoint, headers: c.Headers, client: client}, nil
+	case TypeNtfy:
+		return &NtfyNotifier{name: name, url: endpoint, token: token, client: client}, nil
+	case TypeGotify:
+		return &GotifyNotifier{name: name, url: endpoint, token: token, client: client}, nil
+	case TypeSMTP:
+		port := c.SMTPPort
+		if port == 0 {
+			port = DefaultSMTPPort
+		}
+		return &SMTPNotifier{
+			name:     name,
+			host:     c.SMTPHost,
+			port:     port,
+			username: c.Username,
+			password: resolveSecret(c.Password, c.PasswordEnv),
+			from:     c.From,
+			to:       c.To,
+		}, nil
+	default:
+		return nil, fmt.Errorf("%s: 未対応の通知タイプです: %q", name, c.Type)
+	}
+}
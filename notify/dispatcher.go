@@ -0,0 +1,242 @@
+// 2025 TabDock: darui3018823 All rights reserved.
+// All works created by darui3018823 associated with this repository are the intellectual property of darui3018823.
+// Packages and other third-party materials used in this repository are subject to their respective licenses and copyrights.
+
+package notify
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+)
+
+const (
+	// queueSize bounds the batches waiting per notifier; newer batches are dropped when full.
+	queueSize    = 64
+	tickInterval = time.Second
+)
+
+// dedupEntry tracks repeats of one IP and level inside the dedup window.
+type dedupEntry struct {
+	until      time.Time
+	suppressed int
+	last       Alert
+}
+
+// route feeds one notifier: it filters by level, deduplicates, aggregates and retries.
+type route struct {
+	notifier        Notifier
+	levels          map[string]bool
+	dedupWindow     time.Duration
+	aggregateWindow time.Duration
+	maxRetries      int
+	retryDelay      time.Duration
+
+	mu           sync.Mutex
+	seen         map[string]*dedupEntry
+	pending      []Event
+	pendingSince time.Time
+	// stopped is set by the final flush; later alerts are dropped instead of queued.
+	stopped bool
+
+	// queue is never closed: alerts may still arrive after Stop, from callers that
+	// loaded the dispatcher before it was replaced.
+	queue chan []Event
+}
+
+// Dispatcher fans alerts out to every configured notifier.
+type Dispatcher struct {
+	routes []*route
+	stop   chan struct{}
+	wg     sync.WaitGroup
+	once   sync.Once
+}
+
+// NewDispatcher builds the notifiers and starts their workers. A notifier that cannot
+// be built is logged and skipped so one bad entry does not silence the others.
+func NewDispatcher(configs []Config) *Dispatcher {
+	d := &Dispatcher{stop: make(chan struct{})}
+	for _, c := range configs {
+		notifier, err := New(c)
+		if err != nil {
+			log.Printf("[WARN] 通知先を初期化できません: %v", err)
+			continue
+		}
+		d.routes = append(d.routes, &route{
+			notifier:        notifier,
+			levels:          c.levels(),
+			dedupWindow:     c.dedupWindow(),
+			aggregateWindow: time.Duration(c.AggregateWindowSec) * time.Second,
+			maxRetries:      c.maxRetries(),
+			retryDelay:      c.retryDelay(),
+			seen:            map[string]*dedupEntry{},
+			queue:           make(chan []Event, queueSize),
+		})
+	}
+
+	for _, r := range d.routes {
+		d.wg.Add(1)
+		go func(r *route) {
+			defer d.wg.Done()
+			r.work(d.stop)
+		}(r)
+	}
+	d.wg.Add(1)
+	go func() {
+		defer d.wg.Done()
+		d.tick()
+	}()
+	return d
+}
+
+// Len reports how many notifiers are active.
+func (d *Dispatcher) Len() int {
+	return len(d.routes)
+}
+
+// Dispatch routes an alert to every notifier that accepts its level. It never blocks.
+func (d *Dispatcher) Dispatch(alert Alert) {
+	if alert.Time.IsZero() {
+		alert.Time = time.Now()
+	}
+	for _, r := range d.routes {
+		if r.levels[alert.Level] {
+			r.add(alert)
+		}
+	}
+}
+
+// Stop flushes held-back summaries and pending batches, then waits for delivery to
+// finish. Alerts dispatched afterwards are dropped.
+func (d *Dispatcher) Stop() {
+	d.once.Do(func() {
+		// The final flush queues everything before the workers are told to drain.
+		for _, r := range d.routes {
+			r.flush(time.Now(), true)
+		}
+		close(d.stop)
+		d.wg.Wait()
+	})
+}
+
+func (d *Dispatcher) tick() {
+	ticker := time.NewTicker(tickInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case now := <-ticker.C:
+			for _, r := range d.routes {
+				r.flush(now, false)
+			}
+		case <-d.stop:
+			return
+		}
+	}
+}
+
+func (r *route) add(alert Alert) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.stopped {
+		return
+	}
+
+	if r.dedupWindow > 0 {
+		key := alert.Level + "|" + alert.IP
+		if entry, ok := r.seen[key]; ok && alert.Time.Before(entry.until) {
+			entry.suppressed++
+			entry.last = alert
+			return
+		}
+		r.seen[key] = &dedupEntry{until: alert.Time.Add(r.dedupWindow)}
+	}
+	r.enqueueLocked(Event{Alert: alert, Count: 1})
+}
+
+// enqueueLocked sends the event now, or holds it for the aggregation window.
+func (r *route) enqueueLocked(event Event) {
+	if r.aggregateWindow <= 0 {
+		r.submit([]Event{event})
+		return
+	}
+	if len(r.pending) == 0 {
+		r.pendingSince = time.Now()
+	}
+	r.pending = append(r.pending, event)
+}
+
+func (r *route) submit(events []Event) {
+	select {
+	case r.queue <- events:
+	default:
+		log.Printf("[WARN] 通知キューが満杯のため %d件のアラートを破棄しました (%s)", len(events), r.notifier.Name())
+	}
+}
+
+// flush emits summaries for closed dedup windows and sends aggregated batches that are due.
+// With force set, everything still held is sent.
+func (r *route) flush(now time.Time, force bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.stopped {
+		return
+	}
+
+	for key, entry := range r.seen {
+		if !force && now.Before(entry.until) {
+			continue
+		}
+		if entry.suppressed > 0 {
+			r.enqueueLocked(Event{Alert: entry.last, Count: entry.suppressed, Suppressed: true})
+		}
+		delete(r.seen, key)
+	}
+
+	if len(r.pending) > 0 && (force || now.Sub(r.pendingSince) >= r.aggregateWindow) {
+		r.submit(r.pending)
+		r.pending = nil
+	}
+	if force {
+		r.stopped = true
+	}
+}
+
+// work delivers queued batches until stop, then sends what is left in the queue.
+func (r *route) work(stop <-chan struct{}) {
+	for {
+		select {
+		case events := <-r.queue:
+			r.sendWithRetry(events)
+		case <-stop:
+			for {
+				select {
+				case events := <-r.queue:
+					r.sendWithRetry(events)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+// sendWithRetry retries failed deliveries with exponential backoff.
+func (r *route) sendWithRetry(events []Event) {
+	delay := r.retryDelay
+	for attempt := 0; ; attempt++ {
+		ctx, cancel := context.WithTimeout(context.Background(), sendTimeout)
+		err := r.notifier.Send(ctx, events)
+		cancel()
+		if err == nil {
+			return
+		}
+		if attempt >= r.maxRetries {
+			log.Printf("[ERROR] 通知の送信に失敗しました (%s, %d回試行): %v", r.notifier.Name(), attempt+1, err)
+			return
+		}
+		log.Printf("[WARN] 通知の送信に失敗しました。%s後に再試行します (%s): %v", delay, r.notifier.Name(), err)
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
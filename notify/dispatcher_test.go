@@ -0,0 +1,142 @@
+// 2025 TabDock: darui3018823 All rights reserved.
+// All works created by darui3018823 associated with this repository are the intellectual property of darui3018823.
+// Packages and other third-party materials used in this repository are subject to their respective licenses and copyrights.
+
+package notify
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+)
+
+// webhookEvents decodes the events of every generic webhook request received so far.
+func webhookEvents(t *testing.T, rec *recorder) [][]Event {
+	t.Helper()
+	var batches [][]Event
+	for _, req := range rec.all() {
+		var payload struct {
+			Events []Event `json:"events"`
+		}
+		if err := json.Unmarshal(req.body, &payload); err != nil {
+			t.Fatal(err)
+		}
+		batches = append(batches, payload.Events)
+	}
+	return batches
+}
+
+// retryRoute returns a route that posts to rec and retries without a real backoff.
+func retryRoute(t *testing.T, rec *recorder, maxRetries int) *route {
+	t.Helper()
+	notifier, err := New(Config{Type: TypeWebhook, URL: rec.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &route{notifier: notifier, maxRetries: maxRetries, retryDelay: time.Millisecond}
+}
+
+func TestSendWithRetryRecovers(t *testing.T) {
+	rec := newRecorder(t, http.StatusInternalServerError, http.StatusServiceUnavailable)
+	retryRoute(t, rec, 2).sendWithRetry([]Event{{Alert: testAlert("warn", "203.0.113.7"), Count: 1}})
+
+	if got := len(rec.all()); got != 3 {
+		t.Fatalf("got %d attempts, want 3", got)
+	}
+}
+
+func TestSendWithRetryGivesUp(t *testing.T) {
+	rec := newRecorder(t, http.StatusInternalServerError, http.StatusInternalServerError, http.StatusInternalServerError, http.StatusInternalServerError)
+	retryRoute(t, rec, 1).sendWithRetry([]Event{{Alert: testAlert("warn", "203.0.113.7"), Count: 1}})
+
+	if got := len(rec.all()); got != 2 {
+		t.Fatalf("got %d attempts, want 2 (one try and one retry)", got)
+	}
+}
+
+func TestDispatcherDeduplicates(t *testing.T) {
+	rec := newRecorder(t)
+	d := NewDispatcher([]Config{{Type: TypeWebhook, URL: rec.URL, DedupWindowSec: 60}})
+	for i := 0; i < 3; i++ {
+		d.Dispatch(testAlert("warn", "203.0.113.7"))
+	}
+	d.Dispatch(testAlert("warn", "198.51.100.1"))
+	// Another level for the same IP is tracked separately.
+	d.Dispatch(testAlert("attack", "203.0.113.7"))
+	d.Stop()
+
+	batches := webhookEvents(t, rec)
+	if len(batches) != 4 {
+		t.Fatalf("got %d messages, want 3 immediate alerts and one summary: %+v", len(batches), batches)
+	}
+	for _, batch := range batches[:3] {
+		if len(batch) != 1 || batch[0].Count != 1 || batch[0].Suppressed {
+			t.Fatalf("unexpected immediate alert %+v", batch)
+		}
+	}
+	summary := batches[3]
+	if len(summary) != 1 || !summary[0].Suppressed || summary[0].Count != 2 || summary[0].IP != "203.0.113.7" {
+		t.Fatalf("unexpected summary %+v", summary)
+	}
+}
+
+func TestDispatcherAggregates(t *testing.T) {
+	rec := newRecorder(t)
+	d := NewDispatcher([]Config{{Type: TypeWebhook, URL: rec.URL, DedupWindowSec: -1, AggregateWindowSec: 60}})
+	for i := 0; i < 3; i++ {
+		d.Dispatch(testAlert("block", "203.0.113.7"))
+	}
+	d.Stop()
+
+	batches := webhookEvents(t, rec)
+	if len(batches) != 1 || len(batches[0]) != 3 {
+		t.Fatalf("got %+v, want one batch of 3 events", batches)
+	}
+}
+
+func TestDispatcherFiltersLevels(t *testing.T) {
+	defaults, attackOnly := newRecorder(t), newRecorder(t)
+	d := NewDispatcher([]Config{
+		{Type: TypeWebhook, URL: defaults.URL, DedupWindowSec: -1},
+		{Type: TypeWebhook, URL: attackOnly.URL, DedupWindowSec: -1, Levels: []string{" Attack "}},
+	})
+	d.Dispatch(testAlert("info", "203.0.113.7"))
+	d.Dispatch(testAlert("warn", "203.0.113.7"))
+	d.Dispatch(testAlert("attack", "203.0.113.7"))
+	d.Stop()
+
+	if got := len(defaults.all()); got != 2 {
+		t.Fatalf("default levels received %d alerts, want warn and attack", got)
+	}
+	batches := webhookEvents(t, attackOnly)
+	if len(batches) != 1 || batches[0][0].Level != "attack" {
+		t.Fatalf("attack-only notifier received %+v", batches)
+	}
+}
+
+func TestDispatchAfterStopIsDropped(t *testing.T) {
+	rec := newRecorder(t)
+	d := NewDispatcher([]Config{{Type: TypeWebhook, URL: rec.URL}})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				d.Dispatch(testAlert("warn", "203.0.113.7"))
+			}
+		}()
+	}
+	d.Stop()
+	wg.Wait()
+
+	delivered := len(rec.all())
+	d.Dispatch(testAlert("warn", "198.51.100.1"))
+	d.Stop()
+	if got := len(rec.all()); got != delivered {
+		t.Fatalf("alert dispatched after Stop was delivered (%d -> %d requests)", delivered, got)
+	}
+}
@@ -0,0 +1,142 @@
+// 2025 TabDock: darui3018823 All rights reserved.
+// All works created by darui3018823 associated with this repository are the intellectual property of darui3018823.
+// Packages and other third-party materials used in this repository are subject to their respective licenses and copyrights.
+
+package notify
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Alert is one event worth telling an administrator about: a security event, or a
+// status alert rule changing state when Status is set.
+type Alert struct {
+	Level       string       `json:"level"`
+	IP          string       `json:"ip,omitempty"`
+	Method      string       `json:"method,omitempty"`
+	Path        string       `json:"path,omitempty"`
+	UserAgent   string       `json:"userAgent,omitempty"`
+	RequestHash string       `json:"requestHash,omitempty"`
+	Score       int          `json:"score"`
+	Time        time.Time    `json:"time"`
+	Status      *StatusAlert `json:"status,omitempty"`
+}
+
+// Status alert states.
+const (
+	StateFiring   = "firing"
+	StateResolved = "resolved"
+)
+
+// StatusAlert describes a status alert rule that started or stopped firing on a host.
+type StatusAlert struct {
+	RuleID    string             `json:"ruleId"`
+	Rule      string             `json:"rule"`
+	Host      string             `json:"host"`
+	State     string             `json:"state"`
+	Condition string             `json:"condition"`
+	Values    map[string]float64 `json:"values"`
+	Since     time.Time          `json:"since"`
+}
+
+// Event is an alert as delivered to a notifier. Suppressed events summarise Count
+// duplicates of Alert that were held back by the deduplication window.
+type Event struct {
+	Alert
+	Count      int  `json:"count"`
+	Suppressed bool `json:"suppressed,omitempty"`
+}
+
+// Notifier delivers a batch of events to one channel.
+type Notifier interface {
+	Name() string
+	Send(ctx context.Context, events []Event) error
+}
+
+// levelColor is the Discord embed color used per level.
+func levelColor(level string) int {
+	switch level {
+	case "attack":
+		return 0xFF0000
+	case "block":
+		return 0x800080
+	case "error":
+		return 0xE74C3C
+	case "info":
+		return 0x2ECC71
+	default:
+		return 0xFFCC00
+	}
+}
+
+// highestLevel picks the most severe level in a batch, for titles and priorities.
+func highestLevel(events []Event) string {
+	rank := map[string]int{"info": 0, "warn": 1, "block": 2, "error": 2, "attack": 3}
+	best := ""
+	for _, event := range events {
+		if rank[event.Level] > rank[best] {
+			best = event.Level
+		}
+	}
+	if best == "" && len(events) > 0 {
+		best = events[0].Level
+	}
+	return best
+}
+
+// Title returns a short subject line for a batch.
+func Title(events []Event) string {
+	if len(events) == 1 && events[0].Status != nil {
+		status := events[0].Status
+		return fmt.Sprintf("[STATUS] %s %s (%s)", strings.ToUpper(status.State), status.Rule, status.Host)
+	}
+	if len(events) > 0 && events[0].Status != nil {
+		return fmt.Sprintf("[STATUS] ステータスアラート %d件 (最高レベル: %s)", len(events), strings.ToUpper(highestLevel(events)))
+	}
+	if len(events) == 1 && !events[0].Suppressed {
+		return fmt.Sprintf("[ALERT] %s アクセス検出", strings.ToUpper(events[0].Level))
+	}
+	total := 0
+	for _, event := range events {
+		total += event.Count
+	}
+	return fmt.Sprintf("[ALERT] セキュリティアラート %d件 (最高レベル: %s)", total, strings.ToUpper(highestLevel(events)))
+}
+
+// FormatLine renders one event as a single line of plain text.
+func FormatLine(event Event) string {
+	if status := event.Status; status != nil {
+		keys := make([]string, 0, len(status.Values))
+		for key := range status.Values {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		values := make([]string, 0, len(keys))
+		for _, key := range keys {
+			values = append(values, fmt.Sprintf("%s=%.1f", key, status.Values[key]))
+		}
+		return fmt.Sprintf("%s %s Host=%s Condition=%s Values=%s Since=%s",
+			strings.ToUpper(status.State), status.Rule, status.Host, status.Condition,
+			strings.Join(values, ","), status.Since.Format("2006/01/02 15:04:05"))
+	}
+	line := fmt.Sprintf("%s %s %s IP=%s Score=%d UA=%s Hash=%s Time=%s",
+		strings.ToUpper(event.Level), event.Method, event.Path, event.IP, event.Score,
+		event.UserAgent, event.RequestHash, event.Time.Format("2006/01/02 15:04:05"))
+	if event.Suppressed {
+		line += fmt.Sprintf(" (同じIP・レベルのアラート %d件を抑制しました)", event.Count)
+	}
+	return line
+}
+
+// Body renders a batch as plain text, one event per line.
+func Body(events []Event) string {
+	lines := make([]string, 0, len(events))
+	for _, event := range events {
+		lines = append(lines, FormatLine(event))
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,67 @@
+// 2025 TabDock: darui3018823 All rights reserved.
+// All works created by darui3018823 associated with this repository are the intellectual property of darui3018823.
+// Packages and other third-party materials used in this repository are subject to their respective licenses and copyrights.
+
+package notify
+
+import (
+	"context"
+	"fmt"
+	"mime"
+	"net"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SMTPNotifier emails each batch to a fixed list of recipients.
+type SMTPNotifier struct {
+	name     string
+	host     string
+	port     int
+	username string
+	password string
+	from     string
+	to       []string
+}
+
+// Name returns the configured notifier name.
+func (n *SMTPNotifier) Name() string { return n.name }
+
+// Send delivers the batch as a plain-text email. Authentication is only attempted when
+// a username is configured; net/smtp refuses PLAIN auth without TLS except on localhost.
+func (n *SMTPNotifier) Send(ctx context.Context, events []Event) error {
+	var auth smtp.Auth
+	if n.username != "" {
+		auth = smtp.PlainAuth("", n.username, n.password, n.host)
+	}
+
+	headers := []string{
+		"From: " + n.from,
+		"To: " + strings.Join(n.to, ", "),
+		"Subject: " + mime.QEncoding.Encode("utf-8", Title(events)),
+		"Date: " + time.Now().Format(time.RFC1123Z),
+		"MIME-Version: 1.0",
+		"Content-Type: text/plain; charset=utf-8",
+		"Content-Transfer-Encoding: 8bit",
+	}
+	body := strings.ReplaceAll(Body(events), "\n", "\r\n")
+	message := []byte(strings.Join(headers, "\r\n") + "\r\n\r\n" + body + "\r\n")
+
+	addr := net.JoinHostPort(n.host, strconv.Itoa(n.port))
+	done := make(chan error, 1)
+	go func() {
+		done <- smtp.SendMail(addr, auth, n.from, n.to, message)
+	}()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			return fmt.Errorf("SMTP送信エラー: %w", err)
+		}
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,216 @@
+// 2025 TabDock: darui3018823 All rights reserved.
+// All works created by darui3018823 associated with this repository are the intellectual property of darui3018823.
+// Packages and other third-party materials used in this repository are subject to their respective licenses and copyrights.
+
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxDiscordEmbeds is Discord's limit on embeds per message.
+const maxDiscordEmbeds = 10
+
+// postJSON sends payload to url and treats any non-2xx status as an error.
+func postJSON(ctx context.Context, client *http.Client, url string, payload interface{}, headers map[string]string) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	return post(ctx, client, url, "application/json", body, headers)
+}
+
+func post(ctx context.Context, client *http.Client, url, contentType string, body []byte, headers map[string]string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", contentType)
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if closeErr := resp.Body.Close(); closeErr != nil {
+			log.Printf("[WARN] 通知レスポンスのクローズに失敗しました: %v", closeErr)
+		}
+	}()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		snippet, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("HTTP %d: %s", resp.StatusCode, strings.TrimSpace(string(snippet)))
+	}
+	return nil
+}
+
+// DiscordNotifier posts embeds to a Discord webhook.
+type DiscordNotifier struct {
+	name   string
+	url    string
+	client *http.Client
+}
+
+// Name returns the configured notifier name.
+func (n *DiscordNotifier) Name() string { return n.name }
+
+// Send posts one embed per event, or a single summary embed for larger batches.
+func (n *DiscordNotifier) Send(ctx context.Context, events []Event) error {
+	var embeds []map[string]interface{}
+	if len(events) <= maxDiscordEmbeds {
+		for _, event := range events {
+			if event.Status != nil {
+				embeds = append(embeds, map[string]interface{}{
+					"title":       Title([]Event{event}),
+					"description": "```" + FormatLine(event) + "```",
+					"color":       levelColor(event.Level),
+					"timestamp":   event.Time.Format(time.RFC3339),
+				})
+				continue
+			}
+			description := fmt.Sprintf("```%s %s %s\nUA: %s\nIP: %s\nTime: %s\nHash: %s```",
+				strings.ToUpper(event.Level), event.Method, event.Path, event.UserAgent, event.IP,
+				event.Time.Format("2006/01/02 15:04:05"), event.RequestHash)
+			title := Title([]Event{event})
+			if event.Suppressed {
+				title = fmt.Sprintf("[ALERT] %s 同一IPのアラート %d件を抑制", strings.ToUpper(event.Level), event.Count)
+			}
+			embeds = append(embeds, map[string]interface{}{
+				"title":       title,
+				"description": description,
+				"color":       levelColor(event.Level),
+				"fields": []map[string]interface{}{
+					{"name": "Current IP Score", "value": strconv.Itoa(event.Score), "inline": true},
+					{"name": "Request Hash", "value": event.RequestHash, "inline": true},
+				},
+				"timestamp": event.Time.Format(time.RFC3339),
+			})
+		}
+	} else {
+		body := Body(events)
+		// Discord rejects descriptions over 4096 characters.
+		if len(body) > 4000 {
+			body = body[:4000] + "\n..."
+		}
+		embeds = append(embeds, map[string]interface{}{
+			"title":       Title(events),
+			"description": "```" + body + "```",
+			"color":       levelColor(highestLevel(events)),
+			"timestamp":   time.Now().Format(time.RFC3339),
+		})
+	}
+	return postJSON(ctx, n.client, n.url, map[string]interface{}{"embeds": embeds}, nil)
+}
+
+// SlackNotifier posts plain text to a Slack incoming webhook.
+type SlackNotifier struct {
+	name   string
+	url    string
+	client *http.Client
+}
+
+// Name returns the configured notifier name.
+func (n *SlackNotifier) Name() string { return n.name }
+
+// Send posts the batch as a single message.
+func (n *SlackNotifier) Send(ctx context.Context, events []Event) error {
+	text := fmt.Sprintf("*%s*\n```%s```", Title(events), Body(events))
+	return postJSON(ctx, n.client, n.url, map[string]interface{}{"text": text}, nil)
+}
+
+// WebhookNotifier posts the raw events as JSON to any HTTP endpoint.
+type WebhookNotifier struct {
+	name    string
+	url     string
+	headers map[string]string
+	client  *http.Client
+}
+
+// Name returns the configured notifier name.
+func (n *WebhookNotifier) Name() string { return n.name }
+
+// Send posts {"title", "count", "events"}.
+func (n *WebhookNotifier) Send(ctx context.Context, events []Event) error {
+	payload := map[string]interface{}{
+		"title":  Title(events),
+		"count":  len(events),
+		"events": events,
+	}
+	return postJSON(ctx, n.client, n.url, payload, n.headers)
+}
+
+// NtfyNotifier publishes to an ntfy topic URL.
+type NtfyNotifier struct {
+	name   string
+	url    string
+	token  string
+	client *http.Client
+}
+
+// Name returns the configured notifier name.
+func (n *NtfyNotifier) Name() string { return n.name }
+
+// Send publishes the batch as one message; attacks, blocks and errors are sent with high priority.
+func (n *NtfyNotifier) Send(ctx context.Context, events []Event) error {
+	priority, tags := "default", "warning"
+	switch highestLevel(events) {
+	case "attack":
+		priority = "urgent"
+	case "block", "error":
+		priority = "high"
+	case "info":
+		priority, tags = "low", "white_check_mark"
+	}
+	headers := map[string]string{
+		"Title":    Title(events),
+		"Priority": priority,
+		"Tags":     tags,
+	}
+	if n.token != "" {
+		headers["Authorization"] = "Bearer " + n.token
+	}
+	return post(ctx, n.client, n.url, "text/plain; charset=utf-8", []byte(Body(events)), headers)
+}
+
+// GotifyNotifier sends to a Gotify server's /message endpoint.
+type GotifyNotifier struct {
+	name   string
+	url    string
+	token  string
+	client *http.Client
+}
+
+// Name returns the configured notifier name.
+func (n *GotifyNotifier) Name() string { return n.name }
+
+// Send posts the batch as one message with a priority derived from its highest level.
+func (n *GotifyNotifier) Send(ctx context.Context, events []Event) error {
+	priority := 4
+	switch highestLevel(events) {
+	case "attack":
+		priority = 8
+	case "block", "error":
+		priority = 6
+	case "info":
+		priority = 2
+	}
+	payload := map[string]interface{}{
+		"title":    Title(events),
+		"message":  Body(events),
+		"priority": priority,
+	}
+	url := strings.TrimRight(n.url, "/") + "/message"
+	return postJSON(ctx, n.client, url, payload, map[string]string{"X-Gotify-Key": n.token})
+}
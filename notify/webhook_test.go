@@ -0,0 +1,212 @@
+// 2025 TabDock: darui3018823 All rights reserved.
+// All works created by darui3018823 associated with this repository are the intellectual property of darui3018823.
+// Packages and other third-party materials used in this repository are subject to their respective licenses and copyrights.
+
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// capturedRequest is one request received by a recorder.
+type capturedRequest struct {
+	path   string
+	header http.Header
+	body   []byte
+}
+
+// recorder is a local HTTP stub that records every request and answers with the
+// queued status codes, then 204.
+type recorder struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	requests []capturedRequest
+	statuses []int
+}
+
+func newRecorder(t *testing.T, statuses ...int) *recorder {
+	t.Helper()
+	rec := &recorder{statuses: statuses}
+	rec.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		rec.mu.Lock()
+		rec.requests = append(rec.requests, capturedRequest{path: r.URL.Path, header: r.Header.Clone(), body: body})
+		status := http.StatusNoContent
+		if len(rec.statuses) > 0 {
+			status, rec.statuses = rec.statuses[0], rec.statuses[1:]
+		}
+		rec.mu.Unlock()
+		if r.Method != http.MethodPost {
+			status = http.StatusMethodNotAllowed
+		}
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(rec.Close)
+	return rec
+}
+
+func (rec *recorder) all() []capturedRequest {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	return append([]capturedRequest(nil), rec.requests...)
+}
+
+// only returns the single request received so far.
+func (rec *recorder) only(t *testing.T) capturedRequest {
+	t.Helper()
+	requests := rec.all()
+	if len(requests) != 1 {
+		t.Fatalf("got %d requests, want 1", len(requests))
+	}
+	return requests[0]
+}
+
+func decodeJSON(t *testing.T, body []byte) map[string]interface{} {
+	t.Helper()
+	var payload map[string]interface{}
+	if err := json.Unmarshal(body, &payload); err != nil {
+		t.Fatalf("invalid JSON %q: %v", body, err)
+	}
+	return payload
+}
+
+func testAlert(level, ip string) Alert {
+	return Alert{
+		Level:       level,
+		IP:          ip,
+		Method:      http.MethodGet,
+		Path:        "/wp-login.php",
+		UserAgent:   "curl/8.0",
+		RequestHash: "abc123",
+		Score:       42,
+		Time:        time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC),
+	}
+}
+
+func sendOne(t *testing.T, c Config, events ...Event) {
+	t.Helper()
+	notifier, err := New(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := notifier.Send(context.Background(), events); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+}
+
+func TestDiscordPayload(t *testing.T) {
+	rec := newRecorder(t)
+	sendOne(t, Config{Type: TypeDiscord, URL: rec.URL}, Event{Alert: testAlert("attack", "203.0.113.7"), Count: 1})
+
+	req := rec.only(t)
+	embeds, _ := decodeJSON(t, req.body)["embeds"].([]interface{})
+	if len(embeds) != 1 {
+		t.Fatalf("got %d embeds, want 1", len(embeds))
+	}
+	embed := embeds[0].(map[string]interface{})
+	if embed["title"] != "[ALERT] ATTACK アクセス検出" || embed["color"] != float64(0xFF0000) {
+		t.Fatalf("unexpected embed %v", embed)
+	}
+	if description, _ := embed["description"].(string); !strings.Contains(description, "IP: 203.0.113.7") {
+		t.Fatalf("description %q does not name the IP", description)
+	}
+}
+
+func TestDiscordSummarisesLargeBatches(t *testing.T) {
+	rec := newRecorder(t)
+	events := make([]Event, maxDiscordEmbeds+1)
+	for i := range events {
+		events[i] = Event{Alert: testAlert("warn", "203.0.113.7"), Count: 1}
+	}
+	sendOne(t, Config{Type: TypeDiscord, URL: rec.URL}, events...)
+
+	embeds, _ := decodeJSON(t, rec.only(t).body)["embeds"].([]interface{})
+	if len(embeds) != 1 {
+		t.Fatalf("got %d embeds, want one summary", len(embeds))
+	}
+}
+
+func TestWebhookPayload(t *testing.T) {
+	rec := newRecorder(t)
+	sendOne(t, Config{Type: TypeWebhook, URL: rec.URL, Headers: map[string]string{"X-Secret": "s3"}},
+		Event{Alert: testAlert("block", "203.0.113.7"), Count: 3, Suppressed: true})
+
+	req := rec.only(t)
+	if req.header.Get("X-Secret") != "s3" || req.header.Get("Content-Type") != "application/json" {
+		t.Fatalf("unexpected headers %v", req.header)
+	}
+	var payload struct {
+		Title  string  `json:"title"`
+		Count  int     `json:"count"`
+		Events []Event `json:"events"`
+	}
+	if err := json.Unmarshal(req.body, &payload); err != nil {
+		t.Fatal(err)
+	}
+	if payload.Count != 1 || len(payload.Events) != 1 {
+		t.Fatalf("unexpected payload %+v", payload)
+	}
+	event := payload.Events[0]
+	if event.IP != "203.0.113.7" || event.Count != 3 || !event.Suppressed || event.Score != 42 {
+		t.Fatalf("unexpected event %+v", event)
+	}
+}
+
+func TestSlackPayload(t *testing.T) {
+	rec := newRecorder(t)
+	sendOne(t, Config{Type: TypeSlack, URL: rec.URL}, Event{Alert: testAlert("warn", "203.0.113.7"), Count: 1})
+
+	text, _ := decodeJSON(t, rec.only(t).body)["text"].(string)
+	if !strings.HasPrefix(text, "*[ALERT] WARN アクセス検出*") || !strings.Contains(text, "IP=203.0.113.7") {
+		t.Fatalf("unexpected text %q", text)
+	}
+}
+
+func TestNtfyPayload(t *testing.T) {
+	rec := newRecorder(t)
+	sendOne(t, Config{Type: TypeNtfy, URL: rec.URL + "/alerts", Token: "tk"},
+		Event{Alert: testAlert("warn", "203.0.113.7"), Count: 1},
+		Event{Alert: testAlert("attack", "198.51.100.1"), Count: 1})
+
+	req := rec.only(t)
+	if req.path != "/alerts" || req.header.Get("Priority") != "urgent" || req.header.Get("Authorization") != "Bearer tk" {
+		t.Fatalf("unexpected request %s %v", req.path, req.header)
+	}
+	if lines := strings.Split(string(req.body), "\n"); len(lines) != 2 {
+		t.Fatalf("body has %d lines, want one per event: %q", len(lines), req.body)
+	}
+}
+
+func TestGotifyPayload(t *testing.T) {
+	rec := newRecorder(t)
+	sendOne(t, Config{Type: TypeGotify, URL: rec.URL + "/", Token: "app-token"}, Event{Alert: testAlert("block", "203.0.113.7"), Count: 1})
+
+	req := rec.only(t)
+	if req.path != "/message" || req.header.Get("X-Gotify-Key") != "app-token" {
+		t.Fatalf("unexpected request %s %v", req.path, req.header)
+	}
+	if priority := decodeJSON(t, req.body)["priority"]; priority != float64(6) {
+		t.Fatalf("priority = %v, want 6", priority)
+	}
+}
+
+func TestSendReportsHTTPErrors(t *testing.T) {
+	rec := newRecorder(t, http.StatusBadGateway)
+	notifier, err := New(Config{Type: TypeWebhook, URL: rec.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = notifier.Send(context.Background(), []Event{{Alert: testAlert("warn", "203.0.113.7"), Count: 1}})
+	if err == nil || !strings.Contains(err.Error(), "HTTP 502") {
+		t.Fatalf("Send() = %v, want an HTTP 502 error", err)
+	}
+}
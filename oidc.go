@@ -0,0 +1,489 @@
+// 2025 TabDock: darui3018823 All rights reserved.
+// All works created by darui3018823 associated with this repository are the intellectual property of darui3018823.
+// Packages and other third-party materials used in this repository are subject to their respective licenses and copyrights.
+
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"github.com/google/uuid"
+	"golang.org/x/oauth2"
+)
+
+const (
+	oidcStateCookieName = "tabdock_oidc_state"
+	oidcStateTTL        = 10 * time.Minute
+	oidcHTTPTimeout     = 15 * time.Second
+	oidcLoginRedirect   = "/home/?auth=oidc"
+	oidcErrorRedirect   = "/home/?auth=oidc_error&reason="
+)
+
+// oidcSettings is the OpenID Connect client configuration read from the environment.
+type oidcSettings struct {
+	enabled       bool
+	issuer        string
+	clientID      string
+	clientSecret  string
+	redirectURL   string
+	scopes        []string
+	providerName  string
+	autoProvision bool
+}
+
+// oidcPendingLogin is the server-side half of an authorization request.
+type oidcPendingLogin struct {
+	verifier  string
+	nonce     string
+	expiresAt time.Time
+}
+
+// oidcClaims are the ID token claims used to find or create the local account.
+type oidcClaims struct {
+	Subject           string `json:"sub"`
+	Email             string `json:"email"`
+	EmailVerified     *bool  `json:"email_verified"`
+	PreferredUsername string `json:"preferred_username"`
+	Name              string `json:"name"`
+}
+
+var (
+	oidcOnce sync.Once
+	oidcCfg  oidcSettings
+
+	// The provider is discovered lazily so that an unreachable IdP at startup
+	// does not keep the rest of the server from running.
+	oidcProvider      *oidc.Provider
+	oidcProviderMutex sync.Mutex
+
+	oidcPendingLogins = map[string]*oidcPendingLogin{}
+	oidcPendingMutex  sync.Mutex
+
+	oidcUsernameSanitizer = regexp.MustCompile(`[^A-Za-z0-9_.\-]+`)
+
+	errOIDCNoAccount = errors.New("no linked account")
+)
+
+func getOIDCSettings() oidcSettings {
+	oidcOnce.Do(func() {
+		scopes := []string{oidc.ScopeOpenID}
+		for _, scope := range strings.FieldsFunc(getEnv("OIDC_SCOPES", "profile email"), func(r rune) bool {
+			return r == ',' || r == ' '
+		}) {
+			if scope != oidc.ScopeOpenID {
+				scopes = append(scopes, scope)
+			}
+		}
+
+		oidcCfg = oidcSettings{
+			issuer:        strings.TrimRight(strings.TrimSpace(getEnv("OIDC_ISSUER", "")), "/"),
+			clientID:      strings.TrimSpace(getEnv("OIDC_CLIENT_ID", "")),
+			clientSecret:  strings.TrimSpace(getEnv("OIDC_CLIENT_SECRET", "")),
+			redirectURL:   strings.TrimSpace(getEnv("OIDC_REDIRECT_URL", "")),
+			scopes:        scopes,
+			providerName:  strings.TrimSpace(getEnv("OIDC_PROVIDER_NAME", "SSO")),
+			autoProvision: isTrueEnv(getEnv("OIDC_AUTO_PROVISION", "false")),
+		}
+		oidcCfg.enabled = oidcCfg.issuer != "" && oidcCfg.clientID != "" && oidcCfg.redirectURL != ""
+		if oidcCfg.enabled {
+			log.Printf("[INFO] OIDCログインを有効化しました: issuer=%s client_id=%s", oidcCfg.issuer, oidcCfg.clientID)
+		}
+	})
+	return oidcCfg
+}
+
+func isTrueEnv(value string) bool {
+	switch strings.ToLower(strings.TrimSpace(value)) {
+	case "true", "1", "yes", "on":
+		return true
+	default:
+		return false
+	}
+}
+
+func getOIDCProvider(ctx context.Context, cfg oidcSettings) (*oidc.Provider, error) {
+	oidcProviderMutex.Lock()
+	defer oidcProviderMutex.Unlock()
+
+	if oidcProvider != nil {
+		return oidcProvider, nil
+	}
+	provider, err := oidc.NewProvider(ctx, cfg.issuer)
+	if err != nil {
+		return nil, err
+	}
+	oidcProvider = provider
+	return provider, nil
+}
+
+func oidcOAuthConfig(provider *oidc.Provider, cfg oidcSettings) *oauth2.Config {
+	return &oauth2.Config{
+		ClientID:     cfg.clientID,
+		ClientSecret: cfg.clientSecret,
+		RedirectURL:  cfg.redirectURL,
+		Endpoint:     provider.Endpoint(),
+		Scopes:       cfg.scopes,
+	}
+}
+
+func oidcRandomString() (string, error) {
+	raw, err := randomBytes(32)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(raw), nil
+}
+
+// takeOIDCPendingLogin removes and returns the pending login for state.
+// Each state can be used only once.
+func takeOIDCPendingLogin(state string) (*oidcPendingLogin, bool) {
+	oidcPendingMutex.Lock()
+	defer oidcPendingMutex.Unlock()
+
+	pending, ok := oidcPendingLogins[state]
+	if !ok {
+		return nil, false
+	}
+	delete(oidcPendingLogins, state)
+	if time.Now().After(pending.expiresAt) {
+		return nil, false
+	}
+	return pending, true
+}
+
+// cleanupOIDCPendingLogins drops authorization requests that were never completed.
+func cleanupOIDCPendingLogins() {
+	now := time.Now()
+	oidcPendingMutex.Lock()
+	defer oidcPendingMutex.Unlock()
+
+	for state, pending := range oidcPendingLogins {
+		if now.After(pending.expiresAt) {
+			delete(oidcPendingLogins, state)
+		}
+	}
+}
+
+// handleOIDCConfig tells the login form whether SSO is available.
+func handleOIDCConfig(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	cfg := getOIDCSettings()
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		keySuccess:     true,
+		"enabled":      cfg.enabled,
+		"providerName": cfg.providerName,
+		"loginUrl":     "/api/auth/oidc/login",
+	})
+}
+
+// handleOIDCLogin starts the authorization code flow with PKCE.
+func handleOIDCLogin(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	cfg := getOIDCSettings()
+	if !cfg.enabled {
+		http.Error(w, "OIDCログインは無効です", http.StatusNotFound)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), oidcHTTPTimeout)
+	defer cancel()
+	provider, err := getOIDCProvider(ctx, cfg)
+	if err != nil {
+		log.Printf("[ERROR] OIDCプロバイダーの検出に失敗しました (%s): %v", cfg.issuer, err)
+		http.Redirect(w, r, oidcErrorRedirect+"provider", http.StatusFound)
+		return
+	}
+
+	state, err := oidcRandomString()
+	if err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	nonce, err := oidcRandomString()
+	if err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	verifier := oauth2.GenerateVerifier()
+
+	oidcPendingMutex.Lock()
+	oidcPendingLogins[state] = &oidcPendingLogin{
+		verifier:  verifier,
+		nonce:     nonce,
+		expiresAt: time.Now().Add(oidcStateTTL),
+	}
+	oidcPendingMutex.Unlock()
+
+	// Binding the state to this browser prevents a login CSRF where an attacker
+	// completes their own authorization in the victim's browser.
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcStateCookieName,
+		Value:    state,
+		Path:     "/api/auth/oidc/",
+		MaxAge:   int(oidcStateTTL.Seconds()),
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	authURL := oidcOAuthConfig(provider, cfg).AuthCodeURL(state, oidc.Nonce(nonce), oauth2.S256ChallengeOption(verifier))
+	http.Redirect(w, r, authURL, http.StatusFound)
+}
+
+// handleOIDCCallback completes the flow, links or provisions the account and
+// issues the normal session and restore token.
+func handleOIDCCallback(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	cfg := getOIDCSettings()
+	if !cfg.enabled {
+		http.Error(w, "OIDCログインは無効です", http.StatusNotFound)
+		return
+	}
+
+	ip := getIPAddress(r)
+	fail := func(reason string, format string, args ...interface{}) {
+		log.Printf("[WARN] OIDCログイン失敗 (IP=%s): "+format, append([]interface{}{ip}, args...)...)
+		http.Redirect(w, r, oidcErrorRedirect+url.QueryEscape(reason), http.StatusFound)
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcStateCookieName,
+		Value:    "",
+		Path:     "/api/auth/oidc/",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	query := r.URL.Query()
+	if idpErr := query.Get("error"); idpErr != "" {
+		fail("denied", "IdPがエラーを返しました: %s %s", idpErr, query.Get("error_description"))
+		return
+	}
+
+	state := query.Get("state")
+	stateCookie, err := r.Cookie(oidcStateCookieName)
+	if state == "" || err != nil || stateCookie.Value != state {
+		fail("state", "stateが一致しません")
+		return
+	}
+	pending, ok := takeOIDCPendingLogin(state)
+	if !ok {
+		fail("state", "stateが無効または期限切れです")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), oidcHTTPTimeout)
+	defer cancel()
+	provider, err := getOIDCProvider(ctx, cfg)
+	if err != nil {
+		fail("provider", "プロバイダーの検出に失敗しました: %v", err)
+		return
+	}
+
+	token, err := oidcOAuthConfig(provider, cfg).Exchange(ctx, query.Get("code"), oauth2.VerifierOption(pending.verifier))
+	if err != nil {
+		fail("exchange", "トークン交換に失敗しました: %v", err)
+		return
+	}
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok || rawIDToken == "" {
+		fail("token", "id_tokenがありません")
+		return
+	}
+	idToken, err := provider.Verifier(&oidc.Config{ClientID: cfg.clientID}).Verify(ctx, rawIDToken)
+	if err != nil {
+		fail("token", "id_tokenの検証に失敗しました: %v", err)
+		return
+	}
+	if idToken.Nonce != pending.nonce {
+		fail("token", "nonceが一致しません")
+		return
+	}
+
+	var claims oidcClaims
+	if err := idToken.Claims(&claims); err != nil {
+		fail("token", "クレームの読み取りに失敗しました: %v", err)
+		return
+	}
+	claims.Subject = idToken.Subject
+
+	username, err := resolveOIDCUser(cfg, idToken.Issuer, claims)
+	if err != nil {
+		if errors.Is(err, errOIDCNoAccount) {
+			fail("no_account", "対応するアカウントがありません: sub=%s email=%s", claims.Subject, claims.Email)
+			return
+		}
+		log.Printf("[ERROR] OIDCユーザー解決エラー: %v", err)
+		http.Redirect(w, r, oidcErrorRedirect+"server", http.StatusFound)
+		return
+	}
+	if isUserDisabled(username) {
+		fail("disabled", "無効化されたアカウントです: %s", username)
+		return
+	}
+
+	if _, err := issueSignInSession(w, r, username); err != nil {
+		log.Printf("[ERROR] セッション発行失敗: %v", err)
+		http.Redirect(w, r, oidcErrorRedirect+"server", http.StatusFound)
+		return
+	}
+	recordLoginSuccess(username, ip)
+
+	log.Printf("[INFO] OIDCログイン成功: ユーザー=%s issuer=%s sub=%s IP=%s", username, idToken.Issuer, claims.Subject, ip)
+	http.Redirect(w, r, oidcLoginRedirect, http.StatusFound)
+}
+
+// resolveOIDCUser finds the local account for the ID token. Accounts already linked
+// by issuer and subject win; otherwise an unlinked non-admin account with the same
+// verified email is linked, and failing that a new account is provisioned while
+// registration is open.
+func resolveOIDCUser(cfg oidcSettings, issuer string, claims oidcClaims) (string, error) {
+	if db == nil {
+		return "", fmt.Errorf("データベース接続がありません")
+	}
+
+	var username string
+	err := db.QueryRow(`SELECT username FROM users WHERE oidc_issuer = ? AND oidc_subject = ?`, issuer, claims.Subject).Scan(&username)
+	if err == nil {
+		return username, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return "", err
+	}
+
+	email := strings.TrimSpace(claims.Email)
+	if email != "" && claims.EmailVerified != nil && *claims.EmailVerified {
+		// Admins are never linked by email alone, so an IdP account cannot take one over.
+		err = db.QueryRow(`SELECT username FROM users WHERE lower(email) = lower(?) AND COALESCE(oidc_subject, '') = '' AND COALESCE(role, '') != ?`,
+			email, roleAdmin).Scan(&username)
+		if err == nil {
+			if _, err := db.Exec(`UPDATE users SET oidc_issuer = ?, oidc_subject = ?, updated_at = CURRENT_TIMESTAMP WHERE username = ?`,
+				issuer, claims.Subject, username); err != nil {
+				return "", err
+			}
+			log.Printf("[INFO] OIDCアカウントを既存ユーザーに連携しました: ユーザー=%s sub=%s", username, claims.Subject)
+			return username, nil
+		}
+		if !errors.Is(err, sql.ErrNoRows) {
+			return "", err
+		}
+	}
+
+	if !cfg.autoProvision || !isRegistrationOpen() {
+		return "", errOIDCNoAccount
+	}
+	return provisionOIDCUser(issuer, claims)
+}
+
+// provisionOIDCUser creates a passwordless account for a first-time SSO user.
+func provisionOIDCUser(issuer string, claims oidcClaims) (string, error) {
+	base := oidcUsernameSanitizer.ReplaceAllString(claims.PreferredUsername, "")
+	if base == "" {
+		local, _, _ := strings.Cut(claims.Email, "@")
+		base = oidcUsernameSanitizer.ReplaceAllString(local, "")
+	}
+	if base == "" {
+		base = "user"
+	}
+	if len(base) > 32 {
+		base = base[:32]
+	}
+
+	username := base
+	for i := 2; ; i++ {
+		var count int
+		if err := db.QueryRow(`SELECT COUNT(*) FROM users WHERE username = ?`, username).Scan(&count); err != nil {
+			return "", err
+		}
+		if count == 0 {
+			break
+		}
+		if i > 100 {
+			return "", fmt.Errorf("ユーザー名を決定できません: %s", base)
+		}
+		username = fmt.Sprintf("%s%d", base, i)
+	}
+
+	displayName := strings.TrimSpace(claims.Name)
+	if displayName == "" {
+		displayName = username
+	}
+	_, err := db.Exec(`INSERT INTO users (id, username, display_name, email, oidc_issuer, oidc_subject, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)`,
+		uuid.New().String(), username, displayName, strings.TrimSpace(claims.Email), issuer, claims.Subject)
+	if err != nil {
+		return "", err
+	}
+
+	log.Printf("新規ユーザー登録 (OIDC): %s (%s)", username, claims.Email)
+	return username, nil
+}
+
+// handleOIDCSession returns the logged-in user and a fresh restore token after the
+// callback redirect, so the page can store them the same way as a password login.
+// The session cookie set by the callback is kept as is.
+func handleOIDCSession(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	username, err := getUsernameFromSession(r)
+	if err != nil {
+		writeAuthResponse(w, http.StatusUnauthorized, AuthResponse{
+			Success: false,
+			Message: "セッションが無効です",
+		})
+		return
+	}
+	user, err := getUserByUsername(username)
+	if err != nil {
+		writeAuthResponse(w, http.StatusUnauthorized, AuthResponse{
+			Success: false,
+			Message: "ユーザーが見つかりません",
+		})
+		return
+	}
+
+	restoreToken, err := issueRestoreToken(w, r, user.Username)
+	if err != nil {
+		log.Printf("[ERROR] セッション発行失敗: %v", err)
+		http.Error(w, "認証セッションの作成に失敗しました", http.StatusInternalServerError)
+		return
+	}
+
+	writeAuthResponse(w, http.StatusOK, AuthResponse{
+		Success: true,
+		Message: "ログイン成功",
+		User: map[string]interface{}{
+			keyUsername:     user.Username,
+			keyEmail:        user.Email,
+			keyProfileImage: user.ProfileImage,
+			keyLoginAt:      time.Now().Unix(),
+		},
+		RestoreToken: restoreToken,
+	})
+}
@@ -0,0 +1,420 @@
+// 2025 TabDock: darui3018823 All rights reserved.
+// All works created by darui3018823 associated with this repository are the intellectual property of darui3018823.
+// Packages and other third-party materials used in this repository are subject to their respective licenses and copyrights.
+
+package main
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const testOIDCClientID = "tabdock-test"
+
+func TestMain(m *testing.M) {
+	dir, err := os.MkdirTemp("", "tabdock-test")
+	if err != nil {
+		panic(err)
+	}
+	dbPath = filepath.Join(dir, "acc.db")
+	if err := initDB(); err != nil {
+		panic(err)
+	}
+	code := m.Run()
+	db.Close()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+// fakeIdP is a minimal OpenID provider: discovery, JWKS and a token endpoint that
+// enforces PKCE. Authorization codes are issued directly by the test.
+type fakeIdP struct {
+	*httptest.Server
+	key *rsa.PrivateKey
+
+	mu    sync.Mutex
+	codes map[string]fakeGrant
+}
+
+// fakeGrant is what the IdP remembers about one authorization code.
+type fakeGrant struct {
+	challenge string
+	nonce     string
+	claims    map[string]interface{}
+}
+
+// newFakeIdP starts a provider and points the OIDC client configuration at it.
+func newFakeIdP(t *testing.T, autoProvision bool) *fakeIdP {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	idp := &fakeIdP{key: key, codes: map[string]fakeGrant{}}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"issuer":                                idp.URL,
+			"authorization_endpoint":                idp.URL + "/authorize",
+			"token_endpoint":                        idp.URL + "/token",
+			"jwks_uri":                              idp.URL + "/jwks",
+			"id_token_signing_alg_values_supported": []string{"RS256"},
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"keys": []map[string]string{{
+				"kty": "RSA",
+				"kid": "test",
+				"alg": "RS256",
+				"use": "sig",
+				"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			}},
+		})
+	})
+	mux.HandleFunc("/token", idp.handleToken)
+	idp.Server = httptest.NewServer(mux)
+	t.Cleanup(idp.Close)
+
+	oidcOnce.Do(func() {})
+	oidcCfg = oidcSettings{
+		enabled:       true,
+		issuer:        idp.URL,
+		clientID:      testOIDCClientID,
+		clientSecret:  "secret",
+		redirectURL:   "https://tabdock.example/api/auth/oidc/callback",
+		scopes:        []string{"openid", "profile", "email"},
+		providerName:  "Test",
+		autoProvision: autoProvision,
+	}
+	oidcProvider = nil
+	t.Cleanup(func() {
+		oidcCfg = oidcSettings{}
+		oidcProvider = nil
+	})
+	return idp
+}
+
+func (idp *fakeIdP) handleToken(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	clientID, _, ok := r.BasicAuth()
+	if !ok {
+		clientID = r.PostForm.Get("client_id")
+	}
+
+	idp.mu.Lock()
+	grant, found := idp.codes[r.PostForm.Get("code")]
+	delete(idp.codes, r.PostForm.Get("code"))
+	idp.mu.Unlock()
+
+	sum := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+	if !found || clientID != testOIDCClientID || base64.RawURLEncoding.EncodeToString(sum[:]) != grant.challenge {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant"})
+		return
+	}
+
+	claims := map[string]interface{}{
+		"iss":   idp.URL,
+		"aud":   testOIDCClientID,
+		"iat":   time.Now().Unix(),
+		"exp":   time.Now().Add(time.Minute).Unix(),
+		"nonce": grant.nonce,
+	}
+	for k, v := range grant.claims {
+		claims[k] = v
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"access_token": "access",
+		"token_type":   "Bearer",
+		"expires_in":   60,
+		"id_token":     idp.sign(claims),
+	})
+}
+
+func (idp *fakeIdP) sign(claims map[string]interface{}) string {
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "kid": "test", "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+	signingInput := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signingInput))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, idp.key, crypto.SHA256, digest[:])
+	if err != nil {
+		panic(err)
+	}
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+// authorize issues a code for an authorization request, as the IdP would after the
+// user signs in. The nonce is taken from the request unless one is given.
+func (idp *fakeIdP) authorize(params url.Values, nonce string, claims map[string]interface{}) string {
+	if nonce == "" {
+		nonce = params.Get("nonce")
+	}
+	code := uuid.NewString()
+	idp.mu.Lock()
+	idp.codes[code] = fakeGrant{challenge: params.Get("code_challenge"), nonce: nonce, claims: claims}
+	idp.mu.Unlock()
+	return code
+}
+
+// startOIDCLogin runs the login endpoint and returns the authorization request
+// parameters and the state cookie it set.
+func startOIDCLogin(t *testing.T) (url.Values, *http.Cookie) {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	handleOIDCLogin(rec, httptest.NewRequest(http.MethodGet, "/api/auth/oidc/login", nil))
+	if rec.Code != http.StatusFound {
+		t.Fatalf("login status = %d, want 302", rec.Code)
+	}
+	location, err := url.Parse(rec.Header().Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, cookie := range rec.Result().Cookies() {
+		if cookie.Name == oidcStateCookieName {
+			return location.Query(), cookie
+		}
+	}
+	t.Fatal("login did not set the state cookie")
+	return nil, nil
+}
+
+// finishOIDCLogin calls the callback and returns the response.
+func finishOIDCLogin(state, code string, cookie *http.Cookie) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, "/api/auth/oidc/callback?"+url.Values{"state": {state}, "code": {code}}.Encode(), nil)
+	if cookie != nil {
+		req.AddCookie(cookie)
+	}
+	rec := httptest.NewRecorder()
+	handleOIDCCallback(rec, req)
+	return rec
+}
+
+// assertOIDCRedirect checks that the callback ended on location.
+func assertOIDCRedirect(t *testing.T, rec *httptest.ResponseRecorder, location string) {
+	t.Helper()
+	if rec.Code != http.StatusFound || rec.Header().Get("Location") != location {
+		t.Fatalf("callback = %d %q, want redirect to %q", rec.Code, rec.Header().Get("Location"), location)
+	}
+}
+
+func sessionUser(t *testing.T, rec *httptest.ResponseRecorder) string {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	for _, cookie := range rec.Result().Cookies() {
+		req.AddCookie(cookie)
+	}
+	username, err := getUsernameFromSession(req)
+	if err != nil {
+		t.Fatalf("callback did not start a session: %v", err)
+	}
+	return username
+}
+
+func insertTestUser(t *testing.T, username, email, role, issuer, subject string) {
+	t.Helper()
+	_, err := db.Exec(`INSERT INTO users (id, username, display_name, email, role, oidc_issuer, oidc_subject) VALUES (?, ?, ?, ?, ?, NULLIF(?, ''), NULLIF(?, ''))`,
+		uuid.NewString(), username, username, email, role, issuer, subject)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func linkedSubject(t *testing.T, username string) string {
+	t.Helper()
+	var subject string
+	if err := db.QueryRow(`SELECT COALESCE(oidc_subject, '') FROM users WHERE username = ?`, username).Scan(&subject); err != nil {
+		t.Fatal(err)
+	}
+	return subject
+}
+
+func verifiedClaims(sub, email string) map[string]interface{} {
+	return map[string]interface{}{"sub": sub, "email": email, "email_verified": true}
+}
+
+func TestOIDCLoginFlow(t *testing.T) {
+	idp := newFakeIdP(t, false)
+	insertTestUser(t, "flowuser", "flow@example.com", roleUser, "", "")
+
+	params, cookie := startOIDCLogin(t)
+	if params.Get("state") == "" || params.Get("state") != cookie.Value {
+		t.Fatalf("state %q is not bound to the cookie %q", params.Get("state"), cookie.Value)
+	}
+	if params.Get("nonce") == "" || params.Get("code_challenge") == "" || params.Get("code_challenge_method") != "S256" {
+		t.Fatalf("authorization request lacks nonce or S256 PKCE: %v", params)
+	}
+
+	code := idp.authorize(params, "", verifiedClaims("sub-flow", "Flow@example.com"))
+	rec := finishOIDCLogin(params.Get("state"), code, cookie)
+	assertOIDCRedirect(t, rec, oidcLoginRedirect)
+	if got := sessionUser(t, rec); got != "flowuser" {
+		t.Fatalf("session user = %q, want flowuser", got)
+	}
+
+	// The state is single use.
+	code = idp.authorize(params, "", verifiedClaims("sub-flow", "flow@example.com"))
+	assertOIDCRedirect(t, finishOIDCLogin(params.Get("state"), code, cookie), oidcErrorRedirect+"state")
+}
+
+func TestOIDCCallbackRejectsStateMismatch(t *testing.T) {
+	idp := newFakeIdP(t, false)
+	insertTestUser(t, "stateuser", "state@example.com", roleUser, "", "")
+	params, cookie := startOIDCLogin(t)
+	other, _ := startOIDCLogin(t)
+	code := idp.authorize(params, "", verifiedClaims("sub-state", "state@example.com"))
+
+	// A state from another browser's login is refused even though it is pending.
+	assertOIDCRedirect(t, finishOIDCLogin(other.Get("state"), code, cookie), oidcErrorRedirect+"state")
+	assertOIDCRedirect(t, finishOIDCLogin(params.Get("state"), code, nil), oidcErrorRedirect+"state")
+	forged := &http.Cookie{Name: oidcStateCookieName, Value: "forged"}
+	assertOIDCRedirect(t, finishOIDCLogin("forged", code, forged), oidcErrorRedirect+"state")
+}
+
+func TestOIDCCallbackRejectsNonceMismatch(t *testing.T) {
+	idp := newFakeIdP(t, false)
+	insertTestUser(t, "nonceuser", "nonce@example.com", roleUser, "", "")
+	params, cookie := startOIDCLogin(t)
+
+	code := idp.authorize(params, "replayed-nonce", verifiedClaims("sub-nonce", "nonce@example.com"))
+	assertOIDCRedirect(t, finishOIDCLogin(params.Get("state"), code, cookie), oidcErrorRedirect+"token")
+	if linkedSubject(t, "nonceuser") != "" {
+		t.Fatal("account was linked from a rejected ID token")
+	}
+}
+
+func TestOIDCCallbackEnforcesPKCE(t *testing.T) {
+	idp := newFakeIdP(t, false)
+	insertTestUser(t, "pkceuser", "pkce@example.com", roleUser, "", "")
+	params, cookie := startOIDCLogin(t)
+	other, _ := startOIDCLogin(t)
+
+	// A code issued for another authorization request cannot be redeemed with this
+	// request's verifier.
+	code := idp.authorize(other, params.Get("nonce"), verifiedClaims("sub-pkce", "pkce@example.com"))
+	assertOIDCRedirect(t, finishOIDCLogin(params.Get("state"), code, cookie), oidcErrorRedirect+"exchange")
+}
+
+func TestOIDCCallbackRejectsDisabledAccounts(t *testing.T) {
+	idp := newFakeIdP(t, false)
+	insertTestUser(t, "disableduser", "disabled@example.com", roleUser, "", "")
+	if _, err := db.Exec(`UPDATE users SET disabled = 1 WHERE username = ?`, "disableduser"); err != nil {
+		t.Fatal(err)
+	}
+	params, cookie := startOIDCLogin(t)
+
+	code := idp.authorize(params, "", verifiedClaims("sub-disabled", "disabled@example.com"))
+	rec := finishOIDCLogin(params.Get("state"), code, cookie)
+	assertOIDCRedirect(t, rec, oidcErrorRedirect+"disabled")
+	for _, c := range rec.Result().Cookies() {
+		if c.Name == sessionCookieName {
+			t.Fatal("disabled account received a session")
+		}
+	}
+}
+
+func TestResolveOIDCUserLinking(t *testing.T) {
+	const issuer = "https://idp.example"
+	unverified := false
+	insertTestUser(t, "linked", "shared@example.com", roleUser, issuer, "sub-linked")
+	insertTestUser(t, "byemail", "byemail@example.com", roleUser, "", "")
+	insertTestUser(t, "otherlink", "otherlink@example.com", roleUser, issuer, "sub-other")
+	insertTestUser(t, "adminuser", "admin@example.com", roleAdmin, "", "")
+	insertTestUser(t, "unverified", "unverified@example.com", roleUser, "", "")
+
+	verified := func(sub, email string) oidcClaims {
+		yes := true
+		return oidcClaims{Subject: sub, Email: email, EmailVerified: &yes}
+	}
+	tests := []struct {
+		name   string
+		claims oidcClaims
+		want   string
+	}{
+		{"issuer and subject win over email", verified("sub-linked", "byemail@example.com"), "linked"},
+		{"verified email links an unlinked account", verified("sub-byemail", "BYEMAIL@example.com"), "byemail"},
+		{"account linked to another subject", verified("sub-new", "otherlink@example.com"), ""},
+		{"admin is never linked by email", verified("sub-admin", "admin@example.com"), ""},
+		{"unverified email", oidcClaims{Subject: "sub-unverified", Email: "unverified@example.com", EmailVerified: &unverified}, ""},
+		{"missing email_verified", oidcClaims{Subject: "sub-unverified", Email: "unverified@example.com"}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := resolveOIDCUser(oidcSettings{}, issuer, tt.claims)
+			if tt.want == "" {
+				if !errors.Is(err, errOIDCNoAccount) {
+					t.Fatalf("resolveOIDCUser() = %q, %v, want errOIDCNoAccount", got, err)
+				}
+				return
+			}
+			if err != nil || got != tt.want {
+				t.Fatalf("resolveOIDCUser() = %q, %v, want %q", got, err, tt.want)
+			}
+		})
+	}
+
+	if linkedSubject(t, "byemail") != "sub-byemail" {
+		t.Fatal("email match did not store the subject")
+	}
+	if linkedSubject(t, "adminuser") != "" || linkedSubject(t, "otherlink") != "sub-other" {
+		t.Fatal("a refused account was linked")
+	}
+	// A different issuer with the same subject is a different identity.
+	if _, err := resolveOIDCUser(oidcSettings{}, "https://other.example", oidcClaims{Subject: "sub-linked"}); !errors.Is(err, errOIDCNoAccount) {
+		t.Fatalf("subject from another issuer resolved: %v", err)
+	}
+}
+
+func TestResolveOIDCUserProvisioning(t *testing.T) {
+	const issuer = "https://idp.example"
+	insertTestUser(t, "newcomer", "", roleUser, "", "")
+	t.Cleanup(func() { db.Exec(`DELETE FROM app_settings WHERE key = ?`, settingRegistrationOpen) })
+	claims := oidcClaims{Subject: "sub-newcomer", Email: "newcomer@example.com", PreferredUsername: "new comer!"}
+
+	if _, err := resolveOIDCUser(oidcSettings{autoProvision: false}, issuer, claims); !errors.Is(err, errOIDCNoAccount) {
+		t.Fatalf("provisioned without OIDC_AUTO_PROVISION: %v", err)
+	}
+	if err := setAppSetting(settingRegistrationOpen, "false"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := resolveOIDCUser(oidcSettings{autoProvision: true}, issuer, claims); !errors.Is(err, errOIDCNoAccount) {
+		t.Fatalf("provisioned while registration is closed: %v", err)
+	}
+	if err := setAppSetting(settingRegistrationOpen, "true"); err != nil {
+		t.Fatal(err)
+	}
+	username, err := resolveOIDCUser(oidcSettings{autoProvision: true}, issuer, claims)
+	if err != nil || username != "newcomer2" {
+		t.Fatalf("resolveOIDCUser() = %q, %v, want newcomer2", username, err)
+	}
+	if role, err := getUserRole(username); err != nil || role != roleUser {
+		t.Fatalf("provisioned role = %q, %v", role, err)
+	}
+	if again, err := resolveOIDCUser(oidcSettings{autoProvision: true}, issuer, claims); err != nil || again != username {
+		t.Fatalf("second sign-in resolved to %q, %v", again, err)
+	}
+	if strings.Contains(username, " ") {
+		t.Fatalf("username %q was not sanitized", username)
+	}
+}
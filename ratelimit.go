@@ -0,0 +1,303 @@
+// 2025 TabDock: darui3018823 All rights reserved.
+// All works created by darui3018823 associated with this repository are the intellectual property of darui3018823.
+// Packages and other third-party materials used in this repository are subject to their respective licenses and copyrights.
+
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Rate limit keys: what a policy's buckets are counted per.
+const (
+	rateLimitKeyIP    = "ip"
+	rateLimitKeyUser  = "user"
+	rateLimitKeyToken = "token"
+
+	defaultRateLimitPolicy = "default"
+)
+
+// RateLimitPolicy is a token bucket applied to requests whose path matches Path.
+// Path is matched exactly, or as a prefix when it ends with "*".
+type RateLimitPolicy struct {
+	Name         string   `json:"name"`
+	Path         string   `json:"path"`
+	Methods      []string `json:"methods,omitempty"`
+	Burst        int      `json:"burst"`
+	RefillPerMin float64  `json:"refill_per_min"`
+	// Key is ip (default), user or token. Requests without a user or token fall back to the IP.
+	Key string `json:"key,omitempty"`
+	// Penalize raises the IP's score and blocks it when the limit is exceeded, like the global limit.
+	Penalize bool `json:"penalize,omitempty"`
+}
+
+func (p *RateLimitPolicy) matches(r *http.Request) bool {
+	if prefix, ok := strings.CutSuffix(p.Path, "*"); ok {
+		if !strings.HasPrefix(r.URL.Path, prefix) {
+			return false
+		}
+	} else if r.URL.Path != p.Path {
+		return false
+	}
+	if len(p.Methods) == 0 {
+		return true
+	}
+	for _, method := range p.Methods {
+		if r.Method == method {
+			return true
+		}
+	}
+	return false
+}
+
+// tokenBucket holds the remaining tokens of one policy for one key.
+type tokenBucket struct {
+	Mutex   sync.Mutex
+	Tokens  float64
+	Updated time.Time
+	Burst   float64
+	// Rate is the refill rate in tokens per second.
+	Rate float64
+}
+
+// refill adds the tokens earned since the last update, capped at the burst size.
+func (b *tokenBucket) refill(now time.Time) {
+	if elapsed := now.Sub(b.Updated).Seconds(); elapsed > 0 {
+		b.Tokens = math.Min(b.Burst, b.Tokens+elapsed*b.Rate)
+	}
+	b.Updated = now
+}
+
+// fullAt is when the bucket will have refilled to its burst size.
+func (b *tokenBucket) fullAt() time.Time {
+	missing := b.Burst - b.Tokens
+	return b.Updated.Add(time.Duration(missing / b.Rate * float64(time.Second)))
+}
+
+// rateLimitDecision is the outcome of checkRateLimit. Policy is nil when the request is exempt.
+type rateLimitDecision struct {
+	Allowed    bool
+	Policy     *RateLimitPolicy
+	Limit      int
+	Remaining  int
+	Reset      time.Duration
+	RetryAfter time.Duration
+}
+
+// defaultPolicy turns rate_limit_per_min into a bucket of the same size and refill rate.
+func defaultPolicy(cfg *SecurityConfig) *RateLimitPolicy {
+	return &RateLimitPolicy{
+		Name:         defaultRateLimitPolicy,
+		Path:         "*",
+		Burst:        cfg.RateLimitPerMin,
+		RefillPerMin: float64(cfg.RateLimitPerMin),
+		Key:          rateLimitKeyIP,
+		Penalize:     true,
+	}
+}
+
+// findRateLimitPolicy returns the first configured policy matching the request, or the
+// default policy. Static assets that match no policy are exempt and yield nil.
+func findRateLimitPolicy(r *http.Request) *RateLimitPolicy {
+	cfg := currentSecurityConfig()
+	for i := range cfg.RateLimitPolicies {
+		if cfg.RateLimitPolicies[i].matches(r) {
+			return &cfg.RateLimitPolicies[i]
+		}
+	}
+
+	lowerPath := strings.ToLower(r.URL.Path)
+	for _, ext := range rateLimitExemptExtensions {
+		if strings.HasSuffix(lowerPath, ext) {
+			return nil
+		}
+	}
+	return defaultPolicy(cfg)
+}
+
+// rateLimitIdentity picks what the policy counts against. A bearer token is only used
+// when it is valid, so random tokens cannot be used to get fresh buckets.
+func rateLimitIdentity(r *http.Request, ip, key string) string {
+	switch key {
+	case rateLimitKeyToken, rateLimitKeyUser:
+		if token := getBearerToken(r); token != "" {
+			if tokenID, username, found := lookupAPITokenOwner(token); found {
+				if key == rateLimitKeyToken {
+					return "token:" + tokenID
+				}
+				return "user:" + username
+			}
+			return "ip:" + ip
+		}
+		if key == rateLimitKeyUser {
+			if username, err := getUsernameFromSession(r); err == nil {
+				return "user:" + username
+			}
+		}
+	}
+	return "ip:" + ip
+}
+
+func checkRateLimit(r *http.Request, ip string) rateLimitDecision {
+	if isTrustedIP(ip) || isPrivateOrLoopback(ip) {
+		return rateLimitDecision{Allowed: true}
+	}
+
+	policy := findRateLimitPolicy(r)
+	if policy == nil {
+		return rateLimitDecision{Allowed: true}
+	}
+
+	now := securityNow()
+	burst := float64(policy.Burst)
+	rate := policy.RefillPerMin / 60
+	key := policy.Name + "|" + rateLimitIdentity(r, ip, policy.Key)
+
+	rateLimitMutex.Lock()
+	bucket, exists := rateLimitMap[key]
+	if !exists {
+		bucket = &tokenBucket{Tokens: burst, Updated: now}
+		rateLimitMap[key] = bucket
+	}
+	rateLimitMutex.Unlock()
+
+	bucket.Mutex.Lock()
+	defer bucket.Mutex.Unlock()
+
+	// Follow policy changes from a configuration reload.
+	bucket.Burst, bucket.Rate = burst, rate
+	bucket.refill(now)
+
+	decision := rateLimitDecision{Policy: policy, Limit: policy.Burst}
+	if bucket.Tokens >= 1 {
+		bucket.Tokens--
+		decision.Allowed = true
+	} else {
+		decision.RetryAfter = time.Duration((1 - bucket.Tokens) / rate * float64(time.Second))
+	}
+	decision.Remaining = int(bucket.Tokens)
+	decision.Reset = bucket.fullAt().Sub(now)
+	return decision
+}
+
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
+
+// writeRateLimitHeaders sets the RateLimit-* headers and, when rejected, Retry-After.
+func writeRateLimitHeaders(w http.ResponseWriter, decision rateLimitDecision) {
+	if decision.Policy == nil {
+		return
+	}
+	window := ceilSeconds(time.Duration(float64(decision.Policy.Burst) / decision.Policy.RefillPerMin * float64(time.Minute)))
+	w.Header().Set("RateLimit-Policy", fmt.Sprintf("%d;w=%d", decision.Limit, window))
+	w.Header().Set("RateLimit-Limit", strconv.Itoa(decision.Limit))
+	w.Header().Set("RateLimit-Remaining", strconv.Itoa(decision.Remaining))
+	w.Header().Set("RateLimit-Reset", strconv.Itoa(ceilSeconds(decision.Reset)))
+	if !decision.Allowed {
+		w.Header().Set("Retry-After", strconv.Itoa(max(1, ceilSeconds(decision.RetryAfter))))
+	}
+}
+
+// cleanupRateLimitBuckets drops buckets that have refilled completely, since a full
+// bucket behaves the same as one that was never created.
+func cleanupRateLimitBuckets(now time.Time) {
+	rateLimitMutex.Lock()
+	defer rateLimitMutex.Unlock()
+
+	for key, bucket := range rateLimitMap {
+		bucket.Mutex.Lock()
+		if !now.Before(bucket.fullAt()) {
+			delete(rateLimitMap, key)
+		}
+		bucket.Mutex.Unlock()
+	}
+}
+
+// RateLimitBucketState is a bucket as shown by the admin API.
+type RateLimitBucketState struct {
+	Policy    string  `json:"policy"`
+	Key       string  `json:"key"`
+	Remaining float64 `json:"remaining"`
+	Burst     float64 `json:"burst"`
+}
+
+// rateLimitBucketsFor returns every bucket whose key ends with the given identity.
+func rateLimitBucketsFor(identity string) []RateLimitBucketState {
+	now := time.Now()
+	states := []RateLimitBucketState{}
+
+	rateLimitMutex.RLock()
+	defer rateLimitMutex.RUnlock()
+	for key, bucket := range rateLimitMap {
+		policy, id, ok := strings.Cut(key, "|")
+		if !ok || id != identity {
+			continue
+		}
+		bucket.Mutex.Lock()
+		bucket.refill(now)
+		states = append(states, RateLimitBucketState{
+			Policy:    policy,
+			Key:       id,
+			Remaining: math.Floor(bucket.Tokens*100) / 100,
+			Burst:     bucket.Burst,
+		})
+		bucket.Mutex.Unlock()
+	}
+	return states
+}
+
+func validateRateLimitPolicies(policies []RateLimitPolicy) []string {
+	var problems []string
+	names := map[string]bool{}
+	for i, policy := range policies {
+		label := fmt.Sprintf("rate_limit_policies[%d]", i)
+		if policy.Name == "" {
+			problems = append(problems, label+".name が空です")
+		} else if names[policy.Name] || policy.Name == defaultRateLimitPolicy {
+			problems = append(problems, fmt.Sprintf("%s.name が重複しています: %q", label, policy.Name))
+		}
+		names[policy.Name] = true
+		if !strings.HasPrefix(policy.Path, "/") {
+			problems = append(problems, fmt.Sprintf("%s.path は / で始まる必要があります: %q", label, policy.Path))
+		}
+		if policy.Burst < 1 {
+			problems = append(problems, label+".burst は1以上が必要です")
+		}
+		if policy.RefillPerMin <= 0 {
+			problems = append(problems, label+".refill_per_min は0より大きい値が必要です")
+		}
+		switch policy.Key {
+		case rateLimitKeyIP, rateLimitKeyUser, rateLimitKeyToken:
+		default:
+			problems = append(problems, fmt.Sprintf("%s.key は ip / user / token のいずれかです: %q", label, policy.Key))
+		}
+		for _, method := range policy.Methods {
+			if !httpMethodToken.MatchString(method) {
+				problems = append(problems, fmt.Sprintf("%s.methods に不正な値があります: %q", label, method))
+			}
+		}
+	}
+	return problems
+}
+
+func normalizeRateLimitPolicies(policies []RateLimitPolicy) {
+	for i := range policies {
+		policy := &policies[i]
+		policy.Name = strings.TrimSpace(policy.Name)
+		policy.Path = strings.TrimSpace(policy.Path)
+		policy.Key = strings.ToLower(strings.TrimSpace(policy.Key))
+		if policy.Key == "" {
+			policy.Key = rateLimitKeyIP
+		}
+		for j, method := range policy.Methods {
+			policy.Methods[j] = strings.ToUpper(strings.TrimSpace(method))
+		}
+	}
+}
@@ -0,0 +1,525 @@
+// 2025 TabDock: darui3018823 All rights reserved.
+// All works created by darui3018823 associated with this repository are the intellectual property of darui3018823.
+// Packages and other third-party materials used in this repository are subject to their respective licenses and copyrights.
+
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ruleTrustedMethod labels the only stop outside the rule pipeline: a disallowed method
+// from a trusted or private address.
+const ruleTrustedMethod = "trusted_method"
+
+// securityTrace records what the rules did with one request.
+type securityTrace struct {
+	rule     string
+	level    string
+	outcome  string
+	blocked  string
+	scores   map[string]int
+	observed []string
+}
+
+// replayEntry is one recorded request, as written by logRequest.
+type replayEntry struct {
+	at    time.Time
+	entry LogEntry
+}
+
+type replayRuleStats struct {
+	Stops    int `json:"stops"`
+	Scored   int `json:"scored"`
+	Score    int `json:"score"`
+	Observed int `json:"observed"`
+}
+
+type replayIPStats struct {
+	IP         string    `json:"ip"`
+	Stopped    int       `json:"stopped"`
+	FirstStop  time.Time `json:"firstStop"`
+	Rules      []string  `json:"rules"`
+	BlockedBy  string    `json:"blockedBy,omitempty"`
+	FinalScore int       `json:"finalScore"`
+	seenRules  map[string]bool
+}
+
+// replayResult summarizes one pass over the recorded traffic.
+type replayResult struct {
+	Config     string                      `json:"config"`
+	Requests   int                         `json:"requests"`
+	Allowed    int                         `json:"allowed"`
+	Stopped    int                         `json:"stopped"`
+	Skipped    int                         `json:"skipped"`
+	Rules      map[string]*replayRuleStats `json:"rules"`
+	BlockedIPs []*replayIPStats            `json:"blockedIPs"`
+
+	ips      map[string]*replayIPStats
+	verdicts []string
+}
+
+type replayChange struct {
+	Time   time.Time `json:"time"`
+	IP     string    `json:"ip"`
+	Method string    `json:"method"`
+	Path   string    `json:"path"`
+	Before string    `json:"before"`
+	After  string    `json:"after"`
+}
+
+type replayDiff struct {
+	Changed     int            `json:"changed"`
+	Samples     []replayChange `json:"samples"`
+	OnlyBlocked []string       `json:"onlyBlockedByBase"`
+	NewBlocked  []string       `json:"onlyBlockedByCompare"`
+}
+
+type replayOptions struct {
+	cloudflare bool
+	top        int
+}
+
+// runReplay implements "tabdock replay": it feeds recorded requests through
+// evaluateSecurity with the clock set to each request's timestamp and reports the
+// result, optionally against a second configuration.
+func runReplay(args []string) int {
+	fs := flag.NewFlagSet("replay", flag.ContinueOnError)
+	configPath := fs.String("config", securityConfigPath, "security configuration to evaluate")
+	comparePath := fs.String("compare", "", "second security configuration to compare against -config")
+	trustedPath := fs.String("trusted", trustedIPsFile, "trusted IP list")
+	geoipPath := fs.String("geoip", "./geoip/GeoLite2-Country.mmdb", "GeoIP database for blocked_countries")
+	cloudflare := fs.Bool("cloudflare", false, "treat every recorded request as having come through Cloudflare")
+	asJSON := fs.Bool("json", false, "print the report as JSON")
+	verbose := fs.Bool("v", false, "show the pipeline's own log output")
+	top := fs.Int("top", 20, "number of IPs and changed requests to list")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: tabdock replay [flags] <log file|directory|->...")
+		fmt.Fprintln(fs.Output(), "Replays security log entries (log/security/*.log[.gz]) through the security rules.")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return 2
+	}
+
+	inputs, err := expandReplayInputs(fs.Args())
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "replay:", err)
+		return 1
+	}
+	// -compare reads the traffic three times, which standard input cannot do.
+	if *comparePath != "" {
+		for _, input := range inputs {
+			if input == "-" {
+				fmt.Fprintln(os.Stderr, "replay: standard input cannot be used with -compare")
+				return 2
+			}
+		}
+	}
+	if err := loadTrustedIPs(*trustedPath); err != nil {
+		fmt.Fprintln(os.Stderr, "replay: failed to load trusted IPs:", err)
+		return 1
+	}
+	if err := loadGeoIPDatabase(*geoipPath); err != nil {
+		fmt.Fprintln(os.Stderr, "replay: GeoIP database not loaded, blocked_countries is ignored:", err)
+	}
+
+	// Replay must not write request logs or scores anywhere.
+	securityLogEnabled = false
+	if !*verbose {
+		log.SetOutput(io.Discard)
+	}
+	defer log.SetOutput(os.Stderr)
+
+	opts := replayOptions{cloudflare: *cloudflare, top: *top}
+	base, err := replayTraffic(*configPath, inputs, opts)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "replay:", err)
+		return 1
+	}
+	var compare *replayResult
+	if *comparePath != "" {
+		if compare, err = replayTraffic(*comparePath, inputs, opts); err != nil {
+			fmt.Fprintln(os.Stderr, "replay:", err)
+			return 1
+		}
+	}
+
+	if *asJSON {
+		report := map[string]interface{}{"base": base}
+		if compare != nil {
+			report["compare"] = compare
+			report["diff"] = diffReplayResults(base, compare, inputs, opts.top)
+		}
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(report); err != nil {
+			fmt.Fprintln(os.Stderr, "replay:", err)
+			return 1
+		}
+		return 0
+	}
+
+	printReplayResult(base, opts.top)
+	if compare != nil {
+		fmt.Println()
+		printReplayResult(compare, opts.top)
+		fmt.Println()
+		printReplayDiff(base, compare, diffReplayResults(base, compare, inputs, opts.top))
+	}
+	return 0
+}
+
+// expandReplayInputs turns directories into their daily log files, oldest first.
+func expandReplayInputs(args []string) ([]string, error) {
+	var inputs []string
+	for _, arg := range args {
+		info, err := os.Stat(arg)
+		if arg == "-" || (err == nil && !info.IsDir()) {
+			inputs = append(inputs, arg)
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		var files []string
+		for _, pattern := range []string{"*.log", "*.log.gz"} {
+			matches, err := filepath.Glob(filepath.Join(arg, pattern))
+			if err != nil {
+				return nil, err
+			}
+			files = append(files, matches...)
+		}
+		sort.Strings(files)
+		inputs = append(inputs, files...)
+	}
+	if len(inputs) == 0 {
+		return nil, fmt.Errorf("no log files found")
+	}
+	return inputs, nil
+}
+
+// readReplayEntries calls fn for every entry in the inputs, in file order.
+func readReplayEntries(inputs []string, fn func(replayEntry)) (skipped int, err error) {
+	for _, input := range inputs {
+		var reader io.Reader
+		var closers []io.Closer
+		if input == "-" {
+			reader = os.Stdin
+		} else {
+			f, err := os.Open(input)
+			if err != nil {
+				return skipped, err
+			}
+			closers = append(closers, f)
+			reader = f
+			if strings.HasSuffix(input, ".gz") {
+				gz, err := gzip.NewReader(f)
+				if err != nil {
+					_ = f.Close()
+					return skipped, fmt.Errorf("%s: %w", input, err)
+				}
+				closers = append([]io.Closer{gz}, closers...)
+				reader = gz
+			}
+		}
+
+		scanner := bufio.NewScanner(reader)
+		scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+		for scanner.Scan() {
+			var entry LogEntry
+			if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil || entry.IP == "" {
+				skipped++
+				continue
+			}
+			at, err := time.Parse(time.RFC3339, entry.Timestamp)
+			if err != nil {
+				skipped++
+				continue
+			}
+			fn(replayEntry{at: at, entry: entry})
+		}
+		scanErr := scanner.Err()
+		for _, closer := range closers {
+			_ = closer.Close()
+		}
+		if scanErr != nil {
+			return skipped, fmt.Errorf("%s: %w", input, scanErr)
+		}
+	}
+	return skipped, nil
+}
+
+// resetSecurityState forgets every score, block, grace period and bucket so each
+// configuration starts from the same empty state.
+func resetSecurityState() {
+	ipScoresMutex.Lock()
+	ipScores = map[string]int{}
+	ipScoresMutex.Unlock()
+	ipScoreResetMutex.Lock()
+	ipScoreLastReset = map[string]time.Time{}
+	ipScoreResetMutex.Unlock()
+	firstAccessIPsMutex.Lock()
+	firstAccessIPs = map[string]time.Time{}
+	firstAccessIPsMutex.Unlock()
+	dynamicBlockMutex.Lock()
+	dynamicBlockMap = map[string]dynamicBlock{}
+	dynamicBlockMutex.Unlock()
+	rateLimitMutex.Lock()
+	rateLimitMap = map[string]*tokenBucket{}
+	rateLimitMutex.Unlock()
+	ipEventsMutex.Lock()
+	ipEvents = map[string][]ipEvent{}
+	ipEventsMutex.Unlock()
+	resetMonitorState()
+}
+
+// replayTraffic evaluates every recorded request against the configuration at path.
+func replayTraffic(path string, inputs []string, opts replayOptions) (*replayResult, error) {
+	state, err := readSecurityState(path)
+	if err != nil {
+		return nil, err
+	}
+	activeSecurity.Store(state)
+	resetSecurityState()
+
+	var clock time.Time
+	securityNow = func() time.Time { return clock }
+	defer func() { securityNow = time.Now }()
+
+	result := &replayResult{
+		Config: path,
+		Rules:  map[string]*replayRuleStats{},
+		ips:    map[string]*replayIPStats{},
+	}
+	ruleStats := func(rule string) *replayRuleStats {
+		stats, ok := result.Rules[rule]
+		if !ok {
+			stats = &replayRuleStats{}
+			result.Rules[rule] = stats
+		}
+		return stats
+	}
+
+	skipped, err := readReplayEntries(inputs, func(item replayEntry) {
+		// Keep the clock monotonic when files overlap.
+		if item.at.After(clock) {
+			clock = item.at
+		}
+		e := item.entry
+		r, err := http.NewRequest(e.Method, "http://replay.invalid/", nil)
+		if err != nil {
+			result.Skipped++
+			result.verdicts = append(result.verdicts, "")
+			return
+		}
+		r.URL.Path = e.Path
+		r.Header.Set("User-Agent", e.UserAgent)
+		if opts.cloudflare {
+			r.RemoteAddr = net.JoinHostPort(cloudflareCIDRs[0].IP.String(), "443")
+			r.Header.Set("CF-Connecting-IP", e.IP)
+		}
+
+		trace := &securityTrace{scores: map[string]int{}}
+		allowed := evaluateSecurity(httptest.NewRecorder(), r, e.IP, trace)
+		result.Requests++
+
+		for rule, score := range trace.scores {
+			stats := ruleStats(rule)
+			stats.Scored++
+			stats.Score += score
+		}
+		for _, rule := range trace.observed {
+			ruleStats(rule).Observed++
+		}
+		if allowed {
+			result.Allowed++
+			result.verdicts = append(result.verdicts, "")
+			return
+		}
+
+		rule := trace.rule
+		if rule == "" {
+			rule = ruleTrustedMethod
+		}
+		result.Stopped++
+		result.verdicts = append(result.verdicts, rule)
+		ruleStats(rule).Stops++
+
+		ip, ok := result.ips[e.IP]
+		if !ok {
+			ip = &replayIPStats{IP: e.IP, FirstStop: item.at, seenRules: map[string]bool{}}
+			result.ips[e.IP] = ip
+		}
+		ip.Stopped++
+		if !ip.seenRules[rule] {
+			ip.seenRules[rule] = true
+			ip.Rules = append(ip.Rules, rule)
+		}
+		if ip.BlockedBy == "" && trace.blocked != "" {
+			ip.BlockedBy = trace.blocked
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	result.Skipped += skipped
+
+	ipScoresMutex.RLock()
+	for _, ip := range result.ips {
+		ip.FinalScore = ipScores[ip.IP]
+		result.BlockedIPs = append(result.BlockedIPs, ip)
+	}
+	ipScoresMutex.RUnlock()
+	sort.Slice(result.BlockedIPs, func(i, j int) bool {
+		if result.BlockedIPs[i].Stopped != result.BlockedIPs[j].Stopped {
+			return result.BlockedIPs[i].Stopped > result.BlockedIPs[j].Stopped
+		}
+		return result.BlockedIPs[i].IP < result.BlockedIPs[j].IP
+	})
+	return result, nil
+}
+
+// diffReplayResults compares the verdict of every request and the set of stopped IPs.
+func diffReplayResults(base, compare *replayResult, inputs []string, top int) replayDiff {
+	diff := replayDiff{Samples: []replayChange{}, OnlyBlocked: []string{}, NewBlocked: []string{}}
+	i := 0
+	_, _ = readReplayEntries(inputs, func(item replayEntry) {
+		defer func() { i++ }()
+		if i >= len(base.verdicts) || i >= len(compare.verdicts) {
+			return
+		}
+		before, after := base.verdicts[i], compare.verdicts[i]
+		if before == after {
+			return
+		}
+		diff.Changed++
+		if len(diff.Samples) < top {
+			diff.Samples = append(diff.Samples, replayChange{
+				Time:   item.at,
+				IP:     item.entry.IP,
+				Method: item.entry.Method,
+				Path:   item.entry.Path,
+				Before: verdictLabel(before),
+				After:  verdictLabel(after),
+			})
+		}
+	})
+
+	for ip := range base.ips {
+		if _, ok := compare.ips[ip]; !ok {
+			diff.OnlyBlocked = append(diff.OnlyBlocked, ip)
+		}
+	}
+	for ip := range compare.ips {
+		if _, ok := base.ips[ip]; !ok {
+			diff.NewBlocked = append(diff.NewBlocked, ip)
+		}
+	}
+	sort.Strings(diff.OnlyBlocked)
+	sort.Strings(diff.NewBlocked)
+	return diff
+}
+
+func verdictLabel(rule string) string {
+	if rule == "" {
+		return "allowed"
+	}
+	return rule
+}
+
+func sortedReplayRules(results ...*replayResult) []string {
+	seen := map[string]bool{}
+	var rules []string
+	for _, result := range results {
+		for rule := range result.Rules {
+			if !seen[rule] {
+				seen[rule] = true
+				rules = append(rules, rule)
+			}
+		}
+	}
+	sort.Strings(rules)
+	return rules
+}
+
+func printReplayResult(result *replayResult, top int) {
+	fmt.Printf("== %s\n", result.Config)
+	fmt.Printf("requests: %d  allowed: %d  stopped: %d  skipped: %d\n\n", result.Requests, result.Allowed, result.Stopped, result.Skipped)
+
+	fmt.Printf("%-20s %8s %8s %8s %9s\n", "rule", "stops", "scored", "score", "observed")
+	for _, rule := range sortedReplayRules(result) {
+		stats := result.Rules[rule]
+		fmt.Printf("%-20s %8d %8d %8d %9d\n", rule, stats.Stops, stats.Scored, stats.Score, stats.Observed)
+	}
+
+	if len(result.BlockedIPs) == 0 {
+		return
+	}
+	fmt.Printf("\nstopped IPs (%d):\n", len(result.BlockedIPs))
+	for i, ip := range result.BlockedIPs {
+		if i >= top {
+			fmt.Printf("  ... %d more\n", len(result.BlockedIPs)-top)
+			break
+		}
+		blocked := ""
+		if ip.BlockedBy != "" {
+			blocked = "  blocked by " + ip.BlockedBy
+		}
+		fmt.Printf("  %-39s %6d  score %3d  first %s  %s%s\n", ip.IP, ip.Stopped, ip.FinalScore,
+			ip.FirstStop.Format(time.RFC3339), strings.Join(ip.Rules, ","), blocked)
+	}
+}
+
+func printReplayDiff(base, compare *replayResult, diff replayDiff) {
+	fmt.Printf("== diff: %s -> %s\n", base.Config, compare.Config)
+	fmt.Printf("stopped: %d -> %d (%+d)\n\n", base.Stopped, compare.Stopped, compare.Stopped-base.Stopped)
+
+	fmt.Printf("%-20s %8s %8s %8s\n", "rule", "stops", "stops", "delta")
+	for _, rule := range sortedReplayRules(base, compare) {
+		var before, after int
+		if stats, ok := base.Rules[rule]; ok {
+			before = stats.Stops
+		}
+		if stats, ok := compare.Rules[rule]; ok {
+			after = stats.Stops
+		}
+		if before == 0 && after == 0 {
+			continue
+		}
+		fmt.Printf("%-20s %8d %8d %+8d\n", rule, before, after, after-before)
+	}
+
+	fmt.Printf("\nIPs stopped only by %s: %d\n", base.Config, len(diff.OnlyBlocked))
+	for _, ip := range diff.OnlyBlocked {
+		fmt.Printf("  %s\n", ip)
+	}
+	fmt.Printf("IPs stopped only by %s: %d\n", compare.Config, len(diff.NewBlocked))
+	for _, ip := range diff.NewBlocked {
+		fmt.Printf("  %s\n", ip)
+	}
+
+	fmt.Printf("\nrequests with a different verdict: %d\n", diff.Changed)
+	for _, change := range diff.Samples {
+		fmt.Printf("  %s %-15s %s %s: %s -> %s\n", change.Time.Format(time.RFC3339), change.IP,
+			change.Method, change.Path, change.Before, change.After)
+	}
+}
@@ -0,0 +1,617 @@
+// 2025 TabDock: darui3018823 All rights reserved.
+// All works created by darui3018823 associated with this repository are the intellectual property of darui3018823.
+// Packages and other third-party materials used in this repository are subject to their respective licenses and copyrights.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Kinds of entries in an IP's security history.
+const (
+	ipEventScore   = "score"
+	ipEventReset   = "reset"
+	ipEventBlock   = "block"
+	ipEventUnblock = "unblock"
+
+	maxIPEvents      = 50
+	ipEventRetention = 7 * 24 * time.Hour
+
+	maxManualBlockMinutes = 365 * 24 * 60
+	trustedIPsFile        = "./json/trusted_ips.json"
+)
+
+// ipEvent is one change to an IP's reputation: a score increase, reset, block or unblock.
+type ipEvent struct {
+	Time   time.Time  `json:"time"`
+	Kind   string     `json:"kind"`
+	Delta  int        `json:"delta,omitempty"`
+	Reason string     `json:"reason,omitempty"`
+	Path   string     `json:"path,omitempty"`
+	Until  *time.Time `json:"until,omitempty"`
+	Actor  string     `json:"actor,omitempty"`
+}
+
+var ipEvents = map[string][]ipEvent{}
+var ipEventsMutex sync.RWMutex
+
+// recordIPEvent appends to an IP's history, keeping only the latest maxIPEvents entries.
+func recordIPEvent(ip string, event ipEvent) {
+	if event.Time.IsZero() {
+		event.Time = securityNow()
+	}
+
+	ipEventsMutex.Lock()
+	defer ipEventsMutex.Unlock()
+
+	events := append(ipEvents[ip], event)
+	if len(events) > maxIPEvents {
+		events = events[len(events)-maxIPEvents:]
+	}
+	ipEvents[ip] = events
+	queueIPEvent(ip, event)
+}
+
+func getIPEvents(ip string) []ipEvent {
+	ipEventsMutex.RLock()
+	defer ipEventsMutex.RUnlock()
+	return append([]ipEvent{}, ipEvents[ip]...)
+}
+
+// cleanupIPEvents forgets IPs whose most recent event is older than ipEventRetention.
+func cleanupIPEvents() {
+	cutoff := time.Now().Add(-ipEventRetention)
+
+	ipEventsMutex.Lock()
+	defer ipEventsMutex.Unlock()
+	for ip, events := range ipEvents {
+		if len(events) == 0 || events[len(events)-1].Time.Before(cutoff) {
+			delete(ipEvents, ip)
+		}
+	}
+}
+
+// ===== 状態の参照 =====
+
+// SecurityIPSummary is one row of the admin IP reputation list.
+type SecurityIPSummary struct {
+	IP           string         `json:"ip"`
+	Score        int            `json:"score"`
+	Reasons      map[string]int `json:"reasons,omitempty"`
+	Blocked      bool           `json:"blocked"`
+	BlockedUntil string         `json:"blockedUntil,omitempty"`
+	BlockReason  string         `json:"blockReason,omitempty"`
+	LastEventAt  string         `json:"lastEventAt,omitempty"`
+}
+
+// SecurityIPDetail is the full reputation state of a single IP.
+type SecurityIPDetail struct {
+	SecurityIPSummary
+	Trusted          bool                   `json:"trusted"`
+	BlockedBy        string                 `json:"blockedBy,omitempty"`
+	ScoreUpdatedAt   string                 `json:"scoreUpdatedAt,omitempty"`
+	FirstAccessUntil string                 `json:"firstAccessUntil,omitempty"`
+	RateLimits       []RateLimitBucketState `json:"rateLimits"`
+	Events           []ipEvent              `json:"events"`
+}
+
+func formatOptionalTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format(time.RFC3339)
+}
+
+// activeDynamicBlock returns the IP's block if it has not expired yet.
+func activeDynamicBlock(ip string) (dynamicBlock, bool) {
+	dynamicBlockMutex.RLock()
+	defer dynamicBlockMutex.RUnlock()
+	block, exists := dynamicBlockMap[ip]
+	if !exists || time.Now().After(block.Until) {
+		return dynamicBlock{}, false
+	}
+	return block, true
+}
+
+func buildSecurityIPSummary(ip string, score int) SecurityIPSummary {
+	summary := SecurityIPSummary{IP: ip, Score: score}
+
+	events := getIPEvents(ip)
+	for _, event := range events {
+		if event.Kind == ipEventScore {
+			if summary.Reasons == nil {
+				summary.Reasons = map[string]int{}
+			}
+			summary.Reasons[event.Reason] += event.Delta
+		}
+	}
+	if len(events) > 0 {
+		summary.LastEventAt = formatOptionalTime(events[len(events)-1].Time)
+	}
+
+	if block, ok := activeDynamicBlock(ip); ok {
+		summary.Blocked = true
+		summary.BlockedUntil = formatOptionalTime(block.Until)
+		summary.BlockReason = block.Reason
+	}
+	return summary
+}
+
+// listSecurityIPs returns scored or blocked IPs, highest score first.
+func listSecurityIPs(limit int) []SecurityIPSummary {
+	candidates := map[string]int{}
+
+	ipScoresMutex.RLock()
+	for ip, score := range ipScores {
+		candidates[ip] = score
+	}
+	ipScoresMutex.RUnlock()
+
+	now := time.Now()
+	dynamicBlockMutex.RLock()
+	for ip, block := range dynamicBlockMap {
+		if now.Before(block.Until) {
+			if _, exists := candidates[ip]; !exists {
+				candidates[ip] = 0
+			}
+		}
+	}
+	dynamicBlockMutex.RUnlock()
+
+	summaries := make([]SecurityIPSummary, 0, len(candidates))
+	for ip, score := range candidates {
+		summaries = append(summaries, buildSecurityIPSummary(ip, score))
+	}
+	sort.Slice(summaries, func(i, j int) bool {
+		if summaries[i].Score != summaries[j].Score {
+			return summaries[i].Score > summaries[j].Score
+		}
+		if summaries[i].Blocked != summaries[j].Blocked {
+			return summaries[i].Blocked
+		}
+		return summaries[i].IP < summaries[j].IP
+	})
+	if len(summaries) > limit {
+		summaries = summaries[:limit]
+	}
+	return summaries
+}
+
+func getSecurityIPDetail(ip string) SecurityIPDetail {
+	ipScoresMutex.RLock()
+	score := ipScores[ip]
+	ipScoresMutex.RUnlock()
+
+	detail := SecurityIPDetail{
+		SecurityIPSummary: buildSecurityIPSummary(ip, score),
+		Trusted:           isTrustedIP(ip) || isPrivateOrLoopback(ip),
+		Events:            getIPEvents(ip),
+	}
+
+	if block, ok := activeDynamicBlock(ip); ok {
+		detail.BlockedBy = block.Actor
+	}
+
+	ipScoreResetMutex.RLock()
+	detail.ScoreUpdatedAt = formatOptionalTime(ipScoreLastReset[ip])
+	ipScoreResetMutex.RUnlock()
+
+	if inGrace, expiry := isFirstAccessIP(ip); inGrace {
+		detail.FirstAccessUntil = formatOptionalTime(expiry)
+	}
+
+	detail.RateLimits = rateLimitBucketsFor("ip:" + ip)
+
+	return detail
+}
+
+// ===== 状態の変更 =====
+
+// blockIPManually blocks an IP until the given time, replacing any automatic block.
+func blockIPManually(ip string, until time.Time, reason, actor string) {
+	dynamicBlockMutex.Lock()
+	dynamicBlockMap[ip] = dynamicBlock{Until: until, Reason: reason, Actor: actor}
+	dynamicBlockMutex.Unlock()
+	markBlockDirty(ip)
+
+	recordIPEvent(ip, ipEvent{Kind: ipEventBlock, Reason: reason, Until: &until, Actor: actor})
+}
+
+func unblockIP(ip, actor string) bool {
+	dynamicBlockMutex.Lock()
+	_, existed := dynamicBlockMap[ip]
+	delete(dynamicBlockMap, ip)
+	dynamicBlockMutex.Unlock()
+
+	if existed {
+		markBlockDirty(ip)
+		recordIPEvent(ip, ipEvent{Kind: ipEventUnblock, Actor: actor})
+	}
+	return existed
+}
+
+// resetScoreManually clears one IP's score and reports the score it had.
+func resetScoreManually(ip, actor string) int {
+	ipScoresMutex.RLock()
+	previous := ipScores[ip]
+	ipScoresMutex.RUnlock()
+
+	resetIPScore(ip)
+	if previous != 0 {
+		recordIPEvent(ip, ipEvent{Kind: ipEventReset, Delta: -previous, Actor: actor})
+	}
+	return previous
+}
+
+// resetAllScores clears every IP's score and returns how many were cleared.
+func resetAllScores(actor string) int {
+	// Lock order: ipScoresMutex first, then ipScoreResetMutex to prevent deadlock
+	ipScoresMutex.Lock()
+	previous := ipScores
+	ipScores = map[string]int{}
+	ipScoreResetMutex.Lock()
+	ipScoreLastReset = map[string]time.Time{}
+	ipScoreResetMutex.Unlock()
+	ipScoresMutex.Unlock()
+
+	for ip, score := range previous {
+		markScoreDirty(ip)
+		recordIPEvent(ip, ipEvent{Kind: ipEventReset, Delta: -score, Actor: actor})
+	}
+	return len(previous)
+}
+
+func clearFirstAccess(ip string) bool {
+	firstAccessIPsMutex.Lock()
+	_, existed := firstAccessIPs[ip]
+	delete(firstAccessIPs, ip)
+	firstAccessIPsMutex.Unlock()
+
+	if existed {
+		markFirstAccessDirty(ip)
+	}
+	return existed
+}
+
+// parseTrustedEntry accepts a single IP address or a CIDR range.
+func parseTrustedEntry(entry string) (*net.IPNet, error) {
+	if _, ipnet, err := net.ParseCIDR(entry); err == nil {
+		return ipnet, nil
+	}
+	ip := net.ParseIP(entry)
+	if ip == nil {
+		return nil, fmt.Errorf("IPアドレスまたはCIDRの形式が正しくありません: %s", entry)
+	}
+	if v4 := ip.To4(); v4 != nil {
+		return &net.IPNet{IP: v4, Mask: net.CIDRMask(32, 32)}, nil
+	}
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(128, 128)}, nil
+}
+
+func listTrustedEntries() []string {
+	trustedCIDRsMutex.RLock()
+	defer trustedCIDRsMutex.RUnlock()
+
+	entries := make([]string, 0, len(trustedCIDRs))
+	for _, cidr := range trustedCIDRs {
+		entries = append(entries, cidr.String())
+	}
+	return entries
+}
+
+// addTrustedEntry appends an entry to the trusted list file and applies it immediately.
+// It reports false when the entry is already present.
+func addTrustedEntry(path, entry string) (bool, error) {
+	ipnet, err := parseTrustedEntry(entry)
+	if err != nil {
+		return false, err
+	}
+
+	trustedCIDRsMutex.Lock()
+	defer trustedCIDRsMutex.Unlock()
+
+	for _, existing := range trustedCIDRs {
+		if existing.String() == ipnet.String() {
+			return false, nil
+		}
+	}
+
+	// Keep any other keys in the file untouched.
+	fileData := map[string]json.RawMessage{}
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return false, err
+	}
+	if err := json.Unmarshal(raw, &fileData); err != nil {
+		return false, err
+	}
+	var trusted []string
+	if existing, ok := fileData["trusted"]; ok {
+		if err := json.Unmarshal(existing, &trusted); err != nil {
+			return false, err
+		}
+	}
+	trusted = append(trusted, entry)
+	encoded, err := json.Marshal(trusted)
+	if err != nil {
+		return false, err
+	}
+	fileData["trusted"] = encoded
+
+	data, err := json.MarshalIndent(fileData, "", "    ")
+	if err != nil {
+		return false, err
+	}
+	if err := os.WriteFile(path, append(data, '\n'), 0644); err != nil {
+		return false, err
+	}
+
+	trustedCIDRs = append(trustedCIDRs, ipnet)
+	return true, nil
+}
+
+// ===== 管理者API =====
+
+type securityIPRequest struct {
+	IP               string `json:"ip"`
+	Minutes          int    `json:"minutes,omitempty"`
+	Reason           string `json:"reason,omitempty"`
+	All              bool   `json:"all,omitempty"`
+	KeepScore        bool   `json:"keepScore,omitempty"`
+	ClearFirstAccess bool   `json:"clearFirstAccess,omitempty"`
+	Entry            string `json:"entry,omitempty"`
+}
+
+// normalizeRequestIP trims and canonicalizes an IP address from a request.
+func normalizeRequestIP(raw string) (string, bool) {
+	ip := net.ParseIP(strings.TrimSpace(raw))
+	if ip == nil {
+		return "", false
+	}
+	return ip.String(), true
+}
+
+func decodeSecurityIPRequest(w http.ResponseWriter, r *http.Request, requireIP bool) (*securityIPRequest, bool) {
+	var req securityIPRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return nil, false
+	}
+	if !requireIP && strings.TrimSpace(req.IP) == "" {
+		return &req, true
+	}
+	ip, ok := normalizeRequestIP(req.IP)
+	if !ok {
+		http.Error(w, "有効な ip が必要です", http.StatusBadRequest)
+		return nil, false
+	}
+	req.IP = ip
+	return &req, true
+}
+
+// handleAdminSecurityIPs lists the highest-scored and currently blocked IPs.
+func handleAdminSecurityIPs(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if _, ok := requireAdmin(w, r); !ok {
+		return
+	}
+
+	limit := 50
+	if raw := r.URL.Query().Get("limit"); raw != "" {
+		parsed, err := strconv.Atoi(raw)
+		if err != nil || parsed <= 0 {
+			http.Error(w, "limit が不正です", http.StatusBadRequest)
+			return
+		}
+		limit = min(parsed, 500)
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		keySuccess: true,
+		"ips":      listSecurityIPs(limit),
+	})
+}
+
+// handleAdminSecurityIP returns the score, block, first-access and rate limit state of one IP.
+func handleAdminSecurityIP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if _, ok := requireAdmin(w, r); !ok {
+		return
+	}
+
+	ip, ok := normalizeRequestIP(r.URL.Query().Get("ip"))
+	if !ok {
+		http.Error(w, "有効な ip が必要です", http.StatusBadRequest)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		keySuccess: true,
+		"ip":       getSecurityIPDetail(ip),
+	})
+}
+
+// handleAdminSecurityBlock blocks an IP for the given number of minutes.
+func handleAdminSecurityBlock(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	actor, ok := requireAdmin(w, r)
+	if !ok {
+		return
+	}
+	req, ok := decodeSecurityIPRequest(w, r, true)
+	if !ok {
+		return
+	}
+
+	if isTrustedIP(req.IP) || isPrivateOrLoopback(req.IP) {
+		http.Error(w, "信頼済み・プライベートIPはブロックできません", http.StatusBadRequest)
+		return
+	}
+
+	minutes := req.Minutes
+	if minutes == 0 {
+		minutes = currentSecurityConfig().DynamicBlockTimeMin
+	}
+	if minutes <= 0 || minutes > maxManualBlockMinutes {
+		http.Error(w, fmt.Sprintf("minutes は 1〜%d の範囲で指定してください", maxManualBlockMinutes), http.StatusBadRequest)
+		return
+	}
+	reason := strings.TrimSpace(req.Reason)
+	if reason == "" {
+		reason = "manual"
+	}
+
+	until := time.Now().Add(time.Duration(minutes) * time.Minute)
+	blockIPManually(req.IP, until, reason, actor)
+
+	auditAdminAction(r, actor, fmt.Sprintf("security.block minutes=%d reason=%q", minutes, reason), req.IP)
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		keySuccess:     true,
+		"blockedUntil": until.Format(time.RFC3339),
+	})
+}
+
+// handleAdminSecurityUnblock lifts a block. The score is reset too unless keepScore is set,
+// since a score over the block threshold would immediately block the IP again.
+func handleAdminSecurityUnblock(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	actor, ok := requireAdmin(w, r)
+	if !ok {
+		return
+	}
+	req, ok := decodeSecurityIPRequest(w, r, true)
+	if !ok {
+		return
+	}
+
+	unblocked := unblockIP(req.IP, actor)
+	previousScore := 0
+	if !req.KeepScore {
+		previousScore = resetScoreManually(req.IP, actor)
+	}
+
+	auditAdminAction(r, actor, "security.unblock keepScore="+strconv.FormatBool(req.KeepScore), req.IP)
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		keySuccess:      true,
+		"unblocked":     unblocked,
+		"previousScore": previousScore,
+	})
+}
+
+// handleAdminSecurityReset clears the score of one IP, or of every IP when all is set.
+func handleAdminSecurityReset(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	actor, ok := requireAdmin(w, r)
+	if !ok {
+		return
+	}
+	req, ok := decodeSecurityIPRequest(w, r, false)
+	if !ok {
+		return
+	}
+
+	if req.All {
+		if req.IP != "" {
+			http.Error(w, "ip と all は同時に指定できません", http.StatusBadRequest)
+			return
+		}
+		cleared := resetAllScores(actor)
+		auditAdminAction(r, actor, "security.reset_all", fmt.Sprintf("%d件", cleared))
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			keySuccess: true,
+			"cleared":  cleared,
+		})
+		return
+	}
+	if req.IP == "" {
+		http.Error(w, "ip または all が必要です", http.StatusBadRequest)
+		return
+	}
+
+	previousScore := resetScoreManually(req.IP, actor)
+	firstAccessCleared := false
+	if req.ClearFirstAccess {
+		firstAccessCleared = clearFirstAccess(req.IP)
+	}
+
+	auditAdminAction(r, actor, "security.reset clearFirstAccess="+strconv.FormatBool(req.ClearFirstAccess), req.IP)
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		keySuccess:           true,
+		"previousScore":      previousScore,
+		"firstAccessCleared": firstAccessCleared,
+	})
+}
+
+// handleAdminSecurityTrusted lists the trusted IP ranges or adds a new one.
+func handleAdminSecurityTrusted(w http.ResponseWriter, r *http.Request) {
+	actor, ok := requireAdmin(w, r)
+	if !ok {
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			keySuccess: true,
+			"trusted":  listTrustedEntries(),
+		})
+	case http.MethodPost:
+		var req securityIPRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid JSON", http.StatusBadRequest)
+			return
+		}
+		entry := strings.TrimSpace(req.Entry)
+		if entry == "" {
+			http.Error(w, "entry が必要です", http.StatusBadRequest)
+			return
+		}
+		if _, err := parseTrustedEntry(entry); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		added, err := addTrustedEntry(trustedIPsFile, entry)
+		if err != nil {
+			log.Printf("[ERROR] 信頼済みIPの追加に失敗しました (%s): %v", entry, err)
+			http.Error(w, "信頼済みIPの追加に失敗しました", http.StatusInternalServerError)
+			return
+		}
+		if added {
+			auditAdminAction(r, actor, "security.trust", entry)
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			keySuccess: true,
+			"added":    added,
+			"trusted":  listTrustedEntries(),
+		})
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,99 @@
+// 2025 TabDock: darui3018823 All rights reserved.
+// All works created by darui3018823 associated with this repository are the intellectual property of darui3018823.
+// Packages and other third-party materials used in this repository are subject to their respective licenses and copyrights.
+
+package main
+
+import (
+	"log"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"tabdock/notify"
+)
+
+// alertDispatcher delivers security alerts. It stays nil until startSecurityAlerts runs,
+// which happens after .env is loaded so *_env secrets resolve.
+var alertDispatcher atomic.Pointer[notify.Dispatcher]
+
+// alertNotifierConfigs returns the configured notifiers. Without any, DISCORD_WEBHOOK_URL
+// keeps working as an implicit Discord notifier.
+func alertNotifierConfigs(cfg *SecurityConfig) []notify.Config {
+	if len(cfg.Notifiers) > 0 {
+		return cfg.Notifiers
+	}
+	if os.Getenv("DISCORD_WEBHOOK_URL") != "" {
+		return []notify.Config{{Name: "discord", Type: notify.TypeDiscord, URLEnv: "DISCORD_WEBHOOK_URL"}}
+	}
+	return nil
+}
+
+// startSecurityAlerts builds the notifiers from the current security configuration.
+func startSecurityAlerts() {
+	replaceAlertDispatcher(currentSecurityConfig())
+}
+
+// replaceAlertDispatcher swaps in notifiers for cfg, for security and status alerts
+// alike. The old dispatcher is stopped in the background so its held-back alerts are
+// still delivered.
+func replaceAlertDispatcher(cfg *SecurityConfig) {
+	replaceStatusNotifiers(cfg)
+	next := notify.NewDispatcher(alertNotifierConfigs(cfg))
+	if prev := alertDispatcher.Swap(next); prev != nil {
+		go prev.Stop()
+	}
+	if next.Len() > 0 {
+		log.Printf("[INFO] セキュリティ通知先: %d件", next.Len())
+	}
+}
+
+// reloadAlertNotifiers applies notifier changes from a configuration reload.
+func reloadAlertNotifiers(cfg *SecurityConfig) {
+	if alertDispatcher.Load() == nil {
+		return
+	}
+	replaceAlertDispatcher(cfg)
+}
+
+// stopSecurityAlerts flushes pending security and status alerts, giving up after timeout.
+func stopSecurityAlerts(timeout time.Duration) {
+	d := alertDispatcher.Load()
+	if d == nil {
+		return
+	}
+	done := make(chan struct{})
+	go func() {
+		d.Stop()
+		// Swap the status dispatchers out so the sampler stops routing alerts to them.
+		statusNotifiersMu.Lock()
+		prev := statusNotifiers
+		statusNotifiers = map[string]*notify.Dispatcher{}
+		statusNotifiersMu.Unlock()
+		stopStatusNotifiers(prev)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(timeout):
+		log.Println("[WARN] 未送信のセキュリティ通知を待たずに終了します")
+	}
+}
+
+// dispatchSecurityAlert hands a logged request to the notifiers. Trusted and private
+// addresses never alert.
+func dispatchSecurityAlert(alert notify.Alert) {
+	d := alertDispatcher.Load()
+	if d == nil || d.Len() == 0 {
+		return
+	}
+	if isTrustedIP(alert.IP) || isPrivateOrLoopback(alert.IP) {
+		return
+	}
+
+	ipScoresMutex.RLock()
+	alert.Score = ipScores[alert.IP]
+	ipScoresMutex.RUnlock()
+
+	d.Dispatch(alert)
+}
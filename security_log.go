@@ -0,0 +1,552 @@
+// 2025 TabDock: darui3018823 All rights reserved.
+// All works created by darui3018823 associated with this repository are the intellectual property of darui3018823.
+// Packages and other third-party materials used in this repository are subject to their respective licenses and copyrights.
+
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Security request logs are written as one JSON line per request into a file per day.
+// Days before today are gzipped, and old files are removed by age and total size.
+// Every entry is also indexed in security.db for the admin query API.
+const (
+	securityLogBaseDir = "./log"
+	securityLogDir     = "./log/security"
+	securityLogDay     = "2006-01-02"
+
+	maxSecurityLogQueryLimit = 1000
+)
+
+// legacySecurityLogLevels are the old ./log/<level>/<ip>/<date>.log directories.
+var legacySecurityLogLevels = []string{ActionInfo, ActionWarn, ActionAttack, ActionBlock, "error"}
+
+type indexedLogEntry struct {
+	entry LogEntry
+	at    time.Time
+}
+
+// securityLogEnabled is switched off by replay, which must not write request logs.
+var securityLogEnabled = true
+
+var securityLogMutex sync.Mutex
+var securityLogFile *os.File
+var securityLogFileDay string
+
+var securityLogIndexMutex sync.Mutex
+var pendingLogEntries []indexedLogEntry
+
+func getSecurityLogRetention() time.Duration {
+	return time.Duration(envPositiveInt("LOG_RETENTION_DAYS", 90)) * 24 * time.Hour
+}
+
+func getSecurityLogMaxBytes() int64 {
+	return int64(envPositiveInt("LOG_MAX_TOTAL_MB", 1024)) * 1024 * 1024
+}
+
+// writeSecurityLog appends a line to today's file and queues the entry for indexing.
+func writeSecurityLog(entry LogEntry, line []byte, at time.Time) {
+	if !securityLogEnabled {
+		return
+	}
+	day := at.Format(securityLogDay)
+
+	securityLogMutex.Lock()
+	if securityLogFile == nil || securityLogFileDay != day {
+		if securityLogFile != nil {
+			if err := securityLogFile.Close(); err != nil {
+				fmt.Println("Failed to close log file:", err)
+			}
+			securityLogFile = nil
+		}
+		if err := os.MkdirAll(securityLogDir, fs.ModePerm); err != nil {
+			securityLogMutex.Unlock()
+			fmt.Println("Failed to create log directory:", err)
+			return
+		}
+		f, err := os.OpenFile(filepath.Join(securityLogDir, day+".log"), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+		if err != nil {
+			securityLogMutex.Unlock()
+			fmt.Println("Failed to open log file:", err)
+			return
+		}
+		securityLogFile, securityLogFileDay = f, day
+	}
+	if _, err := securityLogFile.Write(append(line, '\n')); err != nil {
+		fmt.Println("Failed to write log file:", err)
+	}
+	securityLogMutex.Unlock()
+
+	if securityDB == nil {
+		return
+	}
+	securityLogIndexMutex.Lock()
+	pendingLogEntries = append(pendingLogEntries, indexedLogEntry{entry: entry, at: at})
+	securityLogIndexMutex.Unlock()
+}
+
+// flushSecurityLogIndex writes queued entries to the security_log table in one transaction.
+func flushSecurityLogIndex() {
+	securityLogIndexMutex.Lock()
+	entries := pendingLogEntries
+	pendingLogEntries = nil
+	securityLogIndexMutex.Unlock()
+
+	if len(entries) == 0 {
+		return
+	}
+	if err := insertSecurityLogEntries(entries); err != nil {
+		log.Printf("[ERROR] セキュリティログの索引登録に失敗しました: %v", err)
+		securityLogIndexMutex.Lock()
+		pendingLogEntries = append(entries, pendingLogEntries...)
+		securityLogIndexMutex.Unlock()
+	}
+}
+
+func insertSecurityLogEntries(entries []indexedLogEntry) error {
+	tx, err := securityDB.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if rbErr := tx.Rollback(); rbErr != nil && !errors.Is(rbErr, sql.ErrTxDone) {
+			log.Printf("[WARN] ロールバックに失敗しました: %v", rbErr)
+		}
+	}()
+
+	stmt, err := tx.Prepare(`
+		INSERT INTO security_log (ts, level, ip, method, path, user_agent, request_hash)
+		VALUES (?, ?, ?, ?, ?, ?, ?)`)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if closeErr := stmt.Close(); closeErr != nil {
+			log.Printf("[WARN] ステートメントのクローズに失敗しました: %v", closeErr)
+		}
+	}()
+	for _, item := range entries {
+		e := item.entry
+		if _, err := stmt.Exec(formatSecurityTime(item.at), e.Level, e.IP, e.Method, e.Path, e.UserAgent, e.RequestHash); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// ===== ローテーションと保持期間 =====
+
+type securityLogFileInfo struct {
+	name string
+	day  time.Time
+	size int64
+}
+
+func listSecurityLogFiles() ([]securityLogFileInfo, error) {
+	dirEntries, err := os.ReadDir(securityLogDir)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var files []securityLogFileInfo
+	for _, dirEntry := range dirEntries {
+		name := dirEntry.Name()
+		dayPart := strings.TrimSuffix(strings.TrimSuffix(name, ".gz"), ".log")
+		day, err := time.ParseInLocation(securityLogDay, dayPart, time.Local)
+		if err != nil || dirEntry.IsDir() {
+			continue
+		}
+		info, err := dirEntry.Info()
+		if err != nil {
+			continue
+		}
+		files = append(files, securityLogFileInfo{name: name, day: day, size: info.Size()})
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].name < files[j].name })
+	return files, nil
+}
+
+// compressSecurityLog gzips a finished day's file and removes the original.
+func compressSecurityLog(name string) error {
+	src := filepath.Join(securityLogDir, name)
+	dst := src + ".gz"
+
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if closeErr := in.Close(); closeErr != nil {
+			log.Printf("[WARN] ログファイルのクローズに失敗しました: %v", closeErr)
+		}
+	}()
+
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	gz := gzip.NewWriter(out)
+	if _, err := io.Copy(gz, in); err != nil {
+		_ = gz.Close()
+		_ = out.Close()
+		_ = os.Remove(dst)
+		return err
+	}
+	if err := gz.Close(); err != nil {
+		_ = out.Close()
+		_ = os.Remove(dst)
+		return err
+	}
+	if err := out.Close(); err != nil {
+		_ = os.Remove(dst)
+		return err
+	}
+	return os.Remove(src)
+}
+
+// maintainSecurityLogs compresses past days, then removes files beyond the retention
+// age or total size (oldest first, never today's) and prunes the index to match.
+func maintainSecurityLogs() {
+	today := time.Now().Format(securityLogDay)
+	files, err := listSecurityLogFiles()
+	if err != nil {
+		log.Printf("[WARN] セキュリティログ一覧の取得に失敗しました: %v", err)
+		return
+	}
+
+	for i, file := range files {
+		if strings.HasSuffix(file.name, ".log") && file.name != today+".log" {
+			if err := compressSecurityLog(file.name); err != nil {
+				log.Printf("[WARN] セキュリティログの圧縮に失敗しました (%s): %v", file.name, err)
+				continue
+			}
+			if info, err := os.Stat(filepath.Join(securityLogDir, file.name+".gz")); err == nil {
+				files[i].name, files[i].size = file.name+".gz", info.Size()
+			}
+		}
+	}
+
+	cutoff := time.Now().Add(-getSecurityLogRetention())
+	var total int64
+	for _, file := range files {
+		total += file.size
+	}
+	maxBytes := getSecurityLogMaxBytes()
+
+	removed := 0
+	for _, file := range files {
+		if strings.HasPrefix(file.name, today) {
+			break
+		}
+		// A day is expired once its last moment is older than the cutoff.
+		expired := file.day.AddDate(0, 0, 1).Before(cutoff)
+		if !expired && total <= maxBytes {
+			break
+		}
+		if err := os.Remove(filepath.Join(securityLogDir, file.name)); err != nil {
+			log.Printf("[WARN] セキュリティログの削除に失敗しました (%s): %v", file.name, err)
+			break
+		}
+		total -= file.size
+		removed++
+		// Entries of a removed day can no longer be traced back to a file.
+		if day := file.day.AddDate(0, 0, 1); day.After(cutoff) {
+			cutoff = day
+		}
+	}
+	if removed > 0 {
+		log.Printf("[INFO] 古いセキュリティログを %d件削除しました", removed)
+	}
+
+	if securityDB != nil {
+		if _, err := securityDB.Exec(`DELETE FROM security_log WHERE ts < ?`, formatSecurityTime(cutoff)); err != nil {
+			log.Printf("[WARN] セキュリティログ索引の整理に失敗しました: %v", err)
+		}
+	}
+}
+
+// ===== 旧レイアウトの移行 =====
+
+// migrateLegacySecurityLogs moves ./log/<level>/<ip>/<date>.log files into the daily
+// files and the index, then removes the old directories. It runs once in the background.
+func migrateLegacySecurityLogs() {
+	migrated := 0
+	for _, level := range legacySecurityLogLevels {
+		levelDir := filepath.Join(securityLogBaseDir, level)
+		err := filepath.WalkDir(levelDir, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				if errors.Is(err, fs.ErrNotExist) {
+					return nil
+				}
+				return err
+			}
+			if d.IsDir() || !strings.HasSuffix(d.Name(), ".log") {
+				return nil
+			}
+			if err := migrateLegacySecurityLogFile(path, strings.TrimSuffix(d.Name(), ".log")); err != nil {
+				return fmt.Errorf("%s: %w", path, err)
+			}
+			migrated++
+			return os.Remove(path)
+		})
+		if err != nil {
+			log.Printf("[WARN] 旧形式のセキュリティログを移行できませんでした: %v", err)
+			return
+		}
+		if err := os.RemoveAll(levelDir); err != nil {
+			log.Printf("[WARN] 旧ログディレクトリの削除に失敗しました (%s): %v", levelDir, err)
+		}
+	}
+	if migrated > 0 {
+		flushSecurityLogIndex()
+		log.Printf("[INFO] 旧形式のセキュリティログ %d ファイルを log/security に移行しました", migrated)
+	}
+}
+
+func migrateLegacySecurityLogFile(path, day string) error {
+	if _, err := time.Parse(securityLogDay, day); err != nil {
+		return nil
+	}
+	in, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if closeErr := in.Close(); closeErr != nil {
+			log.Printf("[WARN] ログファイルのクローズに失敗しました: %v", closeErr)
+		}
+	}()
+
+	if err := os.MkdirAll(securityLogDir, fs.ModePerm); err != nil {
+		return err
+	}
+	// Finished days may already be compressed; append a new gzip member in that case.
+	target := filepath.Join(securityLogDir, day+".log")
+	var out io.WriteCloser
+	var closers []io.Closer
+	if _, err := os.Stat(target + ".gz"); err == nil {
+		f, err := os.OpenFile(target+".gz", os.O_APPEND|os.O_WRONLY, 0644)
+		if err != nil {
+			return err
+		}
+		gz := gzip.NewWriter(f)
+		out, closers = gz, []io.Closer{gz, f}
+	} else {
+		f, err := os.OpenFile(target, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+		if err != nil {
+			return err
+		}
+		out, closers = f, []io.Closer{f}
+	}
+
+	scanner := bufio.NewScanner(in)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	var batch []indexedLogEntry
+	var writeErr error
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		var entry LogEntry
+		if err := json.Unmarshal(line, &entry); err != nil {
+			continue
+		}
+		if _, err := out.Write(append(append([]byte{}, line...), '\n')); err != nil {
+			writeErr = err
+			break
+		}
+		at, err := time.Parse(time.RFC3339, entry.Timestamp)
+		if err != nil {
+			continue
+		}
+		batch = append(batch, indexedLogEntry{entry: entry, at: at})
+	}
+	for _, closer := range closers {
+		if err := closer.Close(); err != nil && writeErr == nil {
+			writeErr = err
+		}
+	}
+	if writeErr != nil {
+		return writeErr
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	if securityDB != nil && len(batch) > 0 {
+		return insertSecurityLogEntries(batch)
+	}
+	return nil
+}
+
+// ===== 管理者API =====
+
+// SecurityLogRecord is one indexed request returned by the query API.
+type SecurityLogRecord struct {
+	ID          int64  `json:"id"`
+	Timestamp   string `json:"timestamp"`
+	Level       string `json:"level"`
+	IP          string `json:"ip"`
+	Method      string `json:"method"`
+	Path        string `json:"path"`
+	UserAgent   string `json:"userAgent"`
+	RequestHash string `json:"requestHash"`
+}
+
+// parseLogQueryTime accepts RFC 3339 or a plain date (start of that local day).
+func parseLogQueryTime(value string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	return time.ParseInLocation(securityLogDay, value, time.Local)
+}
+
+func escapeLikePattern(value string) string {
+	replacer := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+	return replacer.Replace(value)
+}
+
+// handleAdminSecurityLogs searches the indexed request log, newest first.
+// Results are paged with the before cursor, which is the id of the last entry seen.
+func handleAdminSecurityLogs(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if _, ok := requireAdmin(w, r); !ok {
+		return
+	}
+	if securityDB == nil {
+		http.Error(w, "セキュリティDBが利用できません", http.StatusServiceUnavailable)
+		return
+	}
+
+	q := r.URL.Query()
+	var conditions []string
+	var args []interface{}
+
+	for _, bound := range []struct {
+		param string
+		op    string
+	}{{"from", ">="}, {"to", "<"}} {
+		raw := strings.TrimSpace(q.Get(bound.param))
+		if raw == "" {
+			continue
+		}
+		t, err := parseLogQueryTime(raw)
+		if err != nil {
+			http.Error(w, bound.param+" はRFC3339または YYYY-MM-DD で指定してください", http.StatusBadRequest)
+			return
+		}
+		conditions = append(conditions, "ts "+bound.op+" ?")
+		args = append(args, formatSecurityTime(t))
+	}
+	if level := strings.TrimSpace(q.Get("level")); level != "" {
+		var placeholders []string
+		for _, l := range strings.Split(level, ",") {
+			placeholders = append(placeholders, "?")
+			args = append(args, strings.ToUpper(strings.TrimSpace(l)))
+		}
+		conditions = append(conditions, "level IN ("+strings.Join(placeholders, ",")+")")
+	}
+	if ip := strings.TrimSpace(q.Get("ip")); ip != "" {
+		if normalized, ok := normalizeRequestIP(ip); ok {
+			ip = normalized
+		}
+		conditions = append(conditions, "ip = ?")
+		args = append(args, ip)
+	}
+	if path := q.Get("path"); path != "" {
+		// A path filter is a prefix match.
+		conditions = append(conditions, `path LIKE ? ESCAPE '\'`)
+		args = append(args, escapeLikePattern(path)+"%")
+	}
+	if hash := strings.TrimSpace(q.Get("hash")); hash != "" {
+		conditions = append(conditions, "request_hash = ?")
+		args = append(args, hash)
+	}
+	if before := strings.TrimSpace(q.Get("before")); before != "" {
+		id, err := strconv.ParseInt(before, 10, 64)
+		if err != nil {
+			http.Error(w, "before が不正です", http.StatusBadRequest)
+			return
+		}
+		conditions = append(conditions, "id < ?")
+		args = append(args, id)
+	}
+
+	limit := 100
+	if raw := q.Get("limit"); raw != "" {
+		parsed, err := strconv.Atoi(raw)
+		if err != nil || parsed <= 0 {
+			http.Error(w, "limit が不正です", http.StatusBadRequest)
+			return
+		}
+		limit = min(parsed, maxSecurityLogQueryLimit)
+	}
+
+	// Make sure entries from the last few seconds are searchable.
+	flushSecurityLogIndex()
+
+	query := `SELECT id, ts, level, ip, method, path, user_agent, request_hash FROM security_log`
+	if len(conditions) > 0 {
+		query += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	query += " ORDER BY id DESC LIMIT ?"
+	args = append(args, limit+1)
+
+	rows, err := securityDB.Query(query, args...)
+	if err != nil {
+		log.Printf("[ERROR] セキュリティログ検索エラー: %v", err)
+		http.Error(w, "検索に失敗しました", http.StatusInternalServerError)
+		return
+	}
+	records := []SecurityLogRecord{}
+	for rows.Next() {
+		var record SecurityLogRecord
+		var ts string
+		if err := rows.Scan(&record.ID, &ts, &record.Level, &record.IP, &record.Method, &record.Path, &record.UserAgent, &record.RequestHash); err != nil {
+			_ = rows.Close()
+			log.Printf("[ERROR] セキュリティログ読み取りエラー: %v", err)
+			http.Error(w, "検索に失敗しました", http.StatusInternalServerError)
+			return
+		}
+		if t, err := parseSecurityTime(ts); err == nil {
+			record.Timestamp = t.Local().Format(time.RFC3339)
+		}
+		records = append(records, record)
+	}
+	if err := closeRows(rows); err != nil {
+		log.Printf("[ERROR] セキュリティログ読み取りエラー: %v", err)
+		http.Error(w, "検索に失敗しました", http.StatusInternalServerError)
+		return
+	}
+
+	response := map[string]interface{}{
+		keySuccess: true,
+		"entries":  records,
+	}
+	if len(records) > limit {
+		records = records[:limit]
+		response["entries"] = records
+		response["nextBefore"] = records[len(records)-1].ID
+	}
+	writeJSON(w, http.StatusOK, response)
+}
@@ -0,0 +1,392 @@
+// 2025 TabDock: darui3018823 All rights reserved.
+// All works created by darui3018823 associated with this repository are the intellectual property of darui3018823.
+// Packages and other third-party materials used in this repository are subject to their respective licenses and copyrights.
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Rules of the secureHandler pipeline. They name the steps in monitor_rules and the
+// monitor report.
+const (
+	ruleRequireCloudflare = "require_cloudflare"
+	ruleBlockedCountry    = "blocked_country"
+	ruleMethod            = "method_not_allowed"
+	ruleDirectIP          = "direct_ip"
+	ruleRateLimit         = "rate_limit"
+	ruleDynamicBlock      = "dynamic_block"
+	ruleAdvancedThreat    = "advanced_threat"
+	ruleSuspiciousPath    = "suspicious_path"
+	ruleUserAgent         = "user_agent"
+	ruleHighScore         = "high_score"
+)
+
+var securityRules = []string{
+	ruleRequireCloudflare, ruleBlockedCountry, ruleMethod, ruleDirectIP, ruleRateLimit,
+	ruleDynamicBlock, ruleAdvancedThreat, ruleSuspiciousPath, ruleUserAgent, ruleHighScore,
+}
+
+const (
+	// maxMonitorSamples is how many recent would-be blocks each rule keeps.
+	maxMonitorSamples = 20
+	// maxMonitorKeys caps the distinct IPs and paths counted per rule.
+	maxMonitorKeys  = 1000
+	monitorTopCount = 10
+	shadowStateTTL  = 24 * time.Hour
+)
+
+// monitorRequest records which rules only observe for the current request and the
+// score they would have added.
+type monitorRequest struct {
+	all    bool
+	rules  map[string]bool
+	deltas map[string]int
+}
+
+func newMonitorRequest(cfg *SecurityConfig) *monitorRequest {
+	if !cfg.MonitorMode && len(cfg.MonitorRules) == 0 {
+		return nil
+	}
+	m := &monitorRequest{all: cfg.MonitorMode, rules: map[string]bool{}, deltas: map[string]int{}}
+	for _, rule := range cfg.MonitorRules {
+		m.rules[rule] = true
+	}
+	return m
+}
+
+func (m *monitorRequest) watches(rule string) bool {
+	return m != nil && (m.all || m.rules[rule])
+}
+
+// ===== シャドウ状態 =====
+
+// Monitored rules feed shadow scores and blocks instead of the real ones, so later rules
+// can still report what they would have done. Shadow state lives in memory only.
+type shadowScore struct {
+	score   int
+	updated time.Time
+}
+
+var shadowMutex sync.Mutex
+var shadowScores = map[string]shadowScore{}
+var shadowBlocks = map[string]time.Time{}
+
+func addShadowScore(ip string, amount int) {
+	shadowMutex.Lock()
+	defer shadowMutex.Unlock()
+	entry := shadowScores[ip]
+	shadowScores[ip] = shadowScore{score: entry.score + amount, updated: securityNow()}
+}
+
+// shadowScoreFor returns the would-be score added on top of the real one, expiring it
+// like a real score would be.
+func shadowScoreFor(ip string) int {
+	shadowMutex.Lock()
+	defer shadowMutex.Unlock()
+	entry, ok := shadowScores[ip]
+	if !ok {
+		return 0
+	}
+	if resetAfter := getResetDuration(entry.score); resetAfter > 0 && securityNow().Sub(entry.updated) >= resetAfter {
+		delete(shadowScores, ip)
+		return 0
+	}
+	return entry.score
+}
+
+func addShadowBlock(ip string) {
+	until := securityNow().Add(time.Duration(currentSecurityConfig().DynamicBlockTimeMin) * time.Minute)
+	shadowMutex.Lock()
+	defer shadowMutex.Unlock()
+	if until.After(shadowBlocks[ip]) {
+		shadowBlocks[ip] = until
+	}
+}
+
+func isShadowBlocked(ip string) bool {
+	shadowMutex.Lock()
+	defer shadowMutex.Unlock()
+	return securityNow().Before(shadowBlocks[ip])
+}
+
+func cleanupShadowState(now time.Time) {
+	shadowMutex.Lock()
+	defer shadowMutex.Unlock()
+	for ip, entry := range shadowScores {
+		if now.Sub(entry.updated) >= shadowStateTTL {
+			delete(shadowScores, ip)
+		}
+	}
+	for ip, until := range shadowBlocks {
+		if now.After(until) {
+			delete(shadowBlocks, ip)
+		}
+	}
+}
+
+// ===== パイプラインの操作 =====
+
+// addScore raises the IP's score, or only its shadow score when the rule is monitored.
+func (ctx securityContext) addScore(r *http.Request, rule string, amount int, reason string) {
+	if ctx.trace != nil {
+		ctx.trace.scores[rule] += amount
+	}
+	if !ctx.monitor.watches(rule) {
+		incrementScore(ctx.ip, amount, reason, r.URL.Path)
+		return
+	}
+	log.Printf("[MONITOR] %s: %s %s %s スコア %+d (%s)", rule, ctx.ip, r.Method, r.URL.Path, amount, reason)
+	ctx.monitor.deltas[rule] += amount
+	addShadowScore(ctx.ip, amount)
+	recordMonitorScore(rule, amount)
+}
+
+// block sets a dynamic block, or only a shadow block when the rule is monitored.
+func (ctx securityContext) block(rule, reason string) {
+	if !ctx.monitor.watches(rule) {
+		if ctx.trace != nil && ctx.trace.blocked == "" {
+			ctx.trace.blocked = rule
+		}
+		addDynamicBlock(ctx.ip, reason)
+		return
+	}
+	addShadowBlock(ctx.ip)
+}
+
+// stop logs the request and ends it with respond. When the rule is monitored it records
+// the would-be outcome instead and lets the request continue.
+func (ctx securityContext) stop(r *http.Request, rule, level, outcome string, respond func()) bool {
+	if !ctx.monitor.watches(rule) {
+		if ctx.trace != nil {
+			ctx.trace.rule, ctx.trace.level, ctx.trace.outcome = rule, level, outcome
+		}
+		recordSecurityDecision(rule, level, false)
+		logRequest(r, ctx.ip, level)
+		respond()
+		return true
+	}
+	ctx.observe(r, rule, level, outcome)
+	return false
+}
+
+// observe records a request that a rule would have stopped.
+func (ctx securityContext) observe(r *http.Request, rule, level, outcome string) {
+	if ctx.trace != nil {
+		ctx.trace.observed = append(ctx.trace.observed, rule)
+	}
+	recordSecurityDecision(rule, level, true)
+	delta := 0
+	if ctx.monitor != nil {
+		delta = ctx.monitor.deltas[rule]
+	}
+	log.Printf("[MONITOR] %s: %s %s %s は %s になるところでした (level=%s, score %+d)", rule, ctx.ip, r.Method, r.URL.Path, outcome, level, delta)
+	recordMonitorBlock(rule, MonitorSample{
+		Time:       securityNow(),
+		IP:         ctx.ip,
+		Method:     r.Method,
+		Path:       r.URL.Path,
+		Level:      level,
+		Outcome:    outcome,
+		ScoreDelta: delta,
+	})
+}
+
+// ===== レポート =====
+
+// MonitorSample is one request a rule would have stopped.
+type MonitorSample struct {
+	Time       time.Time `json:"time"`
+	IP         string    `json:"ip"`
+	Method     string    `json:"method"`
+	Path       string    `json:"path"`
+	Level      string    `json:"level"`
+	Outcome    string    `json:"outcome"`
+	ScoreDelta int       `json:"scoreDelta"`
+}
+
+type monitorRuleStats struct {
+	wouldBlock     int
+	scoreDelta     int
+	scoredRequests int
+	ips            map[string]int
+	paths          map[string]int
+	lastSeen       time.Time
+	samples        []MonitorSample
+}
+
+// MonitorCount is a key with how often it was seen.
+type MonitorCount struct {
+	Key   string `json:"key"`
+	Count int    `json:"count"`
+}
+
+// MonitorRuleReport summarizes what one rule would have done.
+type MonitorRuleReport struct {
+	Rule           string          `json:"rule"`
+	WouldBlock     int             `json:"wouldBlock"`
+	ScoreDelta     int             `json:"scoreDelta"`
+	ScoredRequests int             `json:"scoredRequests"`
+	UniqueIPs      int             `json:"uniqueIPs"`
+	TopIPs         []MonitorCount  `json:"topIPs"`
+	TopPaths       []MonitorCount  `json:"topPaths"`
+	LastSeen       *time.Time      `json:"lastSeen,omitempty"`
+	Samples        []MonitorSample `json:"samples"`
+}
+
+var monitorStatsMutex sync.Mutex
+var monitorStats = map[string]*monitorRuleStats{}
+var monitorSince = time.Now()
+
+func monitorStatsFor(rule string) *monitorRuleStats {
+	stats, ok := monitorStats[rule]
+	if !ok {
+		stats = &monitorRuleStats{ips: map[string]int{}, paths: map[string]int{}}
+		monitorStats[rule] = stats
+	}
+	return stats
+}
+
+func countMonitorKey(counts map[string]int, key string) {
+	if _, ok := counts[key]; ok || len(counts) < maxMonitorKeys {
+		counts[key]++
+	}
+}
+
+func recordMonitorScore(rule string, amount int) {
+	monitorStatsMutex.Lock()
+	defer monitorStatsMutex.Unlock()
+	stats := monitorStatsFor(rule)
+	stats.scoreDelta += amount
+	stats.scoredRequests++
+}
+
+func recordMonitorBlock(rule string, sample MonitorSample) {
+	monitorStatsMutex.Lock()
+	defer monitorStatsMutex.Unlock()
+	stats := monitorStatsFor(rule)
+	stats.wouldBlock++
+	stats.lastSeen = sample.Time
+	countMonitorKey(stats.ips, sample.IP)
+	countMonitorKey(stats.paths, sample.Path)
+	stats.samples = append(stats.samples, sample)
+	if len(stats.samples) > maxMonitorSamples {
+		stats.samples = stats.samples[len(stats.samples)-maxMonitorSamples:]
+	}
+}
+
+func topMonitorCounts(counts map[string]int) []MonitorCount {
+	top := make([]MonitorCount, 0, len(counts))
+	for key, count := range counts {
+		top = append(top, MonitorCount{Key: key, Count: count})
+	}
+	sort.Slice(top, func(i, j int) bool {
+		if top[i].Count != top[j].Count {
+			return top[i].Count > top[j].Count
+		}
+		return top[i].Key < top[j].Key
+	})
+	if len(top) > monitorTopCount {
+		top = top[:monitorTopCount]
+	}
+	return top
+}
+
+// monitorReport summarizes every rule that observed something, most would-be blocks first.
+func monitorReport() (time.Time, []MonitorRuleReport) {
+	monitorStatsMutex.Lock()
+	defer monitorStatsMutex.Unlock()
+
+	reports := make([]MonitorRuleReport, 0, len(monitorStats))
+	for rule, stats := range monitorStats {
+		report := MonitorRuleReport{
+			Rule:           rule,
+			WouldBlock:     stats.wouldBlock,
+			ScoreDelta:     stats.scoreDelta,
+			ScoredRequests: stats.scoredRequests,
+			UniqueIPs:      len(stats.ips),
+			TopIPs:         topMonitorCounts(stats.ips),
+			TopPaths:       topMonitorCounts(stats.paths),
+			Samples:        append([]MonitorSample{}, stats.samples...),
+		}
+		if !stats.lastSeen.IsZero() {
+			lastSeen := stats.lastSeen
+			report.LastSeen = &lastSeen
+		}
+		reports = append(reports, report)
+	}
+	sort.Slice(reports, func(i, j int) bool {
+		if reports[i].WouldBlock != reports[j].WouldBlock {
+			return reports[i].WouldBlock > reports[j].WouldBlock
+		}
+		return reports[i].Rule < reports[j].Rule
+	})
+	return monitorSince, reports
+}
+
+// resetMonitorState clears the report and the shadow scores and blocks.
+func resetMonitorState() {
+	monitorStatsMutex.Lock()
+	monitorStats = map[string]*monitorRuleStats{}
+	monitorSince = time.Now()
+	monitorStatsMutex.Unlock()
+
+	shadowMutex.Lock()
+	shadowScores = map[string]shadowScore{}
+	shadowBlocks = map[string]time.Time{}
+	shadowMutex.Unlock()
+}
+
+// validateMonitorRules reports unknown names in monitor_rules.
+func validateMonitorRules(rules []string) []string {
+	known := map[string]bool{}
+	for _, rule := range securityRules {
+		known[rule] = true
+	}
+	var problems []string
+	for _, rule := range rules {
+		if !known[rule] {
+			problems = append(problems, fmt.Sprintf("monitor_rules に不明なルールがあります: %q", rule))
+		}
+	}
+	return problems
+}
+
+// handleAdminSecurityMonitor returns the would-be blocks per rule (GET) or clears them (DELETE).
+func handleAdminSecurityMonitor(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodDelete {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	actor, ok := requireAdmin(w, r)
+	if !ok {
+		return
+	}
+
+	if r.Method == http.MethodDelete {
+		resetMonitorState()
+		auditAdminAction(r, actor, "security.monitor_reset", "")
+		writeJSON(w, http.StatusOK, map[string]interface{}{keySuccess: true})
+		return
+	}
+
+	cfg := currentSecurityConfig()
+	since, rules := monitorReport()
+	monitorRules := cfg.MonitorRules
+	if monitorRules == nil {
+		monitorRules = []string{}
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		keySuccess:     true,
+		"monitorMode":  cfg.MonitorMode,
+		"monitorRules": monitorRules,
+		"since":        since,
+		"rules":        rules,
+	})
+}
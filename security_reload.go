@@ -0,0 +1,425 @@
+// 2025 TabDock: darui3018823 All rights reserved.
+// All works created by darui3018823 associated with this repository are the intellectual property of darui3018823.
+// Packages and other third-party materials used in this repository are subject to their respective licenses and copyrights.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"tabdock/notify"
+)
+
+const securityConfigPath = "./json/security_config.json"
+
+// securityState is one immutable snapshot of the security configuration together
+// with its compiled patterns. Reloads build a new snapshot and swap the pointer,
+// so a request never sees a half-applied configuration.
+type securityState struct {
+	config        SecurityConfig
+	sqlInjection  *regexp.Regexp
+	xss           *regexp.Regexp
+	pathTraversal *regexp.Regexp
+	modTime       time.Time
+	size          int64
+}
+
+var (
+	activeSecurity atomic.Pointer[securityState]
+
+	// securityReloadMutex serialises reloads from the file watcher and SIGHUP.
+	securityReloadMutex sync.Mutex
+
+	httpMethodToken = regexp.MustCompile(`^[A-Z]+$`)
+	countryCode     = regexp.MustCompile(`^[A-Z]{2}$`)
+)
+
+func currentSecurity() *securityState {
+	return activeSecurity.Load()
+}
+
+// currentSecurityConfig returns the active configuration. Callers must treat it as read-only.
+func currentSecurityConfig() *SecurityConfig {
+	return &activeSecurity.Load().config
+}
+
+// readSecurityState parses, validates and compiles the configuration file without activating it.
+func readSecurityState(path string) (*securityState, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("セキュリティ設定ファイルが開けません: %w", err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("セキュリティ設定ファイルが開けません: %w", err)
+	}
+
+	state, err := parseSecurityState(data)
+	if err != nil {
+		return nil, err
+	}
+	state.modTime = info.ModTime()
+	state.size = info.Size()
+	return state, nil
+}
+
+func parseSecurityState(data []byte) (*securityState, error) {
+	var cfg SecurityConfig
+	dec := json.NewDecoder(bytes.NewReader(data))
+	if err := dec.Decode(&cfg); err != nil {
+		return nil, fmt.Errorf("セキュリティ設定のJSONデコードに失敗: %w", err)
+	}
+	if dec.More() {
+		return nil, fmt.Errorf("セキュリティ設定のJSONデコードに失敗: 余分なデータがあります")
+	}
+
+	if cfg.SecurityLevel == "" {
+		cfg.SecurityLevel = SecurityLevelBalanced
+	}
+	normalizeSecurityConfig(&cfg)
+	if err := validateSecurityConfig(&cfg); err != nil {
+		return nil, err
+	}
+
+	state := &securityState{config: cfg}
+	var err error
+	// 正規表現をコンパイル
+	if state.sqlInjection, err = regexp.Compile(cfg.Patterns.SQLInjection); err != nil {
+		return nil, fmt.Errorf("SQL Injection パターンのコンパイルに失敗: %w", err)
+	}
+	if state.xss, err = regexp.Compile(cfg.Patterns.XSS); err != nil {
+		return nil, fmt.Errorf("XSS パターンのコンパイルに失敗: %w", err)
+	}
+	if state.pathTraversal, err = regexp.Compile(cfg.Patterns.PathTraversal); err != nil {
+		return nil, fmt.Errorf("path traversal パターンのコンパイルに失敗: %w", err)
+	}
+	return state, nil
+}
+
+// normalizeSecurityConfig lower-cases the substring lists, which are matched
+// against lower-cased input, and upper-cases methods and country codes.
+func normalizeSecurityConfig(cfg *SecurityConfig) {
+	for _, list := range []*[]string{&cfg.Patterns.MaliciousUA, &cfg.Patterns.SuspiciousUA, &cfg.Patterns.SuspiciousPath} {
+		for i, pattern := range *list {
+			(*list)[i] = strings.ToLower(strings.TrimSpace(pattern))
+		}
+	}
+	for i, method := range cfg.AllowedMethods {
+		cfg.AllowedMethods[i] = strings.ToUpper(strings.TrimSpace(method))
+	}
+	for i, country := range cfg.BlockedCountries {
+		cfg.BlockedCountries[i] = strings.ToUpper(strings.TrimSpace(country))
+	}
+	for i, rule := range cfg.MonitorRules {
+		cfg.MonitorRules[i] = strings.ToLower(strings.TrimSpace(rule))
+	}
+	normalizeRateLimitPolicies(cfg.RateLimitPolicies)
+}
+
+func validateSecurityConfig(cfg *SecurityConfig) error {
+	var problems []string
+	if cfg.MaxRequestSizeMB <= 0 {
+		problems = append(problems, "max_request_size_mb は1以上が必要です")
+	}
+	if cfg.RateLimitPerMin <= 0 {
+		problems = append(problems, "rate_limit_per_min は1以上が必要です")
+	}
+	problems = append(problems, validateRateLimitPolicies(cfg.RateLimitPolicies)...)
+	problems = append(problems, notify.Validate(cfg.Notifiers)...)
+	problems = append(problems, validateMonitorRules(cfg.MonitorRules)...)
+	if cfg.DynamicBlockTimeMin <= 0 {
+		problems = append(problems, "dynamic_block_time_min は1以上が必要です")
+	}
+	if len(cfg.AllowedMethods) == 0 {
+		problems = append(problems, "allowed_methods が空です")
+	}
+	for _, method := range cfg.AllowedMethods {
+		if !httpMethodToken.MatchString(method) {
+			problems = append(problems, fmt.Sprintf("allowed_methods に不正な値があります: %q", method))
+		}
+	}
+	for _, country := range cfg.BlockedCountries {
+		if !countryCode.MatchString(country) {
+			problems = append(problems, fmt.Sprintf("blocked_countries に不正な国コードがあります: %q", country))
+		}
+	}
+	for name, pattern := range map[string]string{
+		"sql_injection":  cfg.Patterns.SQLInjection,
+		"xss":            cfg.Patterns.XSS,
+		"path_traversal": cfg.Patterns.PathTraversal,
+	} {
+		if strings.TrimSpace(pattern) == "" {
+			problems = append(problems, fmt.Sprintf("detection_patterns.%s が空です", name))
+		}
+	}
+	for name, list := range map[string][]string{
+		"malicious_ua":    cfg.Patterns.MaliciousUA,
+		"suspicious_ua":   cfg.Patterns.SuspiciousUA,
+		"suspicious_path": cfg.Patterns.SuspiciousPath,
+	} {
+		for _, pattern := range list {
+			// An empty substring would match every request.
+			if pattern == "" {
+				problems = append(problems, fmt.Sprintf("detection_patterns.%s に空の値があります", name))
+				break
+			}
+		}
+	}
+	if cfg.SecurityLevel != SecurityLevelBalanced && cfg.SecurityLevel != SecurityLevelRelaxed {
+		log.Printf("[WARN] 未知の security_level %q です。relaxed/balanced-secure 以外は厳格モードとして扱われます", cfg.SecurityLevel)
+	}
+	if cfg.BalancedSecure != nil {
+		for key, value := range cfg.BalancedSecure.ResetThresholds {
+			if _, err := strconv.Atoi(key); err != nil {
+				problems = append(problems, fmt.Sprintf("reset_thresholds のキーが数値ではありません: %q", key))
+				continue
+			}
+			switch v := value.(type) {
+			case float64:
+				if v < 0 {
+					problems = append(problems, fmt.Sprintf("reset_thresholds[%s] が負の値です", key))
+				}
+			case string:
+			default:
+				problems = append(problems, fmt.Sprintf("reset_thresholds[%s] は分数または文字列で指定してください", key))
+			}
+		}
+	}
+
+	if len(problems) > 0 {
+		sort.Strings(problems)
+		return fmt.Errorf("セキュリティ設定が不正です: %s", strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// reloadSecurityConfig re-reads the configuration file and activates it when valid.
+// An invalid file is rejected and the running configuration stays in effect.
+func reloadSecurityConfig(path, trigger string) error {
+	securityReloadMutex.Lock()
+	defer securityReloadMutex.Unlock()
+
+	next, err := readSecurityState(path)
+	if err != nil {
+		log.Printf("[SECURITY] セキュリティ設定の再読み込みを拒否しました (%s)。現在の設定を維持します: %v", trigger, err)
+		return err
+	}
+
+	prev := currentSecurity()
+	changes := diffSecurityConfig(prev.config, next.config)
+	activeSecurity.Store(next)
+	if !reflect.DeepEqual(prev.config.Notifiers, next.config.Notifiers) {
+		reloadAlertNotifiers(&next.config)
+	}
+
+	if len(changes) == 0 {
+		log.Printf("[SECURITY] セキュリティ設定を再読み込みしました (%s): 変更なし", trigger)
+		return nil
+	}
+	log.Printf("[SECURITY] セキュリティ設定を再読み込みしました (%s): %d件の変更", trigger, len(changes))
+	for _, change := range changes {
+		log.Printf("[SECURITY]   %s", change)
+	}
+	return nil
+}
+
+// diffSecurityConfig describes every setting that differs between two configurations.
+// Lists are reported as added and removed entries rather than as whole values.
+func diffSecurityConfig(prev, next SecurityConfig) []string {
+	before := flattenSecurityConfig(prev)
+	after := flattenSecurityConfig(next)
+
+	keys := make([]string, 0, len(before)+len(after))
+	seen := map[string]bool{}
+	for _, m := range []map[string]interface{}{before, after} {
+		for key := range m {
+			if !seen[key] {
+				seen[key] = true
+				keys = append(keys, key)
+			}
+		}
+	}
+	sort.Strings(keys)
+
+	var changes []string
+	for _, key := range keys {
+		oldValue, hadOld := before[key]
+		newValue, hasNew := after[key]
+		if hadOld && hasNew && reflect.DeepEqual(oldValue, newValue) {
+			continue
+		}
+
+		oldList, oldIsList := oldValue.([]interface{})
+		newList, newIsList := newValue.([]interface{})
+		if (oldIsList || !hadOld) && (newIsList || !hasNew) {
+			added, removed := diffStringLists(oldList, newList)
+			if len(added) > 0 {
+				changes = append(changes, fmt.Sprintf("%s: 追加 %s", key, strings.Join(added, ", ")))
+			}
+			if len(removed) > 0 {
+				changes = append(changes, fmt.Sprintf("%s: 削除 %s", key, strings.Join(removed, ", ")))
+			}
+			continue
+		}
+
+		changes = append(changes, fmt.Sprintf("%s: %s -> %s", key, formatConfigValue(oldValue, hadOld), formatConfigValue(newValue, hasNew)))
+	}
+	return changes
+}
+
+// flattenSecurityConfig turns the configuration into dotted keys using its JSON names.
+func flattenSecurityConfig(cfg SecurityConfig) map[string]interface{} {
+	out := map[string]interface{}{}
+	data, err := json.Marshal(cfg)
+	if err != nil {
+		return out
+	}
+	var tree map[string]interface{}
+	if err := json.Unmarshal(data, &tree); err != nil {
+		return out
+	}
+	redactNotifierSecrets(tree)
+	flattenInto(out, "", tree)
+	return out
+}
+
+// redactNotifierSecrets hides webhook URLs, tokens and passwords so reload diffs can be logged.
+func redactNotifierSecrets(tree map[string]interface{}) {
+	notifiers, ok := tree["notifiers"].([]interface{})
+	if !ok {
+		return
+	}
+	for _, item := range notifiers {
+		entry, ok := item.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		for _, key := range []string{"url", "token", "password", "headers"} {
+			if _, present := entry[key]; present {
+				entry[key] = "***"
+			}
+		}
+	}
+}
+
+func flattenInto(out map[string]interface{}, prefix string, value interface{}) {
+	node, ok := value.(map[string]interface{})
+	if !ok {
+		out[prefix] = value
+		return
+	}
+	for key, child := range node {
+		name := key
+		if prefix != "" {
+			name = prefix + "." + key
+		}
+		flattenInto(out, name, child)
+	}
+}
+
+func diffStringLists(before, after []interface{}) (added, removed []string) {
+	count := func(list []interface{}) map[string]int {
+		m := map[string]int{}
+		for _, v := range list {
+			m[fmt.Sprint(v)]++
+		}
+		return m
+	}
+	oldSet, newSet := count(before), count(after)
+	for v := range newSet {
+		if oldSet[v] == 0 {
+			added = append(added, fmt.Sprintf("%q", v))
+		}
+	}
+	for v := range oldSet {
+		if newSet[v] == 0 {
+			removed = append(removed, fmt.Sprintf("%q", v))
+		}
+	}
+	sort.Strings(added)
+	sort.Strings(removed)
+	return added, removed
+}
+
+func formatConfigValue(value interface{}, present bool) string {
+	if !present || value == nil {
+		return "(未設定)"
+	}
+	if s, ok := value.(string); ok {
+		return fmt.Sprintf("%q", s)
+	}
+	return fmt.Sprint(value)
+}
+
+func getSecurityConfigPollInterval() time.Duration {
+	return time.Duration(envPositiveInt("SECURITY_CONFIG_POLL_SEC", 5)) * time.Second
+}
+
+// watchedConfigFile is a file that watchSecurityConfig reloads.
+type watchedConfigFile struct {
+	path    string
+	reload  func(path, trigger string) error
+	modTime time.Time
+	size    int64
+}
+
+// watchSecurityConfig reloads the security configuration and the trusted proxies when a
+// file's modification time or size changes, and whenever the process receives SIGHUP.
+func watchSecurityConfig(path string) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+
+	ticker := time.NewTicker(getSecurityConfigPollInterval())
+	defer ticker.Stop()
+
+	// Remember the last file version seen, valid or not, so a broken file is
+	// reported once instead of on every tick.
+	files := []*watchedConfigFile{
+		{path: path, reload: reloadSecurityConfig},
+		{path: getTrustedProxiesPath(), reload: reloadTrustedProxies},
+	}
+	if state := currentSecurity(); state != nil {
+		files[0].modTime, files[0].size = state.modTime, state.size
+	}
+	if proxies := activeProxies.Load(); proxies != nil {
+		files[1].modTime, files[1].size = proxies.modTime, proxies.size
+	}
+
+	for {
+		select {
+		case <-hup:
+			for _, file := range files {
+				_ = file.reload(file.path, "SIGHUP")
+				if info, err := os.Stat(file.path); err == nil {
+					file.modTime, file.size = info.ModTime(), info.Size()
+				}
+			}
+		case <-ticker.C:
+			for _, file := range files {
+				info, err := os.Stat(file.path)
+				if err != nil {
+					continue
+				}
+				if info.ModTime().Equal(file.modTime) && info.Size() == file.size {
+					continue
+				}
+				file.modTime, file.size = info.ModTime(), info.Size()
+				_ = file.reload(file.path, "ファイル変更")
+			}
+		}
+	}
+}
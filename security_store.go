@@ -0,0 +1,543 @@
+// 2025 TabDock: darui3018823 All rights reserved.
+// All works created by darui3018823 associated with this repository are the intellectual property of darui3018823.
+// Packages and other third-party materials used in this repository are subject to their respective licenses and copyrights.
+
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// Legacy JSON files imported into the security database once.
+const (
+	legacyScoreFile          = "./json/ip_scores.json"
+	legacyFirstAccessIPsFile = "./json/first_access_ips.json"
+
+	securityMetaLegacyImported = "legacy_json_imported"
+
+	// securityTimeFormat is fixed-width so stored timestamps sort as text.
+	securityTimeFormat = "2006-01-02T15:04:05.000000Z"
+)
+
+// securityDB persists IP reputation state. The in-memory maps in log.go remain the
+// source of truth for request handling; changes are marked dirty and flushed in batches.
+var securityDB *sql.DB
+
+type pendingIPEvent struct {
+	ip    string
+	event ipEvent
+}
+
+var securityDirtyMutex sync.Mutex
+var dirtyScoreIPs = map[string]struct{}{}
+var dirtyBlockIPs = map[string]struct{}{}
+var dirtyFirstAccessIPs = map[string]struct{}{}
+var pendingIPEvents []pendingIPEvent
+
+func formatSecurityTime(t time.Time) string {
+	return t.UTC().Format(securityTimeFormat)
+}
+
+func parseSecurityTime(s string) (time.Time, error) {
+	return time.Parse(securityTimeFormat, s)
+}
+
+// initSecurityStore opens the security database, imports the legacy JSON files once,
+// loads the saved state into memory and starts the background flusher.
+func initSecurityStore() error {
+	path := getEnv("DB_SECURITY_PATH", "./database/security.db")
+	var err error
+	securityDB, err = sql.Open(sqliteDriverName, path)
+	if err != nil {
+		return err
+	}
+	// A single connection serialises writers so flushes never hit SQLITE_BUSY.
+	securityDB.SetMaxOpenConns(1)
+
+	statements := []string{
+		`PRAGMA journal_mode = WAL`,
+		`PRAGMA busy_timeout = 5000`,
+		`CREATE TABLE IF NOT EXISTS ip_scores (
+			ip TEXT PRIMARY KEY,
+			score INTEGER NOT NULL,
+			updated_at TEXT NOT NULL
+		)`,
+		`CREATE TABLE IF NOT EXISTS ip_score_events (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			ip TEXT NOT NULL,
+			kind TEXT NOT NULL,
+			delta INTEGER NOT NULL DEFAULT 0,
+			reason TEXT NOT NULL DEFAULT '',
+			path TEXT NOT NULL DEFAULT '',
+			until TEXT,
+			actor TEXT NOT NULL DEFAULT '',
+			created_at TEXT NOT NULL
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_ip_score_events_ip ON ip_score_events(ip, created_at)`,
+		`CREATE INDEX IF NOT EXISTS idx_ip_score_events_created ON ip_score_events(created_at)`,
+		`CREATE TABLE IF NOT EXISTS ip_blocks (
+			ip TEXT PRIMARY KEY,
+			until TEXT NOT NULL,
+			reason TEXT NOT NULL DEFAULT '',
+			actor TEXT NOT NULL DEFAULT ''
+		)`,
+		`CREATE TABLE IF NOT EXISTS first_access_ips (
+			ip TEXT PRIMARY KEY,
+			expires_at TEXT NOT NULL
+		)`,
+		`CREATE TABLE IF NOT EXISTS security_log (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			ts TEXT NOT NULL,
+			level TEXT NOT NULL,
+			ip TEXT NOT NULL,
+			method TEXT NOT NULL,
+			path TEXT NOT NULL,
+			user_agent TEXT NOT NULL DEFAULT '',
+			request_hash TEXT NOT NULL
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_security_log_ts ON security_log(ts)`,
+		`CREATE INDEX IF NOT EXISTS idx_security_log_ip ON security_log(ip, ts)`,
+		`CREATE INDEX IF NOT EXISTS idx_security_log_level ON security_log(level, ts)`,
+		`CREATE INDEX IF NOT EXISTS idx_security_log_hash ON security_log(request_hash)`,
+		`CREATE TABLE IF NOT EXISTS security_meta (
+			key TEXT PRIMARY KEY,
+			value TEXT NOT NULL
+		)`,
+	}
+	for _, stmt := range statements {
+		if _, err := securityDB.Exec(stmt); err != nil {
+			return fmt.Errorf("セキュリティDBのスキーマ作成に失敗しました: %w", err)
+		}
+	}
+
+	if err := importLegacySecurityJSON(); err != nil {
+		return fmt.Errorf("旧JSONファイルの取り込みに失敗しました: %w", err)
+	}
+	if err := loadSecurityStore(); err != nil {
+		return fmt.Errorf("セキュリティ状態の読み込みに失敗しました: %w", err)
+	}
+
+	go migrateLegacySecurityLogs()
+	go runSecurityFlusher(time.Duration(envPositiveInt("SECURITY_FLUSH_SEC", 5)) * time.Second)
+	return nil
+}
+
+// importLegacySecurityJSON copies ip_scores.json and first_access_ips.json into the
+// database the first time it starts, then renames them so they are not read again.
+func importLegacySecurityJSON() error {
+	var done string
+	err := securityDB.QueryRow(`SELECT value FROM security_meta WHERE key = ?`, securityMetaLegacyImported).Scan(&done)
+	if err == nil {
+		return nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+
+	scores := map[string]int{}
+	if data, err := os.ReadFile(legacyScoreFile); err == nil {
+		if err := json.Unmarshal(data, &scores); err != nil {
+			log.Printf("[WARN] %s を解析できないため取り込みをスキップします: %v", legacyScoreFile, err)
+			scores = map[string]int{}
+		}
+	}
+
+	var firstAccess struct {
+		IPs map[string]string `json:"ips"`
+	}
+	if data, err := os.ReadFile(legacyFirstAccessIPsFile); err == nil {
+		if err := json.Unmarshal(data, &firstAccess); err != nil {
+			log.Printf("[WARN] %s を解析できないため取り込みをスキップします: %v", legacyFirstAccessIPsFile, err)
+		}
+	}
+
+	tx, err := securityDB.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if rbErr := tx.Rollback(); rbErr != nil && !errors.Is(rbErr, sql.ErrTxDone) {
+			log.Printf("[WARN] ロールバックに失敗しました: %v", rbErr)
+		}
+	}()
+
+	now := formatSecurityTime(time.Now())
+	for ip, score := range scores {
+		if score == 0 {
+			continue
+		}
+		if _, err := tx.Exec(`INSERT OR REPLACE INTO ip_scores (ip, score, updated_at) VALUES (?, ?, ?)`, ip, score, now); err != nil {
+			return err
+		}
+	}
+	imported := 0
+	for ip, raw := range firstAccess.IPs {
+		expiry, err := time.Parse(time.RFC3339, raw)
+		if err != nil || time.Now().After(expiry) {
+			continue
+		}
+		if _, err := tx.Exec(`INSERT OR REPLACE INTO first_access_ips (ip, expires_at) VALUES (?, ?)`, ip, formatSecurityTime(expiry)); err != nil {
+			return err
+		}
+		imported++
+	}
+	if _, err := tx.Exec(`INSERT INTO security_meta (key, value) VALUES (?, ?)`, securityMetaLegacyImported, now); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	for _, file := range []string{legacyScoreFile, legacyFirstAccessIPsFile} {
+		if _, err := os.Stat(file); err != nil {
+			continue
+		}
+		if err := os.Rename(file, file+".imported"); err != nil {
+			log.Printf("[WARN] %s のリネームに失敗しました: %v", file, err)
+		}
+	}
+	if len(scores) > 0 || imported > 0 {
+		log.Printf("[INFO] 旧JSONからセキュリティ状態を取り込みました: スコア=%d件 初回アクセス=%d件", len(scores), imported)
+	}
+	return nil
+}
+
+// loadSecurityStore fills the in-memory maps from the database.
+func loadSecurityStore() error {
+	now := time.Now()
+
+	rows, err := securityDB.Query(`SELECT ip, score, updated_at FROM ip_scores`)
+	if err != nil {
+		return err
+	}
+	scores := map[string]int{}
+	lastReset := map[string]time.Time{}
+	for rows.Next() {
+		var ip, updatedAt string
+		var score int
+		if err := rows.Scan(&ip, &score, &updatedAt); err != nil {
+			_ = rows.Close()
+			return err
+		}
+		scores[ip] = score
+		if t, err := parseSecurityTime(updatedAt); err == nil {
+			lastReset[ip] = t
+		}
+	}
+	if err := closeRows(rows); err != nil {
+		return err
+	}
+
+	rows, err = securityDB.Query(`SELECT ip, until, reason, actor FROM ip_blocks WHERE until > ?`, formatSecurityTime(now))
+	if err != nil {
+		return err
+	}
+	blocks := map[string]dynamicBlock{}
+	for rows.Next() {
+		var ip, until string
+		var block dynamicBlock
+		if err := rows.Scan(&ip, &until, &block.Reason, &block.Actor); err != nil {
+			_ = rows.Close()
+			return err
+		}
+		if block.Until, err = parseSecurityTime(until); err == nil {
+			blocks[ip] = block
+		}
+	}
+	if err := closeRows(rows); err != nil {
+		return err
+	}
+
+	rows, err = securityDB.Query(`SELECT ip, expires_at FROM first_access_ips WHERE expires_at > ?`, formatSecurityTime(now))
+	if err != nil {
+		return err
+	}
+	firstAccess := map[string]time.Time{}
+	for rows.Next() {
+		var ip, expiresAt string
+		if err := rows.Scan(&ip, &expiresAt); err != nil {
+			_ = rows.Close()
+			return err
+		}
+		if t, err := parseSecurityTime(expiresAt); err == nil {
+			firstAccess[ip] = t
+		}
+	}
+	if err := closeRows(rows); err != nil {
+		return err
+	}
+
+	rows, err = securityDB.Query(`
+		SELECT ip, kind, delta, reason, path, COALESCE(until, ''), actor, created_at
+		FROM ip_score_events WHERE created_at > ? ORDER BY id`,
+		formatSecurityTime(now.Add(-ipEventRetention)))
+	if err != nil {
+		return err
+	}
+	events := map[string][]ipEvent{}
+	for rows.Next() {
+		var ip, until, createdAt string
+		var event ipEvent
+		if err := rows.Scan(&ip, &event.Kind, &event.Delta, &event.Reason, &event.Path, &until, &event.Actor, &createdAt); err != nil {
+			_ = rows.Close()
+			return err
+		}
+		event.Time, _ = parseSecurityTime(createdAt)
+		if until != "" {
+			if t, err := parseSecurityTime(until); err == nil {
+				event.Until = &t
+			}
+		}
+		list := append(events[ip], event)
+		if len(list) > maxIPEvents {
+			list = list[len(list)-maxIPEvents:]
+		}
+		events[ip] = list
+	}
+	if err := closeRows(rows); err != nil {
+		return err
+	}
+
+	ipScoresMutex.Lock()
+	ipScores = scores
+	ipScoreResetMutex.Lock()
+	ipScoreLastReset = lastReset
+	ipScoreResetMutex.Unlock()
+	ipScoresMutex.Unlock()
+
+	dynamicBlockMutex.Lock()
+	dynamicBlockMap = blocks
+	dynamicBlockMutex.Unlock()
+
+	firstAccessIPsMutex.Lock()
+	firstAccessIPs = firstAccess
+	firstAccessIPsMutex.Unlock()
+
+	ipEventsMutex.Lock()
+	ipEvents = events
+	ipEventsMutex.Unlock()
+
+	return nil
+}
+
+func closeRows(rows *sql.Rows) error {
+	if err := rows.Err(); err != nil {
+		_ = rows.Close()
+		return err
+	}
+	return rows.Close()
+}
+
+func markScoreDirty(ip string) {
+	if securityDB == nil {
+		return
+	}
+	securityDirtyMutex.Lock()
+	dirtyScoreIPs[ip] = struct{}{}
+	securityDirtyMutex.Unlock()
+}
+
+func markBlockDirty(ip string) {
+	if securityDB == nil {
+		return
+	}
+	securityDirtyMutex.Lock()
+	dirtyBlockIPs[ip] = struct{}{}
+	securityDirtyMutex.Unlock()
+}
+
+func markFirstAccessDirty(ip string) {
+	if securityDB == nil {
+		return
+	}
+	securityDirtyMutex.Lock()
+	dirtyFirstAccessIPs[ip] = struct{}{}
+	securityDirtyMutex.Unlock()
+}
+
+func queueIPEvent(ip string, event ipEvent) {
+	if securityDB == nil {
+		return
+	}
+	securityDirtyMutex.Lock()
+	pendingIPEvents = append(pendingIPEvents, pendingIPEvent{ip: ip, event: event})
+	securityDirtyMutex.Unlock()
+}
+
+func runSecurityFlusher(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		flushSecurityStore()
+	}
+}
+
+var securityFlushMutex sync.Mutex
+
+// flushSecurityStore writes every dirty score, block and first-access record and all
+// queued events in one transaction. On failure the work is queued again for the next flush.
+func flushSecurityStore() {
+	if securityDB == nil {
+		return
+	}
+	securityFlushMutex.Lock()
+	defer securityFlushMutex.Unlock()
+
+	flushSecurityLogIndex()
+
+	securityDirtyMutex.Lock()
+	scoreIPs, blockIPs, firstAccessDirty, events := dirtyScoreIPs, dirtyBlockIPs, dirtyFirstAccessIPs, pendingIPEvents
+	dirtyScoreIPs = map[string]struct{}{}
+	dirtyBlockIPs = map[string]struct{}{}
+	dirtyFirstAccessIPs = map[string]struct{}{}
+	pendingIPEvents = nil
+	securityDirtyMutex.Unlock()
+
+	if len(scoreIPs) == 0 && len(blockIPs) == 0 && len(firstAccessDirty) == 0 && len(events) == 0 {
+		return
+	}
+
+	if err := writeSecurityChanges(scoreIPs, blockIPs, firstAccessDirty, events); err != nil {
+		log.Printf("[ERROR] セキュリティ状態の保存に失敗しました: %v", err)
+		securityDirtyMutex.Lock()
+		for ip := range scoreIPs {
+			dirtyScoreIPs[ip] = struct{}{}
+		}
+		for ip := range blockIPs {
+			dirtyBlockIPs[ip] = struct{}{}
+		}
+		for ip := range firstAccessDirty {
+			dirtyFirstAccessIPs[ip] = struct{}{}
+		}
+		pendingIPEvents = append(events, pendingIPEvents...)
+		securityDirtyMutex.Unlock()
+	}
+}
+
+func writeSecurityChanges(scoreIPs, blockIPs, firstAccessDirty map[string]struct{}, events []pendingIPEvent) error {
+	tx, err := securityDB.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if rbErr := tx.Rollback(); rbErr != nil && !errors.Is(rbErr, sql.ErrTxDone) {
+			log.Printf("[WARN] ロールバックに失敗しました: %v", rbErr)
+		}
+	}()
+
+	for ip := range scoreIPs {
+		ipScoresMutex.RLock()
+		score, exists := ipScores[ip]
+		ipScoreResetMutex.RLock()
+		updatedAt := ipScoreLastReset[ip]
+		ipScoreResetMutex.RUnlock()
+		ipScoresMutex.RUnlock()
+
+		if !exists || score == 0 {
+			if _, err := tx.Exec(`DELETE FROM ip_scores WHERE ip = ?`, ip); err != nil {
+				return err
+			}
+			continue
+		}
+		if updatedAt.IsZero() {
+			updatedAt = time.Now()
+		}
+		if _, err := tx.Exec(`
+			INSERT INTO ip_scores (ip, score, updated_at) VALUES (?, ?, ?)
+			ON CONFLICT(ip) DO UPDATE SET score = excluded.score, updated_at = excluded.updated_at`,
+			ip, score, formatSecurityTime(updatedAt)); err != nil {
+			return err
+		}
+	}
+
+	for ip := range blockIPs {
+		dynamicBlockMutex.RLock()
+		block, exists := dynamicBlockMap[ip]
+		dynamicBlockMutex.RUnlock()
+
+		if !exists {
+			if _, err := tx.Exec(`DELETE FROM ip_blocks WHERE ip = ?`, ip); err != nil {
+				return err
+			}
+			continue
+		}
+		if _, err := tx.Exec(`
+			INSERT INTO ip_blocks (ip, until, reason, actor) VALUES (?, ?, ?, ?)
+			ON CONFLICT(ip) DO UPDATE SET until = excluded.until, reason = excluded.reason, actor = excluded.actor`,
+			ip, formatSecurityTime(block.Until), block.Reason, block.Actor); err != nil {
+			return err
+		}
+	}
+
+	for ip := range firstAccessDirty {
+		firstAccessIPsMutex.RLock()
+		expiry, exists := firstAccessIPs[ip]
+		firstAccessIPsMutex.RUnlock()
+
+		if !exists {
+			if _, err := tx.Exec(`DELETE FROM first_access_ips WHERE ip = ?`, ip); err != nil {
+				return err
+			}
+			continue
+		}
+		if _, err := tx.Exec(`INSERT OR REPLACE INTO first_access_ips (ip, expires_at) VALUES (?, ?)`,
+			ip, formatSecurityTime(expiry)); err != nil {
+			return err
+		}
+	}
+
+	if len(events) > 0 {
+		stmt, err := tx.Prepare(`
+			INSERT INTO ip_score_events (ip, kind, delta, reason, path, until, actor, created_at)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?)`)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			if closeErr := stmt.Close(); closeErr != nil {
+				log.Printf("[WARN] ステートメントのクローズに失敗しました: %v", closeErr)
+			}
+		}()
+		for _, pending := range events {
+			var until interface{}
+			if pending.event.Until != nil {
+				until = formatSecurityTime(*pending.event.Until)
+			}
+			if _, err := stmt.Exec(pending.ip, pending.event.Kind, pending.event.Delta, pending.event.Reason,
+				pending.event.Path, until, pending.event.Actor, formatSecurityTime(pending.event.Time)); err != nil {
+				return err
+			}
+		}
+	}
+
+	return tx.Commit()
+}
+
+// pruneSecurityStore deletes events older than ipEventRetention and expired records.
+func pruneSecurityStore() {
+	if securityDB == nil {
+		return
+	}
+	now := time.Now()
+	prunes := []struct {
+		query string
+		arg   string
+	}{
+		{`DELETE FROM ip_score_events WHERE created_at < ?`, formatSecurityTime(now.Add(-ipEventRetention))},
+		{`DELETE FROM ip_blocks WHERE until < ?`, formatSecurityTime(now)},
+		{`DELETE FROM first_access_ips WHERE expires_at < ?`, formatSecurityTime(now)},
+	}
+	for _, prune := range prunes {
+		if _, err := securityDB.Exec(prune.query, prune.arg); err != nil {
+			log.Printf("[WARN] セキュリティDBの整理に失敗しました: %v", err)
+		}
+	}
+}
@@ -0,0 +1,100 @@
+// 2025 TabDock: darui3018823 All rights reserved.
+// All works created by darui3018823 associated with this repository are the intellectual property of darui3018823.
+// Packages and other third-party materials used in this repository are subject to their respective licenses and copyrights.
+
+package main
+
+import (
+	"net/http"
+
+	"tabdock/getstatus"
+)
+
+// canViewStatusDetails reports whether the caller may see who is logged in to the
+// hosts and what runs on them: signed-in users and requests from private or trusted
+// networks. Others get the public status.
+func canViewStatusDetails(r *http.Request) bool {
+	if isLocalRequest(r) {
+		return true
+	}
+	_, err := getUsernameFromRequest(r)
+	return err == nil
+}
+
+// publicStatus returns a copy of status without the user names and remote addresses
+// of the login sessions, and with only the custom fields marked public, without
+// their errors.
+func publicStatus(status *getstatus.PCStatus) *getstatus.PCStatus {
+	if status == nil {
+		return nil
+	}
+	public := *status
+	public.Sessions = make([]getstatus.SessionStatus, len(status.Sessions))
+	for i, s := range status.Sessions {
+		s.User, s.Host = "", ""
+		public.Sessions[i] = s
+	}
+	public.Custom = make(map[string]getstatus.CustomStatus)
+	for name, c := range status.Custom {
+		if c.Public {
+			c.Error = ""
+			public.Custom[name] = c
+		}
+	}
+	return &public
+}
+
+// publicStatusV2 is publicStatus for the structured status. It also leaves out the
+// process lists, keeping only the process count, and the probe targets and errors,
+// which can name internal hosts or URLs with credentials.
+func publicStatusV2(details *getstatus.StatusV2) *getstatus.StatusV2 {
+	if details == nil {
+		return nil
+	}
+	public := *details
+	public.Activity.Sessions = make([]getstatus.SessionInfo, len(details.Activity.Sessions))
+	for i, s := range details.Activity.Sessions {
+		s.User, s.Host = "", ""
+		public.Activity.Sessions[i] = s
+	}
+	public.Custom = make(map[string]getstatus.CustomInfo)
+	for name, c := range details.Custom {
+		if c.Public {
+			c.Error = ""
+			public.Custom[name] = c
+		}
+	}
+	public.WAN.Probes = make([]getstatus.ProbeInfo, len(details.WAN.Probes))
+	for i, p := range details.WAN.Probes {
+		p.Target, p.Error = "", ""
+		history := make([]getstatus.ProbeSample, len(p.History))
+		for j, sample := range p.History {
+			sample.Error = ""
+			history[j] = sample
+		}
+		p.History = history
+		public.WAN.Probes[i] = p
+	}
+	public.Processes = getstatus.ProcessRankingInfo{
+		Total:    details.Processes.Total,
+		ByCPU:    []getstatus.ProcessInfo{},
+		ByMemory: []getstatus.ProcessInfo{},
+	}
+	return &public
+}
+
+// viewStatus returns status as the caller may see it.
+func viewStatus(r *http.Request, status *getstatus.PCStatus) *getstatus.PCStatus {
+	if canViewStatusDetails(r) {
+		return status
+	}
+	return publicStatus(status)
+}
+
+// viewStatusV2 returns details as the caller may see it.
+func viewStatusV2(r *http.Request, details *getstatus.StatusV2) *getstatus.StatusV2 {
+	if canViewStatusDetails(r) {
+		return details
+	}
+	return publicStatusV2(details)
+}
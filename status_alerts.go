@@ -0,0 +1,873 @@
+// 2025 TabDock: darui3018823 All rights reserved.
+// All works created by darui3018823 associated with this repository are the intellectual property of darui3018823.
+// Packages and other third-party materials used in this repository are subject to their respective licenses and copyrights.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"tabdock/getstatus"
+	"tabdock/notify"
+
+	"github.com/google/uuid"
+)
+
+// Metrics a status alert condition can test, in addition to the history metrics.
+// Percentages are 0-100, temp is the hottest sensor in Celsius, and wan and charging
+// are 1 or 0.
+const (
+	metricLoad1    = "load1"
+	metricBattery  = "battery"
+	metricCharging = "charging"
+	metricWAN      = "wan"
+	metricGPU      = "gpu"
+	metricVRAM     = "vram"
+	metricTemp     = "temp"
+)
+
+var statusAlertMetrics = map[string]bool{
+	metricCPU: true, metricMem: true, metricSwap: true, metricDisk: true,
+	metricNetRx: true, metricNetTx: true, metricLoad1: true, metricBattery: true,
+	metricCharging: true, metricWAN: true, metricGPU: true, metricVRAM: true, metricTemp: true,
+	metricProbeUp: true, metricProbeLatency: true, metricProbeLoss: true,
+}
+
+var statusAlertOperators = map[string]func(a, b float64) bool{
+	">":  func(a, b float64) bool { return a > b },
+	">=": func(a, b float64) bool { return a >= b },
+	"<":  func(a, b float64) bool { return a < b },
+	"<=": func(a, b float64) bool { return a <= b },
+	"==": func(a, b float64) bool { return a == b },
+	"!=": func(a, b float64) bool { return a != b },
+}
+
+const (
+	// allHosts in a rule's host applies it to this server and every agent.
+	allHosts = "*"
+
+	maxStatusAlertRules      = 100
+	maxStatusAlertConditions = 8
+	maxStatusAlertFor        = 7 * 24 * time.Hour
+	maxStatusAlertSilence    = 30 * 24 * time.Hour
+)
+
+// AlertCondition compares one metric with a value. Mount picks the disk for the disk
+// metric; without it the drive shown as DriveC is used. Probe names the connectivity
+// probe the probe metrics are read from.
+type AlertCondition struct {
+	Metric string  `json:"metric"`
+	Mount  string  `json:"mount,omitempty"`
+	Probe  string  `json:"probe,omitempty"`
+	Op     string  `json:"op"`
+	Value  float64 `json:"value"`
+}
+
+func (c AlertCondition) key() string {
+	switch {
+	case c.Mount != "":
+		return c.Metric + ":" + c.Mount
+	case c.Probe != "":
+		return probeMetricKey(c.Metric, c.Probe)
+	}
+	return c.Metric
+}
+
+func (c AlertCondition) String() string {
+	return fmt.Sprintf("%s %s %g", c.key(), c.Op, c.Value)
+}
+
+// StatusAlertRule fires when all of its conditions hold on a host for ForSeconds.
+type StatusAlertRule struct {
+	ID         string           `json:"id"`
+	Name       string           `json:"name"`
+	Host       string           `json:"host"`
+	Conditions []AlertCondition `json:"conditions"`
+	ForSeconds int              `json:"forSeconds"`
+	Severity   string           `json:"severity"`
+	Notifiers  []string         `json:"notifiers"`
+	Enabled    bool             `json:"enabled"`
+	CreatedBy  string           `json:"createdBy"`
+	CreatedAt  string           `json:"createdAt"`
+	UpdatedAt  string           `json:"updatedAt"`
+}
+
+func (rule *StatusAlertRule) appliesTo(host string) bool {
+	return rule.Host == allHosts || strings.EqualFold(rule.Host, host)
+}
+
+func (rule *StatusAlertRule) condition() string {
+	parts := make([]string, 0, len(rule.Conditions))
+	for _, c := range rule.Conditions {
+		parts = append(parts, c.String())
+	}
+	return strings.Join(parts, " && ")
+}
+
+// StatusAlertSilence mutes notifications of one rule, or of every rule when RuleID is
+// empty, on one host or on all of them when Host is empty.
+type StatusAlertSilence struct {
+	ID        string `json:"id"`
+	RuleID    string `json:"ruleId"`
+	Host      string `json:"host"`
+	Until     string `json:"until"`
+	Reason    string `json:"reason"`
+	CreatedBy string `json:"createdBy"`
+	CreatedAt string `json:"createdAt"`
+
+	until time.Time
+}
+
+// statusAlertState follows one rule on one host.
+type statusAlertState struct {
+	pendingSince time.Time
+	firingSince  time.Time
+	firing       bool
+	// notified is set once the firing notification went out, so a recovery is only
+	// announced for alerts that were announced.
+	notified bool
+	values   map[string]float64
+}
+
+var (
+	statusAlertsMu      sync.Mutex
+	statusAlertRules    []StatusAlertRule
+	statusAlertSilences []StatusAlertSilence
+	statusAlertStates   = map[string]*statusAlertState{}
+)
+
+func statusAlertStateKey(ruleID, host string) string {
+	return ruleID + "|" + strings.ToLower(host)
+}
+
+// initStatusAlerts creates the rule tables in the status database and loads them.
+func initStatusAlerts() error {
+	statements := []string{
+		`CREATE TABLE IF NOT EXISTS status_alert_rules (
+			id TEXT PRIMARY KEY,
+			name TEXT NOT NULL,
+			host TEXT NOT NULL,
+			conditions TEXT NOT NULL,
+			for_seconds INTEGER NOT NULL DEFAULT 0,
+			severity TEXT NOT NULL,
+			notifiers TEXT NOT NULL DEFAULT '[]',
+			enabled INTEGER NOT NULL DEFAULT 1,
+			created_by TEXT NOT NULL,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		)`,
+		`CREATE TABLE IF NOT EXISTS status_alert_silences (
+			id TEXT PRIMARY KEY,
+			rule_id TEXT NOT NULL DEFAULT '',
+			host TEXT NOT NULL DEFAULT '',
+			until INTEGER NOT NULL,
+			reason TEXT NOT NULL DEFAULT '',
+			created_by TEXT NOT NULL,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		)`,
+	}
+	for _, stmt := range statements {
+		if _, err := statusDB.Exec(stmt); err != nil {
+			return fmt.Errorf("ステータスアラートのスキーマ作成に失敗しました: %w", err)
+		}
+	}
+	return loadStatusAlerts()
+}
+
+// loadStatusAlerts refreshes the cached rules and silences from the database and drops
+// the state of rules that were deleted, disabled or moved to other hosts.
+func loadStatusAlerts() error {
+	rows, err := statusDB.Query(`SELECT id, name, host, conditions, for_seconds, severity, notifiers, enabled,
+		created_by, created_at, updated_at FROM status_alert_rules ORDER BY created_at, id`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	rules := []StatusAlertRule{}
+	for rows.Next() {
+		var rule StatusAlertRule
+		var conditions, notifiers string
+		if err := rows.Scan(&rule.ID, &rule.Name, &rule.Host, &conditions, &rule.ForSeconds, &rule.Severity,
+			&notifiers, &rule.Enabled, &rule.CreatedBy, &rule.CreatedAt, &rule.UpdatedAt); err != nil {
+			return err
+		}
+		if err := json.Unmarshal([]byte(conditions), &rule.Conditions); err != nil {
+			return fmt.Errorf("ルール %s の条件を読み込めません: %w", rule.ID, err)
+		}
+		if err := json.Unmarshal([]byte(notifiers), &rule.Notifiers); err != nil {
+			return fmt.Errorf("ルール %s の通知先を読み込めません: %w", rule.ID, err)
+		}
+		rules = append(rules, rule)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	if _, err := statusDB.Exec(`DELETE FROM status_alert_silences WHERE until <= ?`, time.Now().Unix()); err != nil {
+		return err
+	}
+	silenceRows, err := statusDB.Query(`SELECT id, rule_id, host, until, reason, created_by, created_at
+		FROM status_alert_silences ORDER BY until`)
+	if err != nil {
+		return err
+	}
+	defer silenceRows.Close()
+
+	silences := []StatusAlertSilence{}
+	for silenceRows.Next() {
+		var silence StatusAlertSilence
+		var until int64
+		if err := silenceRows.Scan(&silence.ID, &silence.RuleID, &silence.Host, &until, &silence.Reason,
+			&silence.CreatedBy, &silence.CreatedAt); err != nil {
+			return err
+		}
+		silence.until = time.Unix(until, 0)
+		silence.Until = silence.until.UTC().Format(time.RFC3339)
+		silences = append(silences, silence)
+	}
+	if err := silenceRows.Err(); err != nil {
+		return err
+	}
+
+	statusAlertsMu.Lock()
+	defer statusAlertsMu.Unlock()
+	statusAlertRules, statusAlertSilences = rules, silences
+	for key := range statusAlertStates {
+		ruleID, host, _ := strings.Cut(key, "|")
+		keep := false
+		for i := range rules {
+			if rules[i].ID == ruleID && rules[i].Enabled && rules[i].appliesTo(host) {
+				keep = true
+			}
+		}
+		if !keep {
+			delete(statusAlertStates, key)
+		}
+	}
+	return nil
+}
+
+// statusAlertValues extracts every metric a condition can test from a sample. Metrics
+// the host does not have, such as a battery, are left out and never match.
+func statusAlertValues(details *getstatus.StatusV2) map[string]float64 {
+	values := statusMetrics(details)
+	if details.CPU.Load != nil {
+		values[metricLoad1] = details.CPU.Load.Load1
+	}
+	if details.Battery != nil {
+		values[metricBattery] = details.Battery.Percent
+		values[metricCharging] = boolMetric(details.Battery.Charging)
+	}
+	values[metricWAN] = boolMetric(details.WAN.Online)
+	for _, gpu := range details.GPUs {
+		values[metricGPU] = math.Max(values[metricGPU], gpu.Percent)
+		if gpu.VRAMTotalBytes > 0 {
+			values[metricVRAM] = math.Max(values[metricVRAM], float64(gpu.VRAMUsedBytes)/float64(gpu.VRAMTotalBytes)*100)
+		}
+	}
+	for _, sensor := range details.Temperatures {
+		values[metricTemp] = math.Max(values[metricTemp], sensor.Celsius)
+	}
+	return values
+}
+
+func boolMetric(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// conditionValue returns the value a condition tests, looking up its mount when set.
+func conditionValue(c AlertCondition, details *getstatus.StatusV2, values map[string]float64) (float64, bool) {
+	if c.Metric == metricDisk && c.Mount != "" {
+		trim := func(p string) string {
+			if len(p) > 1 {
+				return strings.TrimRight(p, `\/`)
+			}
+			return p
+		}
+		for _, mount := range details.Mounts {
+			if strings.EqualFold(trim(mount.Mount), trim(c.Mount)) {
+				return mount.Percent, true
+			}
+		}
+		return 0, false
+	}
+	value, ok := values[c.key()]
+	return value, ok
+}
+
+// evaluateStatusAlerts checks every rule for host against a new sample and sends the
+// notifications for rules that started or stopped firing.
+func evaluateStatusAlerts(host string, details *getstatus.StatusV2, now time.Time) {
+	if details == nil {
+		return
+	}
+	values := statusAlertValues(details)
+
+	type pendingAlert struct {
+		rule  StatusAlertRule
+		alert notify.Alert
+	}
+	var outgoing []pendingAlert
+
+	statusAlertsMu.Lock()
+	for i := range statusAlertRules {
+		rule := &statusAlertRules[i]
+		if !rule.Enabled || !rule.appliesTo(host) {
+			continue
+		}
+
+		matched := true
+		observed := map[string]float64{}
+		for _, c := range rule.Conditions {
+			value, ok := conditionValue(c, details, values)
+			if ok {
+				observed[c.key()] = value
+			}
+			if !ok || !statusAlertOperators[c.Op](value, c.Value) {
+				matched = false
+			}
+		}
+
+		key := statusAlertStateKey(rule.ID, host)
+		state := statusAlertStates[key]
+		if state == nil {
+			if !matched {
+				continue
+			}
+			state = &statusAlertState{}
+			statusAlertStates[key] = state
+		}
+		state.values = observed
+		silenced := statusAlertSilencedLocked(rule.ID, host, now)
+
+		if matched {
+			if state.pendingSince.IsZero() {
+				state.pendingSince = now
+			}
+			if !state.firing && now.Sub(state.pendingSince) >= time.Duration(rule.ForSeconds)*time.Second {
+				state.firing, state.firingSince = true, now
+				log.Printf("[WARN] ステータスアラートが発生しました: ルール=%s ホスト=%s 条件=%s", rule.Name, host, rule.condition())
+			}
+			// A silence that ends while the rule is still firing lets the alert through then.
+			if state.firing && !state.notified && !silenced {
+				state.notified = true
+				outgoing = append(outgoing, pendingAlert{*rule, statusAlert(rule, host, notify.StateFiring, rule.Severity, state, now)})
+			}
+			continue
+		}
+
+		if state.firing {
+			log.Printf("[INFO] ステータスアラートが復旧しました: ルール=%s ホスト=%s", rule.Name, host)
+			if state.notified && !silenced {
+				outgoing = append(outgoing, pendingAlert{*rule, statusAlert(rule, host, notify.StateResolved, "info", state, now)})
+			}
+		}
+		delete(statusAlertStates, key)
+	}
+	statusAlertsMu.Unlock()
+
+	for _, item := range outgoing {
+		dispatchStatusAlert(item.rule.Notifiers, item.alert)
+	}
+}
+
+func statusAlert(rule *StatusAlertRule, host, state, level string, s *statusAlertState, now time.Time) notify.Alert {
+	return notify.Alert{
+		Level: level,
+		Time:  now,
+		Status: &notify.StatusAlert{
+			RuleID:    rule.ID,
+			Rule:      rule.Name,
+			Host:      host,
+			State:     state,
+			Condition: rule.condition(),
+			Values:    s.values,
+			Since:     s.firingSince,
+		},
+	}
+}
+
+func statusAlertSilencedLocked(ruleID, host string, now time.Time) bool {
+	for _, silence := range statusAlertSilences {
+		if now.Before(silence.until) && (silence.RuleID == "" || silence.RuleID == ruleID) &&
+			(silence.Host == "" || strings.EqualFold(silence.Host, host)) {
+			return true
+		}
+	}
+	return false
+}
+
+// statusNotifiers holds one dispatcher per configured notifier, keyed by name, so each
+// rule can pick its own. Status alerts are sent on every state change, so
+// deduplication is off and every level is routed.
+var (
+	statusNotifiersMu sync.Mutex
+	statusNotifiers   = map[string]*notify.Dispatcher{}
+)
+
+func notifierName(c notify.Config) string {
+	if c.Name != "" {
+		return c.Name
+	}
+	return c.Type
+}
+
+// replaceStatusNotifiers rebuilds the status alert dispatchers from the security
+// configuration's notifiers.
+func replaceStatusNotifiers(cfg *SecurityConfig) {
+	next := map[string]*notify.Dispatcher{}
+	for _, c := range alertNotifierConfigs(cfg) {
+		c.Levels = []string{"info", "warn", "error"}
+		c.DedupWindowSec = -1
+		next[notifierName(c)] = notify.NewDispatcher([]notify.Config{c})
+	}
+
+	statusNotifiersMu.Lock()
+	prev := statusNotifiers
+	statusNotifiers = next
+	statusNotifiersMu.Unlock()
+	go stopStatusNotifiers(prev)
+}
+
+func stopStatusNotifiers(dispatchers map[string]*notify.Dispatcher) {
+	for _, d := range dispatchers {
+		d.Stop()
+	}
+}
+
+func statusNotifierNames() []string {
+	statusNotifiersMu.Lock()
+	defer statusNotifiersMu.Unlock()
+	names := make([]string, 0, len(statusNotifiers))
+	for name := range statusNotifiers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// dispatchStatusAlert sends an alert to the named notifiers, or to all of them.
+func dispatchStatusAlert(names []string, alert notify.Alert) {
+	statusNotifiersMu.Lock()
+	defer statusNotifiersMu.Unlock()
+	if len(names) == 0 {
+		for _, d := range statusNotifiers {
+			d.Dispatch(alert)
+		}
+		return
+	}
+	for _, name := range names {
+		if d, ok := statusNotifiers[name]; ok {
+			d.Dispatch(alert)
+		} else {
+			log.Printf("[WARN] ステータスアラートの通知先が見つかりません: %s", name)
+		}
+	}
+}
+
+// statusAlertRuleRequest is the body for creating or updating a rule. Enabled defaults
+// to true on creation and is kept on update when omitted.
+type statusAlertRuleRequest struct {
+	ID         string           `json:"id"`
+	Name       string           `json:"name"`
+	Host       string           `json:"host"`
+	Conditions []AlertCondition `json:"conditions"`
+	ForSeconds int              `json:"forSeconds"`
+	Severity   string           `json:"severity"`
+	Notifiers  []string         `json:"notifiers"`
+	Enabled    *bool            `json:"enabled"`
+}
+
+// validate normalises the request and reports the first problem.
+func (req *statusAlertRuleRequest) validate() error {
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" || utf8.RuneCountInString(req.Name) > 100 {
+		return fmt.Errorf("name は1〜100文字で指定してください")
+	}
+
+	req.Host = strings.TrimSpace(req.Host)
+	switch {
+	case req.Host == "" || strings.EqualFold(req.Host, localHostName):
+		req.Host = localHostName
+	case req.Host == allHosts:
+	case !validHostName(req.Host):
+		return fmt.Errorf("host が不正です: %q", req.Host)
+	}
+
+	if len(req.Conditions) == 0 || len(req.Conditions) > maxStatusAlertConditions {
+		return fmt.Errorf("conditions は1〜%d件で指定してください", maxStatusAlertConditions)
+	}
+	for i, c := range req.Conditions {
+		if !statusAlertMetrics[c.Metric] {
+			return fmt.Errorf("conditions[%d]: metric が不正です: %q", i, c.Metric)
+		}
+		if _, ok := statusAlertOperators[c.Op]; !ok {
+			return fmt.Errorf("conditions[%d]: op は >, >=, <, <=, ==, != のいずれかで指定してください", i)
+		}
+		if c.Mount != "" && (c.Metric != metricDisk || len(c.Mount) > 256) {
+			return fmt.Errorf("conditions[%d]: mount は disk にのみ指定できます", i)
+		}
+		if _, isProbe := statusProbeMetricUnits[c.Metric]; isProbe != (c.Probe != "") || len(c.Probe) > 64 {
+			return fmt.Errorf("conditions[%d]: probe は probe_up, probe_latency, probe_loss に必ず指定し、それ以外には指定できません", i)
+		}
+		if math.IsNaN(c.Value) || math.IsInf(c.Value, 0) {
+			return fmt.Errorf("conditions[%d]: value が不正です", i)
+		}
+	}
+
+	if req.ForSeconds < 0 || time.Duration(req.ForSeconds)*time.Second > maxStatusAlertFor {
+		return fmt.Errorf("forSeconds は0〜%d秒で指定してください", int(maxStatusAlertFor/time.Second))
+	}
+
+	switch req.Severity {
+	case "":
+		req.Severity = "warn"
+	case "warn", "error":
+	default:
+		return fmt.Errorf("severity は warn または error で指定してください")
+	}
+
+	if req.Notifiers == nil {
+		req.Notifiers = []string{}
+	}
+	known := statusNotifierNames()
+	for _, name := range req.Notifiers {
+		if i := sort.SearchStrings(known, name); i == len(known) || known[i] != name {
+			return fmt.Errorf("通知先が見つかりません: %q", name)
+		}
+	}
+	return nil
+}
+
+// StatusAlertRuleView is a rule with its current state on each host it watches.
+type StatusAlertRuleView struct {
+	StatusAlertRule
+	States []StatusAlertStateView `json:"states"`
+}
+
+// StatusAlertStateView is the state of a rule on one host: pending while its
+// conditions hold for less than forSeconds, then firing.
+type StatusAlertStateView struct {
+	Host     string             `json:"host"`
+	State    string             `json:"state"`
+	Since    string             `json:"since"`
+	Values   map[string]float64 `json:"values"`
+	Silenced bool               `json:"silenced"`
+}
+
+func listStatusAlerts(now time.Time) ([]StatusAlertRuleView, []StatusAlertSilence) {
+	statusAlertsMu.Lock()
+	defer statusAlertsMu.Unlock()
+
+	views := make([]StatusAlertRuleView, 0, len(statusAlertRules))
+	for _, rule := range statusAlertRules {
+		view := StatusAlertRuleView{StatusAlertRule: rule, States: []StatusAlertStateView{}}
+		for key, state := range statusAlertStates {
+			ruleID, host, _ := strings.Cut(key, "|")
+			if ruleID != rule.ID {
+				continue
+			}
+			entry := StatusAlertStateView{
+				Host:     host,
+				State:    "pending",
+				Since:    state.pendingSince.UTC().Format(time.RFC3339),
+				Values:   state.values,
+				Silenced: statusAlertSilencedLocked(rule.ID, host, now),
+			}
+			if state.firing {
+				entry.State, entry.Since = notify.StateFiring, state.firingSince.UTC().Format(time.RFC3339)
+			}
+			view.States = append(view.States, entry)
+		}
+		sort.Slice(view.States, func(i, j int) bool { return view.States[i].Host < view.States[j].Host })
+		views = append(views, view)
+	}
+
+	silences := make([]StatusAlertSilence, 0, len(statusAlertSilences))
+	for _, silence := range statusAlertSilences {
+		if now.Before(silence.until) {
+			silences = append(silences, silence)
+		}
+	}
+	return views, silences
+}
+
+func statusAlertRuleExists(id string) bool {
+	statusAlertsMu.Lock()
+	defer statusAlertsMu.Unlock()
+	for _, rule := range statusAlertRules {
+		if rule.ID == id {
+			return true
+		}
+	}
+	return false
+}
+
+// handleStatusAlerts lists the rules with their state (GET) or creates a rule (POST).
+func handleStatusAlerts(w http.ResponseWriter, r *http.Request) {
+	actor, ok := requireAdmin(w, r)
+	if !ok {
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		rules, silences := listStatusAlerts(time.Now())
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			keySuccess:  true,
+			"rules":     rules,
+			"silences":  silences,
+			"notifiers": statusNotifierNames(),
+		})
+	case http.MethodPost:
+		var req statusAlertRuleRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid JSON", http.StatusBadRequest)
+			return
+		}
+		if err := req.validate(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		var count int
+		if err := statusDB.QueryRow(`SELECT COUNT(*) FROM status_alert_rules`).Scan(&count); err != nil {
+			log.Printf("[ERROR] ステータスアラートの件数取得に失敗しました: %v", err)
+			http.Error(w, "ルールの作成に失敗しました", http.StatusInternalServerError)
+			return
+		}
+		if count >= maxStatusAlertRules {
+			http.Error(w, fmt.Sprintf("ルールは最大%d件までです", maxStatusAlertRules), http.StatusConflict)
+			return
+		}
+
+		enabled := req.Enabled == nil || *req.Enabled
+		conditions, _ := json.Marshal(req.Conditions)
+		notifiers, _ := json.Marshal(req.Notifiers)
+		id := uuid.New().String()
+		if _, err := statusDB.Exec(`INSERT INTO status_alert_rules
+			(id, name, host, conditions, for_seconds, severity, notifiers, enabled, created_by)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			id, req.Name, req.Host, string(conditions), req.ForSeconds, req.Severity, string(notifiers), enabled, actor); err != nil {
+			log.Printf("[ERROR] ステータスアラートの作成に失敗しました: %v", err)
+			http.Error(w, "ルールの作成に失敗しました", http.StatusInternalServerError)
+			return
+		}
+		respondStatusAlertChange(w, r, actor, "status.alert.create", id, http.StatusCreated)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleStatusAlertUpdate replaces a rule's settings.
+func handleStatusAlertUpdate(w http.ResponseWriter, r *http.Request) {
+	actor, ok := requireAdmin(w, r)
+	if !ok {
+		return
+	}
+	if r.Method != http.MethodPost && r.Method != http.MethodPut {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req statusAlertRuleRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	if req.ID == "" {
+		http.Error(w, "id が必要です", http.StatusBadRequest)
+		return
+	}
+	if err := req.validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	conditions, _ := json.Marshal(req.Conditions)
+	notifiers, _ := json.Marshal(req.Notifiers)
+	res, err := statusDB.Exec(`UPDATE status_alert_rules SET name = ?, host = ?, conditions = ?, for_seconds = ?,
+		severity = ?, notifiers = ?, enabled = COALESCE(?, enabled), updated_at = CURRENT_TIMESTAMP WHERE id = ?`,
+		req.Name, req.Host, string(conditions), req.ForSeconds, req.Severity, string(notifiers), req.Enabled, req.ID)
+	if err != nil {
+		log.Printf("[ERROR] ステータスアラートの更新に失敗しました (%s): %v", req.ID, err)
+		http.Error(w, "ルールの更新に失敗しました", http.StatusInternalServerError)
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		http.Error(w, "ルールが見つかりません", http.StatusNotFound)
+		return
+	}
+	respondStatusAlertChange(w, r, actor, "status.alert.update", req.ID, http.StatusOK)
+}
+
+// handleStatusAlertDelete deletes a rule and the silences that only applied to it.
+func handleStatusAlertDelete(w http.ResponseWriter, r *http.Request) {
+	actor, ok := requireAdmin(w, r)
+	if !ok {
+		return
+	}
+	if r.Method != http.MethodPost && r.Method != http.MethodDelete {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		ID string `json:"id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.ID == "" {
+		http.Error(w, "id が必要です", http.StatusBadRequest)
+		return
+	}
+	res, err := statusDB.Exec(`DELETE FROM status_alert_rules WHERE id = ?`, req.ID)
+	if err != nil {
+		log.Printf("[ERROR] ステータスアラートの削除に失敗しました (%s): %v", req.ID, err)
+		http.Error(w, "ルールの削除に失敗しました", http.StatusInternalServerError)
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		http.Error(w, "ルールが見つかりません", http.StatusNotFound)
+		return
+	}
+	if _, err := statusDB.Exec(`DELETE FROM status_alert_silences WHERE rule_id = ?`, req.ID); err != nil {
+		log.Printf("[WARN] ルールのサイレンス削除に失敗しました (%s): %v", req.ID, err)
+	}
+	respondStatusAlertChange(w, r, actor, "status.alert.delete", req.ID, http.StatusOK)
+}
+
+// handleStatusAlertSilences lists active silences (GET) or adds one (POST). A silence
+// lasts "minutes" or until the RFC 3339 time "until".
+func handleStatusAlertSilences(w http.ResponseWriter, r *http.Request) {
+	actor, ok := requireAdmin(w, r)
+	if !ok {
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		_, silences := listStatusAlerts(time.Now())
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			keySuccess: true,
+			"silences": silences,
+		})
+	case http.MethodPost:
+		var req struct {
+			RuleID  string `json:"ruleId"`
+			Host    string `json:"host"`
+			Minutes int    `json:"minutes"`
+			Until   string `json:"until"`
+			Reason  string `json:"reason"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid JSON", http.StatusBadRequest)
+			return
+		}
+
+		now := time.Now()
+		var until time.Time
+		switch {
+		case req.Until != "":
+			parsed, err := time.Parse(time.RFC3339, req.Until)
+			if err != nil {
+				http.Error(w, "until は RFC 3339 形式で指定してください", http.StatusBadRequest)
+				return
+			}
+			until = parsed
+		case req.Minutes > 0:
+			until = now.Add(time.Duration(req.Minutes) * time.Minute)
+		default:
+			http.Error(w, "minutes または until が必要です", http.StatusBadRequest)
+			return
+		}
+		if !until.After(now) || until.Sub(now) > maxStatusAlertSilence {
+			http.Error(w, "サイレンスの期間は最大30日です", http.StatusBadRequest)
+			return
+		}
+		if req.RuleID != "" && !statusAlertRuleExists(req.RuleID) {
+			http.Error(w, "ルールが見つかりません", http.StatusNotFound)
+			return
+		}
+		req.Host = strings.TrimSpace(req.Host)
+		if req.Host != "" && !strings.EqualFold(req.Host, localHostName) && !validHostName(req.Host) {
+			http.Error(w, "host が不正です", http.StatusBadRequest)
+			return
+		}
+		req.Reason = strings.TrimSpace(req.Reason)
+		if utf8.RuneCountInString(req.Reason) > 200 {
+			http.Error(w, "reason は200文字以内で指定してください", http.StatusBadRequest)
+			return
+		}
+
+		id := uuid.New().String()
+		if _, err := statusDB.Exec(`INSERT INTO status_alert_silences (id, rule_id, host, until, reason, created_by)
+			VALUES (?, ?, ?, ?, ?, ?)`, id, req.RuleID, req.Host, until.Unix(), req.Reason, actor); err != nil {
+			log.Printf("[ERROR] サイレンスの作成に失敗しました: %v", err)
+			http.Error(w, "サイレンスの作成に失敗しました", http.StatusInternalServerError)
+			return
+		}
+		respondStatusAlertChange(w, r, actor, "status.alert.silence", id, http.StatusCreated)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleStatusAlertSilenceDelete ends a silence early.
+func handleStatusAlertSilenceDelete(w http.ResponseWriter, r *http.Request) {
+	actor, ok := requireAdmin(w, r)
+	if !ok {
+		return
+	}
+	if r.Method != http.MethodPost && r.Method != http.MethodDelete {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		ID string `json:"id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.ID == "" {
+		http.Error(w, "id が必要です", http.StatusBadRequest)
+		return
+	}
+	res, err := statusDB.Exec(`DELETE FROM status_alert_silences WHERE id = ?`, req.ID)
+	if err != nil {
+		log.Printf("[ERROR] サイレンスの削除に失敗しました (%s): %v", req.ID, err)
+		http.Error(w, "サイレンスの削除に失敗しました", http.StatusInternalServerError)
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		http.Error(w, "サイレンスが見つかりません", http.StatusNotFound)
+		return
+	}
+	respondStatusAlertChange(w, r, actor, "status.alert.unsilence", req.ID, http.StatusOK)
+}
+
+// respondStatusAlertChange reloads the cache after a change, audits it and answers with
+// the current rules and silences.
+func respondStatusAlertChange(w http.ResponseWriter, r *http.Request, actor, action, id string, status int) {
+	if err := loadStatusAlerts(); err != nil {
+		log.Printf("[ERROR] ステータスアラートの再読み込みに失敗しました: %v", err)
+		http.Error(w, "ステータスアラートの再読み込みに失敗しました", http.StatusInternalServerError)
+		return
+	}
+	auditAdminAction(r, actor, action, id)
+	rules, silences := listStatusAlerts(time.Now())
+	writeJSON(w, status, map[string]interface{}{
+		keySuccess: true,
+		"id":       id,
+		"rules":    rules,
+		"silences": silences,
+	})
+}
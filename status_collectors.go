@@ -0,0 +1,39 @@
+// 2025 TabDock: darui3018823 All rights reserved.
+// All works created by darui3018823 associated with this repository are the intellectual property of darui3018823.
+// Packages and other third-party materials used in this repository are subject to their respective licenses and copyrights.
+
+package main
+
+import (
+	"errors"
+	"log"
+	"os"
+
+	"tabdock/getstatus"
+)
+
+const defaultStatusCollectorsPath = "./json/status_collectors.json"
+
+// statusCollectorsFile is the layout of the custom status field configuration.
+type statusCollectorsFile struct {
+	Collectors []getstatus.CollectorConfig `json:"collectors"`
+}
+
+// configureStatusCollectors sets up the custom status fields from
+// STATUS_COLLECTORS_PATH. Without the file no custom fields are reported.
+func configureStatusCollectors() {
+	path := getEnv("STATUS_COLLECTORS_PATH", defaultStatusCollectorsPath)
+	var file statusCollectorsFile
+	err := readStrictJSON(path, &file)
+	if errors.Is(err, os.ErrNotExist) {
+		return
+	}
+	if err == nil {
+		err = getstatus.ConfigureCollectors(file.Collectors)
+	}
+	if err != nil {
+		log.Printf("[WARN] カスタムステータス設定 %s を読み込めませんでした。カスタム項目は表示されません: %v", path, err)
+		return
+	}
+	log.Printf("[INFO] カスタムステータス項目を%d件設定しました (%s)", len(file.Collectors), path)
+}
@@ -0,0 +1,397 @@
+// 2025 TabDock: darui3018823 All rights reserved.
+// All works created by darui3018823 associated with this repository are the intellectual property of darui3018823.
+// Packages and other third-party materials used in this repository are subject to their respective licenses and copyrights.
+
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"tabdock/getstatus"
+)
+
+// Status history metrics. Percentages are 0-100; network rates are bytes per second
+// summed over all non-loopback interfaces.
+const (
+	metricCPU   = "cpu"
+	metricMem   = "mem"
+	metricSwap  = "swap"
+	metricDisk  = "disk"
+	metricNetRx = "net_rx"
+	metricNetTx = "net_tx"
+)
+
+// Connectivity probe metrics, recorded per probe as "<metric>:<probe name>". Up is 1
+// or 0, so its average over a range is the availability.
+const (
+	metricProbeUp      = "probe_up"
+	metricProbeLatency = "probe_latency"
+	metricProbeLoss    = "probe_loss"
+)
+
+var statusMetricUnits = map[string]string{
+	metricCPU:   "percent",
+	metricMem:   "percent",
+	metricSwap:  "percent",
+	metricDisk:  "percent",
+	metricNetRx: "bytes_per_sec",
+	metricNetTx: "bytes_per_sec",
+}
+
+var statusProbeMetricUnits = map[string]string{
+	metricProbeUp:      "ratio",
+	metricProbeLatency: "milliseconds",
+	metricProbeLoss:    "percent",
+}
+
+func probeMetricKey(metric, probe string) string {
+	return metric + ":" + probe
+}
+
+// statusTier is one resolution of the history. Raw samples are rolled up into
+// minutes, and minutes into hours; each tier is pruned after its retention.
+type statusTier struct {
+	id        int
+	name      string
+	step      time.Duration
+	retention time.Duration
+}
+
+var statusTiers []statusTier
+
+// maxHistoryPoints caps how many points one history response may contain. Longer
+// ranges use a coarser tier, and points are merged when even that has too many.
+const maxHistoryPoints = 1500
+
+var statusDB *sql.DB
+
+// initStatusHistory opens the status history database.
+func initStatusHistory() error {
+	path := getEnv("DB_STATUS_PATH", "./database/status.db")
+	var err error
+	statusDB, err = sql.Open(sqliteDriverName, path)
+	if err != nil {
+		return err
+	}
+	statusDB.SetMaxOpenConns(1)
+
+	interval := statusSampleInterval()
+	statusTiers = []statusTier{
+		{id: 0, name: "raw", step: interval, retention: time.Duration(envPositiveInt("STATUS_HISTORY_RAW_HOURS", 24)) * time.Hour},
+		{id: 1, name: "1m", step: time.Minute, retention: time.Duration(envPositiveInt("STATUS_HISTORY_MINUTE_DAYS", 7)) * 24 * time.Hour},
+		{id: 2, name: "1h", step: time.Hour, retention: time.Duration(envPositiveInt("STATUS_HISTORY_HOUR_DAYS", 365)) * 24 * time.Hour},
+	}
+
+	statements := []string{
+		`PRAGMA journal_mode = WAL`,
+		`PRAGMA busy_timeout = 5000`,
+		`CREATE TABLE IF NOT EXISTS status_history (
+			tier INTEGER NOT NULL,
+			metric TEXT NOT NULL,
+			ts INTEGER NOT NULL,
+			avg REAL NOT NULL,
+			min REAL NOT NULL,
+			max REAL NOT NULL,
+			samples INTEGER NOT NULL,
+			PRIMARY KEY (tier, metric, ts)
+		) WITHOUT ROWID`,
+		`CREATE INDEX IF NOT EXISTS idx_status_history_ts ON status_history(tier, ts)`,
+	}
+	for _, stmt := range statements {
+		if _, err := statusDB.Exec(stmt); err != nil {
+			return fmt.Errorf("ステータス履歴DBのスキーマ作成に失敗しました: %w", err)
+		}
+	}
+	return nil
+}
+
+func statusSampleInterval() time.Duration {
+	return time.Duration(envPositiveInt("STATUS_SAMPLE_SEC", 10)) * time.Second
+}
+
+// startStatusSampler collects a status snapshot every interval, publishes it to the
+// stream clients, checks the alert rules and records its metrics into the history.
+func startStatusSampler() {
+	interval := statusSampleInterval()
+	log.Printf("[INFO] ステータス履歴の記録を開始します (間隔: %s)", interval)
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			sampleStatus(time.Now())
+			<-ticker.C
+		}
+	}()
+}
+
+func sampleStatus(now time.Time) {
+	base, status, err := getstatus.Collect(defaultStatusTopProcesses)
+	if err != nil {
+		log.Printf("[WARN] ステータスの取得に失敗しました: %v", err)
+		return
+	}
+	statusStream.publish(base, status, now)
+	evaluateStatusAlerts(localHostName, status, now)
+
+	if err := recordStatusSample(now, statusMetrics(status)); err != nil {
+		log.Printf("[WARN] ステータス履歴の書き込みに失敗しました: %v", err)
+	}
+	if err := rollupStatusHistory(now); err != nil {
+		log.Printf("[WARN] ステータス履歴の集約に失敗しました: %v", err)
+	}
+}
+
+// statusMetrics extracts the recorded metrics from a snapshot. Metrics that are not
+// available, such as network rates on the first sample, are left out.
+func statusMetrics(status *getstatus.StatusV2) map[string]float64 {
+	metrics := map[string]float64{
+		metricCPU: status.CPU.Percent,
+		metricMem: status.Memory.Percent,
+	}
+	if status.Swap != nil && status.Swap.TotalBytes > 0 {
+		metrics[metricSwap] = status.Swap.Percent
+	}
+	if len(status.Mounts) > 0 {
+		metrics[metricDisk] = status.Mounts[0].Percent
+	}
+	var rx, tx float64
+	haveRates := false
+	for _, iface := range status.Network {
+		if iface.Loopback || iface.RxBytesPerSec == nil {
+			continue
+		}
+		rx += *iface.RxBytesPerSec
+		tx += *iface.TxBytesPerSec
+		haveRates = true
+	}
+	if haveRates {
+		metrics[metricNetRx] = rx
+		metrics[metricNetTx] = tx
+	}
+	for _, probe := range status.WAN.Probes {
+		if probe.CheckedAt == nil {
+			continue
+		}
+		metrics[probeMetricKey(metricProbeUp, probe.Name)] = boolMetric(probe.Up)
+		metrics[probeMetricKey(metricProbeLoss, probe.Name)] = probe.LossPercent
+		if probe.LatencyMs != nil {
+			metrics[probeMetricKey(metricProbeLatency, probe.Name)] = *probe.LatencyMs
+		}
+	}
+	return metrics
+}
+
+func recordStatusSample(now time.Time, metrics map[string]float64) error {
+	tx, err := statusDB.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	stmt, err := tx.Prepare(`INSERT OR REPLACE INTO status_history (tier, metric, ts, avg, min, max, samples)
+		VALUES (0, ?, ?, ?, ?, ?, 1)`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	ts := now.Unix()
+	for metric, value := range metrics {
+		if _, err := stmt.Exec(metric, ts, value, value, value); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// rollupStatusHistory aggregates every finished minute and hour that has not been
+// rolled up yet, then prunes each tier past its retention. Re-running it is harmless.
+func rollupStatusHistory(now time.Time) error {
+	for i := 1; i < len(statusTiers); i++ {
+		src, dst := statusTiers[i-1], statusTiers[i]
+		step := int64(dst.step / time.Second)
+		end := now.Unix() / step * step
+
+		var last sql.NullInt64
+		if err := statusDB.QueryRow(`SELECT MAX(ts) FROM status_history WHERE tier = ?`, dst.id).Scan(&last); err != nil {
+			return err
+		}
+		start := int64(0)
+		if last.Valid {
+			start = last.Int64 + step
+		}
+		if start >= end {
+			continue
+		}
+
+		_, err := statusDB.Exec(`INSERT OR REPLACE INTO status_history (tier, metric, ts, avg, min, max, samples)
+			SELECT ?, metric, (ts / ?) * ?, SUM(avg * samples) / SUM(samples), MIN(min), MAX(max), SUM(samples)
+			FROM status_history
+			WHERE tier = ? AND ts >= ? AND ts < ?
+			GROUP BY metric, ts / ?`,
+			dst.id, step, step, src.id, start, end, step)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, tier := range statusTiers {
+		cutoff := now.Add(-tier.retention).Unix()
+		if _, err := statusDB.Exec(`DELETE FROM status_history WHERE tier = ? AND ts < ?`, tier.id, cutoff); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// HistoryPoint is one point of a metric history.
+type HistoryPoint struct {
+	Timestamp int64   `json:"ts"`
+	Avg       float64 `json:"avg"`
+	Min       float64 `json:"min"`
+	Max       float64 `json:"max"`
+}
+
+// parseHistoryRange accepts Go durations such as "90m" or "24h" and whole days such as "7d".
+func parseHistoryRange(raw string) (time.Duration, error) {
+	if days, ok := strings.CutSuffix(raw, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil || n <= 0 {
+			return 0, fmt.Errorf("invalid range %q", raw)
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	d, err := time.ParseDuration(raw)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("invalid range %q", raw)
+	}
+	return d, nil
+}
+
+// pickStatusTier returns the finest tier that still covers the range within
+// maxHistoryPoints, falling back to the coarsest one.
+func pickStatusTier(span time.Duration) statusTier {
+	for _, tier := range statusTiers {
+		if span <= tier.retention && span/tier.step <= maxHistoryPoints {
+			return tier
+		}
+	}
+	return statusTiers[len(statusTiers)-1]
+}
+
+// handleStatusHistory returns the history of one metric over a range for charts.
+func handleStatusHistory(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodHead {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := r.URL.Query()
+	metric, probe := query.Get("metric"), query.Get("probe")
+	key := metric
+	unit, ok := statusMetricUnits[metric]
+	if probeUnit, isProbe := statusProbeMetricUnits[metric]; isProbe {
+		unit, ok = probeUnit, probe != ""
+		key = probeMetricKey(metric, probe)
+	} else if probe != "" {
+		ok = false
+	}
+	if !ok {
+		writeJSON(w, http.StatusBadRequest, map[string]interface{}{
+			keySuccess: false,
+			keyMessage: "metric は cpu, mem, swap, disk, net_rx, net_tx のいずれか、または probe と合わせて probe_up, probe_latency, probe_loss のいずれかで指定してください",
+		})
+		return
+	}
+
+	rawRange := query.Get("range")
+	if rawRange == "" {
+		rawRange = "24h"
+	}
+	span, err := parseHistoryRange(rawRange)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]interface{}{
+			keySuccess: false,
+			keyMessage: "range は 90m, 24h, 7d のように指定してください",
+		})
+		return
+	}
+
+	tier := pickStatusTier(span)
+	if name := query.Get("resolution"); name != "" {
+		found := false
+		for _, t := range statusTiers {
+			if t.name == name {
+				tier, found = t, true
+			}
+		}
+		if !found {
+			writeJSON(w, http.StatusBadRequest, map[string]interface{}{
+				keySuccess: false,
+				keyMessage: "resolution は raw, 1m, 1h のいずれかで指定してください",
+			})
+			return
+		}
+	}
+
+	// Points are merged into buckets of whole tier steps so that long ranges stay
+	// within maxHistoryPoints.
+	step := int64(tier.step / time.Second)
+	bucket := step
+	if perPoint := int64(span/time.Second) / maxHistoryPoints; perPoint > bucket {
+		bucket = (perPoint + step - 1) / step * step
+	}
+
+	now := time.Now()
+	rows, err := statusDB.Query(`SELECT (ts / ?) * ?, SUM(avg * samples) / SUM(samples), MIN(min), MAX(max)
+		FROM status_history
+		WHERE tier = ? AND metric = ? AND ts >= ?
+		GROUP BY ts / ? ORDER BY 1`,
+		bucket, bucket, tier.id, key, now.Add(-span).Unix(), bucket)
+	if err != nil {
+		log.Printf("[ERROR] ステータス履歴の取得に失敗しました: %v", err)
+		http.Error(w, "Failed to get status history", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	points := []HistoryPoint{}
+	for rows.Next() {
+		var p HistoryPoint
+		if err := rows.Scan(&p.Timestamp, &p.Avg, &p.Min, &p.Max); err != nil {
+			log.Printf("[ERROR] ステータス履歴の読み込みに失敗しました: %v", err)
+			http.Error(w, "Failed to get status history", http.StatusInternalServerError)
+			return
+		}
+		points = append(points, p)
+	}
+	if err := rows.Err(); err != nil {
+		log.Printf("[ERROR] ステータス履歴の読み込みに失敗しました: %v", err)
+		http.Error(w, "Failed to get status history", http.StatusInternalServerError)
+		return
+	}
+
+	response := map[string]interface{}{
+		keySuccess:    true,
+		"metric":      metric,
+		"unit":        unit,
+		"range":       rawRange,
+		"resolution":  tier.name,
+		"stepSeconds": bucket,
+		"points":      points,
+	}
+	if probe != "" {
+		response["probe"] = probe
+	}
+	writeJSON(w, http.StatusOK, response)
+}
@@ -0,0 +1,256 @@
+// 2025 TabDock: darui3018823 All rights reserved.
+// All works created by darui3018823 associated with this repository are the intellectual property of darui3018823.
+// Packages and other third-party materials used in this repository are subject to their respective licenses and copyrights.
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// localHostName selects this server's own status in host parameters.
+	localHostName = "local"
+
+	maxAgentPushBytes = 1 << 20
+	// minOfflineAfter is the shortest silence after which an agent counts as offline,
+	// whatever interval it announced.
+	minOfflineAfter = 30 * time.Second
+	maxRemoteHosts  = 64
+)
+
+var hostNamePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]{0,63}$`)
+
+func validHostName(name string) bool {
+	return hostNamePattern.MatchString(name) && !strings.EqualFold(name, localHostName)
+}
+
+// remoteHost is a machine reporting through "tabdock agent".
+type remoteHost struct {
+	name     string
+	hub      *statusHub
+	lastSeen time.Time
+	ip       string
+	interval time.Duration
+	version  string
+	owner    string
+	offline  bool
+}
+
+func (h *remoteHost) offlineAfter() time.Duration {
+	return max(3*h.interval, minOfflineAfter)
+}
+
+func (h *remoteHost) isOnline(now time.Time) bool {
+	return now.Sub(h.lastSeen) < h.offlineAfter()
+}
+
+var (
+	remoteHostsMu sync.Mutex
+	remoteHosts   = map[string]*remoteHost{}
+)
+
+var errUnknownHost = errors.New("unknown host")
+
+// agentForgetAfter is how long an agent is kept without a sample.
+func agentForgetAfter() time.Duration {
+	return time.Duration(envPositiveInt("AGENT_FORGET_HOURS", 24)) * time.Hour
+}
+
+// statusHubForHost returns the hub holding a host's samples, whether the host is
+// online and when it last reported. An empty name or "local" is this server.
+func statusHubForHost(name string) (*statusHub, bool, time.Time, error) {
+	if name == "" || strings.EqualFold(name, localHostName) {
+		return statusStream, true, time.Time{}, nil
+	}
+	remoteHostsMu.Lock()
+	defer remoteHostsMu.Unlock()
+	host, ok := remoteHosts[strings.ToLower(name)]
+	if !ok {
+		return nil, false, time.Time{}, errUnknownHost
+	}
+	return host.hub, host.isOnline(time.Now()), host.lastSeen, nil
+}
+
+// writeHostUnavailable answers a status request for a host that is unknown or offline.
+// It returns true when it did so.
+func writeHostUnavailable(w http.ResponseWriter, name string, online bool, lastSeen time.Time, err error) bool {
+	if errors.Is(err, errUnknownHost) {
+		writeJSON(w, http.StatusNotFound, map[string]interface{}{
+			keySuccess: false,
+			keyMessage: "ホストが見つかりません: " + name,
+		})
+		return true
+	}
+	if !online {
+		writeJSON(w, http.StatusServiceUnavailable, map[string]interface{}{
+			keySuccess: false,
+			keyMessage: "ホストがオフラインです: " + name,
+			"lastSeen": lastSeen.UTC().Format(time.RFC3339),
+		})
+		return true
+	}
+	return false
+}
+
+// handleAgentPush accepts a status sample from "tabdock agent". It requires an API
+// token with the status:push scope whose owner is an admin.
+func handleAgentPush(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	token := getBearerToken(r)
+	if token == "" {
+		http.Error(w, "APIトークンが必要です", http.StatusUnauthorized)
+		return
+	}
+	username, err := getUsernameFromAPIToken(r, token)
+	if err != nil {
+		http.Error(w, "認証情報が確認できません", http.StatusUnauthorized)
+		return
+	}
+	if role, err := getUserRole(username); err != nil || role != roleAdmin {
+		log.Printf("[ADMIN] 管理者以外のエージェント送信を拒否しました: ユーザー=%s IP=%s", username, getIPAddress(r))
+		logRequest(r, getIPAddress(r), ActionWarn)
+		http.Error(w, "管理者権限が必要です", http.StatusForbidden)
+		return
+	}
+
+	var push agentPush
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxAgentPushBytes)).Decode(&push); err != nil {
+		http.Error(w, "リクエストの形式が不正です", http.StatusBadRequest)
+		return
+	}
+	if !validHostName(push.Host) {
+		http.Error(w, "ホスト名が不正です", http.StatusBadRequest)
+		return
+	}
+	if push.Status == nil || push.Details == nil {
+		http.Error(w, "status と details が必要です", http.StatusBadRequest)
+		return
+	}
+	// The interval only widens the offline threshold; cap it at the time after which the
+	// host is forgotten anyway.
+	forgetAfter := agentForgetAfter()
+	interval := statusSampleInterval()
+	if push.IntervalSec > 0 {
+		interval = time.Duration(min(push.IntervalSec, int(forgetAfter/time.Second))) * time.Second
+	}
+
+	now := time.Now()
+	key := strings.ToLower(push.Host)
+	remoteHostsMu.Lock()
+	host, ok := remoteHosts[key]
+	if !ok {
+		if len(remoteHosts) >= maxRemoteHosts {
+			remoteHostsMu.Unlock()
+			http.Error(w, "登録できるホスト数の上限に達しています", http.StatusConflict)
+			return
+		}
+		host = &remoteHost{name: push.Host, hub: newStatusHub()}
+		remoteHosts[key] = host
+		log.Printf("[INFO] エージェントを登録しました: ホスト=%s ユーザー=%s IP=%s", push.Host, username, getIPAddress(r))
+	} else if host.offline {
+		log.Printf("[INFO] エージェントがオンラインに戻りました: ホスト=%s", host.name)
+	}
+	host.lastSeen, host.ip, host.interval = now, getIPAddress(r), interval
+	host.version, host.owner, host.offline = push.Version, username, false
+	hub, name := host.hub, host.name
+	remoteHostsMu.Unlock()
+
+	hub.publish(push.Status, push.Details, now)
+	evaluateStatusAlerts(name, push.Details, now)
+	writeJSON(w, http.StatusOK, map[string]interface{}{keySuccess: true})
+}
+
+// watchRemoteHosts logs agents that stop reporting and forgets them after
+// AGENT_FORGET_HOURS without a sample.
+func watchRemoteHosts() {
+	forgetAfter := agentForgetAfter()
+	ticker := time.NewTicker(10 * time.Second)
+	defer ticker.Stop()
+	for now := range ticker.C {
+		remoteHostsMu.Lock()
+		for key, host := range remoteHosts {
+			switch {
+			case now.Sub(host.lastSeen) >= forgetAfter:
+				delete(remoteHosts, key)
+				log.Printf("[INFO] 応答のないエージェントを削除しました: ホスト=%s", host.name)
+			case !host.offline && !host.isOnline(now):
+				host.offline = true
+				log.Printf("[WARN] エージェントがオフラインになりました: ホスト=%s 最終受信=%s", host.name,
+					host.lastSeen.Format(time.RFC3339))
+			}
+		}
+		remoteHostsMu.Unlock()
+	}
+}
+
+// StatusHost is one entry of /api/status/hosts.
+type StatusHost struct {
+	Name        string `json:"name"`
+	Local       bool   `json:"local"`
+	Online      bool   `json:"online"`
+	DisplayName string `json:"displayName"`
+	LastSeen    string `json:"lastSeen,omitempty"`
+	IntervalSec int    `json:"intervalSec"`
+	Version     string `json:"version,omitempty"`
+}
+
+// handleStatusHosts lists this server and every registered agent.
+func handleStatusHosts(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodHead {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	now := time.Now()
+	local := StatusHost{
+		Name:        localHostName,
+		Local:       true,
+		Online:      true,
+		IntervalSec: int(statusSampleInterval() / time.Second),
+		Version:     version,
+	}
+	if status, _, at := statusStream.latest(); status != nil {
+		local.DisplayName = status.PC
+		local.LastSeen = at.UTC().Format(time.RFC3339)
+	}
+
+	remoteHostsMu.Lock()
+	hosts := make([]StatusHost, 0, len(remoteHosts))
+	for _, host := range remoteHosts {
+		entry := StatusHost{
+			Name:        host.name,
+			Online:      host.isOnline(now),
+			DisplayName: host.name,
+			LastSeen:    host.lastSeen.UTC().Format(time.RFC3339),
+			IntervalSec: int(host.interval / time.Second),
+			Version:     host.version,
+		}
+		if status, _, _ := host.hub.latest(); status != nil && status.PC != "" {
+			entry.DisplayName = status.PC
+		}
+		hosts = append(hosts, entry)
+	}
+	remoteHostsMu.Unlock()
+	sort.Slice(hosts, func(i, j int) bool { return hosts[i].Name < hosts[j].Name })
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		keySuccess: true,
+		"hosts":    append([]StatusHost{local}, hosts...),
+	})
+}
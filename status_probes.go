@@ -0,0 +1,57 @@
+// 2025 TabDock: darui3018823 All rights reserved.
+// All works created by darui3018823 associated with this repository are the intellectual property of darui3018823.
+// Packages and other third-party materials used in this repository are subject to their respective licenses and copyrights.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+
+	"tabdock/getstatus"
+)
+
+const defaultStatusProbesPath = "./json/status_probes.json"
+
+// statusProbesFile is the layout of the connectivity probe configuration.
+type statusProbesFile struct {
+	Probes []getstatus.ProbeConfig `json:"probes"`
+}
+
+// configureStatusProbes starts the connectivity probes from STATUS_PROBES_PATH. When
+// the file is missing or invalid the default WAN probe is used.
+func configureStatusProbes() {
+	path := getEnv("STATUS_PROBES_PATH", defaultStatusProbesPath)
+	var file statusProbesFile
+	err := readStrictJSON(path, &file)
+	if errors.Is(err, os.ErrNotExist) {
+		return
+	}
+	if err == nil {
+		err = getstatus.ConfigureProbes(file.Probes)
+	}
+	if err != nil {
+		log.Printf("[WARN] 接続プローブ設定 %s を読み込めませんでした。既定のWANプローブを使用します: %v", path, err)
+		return
+	}
+	log.Printf("[INFO] 接続プローブを%d件設定しました (%s)", len(file.Probes), path)
+}
+
+// readStrictJSON decodes the configuration file at path into v, rejecting unknown
+// keys so that a misspelt option is reported instead of ignored.
+func readStrictJSON(path string, v interface{}) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(v); err != nil {
+		return fmt.Errorf("JSONの解析に失敗しました: %w", err)
+	}
+	return nil
+}
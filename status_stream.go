@@ -0,0 +1,337 @@
+// 2025 TabDock: darui3018823 All rights reserved.
+// All works created by darui3018823 associated with this repository are the intellectual property of darui3018823.
+// Packages and other third-party materials used in this repository are subject to their respective licenses and copyrights.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"tabdock/getstatus"
+)
+
+const (
+	// statusStreamBacklog is how many deltas are kept for clients resuming with Last-Event-ID.
+	statusStreamBacklog = 32
+	// statusStreamBuffer is how many events may queue for one client before it is
+	// considered slow; a slow client skips the queued deltas and gets a fresh snapshot.
+	statusStreamBuffer   = 8
+	statusStreamRetryMs  = 5000
+	statusWriteTimeout   = 10 * time.Second
+	maxStatusStreamUsers = 100
+)
+
+// statusStreamPayload is the data of a snapshot event. Delta events carry the same
+// sections with only the top-level fields that changed.
+type statusStreamPayload struct {
+	Status  *getstatus.PCStatus `json:"status"`
+	Details *getstatus.StatusV2 `json:"details"`
+}
+
+type statusEvent struct {
+	id   uint64
+	name string
+	data []byte
+}
+
+type statusStreamClient struct {
+	events chan statusEvent
+	lagged atomic.Bool
+}
+
+// statusFeed is the event stream of one audience. Signed-in and local clients get the
+// full sample; others get the public one (see publicStatus).
+type statusFeed struct {
+	fields   map[string]map[string]json.RawMessage
+	snapshot []byte
+	backlog  []statusEvent
+	clients  map[*statusStreamClient]struct{}
+}
+
+// statusHub holds the latest sample and fans it out to stream clients, so any number
+// of dashboards share one collection per sampling interval.
+type statusHub struct {
+	mu        sync.Mutex
+	id        uint64
+	at        time.Time
+	status    *getstatus.PCStatus
+	details   *getstatus.StatusV2
+	full      statusFeed
+	public    statusFeed
+	connected atomic.Int64
+}
+
+// statusStream is the hub of this server's own sampler; agents have one hub each.
+var statusStream = newStatusHub()
+
+// newStatusHub starts event IDs at the current time in milliseconds, so IDs from
+// before a restart are never mistaken for current ones and such clients get a snapshot.
+func newStatusHub() *statusHub {
+	return &statusHub{
+		id:     uint64(time.Now().UnixMilli()),
+		full:   statusFeed{clients: map[*statusStreamClient]struct{}{}},
+		public: statusFeed{clients: map[*statusStreamClient]struct{}{}},
+	}
+}
+
+func (h *statusHub) feed(full bool) *statusFeed {
+	if full {
+		return &h.full
+	}
+	return &h.public
+}
+
+// statusSections splits a payload into its top-level fields for delta comparison.
+func statusSections(payload statusStreamPayload) (map[string]map[string]json.RawMessage, error) {
+	sections := map[string]map[string]json.RawMessage{}
+	for name, v := range map[string]interface{}{"status": payload.Status, "details": payload.Details} {
+		data, err := json.Marshal(v)
+		if err != nil {
+			return nil, err
+		}
+		var fields map[string]json.RawMessage
+		if err := json.Unmarshal(data, &fields); err != nil {
+			return nil, err
+		}
+		sections[name] = fields
+	}
+	return sections, nil
+}
+
+// encodedPayload is a payload serialized for a feed.
+type encodedPayload struct {
+	sections map[string]map[string]json.RawMessage
+	snapshot []byte
+}
+
+func encodeStatusPayload(payload statusStreamPayload) (encodedPayload, error) {
+	sections, err := statusSections(payload)
+	if err != nil {
+		return encodedPayload{}, err
+	}
+	snapshot, err := json.Marshal(payload)
+	if err != nil {
+		return encodedPayload{}, err
+	}
+	return encodedPayload{sections: sections, snapshot: snapshot}, nil
+}
+
+// publish stores a new sample and sends its changes to every client.
+func (h *statusHub) publish(status *getstatus.PCStatus, details *getstatus.StatusV2, at time.Time) {
+	full, err := encodeStatusPayload(statusStreamPayload{Status: status, Details: details})
+	if err != nil {
+		log.Printf("[WARN] ステータス配信データの作成に失敗しました: %v", err)
+		return
+	}
+	public, err := encodeStatusPayload(statusStreamPayload{Status: publicStatus(status), Details: publicStatusV2(details)})
+	if err != nil {
+		log.Printf("[WARN] ステータス配信データの作成に失敗しました: %v", err)
+		return
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.id++
+	h.at, h.status, h.details = at, status, details
+	if err := h.full.advance(h.id, full); err != nil {
+		log.Printf("[WARN] ステータス配信データの作成に失敗しました: %v", err)
+	}
+	if err := h.public.advance(h.id, public); err != nil {
+		log.Printf("[WARN] ステータス配信データの作成に失敗しました: %v", err)
+	}
+}
+
+// advance records the sample with the given ID in the feed and sends the change to
+// its clients: a snapshot the first time, a delta afterwards.
+func (f *statusFeed) advance(id uint64, payload encodedPayload) error {
+	first := f.fields == nil
+	delta := map[string]map[string]json.RawMessage{}
+	for name, fields := range payload.sections {
+		changed := map[string]json.RawMessage{}
+		for key, value := range fields {
+			if !bytes.Equal(f.fields[name][key], value) {
+				changed[key] = value
+			}
+		}
+		delta[name] = changed
+	}
+	deltaData, err := json.Marshal(delta)
+	if err != nil {
+		return err
+	}
+	f.fields, f.snapshot = payload.sections, payload.snapshot
+
+	event := statusEvent{id: id, name: "delta", data: deltaData}
+	if first {
+		event = statusEvent{id: id, name: "snapshot", data: f.snapshot}
+	} else {
+		f.backlog = append(f.backlog, event)
+		if len(f.backlog) > statusStreamBacklog {
+			f.backlog = f.backlog[len(f.backlog)-statusStreamBacklog:]
+		}
+	}
+
+	for client := range f.clients {
+		select {
+		case client.events <- event:
+		default:
+			client.lagged.Store(true)
+		}
+	}
+	return nil
+}
+
+// latest returns the most recent sample and when it was taken.
+func (h *statusHub) latest() (*getstatus.PCStatus, *getstatus.StatusV2, time.Time) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.status, h.details, h.at
+}
+
+func (h *statusHub) currentSnapshot(full bool) statusEvent {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return statusEvent{id: h.id, name: "snapshot", data: h.feed(full).snapshot}
+}
+
+// subscribe registers a client of the full or public feed and returns the events that
+// bring it up to date: the missed deltas after lastID when they are still in the
+// backlog, or a snapshot.
+func (h *statusHub) subscribe(lastID uint64, resume, full bool) (*statusStreamClient, []statusEvent) {
+	client := &statusStreamClient{events: make(chan statusEvent, statusStreamBuffer)}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	f := h.feed(full)
+	f.clients[client] = struct{}{}
+
+	if f.snapshot == nil {
+		return client, nil
+	}
+	if resume && lastID <= h.id {
+		if lastID == h.id {
+			return client, nil
+		}
+		if len(f.backlog) > 0 && lastID+1 >= f.backlog[0].id {
+			var missed []statusEvent
+			for _, event := range f.backlog {
+				if event.id > lastID {
+					missed = append(missed, event)
+				}
+			}
+			return client, missed
+		}
+	}
+	return client, []statusEvent{{id: h.id, name: "snapshot", data: f.snapshot}}
+}
+
+func (h *statusHub) unsubscribe(client *statusStreamClient) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.full.clients, client)
+	delete(h.public.clients, client)
+}
+
+func writeStatusEvent(w http.ResponseWriter, rc *http.ResponseController, event statusEvent) error {
+	_ = rc.SetWriteDeadline(time.Now().Add(statusWriteTimeout))
+	var err error
+	if event.id > 0 {
+		_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", event.id, event.name, event.data)
+	} else {
+		_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.name, event.data)
+	}
+	if err != nil {
+		return err
+	}
+	return rc.Flush()
+}
+
+// handleStatusStream streams status changes of this server, or of the agent named by
+// "host", as Server-Sent Events. A reconnecting client sends Last-Event-ID (or
+// lastEventId in the query) and receives only what it missed when possible.
+func handleStatusStream(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	hostName := r.URL.Query().Get("host")
+	hub, _, _, err := statusHubForHost(hostName)
+	if writeHostUnavailable(w, hostName, true, time.Time{}, err) {
+		return
+	}
+	if hub.connected.Add(1) > maxStatusStreamUsers {
+		hub.connected.Add(-1)
+		w.Header().Set("Retry-After", "30")
+		http.Error(w, "接続数が上限に達しています", http.StatusServiceUnavailable)
+		return
+	}
+	defer hub.connected.Add(-1)
+
+	rawLastID := r.Header.Get("Last-Event-ID")
+	if rawLastID == "" {
+		rawLastID = r.URL.Query().Get("lastEventId")
+	}
+	lastID, err := strconv.ParseUint(rawLastID, 10, 64)
+	resume := rawLastID != "" && err == nil
+
+	rc := http.NewResponseController(w)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	if _, err := fmt.Fprintf(w, "retry: %d\n\n", statusStreamRetryMs); err != nil {
+		return
+	}
+	if err := rc.Flush(); err != nil {
+		log.Printf("[WARN] ステータス配信はこの接続でサポートされていません: %v", err)
+		return
+	}
+
+	full := canViewStatusDetails(r)
+	client, initial := hub.subscribe(lastID, resume, full)
+	defer hub.unsubscribe(client)
+
+	var sent uint64
+	for _, event := range initial {
+		if err := writeStatusEvent(w, rc, event); err != nil {
+			return
+		}
+		sent = event.id
+	}
+
+	heartbeat := time.NewTicker(time.Duration(envPositiveInt("STATUS_STREAM_HEARTBEAT_SEC", 15)) * time.Second)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case now := <-heartbeat.C:
+			data := fmt.Appendf(nil, `{"ts":%d}`, now.Unix())
+			if err := writeStatusEvent(w, rc, statusEvent{name: "heartbeat", data: data}); err != nil {
+				return
+			}
+		case event := <-client.events:
+			if client.lagged.Swap(false) {
+				// Deltas were dropped for this client, so the queued ones no longer apply.
+				event = hub.currentSnapshot(full)
+			}
+			if event.id <= sent {
+				continue
+			}
+			if err := writeStatusEvent(w, rc, event); err != nil {
+				return
+			}
+			sent = event.id
+		}
+	}
+}
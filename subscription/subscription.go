@@ -373,3 +373,10 @@ func (s *SubscriptionDB) Delete(id int64, userID string) error {
 	}
 	return nil
 }
+
+// DeleteAllByUserID removes every subscription belonging to the user.
+func (s *SubscriptionDB) DeleteAllByUserID(userID string) error {
+	query := `DELETE FROM subscriptions WHERE user_id = ?`
+	_, err := s.db.Exec(query, userID)
+	return err
+}
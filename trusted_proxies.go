@@ -0,0 +1,293 @@
+// 2025 TabDock: darui3018823 All rights reserved.
+// All works created by darui3018823 associated with this repository are the intellectual property of darui3018823.
+// Packages and other third-party materials used in this repository are subject to their respective licenses and copyrights.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// cloudflareCIDRs are Cloudflare's published edge ranges, trusted when "cloudflare" is on.
+var cloudflareCIDRs = parseCIDRs([]string{
+	"173.245.48.0/20",
+	"103.21.244.0/22",
+	"103.22.200.0/22",
+	"103.31.4.0/22",
+	"141.101.64.0/18",
+	"108.162.192.0/18",
+	"190.93.240.0/20",
+	"188.114.96.0/20",
+	"197.234.240.0/22",
+	"198.41.128.0/17",
+	"162.158.0.0/15",
+	"104.16.0.0/13",
+	"104.24.0.0/14",
+	"172.64.0.0/13",
+	"131.0.72.0/22",
+	"2400:cb00::/32",
+	"2405:8100::/32",
+	"2405:b500::/32",
+	"2606:4700::/32",
+	"2803:f800::/32",
+	"2c0f:f248::/32",
+	"2a06:98c0::/29",
+})
+
+// TrustedProxyConfig is the content of the trusted proxies file. Omitted switches
+// default to true, which matches a deployment behind Cloudflare or a local proxy.
+type TrustedProxyConfig struct {
+	// TrustPrivate treats loopback and private addresses as proxies.
+	TrustPrivate *bool `json:"trust_private,omitempty"`
+	// Cloudflare trusts Cloudflare's ranges and its CF-Connecting-IP header.
+	Cloudflare *bool `json:"cloudflare,omitempty"`
+	// Proxies lists further proxy addresses or CIDR ranges, such as nginx or another CDN.
+	Proxies []string `json:"proxies"`
+	// Header is the forwarding header the proxies set: "x-forwarded-for" (the default)
+	// or "forwarded". The other one is ignored, since a client can send it unchanged.
+	Header string `json:"header,omitempty"`
+}
+
+// Forwarding headers a trusted proxy can be configured to set.
+const (
+	forwardHeaderXFF       = "x-forwarded-for"
+	forwardHeaderForwarded = "forwarded"
+)
+
+// trustedProxySet is one immutable snapshot of the trusted proxies.
+type trustedProxySet struct {
+	networks     []*net.IPNet
+	trustPrivate bool
+	cloudflare   bool
+	header       string
+	modTime      time.Time
+	size         int64
+}
+
+var activeProxies atomic.Pointer[trustedProxySet]
+
+func getTrustedProxiesPath() string {
+	return getEnv("TRUSTED_PROXIES_PATH", "./json/trusted_proxies.json")
+}
+
+func defaultTrustedProxySet() *trustedProxySet {
+	return &trustedProxySet{networks: cloudflareCIDRs, trustPrivate: true, cloudflare: true, header: forwardHeaderXFF}
+}
+
+// readTrustedProxies parses the proxies file. A missing file yields the defaults.
+func readTrustedProxies(path string) (*trustedProxySet, error) {
+	info, err := os.Stat(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return defaultTrustedProxySet(), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var cfg TrustedProxyConfig
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&cfg); err != nil {
+		return nil, fmt.Errorf("信頼プロキシ設定のJSONデコードに失敗: %w", err)
+	}
+
+	set := &trustedProxySet{
+		trustPrivate: cfg.TrustPrivate == nil || *cfg.TrustPrivate,
+		cloudflare:   cfg.Cloudflare == nil || *cfg.Cloudflare,
+		header:       strings.ToLower(strings.TrimSpace(cfg.Header)),
+		modTime:      info.ModTime(),
+		size:         info.Size(),
+	}
+	switch set.header {
+	case "":
+		set.header = forwardHeaderXFF
+	case forwardHeaderXFF, forwardHeaderForwarded:
+	default:
+		return nil, fmt.Errorf("header は %q か %q を指定してください: %q", forwardHeaderXFF, forwardHeaderForwarded, cfg.Header)
+	}
+	if set.cloudflare {
+		set.networks = append(set.networks, cloudflareCIDRs...)
+	}
+	var problems []string
+	for _, entry := range cfg.Proxies {
+		network, err := parseTrustedEntry(entry)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("%q", entry))
+			continue
+		}
+		set.networks = append(set.networks, network)
+	}
+	if len(problems) > 0 {
+		return nil, fmt.Errorf("proxies に不正な値があります: %s", strings.Join(problems, ", "))
+	}
+	return set, nil
+}
+
+// loadTrustedProxies activates the proxies file at startup.
+func loadTrustedProxies(path string) error {
+	set, err := readTrustedProxies(path)
+	if err != nil {
+		return err
+	}
+	activeProxies.Store(set)
+	return nil
+}
+
+// reloadTrustedProxies re-reads the proxies file. An invalid file keeps the current list.
+func reloadTrustedProxies(path, trigger string) error {
+	set, err := readTrustedProxies(path)
+	if err != nil {
+		log.Printf("[SECURITY] 信頼プロキシ設定の再読み込みを拒否しました (%s)。現在の設定を維持します: %v", trigger, err)
+		return err
+	}
+	activeProxies.Store(set)
+	log.Printf("[SECURITY] 信頼プロキシ設定を再読み込みしました (%s): %d件 (private=%t, cloudflare=%t, header=%s)",
+		trigger, len(set.networks), set.trustPrivate, set.cloudflare, set.header)
+	return nil
+}
+
+func currentTrustedProxies() *trustedProxySet {
+	if set := activeProxies.Load(); set != nil {
+		return set
+	}
+	return defaultTrustedProxySet()
+}
+
+func (s *trustedProxySet) trusts(ip net.IP) bool {
+	if ip == nil {
+		return false
+	}
+	if s.trustPrivate && (ip.IsLoopback() || ip.IsPrivate()) {
+		return true
+	}
+	return inNetworks(ip, s.networks)
+}
+
+// parseForwardedFor returns the for= values of RFC 7239 Forwarded headers, nearest
+// hop last. Hops without a usable address are returned as empty strings.
+func parseForwardedFor(values []string) []string {
+	var hops []string
+	for _, value := range values {
+		for _, element := range strings.Split(value, ",") {
+			hop := ""
+			for _, pair := range strings.Split(element, ";") {
+				key, val, ok := strings.Cut(strings.TrimSpace(pair), "=")
+				if !ok || !strings.EqualFold(key, "for") {
+					continue
+				}
+				hop = forwardedNodeIP(strings.Trim(val, `"`))
+			}
+			hops = append(hops, hop)
+		}
+	}
+	return hops
+}
+
+// forwardedNodeIP strips the port and brackets from a Forwarded node such as
+// "192.0.2.60:4711" or "[2001:db8::1]:4711". Obfuscated or "unknown" nodes yield "".
+func forwardedNodeIP(node string) string {
+	if strings.HasPrefix(node, "[") {
+		end := strings.Index(node, "]")
+		if end < 0 {
+			return ""
+		}
+		node = node[1:end]
+	} else if host, _, err := net.SplitHostPort(node); err == nil {
+		node = host
+	}
+	if ip := net.ParseIP(node); ip != nil {
+		return ip.String()
+	}
+	return ""
+}
+
+func splitForwardedFor(values []string) []string {
+	var hops []string
+	for _, value := range values {
+		for _, part := range strings.Split(value, ",") {
+			hops = append(hops, forwardedNodeIP(strings.TrimSpace(part)))
+		}
+	}
+	return hops
+}
+
+// clientIPFromHops walks the forwarding chain from the nearest hop and returns the
+// first address not run by a trusted proxy. When every hop is trusted, or an
+// unparseable one ends the walk, the earliest trusted address is only taken if it is
+// public: a private or loopback one there may have been written by the client, so
+// the nearest hop, which the connected proxy added itself, is used instead.
+func clientIPFromHops(hops []string, proxies *trustedProxySet, remoteIP string) string {
+	var earliest net.IP
+	for i := len(hops) - 1; i >= 0; i-- {
+		ip := net.ParseIP(hops[i])
+		if ip == nil {
+			break
+		}
+		if !proxies.trusts(ip) {
+			return ip.String()
+		}
+		earliest = ip
+	}
+	if earliest == nil {
+		return remoteIP
+	}
+	if !earliest.IsLoopback() && !earliest.IsPrivate() {
+		return earliest.String()
+	}
+	return net.ParseIP(hops[len(hops)-1]).String()
+}
+
+// clientIPFromRequest resolves the client address of a request that came from remoteIP.
+// Forwarding headers are only read when remoteIP is a trusted proxy, and
+// CF-Connecting-IP only when it is a Cloudflare edge, which overwrites the header.
+func clientIPFromRequest(r *http.Request, remoteIP string) string {
+	proxies := currentTrustedProxies()
+	peer := net.ParseIP(remoteIP)
+	if !proxies.trusts(peer) {
+		return remoteIP
+	}
+
+	if proxies.cloudflare && isCloudflareEdge(peer) {
+		if cf := strings.TrimSpace(r.Header.Get("CF-Connecting-IP")); cf != "" {
+			if parsed := net.ParseIP(cf); parsed != nil {
+				return parsed.String()
+			}
+		}
+	}
+	var hops []string
+	if proxies.header == forwardHeaderForwarded {
+		hops = parseForwardedFor(r.Header.Values("Forwarded"))
+	} else {
+		hops = splitForwardedFor(r.Header.Values("X-Forwarded-For"))
+	}
+	return clientIPFromHops(hops, proxies, remoteIP)
+}
+
+// isCloudflareEdge reports whether ip is in Cloudflare's published edge ranges.
+func isCloudflareEdge(ip net.IP) bool {
+	return inNetworks(ip, cloudflareCIDRs)
+}
+
+func inNetworks(ip net.IP, networks []*net.IPNet) bool {
+	for _, network := range networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
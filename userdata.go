@@ -0,0 +1,377 @@
+// 2025 TabDock: darui3018823 All rights reserved.
+// All works created by darui3018823 associated with this repository are the intellectual property of darui3018823.
+// Packages and other third-party materials used in this repository are subject to their respective licenses and copyrights.
+
+package main
+
+import (
+	"archive/zip"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"tabdock/schedule"
+	"tabdock/subscription"
+	"tabdock/wallpaper"
+)
+
+// profileImageDir is where uploaded account icons are stored.
+const profileImageDir = "home/assets/acc_icon"
+
+// userFile is an uploaded file owned by a user.
+type userFile struct {
+	// archivePath is the path inside the export zip.
+	archivePath string
+	diskPath    string
+}
+
+// userExport is everything gathered for an account export.
+type userExport struct {
+	account       map[string]interface{}
+	apiTokens     []APIToken
+	schedules     []schedule.Schedule
+	shifts        []map[string]interface{}
+	subscriptions []subscription.Subscription
+	wallpapers    []wallpaper.Wallpaper
+	files         []userFile
+}
+
+// withDataDB opens one of the per-feature SQLite files for the duration of fn.
+func withDataDB(envKey, fallback string, fn func(*sql.DB) error) error {
+	path := getEnv(envKey, fallback)
+	conn, err := sql.Open(sqliteDriverName, path)
+	if err != nil {
+		return fmt.Errorf("%s 接続エラー: %w", path, err)
+	}
+	defer func() {
+		if closeErr := conn.Close(); closeErr != nil {
+			log.Printf("Failed to close DB: %v", closeErr)
+		}
+	}()
+	return fn(conn)
+}
+
+// purgeUserData removes every row owned by userID from the feature databases.
+// Each feature lives in its own SQLite file, so the FOREIGN KEY ... ON DELETE CASCADE
+// declared on those tables cannot reach them; the cascade is done here instead.
+func purgeUserData(userID string) error {
+	steps := []struct {
+		name     string
+		envKey   string
+		fallback string
+		purge    func(*sql.DB) error
+	}{
+		{"schedule", "DB_SCHEDULE_PATH", "./database/schedule.db", func(conn *sql.DB) error {
+			return schedule.NewScheduleDB(conn).DeleteAll(userID)
+		}},
+		{"shift", "DB_SHIFT_PATH", "./database/shift.db", func(conn *sql.DB) error {
+			_, err := conn.Exec(`DELETE FROM shifts WHERE user_id = ?`, userID)
+			return err
+		}},
+		{"wallpaper", "DB_WALLPAPER_PATH", "./database/wallpaper.db", func(conn *sql.DB) error {
+			return wallpaper.NewWallpaperDB(conn).DeleteAllByUserID(userID)
+		}},
+		{"subscription", "DB_SUBSCRIPTION_PATH", "./database/subscription.db", func(conn *sql.DB) error {
+			return subscription.NewSubscriptionDB(conn).DeleteAllByUserID(userID)
+		}},
+	}
+
+	for _, step := range steps {
+		if err := withDataDB(step.envKey, step.fallback, step.purge); err != nil {
+			return fmt.Errorf("%s データ削除エラー: %w", step.name, err)
+		}
+	}
+	return nil
+}
+
+// deleteUserAccount removes the user's data from every database and then the user row.
+// The user row goes last so a failed purge can simply be retried. Uploaded files are
+// removed once the rows referencing them are gone.
+func deleteUserAccount(userID string) error {
+	if db == nil {
+		return fmt.Errorf("データベース接続がありません")
+	}
+
+	files, err := collectUserFiles(userID)
+	if err != nil {
+		return fmt.Errorf("ファイル一覧取得エラー: %w", err)
+	}
+
+	if err := purgeUserData(userID); err != nil {
+		return err
+	}
+
+	if err := deleteAPITokensForUser(userID); err != nil {
+		return fmt.Errorf("APIトークン削除エラー: %w", err)
+	}
+
+	if _, err := db.Exec(`DELETE FROM users WHERE id = ?`, userID); err != nil {
+		return fmt.Errorf("ユーザー削除エラー: %w", err)
+	}
+
+	removeUserFiles(files)
+
+	log.Printf("[INFO] ユーザー %s と関連データを削除しました", userID)
+	return nil
+}
+
+// userFilePath returns the on-disk path of a stored file name, or "" when the name
+// cannot refer to a file inside dir.
+func userFilePath(dir, name string) string {
+	name = filepath.Base(strings.TrimSpace(name))
+	if name == "" || name == "." || name == ".." || name == string(filepath.Separator) {
+		return ""
+	}
+	return filepath.Join(dir, name)
+}
+
+// collectUserFiles lists the calendar attachments, wallpapers and profile image owned by the user.
+func collectUserFiles(userID string) ([]userFile, error) {
+	data, err := loadUserExport(userID)
+	if err != nil {
+		return nil, err
+	}
+	return data.files, nil
+}
+
+func removeUserFiles(files []userFile) {
+	for _, f := range files {
+		if err := os.Remove(f.diskPath); err != nil && !errors.Is(err, os.ErrNotExist) {
+			log.Printf("[WARN] ファイル削除に失敗しました (%s): %v", f.diskPath, err)
+		}
+	}
+}
+
+func loadExportAccount(userID string) (map[string]interface{}, string, error) {
+	var (
+		id, username, displayName, email, profileImage, role string
+		createdAt, updatedAt, oidcIssuer, oidcSubject        string
+		hasPassword, hasPasskey                              bool
+	)
+	err := db.QueryRow(`
+		SELECT id, COALESCE(username, ''), COALESCE(display_name, ''), COALESCE(email, ''), COALESCE(profile_image, ''),
+		       COALESCE(role, ?), COALESCE(created_at, ''), COALESCE(updated_at, ''),
+		       COALESCE(oidc_issuer, ''), COALESCE(oidc_subject, ''),
+		       COALESCE(password, '') != '', COALESCE(credential_id, '') != ''
+		FROM users WHERE id = ?
+	`, roleUser, userID).Scan(&id, &username, &displayName, &email, &profileImage, &role, &createdAt, &updatedAt,
+		&oidcIssuer, &oidcSubject, &hasPassword, &hasPasskey)
+	if err != nil {
+		return nil, "", err
+	}
+
+	// Password hashes and passkey public keys are deliberately left out.
+	return map[string]interface{}{
+		"id":           id,
+		"username":     username,
+		"displayName":  displayName,
+		"email":        email,
+		"profileImage": profileImage,
+		"role":         role,
+		"createdAt":    createdAt,
+		"updatedAt":    updatedAt,
+		"oidcIssuer":   oidcIssuer,
+		"oidcSubject":  oidcSubject,
+		"hasPassword":  hasPassword,
+		"hasPasskey":   hasPasskey,
+	}, profileImage, nil
+}
+
+func loadExportShifts(conn *sql.DB, userID string) ([]map[string]interface{}, error) {
+	rows, err := conn.Query(`
+		SELECT id, date, start_time, end_time, COALESCE(location, ''), COALESCE(description, ''), COALESCE(created_at, '')
+		FROM shifts
+		WHERE user_id = ?
+		ORDER BY date ASC, start_time ASC
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if closeErr := rows.Close(); closeErr != nil {
+			log.Printf("Failed to close rows: %v", closeErr)
+		}
+	}()
+
+	shifts := []map[string]interface{}{}
+	for rows.Next() {
+		var id int64
+		var date, start, end, location, description, createdAt string
+		if err := rows.Scan(&id, &date, &start, &end, &location, &description, &createdAt); err != nil {
+			return nil, err
+		}
+		shifts = append(shifts, map[string]interface{}{
+			"id":          id,
+			"date":        date,
+			"startTime":   start,
+			"endTime":     end,
+			"location":    location,
+			"description": description,
+			"createdAt":   createdAt,
+		})
+	}
+	return shifts, rows.Err()
+}
+
+// loadUserExport reads every row and file reference owned by the user.
+func loadUserExport(userID string) (*userExport, error) {
+	if db == nil {
+		return nil, fmt.Errorf("データベース接続がありません")
+	}
+
+	data := &userExport{}
+	account, profileImage, err := loadExportAccount(userID)
+	if err != nil {
+		return nil, fmt.Errorf("ユーザー取得エラー: %w", err)
+	}
+	data.account = account
+
+	if data.apiTokens, err = listAPITokens(userID); err != nil {
+		return nil, fmt.Errorf("APIトークン取得エラー: %w", err)
+	}
+
+	steps := []struct {
+		name     string
+		envKey   string
+		fallback string
+		load     func(*sql.DB) error
+	}{
+		{"schedule", "DB_SCHEDULE_PATH", "./database/schedule.db", func(conn *sql.DB) (err error) {
+			data.schedules, err = schedule.NewScheduleDB(conn).GetByUserID(userID)
+			return err
+		}},
+		{"shift", "DB_SHIFT_PATH", "./database/shift.db", func(conn *sql.DB) (err error) {
+			data.shifts, err = loadExportShifts(conn, userID)
+			return err
+		}},
+		{"subscription", "DB_SUBSCRIPTION_PATH", "./database/subscription.db", func(conn *sql.DB) (err error) {
+			data.subscriptions, err = subscription.NewSubscriptionDB(conn).GetByUserID(userID)
+			return err
+		}},
+		{"wallpaper", "DB_WALLPAPER_PATH", "./database/wallpaper.db", func(conn *sql.DB) error {
+			all, err := wallpaper.NewWallpaperDB(conn).GetByUserID(userID)
+			if err != nil {
+				return err
+			}
+			// GetByUserID also returns the shared defaults, which the user does not own.
+			for _, wp := range all {
+				if wp.UserID == userID {
+					data.wallpapers = append(data.wallpapers, wp)
+				}
+			}
+			return nil
+		}},
+	}
+	for _, step := range steps {
+		if err := withDataDB(step.envKey, step.fallback, step.load); err != nil {
+			return nil, fmt.Errorf("%s データ取得エラー: %w", step.name, err)
+		}
+	}
+
+	// Empty data sets are exported as [] rather than null.
+	if data.schedules == nil {
+		data.schedules = []schedule.Schedule{}
+	}
+	if data.subscriptions == nil {
+		data.subscriptions = []subscription.Subscription{}
+	}
+	if data.wallpapers == nil {
+		data.wallpapers = []wallpaper.Wallpaper{}
+	}
+
+	for _, sched := range data.schedules {
+		if path := userFilePath(schedule.CalendarDir, sched.Attachment); path != "" && sched.Attachment != "" {
+			data.files = append(data.files, userFile{"files/calendar/" + filepath.Base(path), path})
+		}
+	}
+	for _, wp := range data.wallpapers {
+		if path := userFilePath(wallpaper.WallpaperDir, wp.Filename); path != "" {
+			data.files = append(data.files, userFile{"files/wallpapers/" + filepath.Base(path), path})
+		}
+	}
+	// Only icons uploaded through the API are the user's own; other paths may be shared assets.
+	if strings.HasPrefix(strings.TrimPrefix(profileImage, "/"), profileImageDir+"/") {
+		if path := userFilePath(profileImageDir, profileImage); path != "" {
+			data.files = append(data.files, userFile{"files/acc_icon/" + filepath.Base(path), path})
+		}
+	}
+	return data, nil
+}
+
+// writeUserExport writes the export as a zip archive: one JSON document per
+// data set plus the user's uploaded files under files/.
+func writeUserExport(w io.Writer, data *userExport) error {
+	zw := zip.NewWriter(w)
+
+	documents := []struct {
+		name  string
+		value interface{}
+	}{
+		{"account.json", data.account},
+		{"api_tokens.json", data.apiTokens},
+		{"schedules.json", data.schedules},
+		{"shifts.json", data.shifts},
+		{"subscriptions.json", data.subscriptions},
+		{"wallpapers.json", data.wallpapers},
+	}
+	for _, doc := range documents {
+		entry, err := zw.CreateHeader(&zip.FileHeader{Name: doc.name, Method: zip.Deflate, Modified: time.Now()})
+		if err != nil {
+			return err
+		}
+		enc := json.NewEncoder(entry)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(doc.value); err != nil {
+			return err
+		}
+	}
+
+	for _, f := range data.files {
+		if err := addFileToZip(zw, f); err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				log.Printf("[WARN] エクスポート対象のファイルがありません: %s", f.diskPath)
+				continue
+			}
+			return err
+		}
+	}
+
+	return zw.Close()
+}
+
+func addFileToZip(zw *zip.Writer, f userFile) error {
+	src, err := os.Open(f.diskPath)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if closeErr := src.Close(); closeErr != nil {
+			log.Printf("Failed to close file: %v", closeErr)
+		}
+	}()
+
+	info, err := src.Stat()
+	if err != nil {
+		return err
+	}
+	header, err := zip.FileInfoHeader(info)
+	if err != nil {
+		return err
+	}
+	header.Name = f.archivePath
+	header.Method = zip.Deflate
+
+	entry, err := zw.CreateHeader(header)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(entry, src)
+	return err
+}
@@ -106,3 +106,11 @@ func (w *WallpaperDB) Delete(id int64, userID string) error {
 	}
 	return nil
 }
+
+// DeleteAllByUserID removes every wallpaper owned by the user.
+// Shared default wallpapers are never touched.
+func (w *WallpaperDB) DeleteAllByUserID(userID string) error {
+	query := `DELETE FROM wallpapers WHERE user_id = ? AND user_id != 'default'`
+	_, err := w.db.Exec(query, userID)
+	return err
+}